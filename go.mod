@@ -3,6 +3,7 @@ module github.com/sapcc/ocm-helm-toolbox
 go 1.26
 
 require (
+	github.com/opencontainers/go-digest v1.0.0
 	github.com/sapcc/go-api-declarations v1.24.0
 	github.com/sapcc/go-bits v0.0.0-20260806170240-4bbc84d224db
 	github.com/spf13/cobra v1.10.2
@@ -14,7 +15,6 @@ require (
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
-	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
 	go.podman.io/storage v1.64.0 // indirect
 )
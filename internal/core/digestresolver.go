@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"go.podman.io/image/v5/docker/reference"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// DigestResolver looks up which manifest digest an image tag currently points to.
+// This is used by `bundle --resolve-digests`.
+type DigestResolver interface {
+	ResolveDigest(ctx context.Context, ref reference.NamedTagged) (digest.Digest, error)
+}
+
+// NewRegistryDigestResolver returns a DigestResolver that asks the registry referenced by each image.
+// Credentials are taken from the Docker CLI configuration file, as described for oci.NewRegistry().
+func NewRegistryDigestResolver() DigestResolver {
+	return registryDigestResolver{}
+}
+
+type registryDigestResolver struct{}
+
+// ResolveDigest implements the DigestResolver interface.
+func (registryDigestResolver) ResolveDigest(ctx context.Context, ref reference.NamedTagged) (digest.Digest, error) {
+	registry, err := oci.NewRegistry(reference.Domain(ref))
+	if err != nil {
+		return "", err
+	}
+	desc, err := registry.Repository(reference.Path(ref)).ResolveManifest(ctx, ref.Tag())
+	if err != nil {
+		return "", err
+	}
+	return digest.Parse(desc.Digest)
+}
+
+// ResolveDigests adds a digest to each image reference that only has a tag, using the given DigestResolver.
+// Image references that already have a digest are not changed.
+//
+// This must be called before AssignResourceNames(), since the image references are changed.
+func (rels ImageRelations) ResolveDigests(ctx context.Context, resolver DigestResolver) error {
+	resolved := make(map[string]reference.Named) // key = original image reference
+	for _, rel := range rels {
+		original := rel.ImageReference.String()
+		if imageRef, ok := resolved[original]; ok {
+			rel.ImageReference = imageRef
+			continue
+		}
+
+		if _, ok := rel.ImageReference.(reference.Digested); ok {
+			continue
+		}
+		tagged, ok := rel.ImageReference.(reference.NamedTagged)
+		if !ok {
+			return fmt.Errorf("cannot resolve digest for image %s: neither tag nor digest is given", original)
+		}
+		dgst, err := resolver.ResolveDigest(ctx, tagged)
+		if err != nil {
+			return fmt.Errorf("while resolving digest for image %s: %w", original, err)
+		}
+		imageRef, err := reference.WithDigest(tagged, dgst)
+		if err != nil {
+			return fmt.Errorf("while resolving digest for image %s: %w", original, err)
+		}
+		logg.Debug("resolved image %s to %s", original, imageRef.String())
+
+		resolved[original] = imageRef
+		rel.ImageReference = imageRef
+	}
+	return nil
+}
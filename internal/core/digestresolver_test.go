@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/docker/reference"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+func TestRegistryDigestResolver(t *testing.T) {
+	imageManifest := testManifest{
+		MediaType: oci.ImageManifestMediaType,
+		Contents:  []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`),
+	}
+	imageIndex := testManifest{
+		MediaType: oci.ImageIndexMediaType,
+		Contents:  []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`),
+	}
+	dockerManifestList := testManifest{
+		MediaType: oci.DockerManifestListMediaType,
+		Contents:  []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[]}`),
+	}
+	registry := &testRegistry{
+		Manifests: map[string]testManifest{
+			"foo/app:1.0":      imageManifest,
+			"foo/multi:1.0":    imageIndex,
+			"foo/docker:1.0":   dockerManifestList,
+			"foo/nodigest:1.0": imageManifest,
+		},
+		OmitDigestHeaderIn: map[string]bool{"foo/nodigest": true},
+	}
+	host := registry.Start(t)
+
+	testCases := []struct {
+		Image            string
+		ExpectedDigest   string
+		ExpectedRequests []string
+		ExpectedError    string
+	}{
+		{
+			// the first request runs into the auth challenge, then the request is repeated with a token
+			Image:            "foo/app:1.0",
+			ExpectedDigest:   imageManifest.Digest(),
+			ExpectedRequests: []string{"HEAD /v2/foo/app/manifests/1.0", "GET /token", "HEAD /v2/foo/app/manifests/1.0"},
+		},
+		{
+			Image:            "foo/multi:1.0",
+			ExpectedDigest:   imageIndex.Digest(),
+			ExpectedRequests: []string{"HEAD /v2/foo/multi/manifests/1.0", "GET /token", "HEAD /v2/foo/multi/manifests/1.0"},
+		},
+		{
+			Image:            "foo/docker:1.0",
+			ExpectedDigest:   dockerManifestList.Digest(),
+			ExpectedRequests: []string{"HEAD /v2/foo/docker/manifests/1.0", "GET /token", "HEAD /v2/foo/docker/manifests/1.0"},
+		},
+		{
+			// without Docker-Content-Digest, the manifest needs to be downloaded to compute its digest
+			Image:          "foo/nodigest:1.0",
+			ExpectedDigest: imageManifest.Digest(),
+			ExpectedRequests: []string{
+				"HEAD /v2/foo/nodigest/manifests/1.0", "GET /token", "HEAD /v2/foo/nodigest/manifests/1.0",
+				"GET /v2/foo/nodigest/manifests/1.0",
+			},
+		},
+		{
+			Image:         "foo/app:2.0",
+			ExpectedError: "manifest foo/app:2.0 in " + host + " not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		ref, err := reference.ParseNormalizedNamed(host + "/" + tc.Image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		countBefore := len(registry.Requests())
+		// NOTE: Each ResolveDigest() call uses a fresh registry client, so every case goes through the auth challenge.
+		dgst, err := NewRegistryDigestResolver().ResolveDigest(t.Context(), ref.(reference.NamedTagged))
+
+		if tc.ExpectedError != "" {
+			if err == nil || err.Error() != tc.ExpectedError {
+				t.Errorf("%s: expected error %q, but got %v", tc.Image, tc.ExpectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.Image, err.Error())
+			continue
+		}
+		if dgst.String() != tc.ExpectedDigest {
+			t.Errorf("%s: expected digest %s, but got %s", tc.Image, tc.ExpectedDigest, dgst)
+		}
+		requests := registry.Requests()[countBefore:]
+		if strings.Join(requests, ", ") != strings.Join(tc.ExpectedRequests, ", ") {
+			t.Errorf("%s: expected requests %v, but got %v", tc.Image, tc.ExpectedRequests, requests)
+		}
+	}
+}
+
+// staticDigestResolver is a DigestResolver that does not require network access.
+type staticDigestResolver map[string]digest.Digest
+
+func (r staticDigestResolver) ResolveDigest(_ context.Context, ref reference.NamedTagged) (digest.Digest, error) {
+	dgst, ok := r[ref.String()]
+	if !ok {
+		return "", errors.New("no such image")
+	}
+	return dgst, nil
+}
+
+func TestResolveDigests(t *testing.T) {
+	digestA := digest.FromString("a")
+	digestB := digest.FromString("b")
+	rels, err := ParseImageRelations(t.Context(), []string{
+		".Values.image.repository is repository of quay.io/foo/app:1.0",
+		".Values.image.tag is tag of quay.io/foo/app:1.0",
+		".Values.image.digest is digest of quay.io/foo/app:1.0",
+		".Values.sidecar.image is reference of quay.io/foo/sidecar:2.0@" + digestA.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rels.ResolveDigests(t.Context(), staticDigestResolver{"quay.io/foo/app:1.0": digestB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"quay.io/foo/app:1.0@" + digestB.String(),
+		"quay.io/foo/app:1.0@" + digestB.String(),
+		"quay.io/foo/app:1.0@" + digestB.String(),
+		// images that already have a digest are not resolved again
+		"quay.io/foo/sidecar:2.0@" + digestA.String(),
+	}
+	for idx, rel := range rels {
+		if rel.ImageReference.String() != expected[idx] {
+			t.Errorf("expected relation %d to refer to %s, but got %s", idx, expected[idx], rel.ImageReference.String())
+		}
+	}
+
+	// resolution errors are reported with the image reference
+	rels, err = ParseImageRelations(t.Context(), []string{".Values.image is reference of quay.io/foo/unknown:1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rels.ResolveDigests(t.Context(), staticDigestResolver{})
+	if err == nil || err.Error() != "while resolving digest for image quay.io/foo/unknown:1.0: no such image" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
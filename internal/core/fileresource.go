@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// FileResource is a plain file or directory that is bundled alongside the Helm charts, as requested by `bundle --file-resource`.
+// Typical examples are Grafana dashboards or CRD tarballs that are not part of any chart.
+type FileResource struct {
+	Path  string
+	IsDir bool
+}
+
+// FileResourcesDirName is the subdirectory of the `unbundle` target directory into which file resources are unpacked.
+const FileResourcesDirName = "files"
+
+// CheckFileResourcesDirName returns an error if any of the given charts would be unpacked into FileResourcesDirName.
+// Since each chart is unpacked into a subdirectory named after the chart, this is the case for a chart named "files".
+func CheckFileResourcesDirName(chartNames []string) error {
+	if slices.Contains(chartNames, FileResourcesDirName) {
+		return fmt.Errorf("cannot combine file resources with a Helm chart named %q, since both would be unpacked into the subdirectory %q",
+			FileResourcesDirName, FileResourcesDirName)
+	}
+	return nil
+}
+
+// FileName returns the name under which a file resource is unpacked by `unbundle`.
+// This is the basename of its original path.
+func (f FileResource) FileName() string {
+	return filepath.Base(f.Path)
+}
+
+// ParseFileResources parses the --file-resource options of the `bundle` subcommand.
+func ParseFileResources(inputs []string) ([]FileResource, error) {
+	result := make([]FileResource, 0, len(inputs))
+	isFileName := make(map[string]bool, len(inputs))
+	for _, input := range inputs {
+		fi, err := os.Stat(input)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing --file-resource %q: %w", input, err)
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil, fmt.Errorf("while parsing --file-resource %q: expected a regular file or directory", input)
+		}
+		f := FileResource{Path: input, IsDir: fi.IsDir()}
+		if isFileName[f.FileName()] {
+			return nil, fmt.Errorf("--file-resource was given multiple times for files named %q", f.FileName())
+		}
+		isFileName[f.FileName()] = true
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// AsOCMResource returns a resource declaration for this file resource.
+// Files are bundled as resources of type "blob", directories as resources of type "directoryTree".
+func (f FileResource) AsOCMResource(bundleVersion string) OCMResourceDeclaration {
+	decl := OCMResourceDeclaration{
+		Name:    "file-" + f.FileName(),
+		Type:    "blob",
+		Version: bundleVersion,
+		Labels: []OCMLabel{{
+			Name:    FileNameLabelName,
+			Value:   f.FileName(),
+			Signing: true,
+		}},
+		Input: map[string]any{
+			"type":      "file",
+			"path":      f.Path,
+			"mediaType": "application/octet-stream",
+		},
+	}
+	if f.IsDir {
+		decl.Type = "directoryTree"
+		decl.Input = map[string]any{
+			"type": "dir",
+			"path": f.Path,
+		}
+	}
+	return decl
+}
+
+// UnpackFileResource writes the payload of a resource created by FileResource.AsOCMResource() into the given directory.
+// Files are written into a file with the given name, directories are unpacked into a subdirectory with the given name.
+func UnpackFileResource(res OCMResourceInfo, buf []byte, outputDirPath string) error {
+	value, ok := res.GetLabel(FileNameLabelName)
+	if !ok {
+		return fmt.Errorf("could not unpack resource %q: missing required label %q", res.Name, FileNameLabelName)
+	}
+	fileName, ok := value.(string)
+	if !ok || !filepath.IsLocal(fileName) || filepath.Base(fileName) != fileName {
+		return fmt.Errorf("could not read label %q on resource %q: expected a file name, but got %#v", FileNameLabelName, res.Name, value)
+	}
+	targetPath := filepath.Join(outputDirPath, fileName)
+	_, err := os.Lstat(targetPath)
+	if err == nil {
+		return fmt.Errorf("could not unpack resource %q: %s already exists", res.Name, targetPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.MkdirAll(outputDirPath, 0777) // NOTE: final mode is subject to umask
+	if err != nil {
+		return err
+	}
+	switch res.Type {
+	case "directoryTree":
+		err = unpackTarball(buf, targetPath)
+	default:
+		err = os.WriteFile(targetPath, buf, 0666) // NOTE: final mode is subject to umask
+	}
+	if err != nil {
+		return fmt.Errorf("could not unpack resource %q: %w", res.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFileResources(t *testing.T) {
+	dirPath := t.TempDir()
+	for _, relPath := range []string{"a/dashboard.json", "b/dashboard.json", "crds/foo.yaml"} {
+		path := filepath.Join(dirPath, relPath)
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte("{}"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ParseFileResources([]string{filepath.Join(dirPath, "a/dashboard.json"), filepath.Join(dirPath, "crds")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].FileName() != "dashboard.json" || files[0].IsDir || files[1].FileName() != "crds" || !files[1].IsDir {
+		t.Errorf("unexpected file resources: %#v", files)
+	}
+
+	// basenames must be unique, since they become the file names during unbundling
+	_, err = ParseFileResources([]string{filepath.Join(dirPath, "a/dashboard.json"), filepath.Join(dirPath, "b/dashboard.json")})
+	if err == nil || !strings.Contains(err.Error(), `multiple times for files named "dashboard.json"`) {
+		t.Errorf("expected error for duplicate basename, but got %v", err)
+	}
+}
+
+func TestCheckFileResourcesDirName(t *testing.T) {
+	err := CheckFileResourcesDirName([]string{"keystone", "files-exporter"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	err = CheckFileResourcesDirName([]string{"keystone", "files"})
+	if err == nil || !strings.Contains(err.Error(), `a Helm chart named "files"`) {
+		t.Errorf("expected error for chart named %q, but got %v", "files", err)
+	}
+}
@@ -4,16 +4,16 @@
 package core
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strconv"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/sapcc/go-bits/logg"
 	. "go.xyrillian.de/gg/option"
 )
 
@@ -28,103 +28,187 @@ type GitLocation struct {
 	CommitID      string            `json:"commit-id"`
 	RepositoryURL string            `json:"remote-url"`
 	DirectoryPath string            `json:"subpath,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	// None if the directory might contain uncommitted changes, but we cannot tell for sure (see FindUncommittedChanges()).
+	IsDirty Option[bool] `json:"dirty,omitzero"`
+}
+
+// GitLocationOptions contains options for TryGetGitLocation().
+type GitLocationOptions struct {
+	// The name of the remote whose URL is recorded in the GitLocation. Defaults to "origin".
+	RemoteName string
+	// If true, TryGetGitLocation() fails if the directory contains uncommitted changes (including untracked files),
+	// or if it cannot tell whether it does.
+	RequireCleanWorktree bool
 }
 
 // TryGetGitLocation returns the GitLocation of the given directory, if it is
 // inside a checkout of a Git repository, or None otherwise.
-func TryGetGitLocation(path string) (Option[GitLocation], error) {
+//
+// The repository is inspected by reading the files below `.git` directly, so the `git` command is not required.
+func TryGetGitLocation(path string, opts GitLocationOptions) (Option[GitLocation], error) {
+	remoteName := opts.RemoteName
+	if remoteName == "" {
+		remoteName = "origin"
+	}
+
 	// are we in a Git repository at all?
-	out, err := execGitInPath(path, "rev-parse", "--is-inside-work-tree")
+	maybeRepo, err := openGitRepository(path)
 	if err != nil {
-		if errors.Is(err, errNotAGitRepository) {
-			err = nil
-		}
 		return None[GitLocation](), err
 	}
-	if strings.TrimSpace(out) != "true" {
+	repo, ok := maybeRepo.Unpack()
+	if !ok {
 		return None[GitLocation](), nil
 	}
 
 	// get information about HEAD commit
-	out, err = execGitInPath(path, "show", "-s", "--pretty=%H %at %ct", "HEAD")
+	headRefName, headCommitID, err := repo.ResolveRef("HEAD")
 	if err != nil {
-		return None[GitLocation](), err
+		return None[GitLocation](), fmt.Errorf("in Git repository at %s: %w", repo.WorktreePath, err)
 	}
-	fields := strings.Fields(out)
-	if len(fields) != 3 {
-		return None[GitLocation](), fmt.Errorf("malformed input from `git show --pretty='%%H %%at %%ct' HEAD`: %q", strings.TrimSpace(out))
+	if headCommitID == "" {
+		return None[GitLocation](), fmt.Errorf("in Git repository at %s: %s does not point to a commit yet", repo.WorktreePath, headRefName)
 	}
-	authoredTimestamp, err := strconv.ParseInt(fields[1], 10, 64)
+	headCommit, err := repo.ReadCommit(headCommitID)
 	if err != nil {
-		return None[GitLocation](), fmt.Errorf("malformed input from `git show --pretty='%%H %%at %%ct' HEAD`: %w", err)
-	}
-	committedTimestamp, err := strconv.ParseInt(fields[2], 10, 64)
-	if err != nil {
-		return None[GitLocation](), fmt.Errorf("malformed input from `git show --pretty='%%H %%at %%ct' HEAD`: %w", err)
+		return None[GitLocation](), fmt.Errorf("in Git repository at %s: %w", repo.WorktreePath, err)
 	}
 	result := GitLocation{
-		CommitID:    fields[0],
-		AuthoredAt:  Some(time.Unix(authoredTimestamp, 0)),
-		CommittedAt: Some(time.Unix(committedTimestamp, 0)),
+		CommitID:    headCommitID,
+		AuthoredAt:  Some(headCommit.AuthoredAt),
+		CommittedAt: Some(headCommit.CommittedAt),
 	}
 
-	// get name of branch containing HEAD commit (the "if upstream" match drops the "detached HEAD" line, if there is one)
-	outputFormat := "%(if)%(upstream)%(then)%(refname:short)%(end)"
-	out, err = execGitInPath(path, "branch", "--contains", "HEAD", "--format="+outputFormat, "--omit-empty")
+	// get tags pointing to HEAD commit
+	result.Tags, err = repo.FindTagsPointingAt(headCommitID)
 	if err != nil {
-		return None[GitLocation](), err
+		return None[GitLocation](), fmt.Errorf("in Git repository at %s: %w", repo.WorktreePath, err)
 	}
-	fields = strings.Fields(out)
-	if len(fields) != 0 {
-		result.BranchName = fields[0]
+
+	// get name of checked-out branch
+	if branchName, ok := strings.CutPrefix(headRefName, "refs/heads/"); ok {
+		result.BranchName = branchName
+	} else {
+		// if HEAD is detached (as is usual in CI checkouts), the CI system can usually tell us which branch is being built
+		result.BranchName = getBranchNameFromCIEnvironment(remoteName, result.Tags)
+	}
+	if result.BranchName == "" {
+		// otherwise, get name of branch containing HEAD commit
+		result.BranchName, err = repo.FindTrackingBranchContaining(headCommitID)
+		if err != nil {
+			return None[GitLocation](), fmt.Errorf("in Git repository at %s: %w", repo.WorktreePath, err)
+		}
 	}
 
 	// get path within working tree
-	out, err = execGitInPath(path, "rev-parse", "--show-prefix")
+	result.DirectoryPath, err = repo.RelativePath(path)
 	if err != nil {
 		return None[GitLocation](), err
 	}
-	out = strings.TrimSpace(out)
-	if out == "" {
-		result.DirectoryPath = ""
-	} else {
-		result.DirectoryPath = filepath.Clean(out)
+
+	// check for uncommitted changes within the directory (only those are relevant since only this directory gets bundled)
+	changes, err := repo.FindUncommittedChanges(headCommit.TreeID, result.DirectoryPath)
+	var statusErr gitStatusUnknownError
+	switch {
+	case errors.As(err, &statusErr):
+		if opts.RequireCleanWorktree {
+			return None[GitLocation](), fmt.Errorf("refusing to bundle %s since the Git worktree might have uncommitted changes in it: %w", path, err)
+		}
+		logg.Debug("recording Git location of %s without dirty state: %s", path, err.Error())
+	case err != nil:
+		return None[GitLocation](), fmt.Errorf("while checking for uncommitted changes in %s: %w", path, err)
+	case len(changes) > 0:
+		if opts.RequireCleanWorktree {
+			return None[GitLocation](), fmt.Errorf("refusing to bundle %s since the Git worktree has uncommitted changes in it: %s",
+				path, strings.Join(changes, ", "))
+		}
+		result.IsDirty = Some(true)
+	default:
+		result.IsDirty = Some(false)
 	}
 
-	// get repository URL from remote "origin"
+	// get repository URL from the selected remote (usually "origin")
 	//
 	// This fails if the remotes are set up differently, but if they are not,
 	// we do not have a good basis for choosing the main upstream URL anyway.
-	out, err = execGitInPath(path, "remote", "get-url", "origin")
-	if err != nil {
-		return None[GitLocation](), err
+	remoteURL := repo.Config.Get("remote." + remoteName + ".url")
+	if remoteURL == "" {
+		hint := ""
+		if repo.Config.HasIncludes() {
+			hint = " (if it is configured in an included file, please move it into .git/config since [include] and [includeIf] are not evaluated)"
+		}
+		return None[GitLocation](), fmt.Errorf("in Git repository at %s: no URL configured for remote %q%s", repo.WorktreePath, remoteName, hint)
 	}
-	result.RepositoryURL = strings.TrimSpace(out)
+	result.RepositoryURL = scrubCredentialsFromURL(repo.Config.RewriteURL(remoteURL))
 
 	return Some(result), nil
 }
 
-var errNotAGitRepository = errors.New("not a Git repository")
+// Environment variables in which CI systems report the branch that is being built, in order of preference.
+//
+// Names like $GIT_BRANCH or $BRANCH_NAME are generic enough that they might be set outside of CI for unrelated reasons,
+// so each variable is only consulted if the marker variable of the respective CI system is set.
+var ciBranchEnvVars = []struct {
+	MarkerName string
+	BranchName string
+}{
+	{"JENKINS_URL", "GIT_BRANCH"},                        // Jenkins (Git plugin)
+	{"JENKINS_URL", "CHANGE_BRANCH"},                     // Jenkins (multibranch pipelines, only for pull requests)
+	{"JENKINS_URL", "BRANCH_NAME"},                       // Jenkins (multibranch pipelines)
+	{"GITLAB_CI", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"}, // GitLab CI (only for merge requests)
+	{"GITLAB_CI", "CI_COMMIT_REF_NAME"},                  // GitLab CI
+	{"GITHUB_ACTIONS", "GITHUB_HEAD_REF"},                // GitHub Actions (only for pull requests)
+	{"GITHUB_ACTIONS", "GITHUB_REF"},                     // GitHub Actions
+	{"TF_BUILD", "BUILD_SOURCEBRANCH"},                   // Azure Pipelines
+	{"BUILDKITE", "BUILDKITE_BRANCH"},                    // Buildkite
+	{"CIRCLECI", "CIRCLE_BRANCH"},                        // CircleCI
+	{"TRAVIS", "TRAVIS_BRANCH"},                          // Travis CI
+	{"BITBUCKET_BUILD_NUMBER", "BITBUCKET_BRANCH"},       // Bitbucket Pipelines
+}
+
+// Returns the name of the branch being built according to the CI environment variables, or "" if none is set.
+// Values that refer to something other than a branch (e.g. a tag pointing at HEAD, or "refs/pull/123/merge") are ignored.
+func getBranchNameFromCIEnvironment(remoteName string, tags []string) string {
+	for _, envVar := range ciBranchEnvVars {
+		if os.Getenv(envVar.MarkerName) == "" {
+			continue
+		}
+		value := strings.TrimSpace(os.Getenv(envVar.BranchName))
+		if strings.HasPrefix(value, "refs/") {
+			var ok bool
+			value, ok = strings.CutPrefix(value, "refs/heads/")
+			if !ok {
+				continue
+			}
+		}
+		value = strings.TrimPrefix(value, remoteName+"/") // e.g. Jenkins reports "origin/main"
+		if value != "" && !slices.Contains(tags, value) {
+			return value
+		}
+	}
+	return ""
+}
 
-func execGitInPath(path string, args ...string) (string, error) {
-	cmd := exec.Command("git", append([]string{"-C", path}, args...)...) //nolint:gosec // args not controlled by user
-	var buf bytes.Buffer
-	cmd.Stderr = &buf
+// Matches the userinfo part of URLs that url.Parse() does not understand.
+var userinfoInURLRx = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://)[^/]*@`)
 
-	out, err := cmd.Output()
-	switch {
-	case err == nil:
-		return string(out), nil
-	case strings.Contains(buf.String(), "not a git repository"):
-		// This match is somewhat brittle, but I did not find a more resilient way.
-		// On the plus side, if it ever breaks, we should notice very immediately
-		// because the alternative is the loud error below.
-		return "", errNotAGitRepository
-	default:
-		os.Stderr.Write(buf.Bytes()) // forward error output from Git to our stderr
-		return "", fmt.Errorf(
-			"could not run `git -C %q %s`: %w (stdout was %q)",
-			path, strings.Join(args, " "), err, string(out),
-		)
+// Removes credentials from a remote URL before it is recorded in a label.
+// For HTTP(S) URLs, the entire userinfo is removed since access tokens are often given as the username.
+// For other URLs (e.g. "ssh://git@example.com/foo.git"), only the password is removed.
+// The scp-like syntax ("git@example.com:foo.git") never contains a password, so it is left unchanged.
+func scrubCredentialsFromURL(remoteURL string) string {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return userinfoInURLRx.ReplaceAllString(remoteURL, "$1")
+	}
+	if u.User == nil {
+		return remoteURL
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		u.User = nil
+	} else {
+		u.User = url.User(u.User.Username())
 	}
+	return u.String()
 }
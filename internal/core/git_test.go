@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import "testing"
+
+func TestGetBranchNameFromCIEnvironment(t *testing.T) {
+	// start from a clean environment, regardless of where this test runs
+	for _, envVar := range ciBranchEnvVars {
+		t.Setenv(envVar.MarkerName, "")
+		t.Setenv(envVar.BranchName, "")
+	}
+	tags := []string{"v1.2.3"}
+
+	// outside of CI, generic variable names are not trusted
+	t.Setenv("GIT_BRANCH", "origin/feature")
+	t.Setenv("BRANCH_NAME", "feature")
+	if actual := getBranchNameFromCIEnvironment("origin", tags); actual != "" {
+		t.Errorf("expected no branch name outside of CI, but got %q", actual)
+	}
+
+	// in Jenkins, the remote name is stripped from $GIT_BRANCH
+	t.Setenv("JENKINS_URL", "https://jenkins.example.org/")
+	if actual := getBranchNameFromCIEnvironment("origin", tags); actual != "feature" {
+		t.Errorf("expected branch name %q in Jenkins, but got %q", "feature", actual)
+	}
+	t.Setenv("JENKINS_URL", "")
+
+	// refs other than branches are ignored
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_REF", "refs/pull/123/merge")
+	if actual := getBranchNameFromCIEnvironment("origin", tags); actual != "" {
+		t.Errorf("expected no branch name for pull request ref, but got %q", actual)
+	}
+	t.Setenv("GITHUB_REF", "refs/heads/main")
+	if actual := getBranchNameFromCIEnvironment("origin", tags); actual != "main" {
+		t.Errorf("expected branch name %q in GitHub Actions, but got %q", "main", actual)
+	}
+	t.Setenv("GITHUB_ACTIONS", "")
+
+	// when building a tag, some CI systems report the tag name as the ref name
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("CI_COMMIT_REF_NAME", "v1.2.3")
+	if actual := getBranchNameFromCIEnvironment("origin", tags); actual != "" {
+		t.Errorf("expected no branch name when building a tag, but got %q", actual)
+	}
+}
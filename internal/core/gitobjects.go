@@ -0,0 +1,575 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "go.xyrillian.de/gg/option"
+)
+
+// gitObject is an object from the object database of a Git repository.
+type gitObject struct {
+	Type string // "commit", "tree", "blob" or "tag"
+	Data []byte
+}
+
+func (r *gitRepository) isObjectID(input string) bool {
+	if len(input) != 2*r.hashSize {
+		return false
+	}
+	_, err := hex.DecodeString(input)
+	return err == nil
+}
+
+// ReadObject reads an object from the repository's object database, either as a loose object or from a pack.
+// Ref: <https://git-scm.com/book/en/v2/Git-Internals-Git-Objects>
+func (r *gitRepository) ReadObject(objectID string) (gitObject, error) {
+	if !r.isObjectID(objectID) {
+		return gitObject{}, fmt.Errorf("malformed object ID: %q", objectID)
+	}
+
+	for _, objectDir := range r.objectDirs {
+		obj, exists, err := readLooseGitObject(filepath.Join(objectDir, objectID[:2], objectID[2:]))
+		if err != nil {
+			return gitObject{}, fmt.Errorf("while reading object %s: %w", objectID, err)
+		}
+		if exists {
+			return obj, nil
+		}
+	}
+
+	packs, err := r.getPacks()
+	if err != nil {
+		return gitObject{}, err
+	}
+	rawObjectID, err := hex.DecodeString(objectID)
+	if err != nil {
+		return gitObject{}, err
+	}
+	for _, pack := range packs {
+		offset, exists := pack.FindOffset(rawObjectID)
+		if exists {
+			obj, err := pack.ReadObjectAt(r, offset)
+			if err != nil {
+				return gitObject{}, fmt.Errorf("while reading object %s from %s: %w", objectID, pack.PackPath, err)
+			}
+			return obj, nil
+		}
+	}
+	return gitObject{}, fmt.Errorf("object %s not found in Git repository at %s", objectID, r.WorktreePath)
+}
+
+func readLooseGitObject(path string) (gitObject, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return gitObject{}, false, err
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return gitObject{}, false, err
+	}
+	buf, err := io.ReadAll(zr)
+	if err != nil {
+		return gitObject{}, false, err
+	}
+
+	// the object starts with a header like "blob 42\0"
+	header, data, ok := bytes.Cut(buf, []byte{0})
+	if !ok {
+		return gitObject{}, false, errors.New("malformed object header")
+	}
+	objectType, sizeStr, ok := strings.Cut(string(header), " ")
+	if !ok {
+		return gitObject{}, false, fmt.Errorf("malformed object header: %q", string(header))
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size != len(data) {
+		return gitObject{}, false, fmt.Errorf("object size does not match object header %q", string(header))
+	}
+	return gitObject{Type: objectType, Data: data}, true, nil
+}
+
+// Follows annotated tag objects until a non-tag object is reached, and returns the ID of that object.
+func (r *gitRepository) peelObject(objectID string) (string, error) {
+	for range 10 {
+		obj, err := r.ReadObject(objectID)
+		if err != nil {
+			return "", err
+		}
+		if obj.Type != "tag" {
+			return objectID, nil
+		}
+		// the first line of a tag object is "object <id>"
+		firstLine, _, _ := strings.Cut(string(obj.Data), "\n")
+		targetID, ok := strings.CutPrefix(firstLine, "object ")
+		if !ok {
+			return "", fmt.Errorf("malformed tag object %s", objectID)
+		}
+		objectID = targetID
+	}
+	return "", fmt.Errorf("too many levels of nested tags at %s", objectID)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// commits and trees
+
+// gitCommit contains the parts of a commit object that we need.
+type gitCommit struct {
+	TreeID      string
+	ParentIDs   []string
+	AuthoredAt  time.Time
+	CommittedAt time.Time
+}
+
+// ReadCommit reads and parses the commit object with the given ID.
+func (r *gitRepository) ReadCommit(commitID string) (gitCommit, error) {
+	if commit, ok := r.commitCache[commitID]; ok {
+		return commit, nil
+	}
+
+	obj, err := r.ReadObject(commitID)
+	if err != nil {
+		return gitCommit{}, err
+	}
+	if obj.Type != "commit" {
+		return gitCommit{}, fmt.Errorf("expected object %s to be a commit, but is a %s", commitID, obj.Type)
+	}
+
+	// the commit message is separated from the headers by an empty line
+	var commit gitCommit
+	headers, _, _ := strings.Cut(string(obj.Data), "\n\n")
+	for line := range strings.SplitSeq(headers, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			commit.TreeID = value
+		case "parent":
+			commit.ParentIDs = append(commit.ParentIDs, value)
+		case "author":
+			commit.AuthoredAt, err = parseGitSignatureTime(value)
+		case "committer":
+			commit.CommittedAt, err = parseGitSignatureTime(value)
+		}
+		if err != nil {
+			return gitCommit{}, fmt.Errorf("malformed %s line in commit %s: %w", key, commitID, err)
+		}
+	}
+	if commit.TreeID == "" {
+		return gitCommit{}, fmt.Errorf("malformed commit %s: no tree line", commitID)
+	}
+
+	r.commitCache[commitID] = commit
+	return commit, nil
+}
+
+// Parses the timestamp from a line like "author Jane Doe <jane@example.com> 1700000000 +0100".
+func parseGitSignatureTime(signature string) (time.Time, error) {
+	idx := strings.LastIndexByte(signature, '>')
+	fields := strings.Fields(signature[idx+1:])
+	if len(fields) == 0 {
+		return time.Time{}, fmt.Errorf("no timestamp in %q", signature)
+	}
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(timestamp, 0), nil
+}
+
+// gitTreeEntry is an entry in a tree object.
+type gitTreeEntry struct {
+	Mode     uint32 // e.g. 0100644 for a regular file, or 040000 for a directory
+	ObjectID string
+}
+
+// Parses the entries of a tree object, keyed by file name.
+func (r *gitRepository) readTree(treeID string) (map[string]gitTreeEntry, error) {
+	obj, err := r.ReadObject(treeID)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type != "tree" {
+		return nil, fmt.Errorf("expected object %s to be a tree, but is a %s", treeID, obj.Type)
+	}
+
+	// each entry is "<octal mode> <name>\0<raw object ID>"
+	result := make(map[string]gitTreeEntry)
+	data := obj.Data
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < r.hashSize {
+			return nil, fmt.Errorf("malformed tree object %s", treeID)
+		}
+		modeStr, name, ok := strings.Cut(string(header), " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed tree object %s", treeID)
+		}
+		mode, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tree object %s: %w", treeID, err)
+		}
+		result[name] = gitTreeEntry{
+			Mode:     uint32(mode),
+			ObjectID: hex.EncodeToString(rest[:r.hashSize]),
+		}
+		data = rest[r.hashSize:]
+	}
+	return result, nil
+}
+
+// Lists all non-tree entries below the given directory within the given tree, keyed by path relative to the tree's root.
+// If the directory does not exist in the tree, an empty result is returned.
+func (r *gitRepository) readTreeRecursively(treeID, dirPath string) (map[string]gitTreeEntry, error) {
+	// find the tree object for the directory
+	result := make(map[string]gitTreeEntry)
+	if dirPath != "" {
+		for name := range strings.SplitSeq(dirPath, "/") {
+			entries, err := r.readTree(treeID)
+			if err != nil {
+				return nil, err
+			}
+			entry, exists := entries[name]
+			if !exists || entry.Mode != 0o40000 {
+				return result, nil
+			}
+			treeID = entry.ObjectID
+		}
+	}
+
+	var collect func(treeID, pathPrefix string) error
+	collect = func(treeID, pathPrefix string) error {
+		entries, err := r.readTree(treeID)
+		if err != nil {
+			return err
+		}
+		for name, entry := range entries {
+			if entry.Mode == 0o40000 {
+				err := collect(entry.ObjectID, pathPrefix+name+"/")
+				if err != nil {
+					return err
+				}
+			} else {
+				result[pathPrefix+name] = entry
+			}
+		}
+		return nil
+	}
+
+	pathPrefix := ""
+	if dirPath != "" {
+		pathPrefix = dirPath + "/"
+	}
+	return result, collect(treeID, pathPrefix)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// packs
+
+// gitPack is a pack file, with the contents of its index loaded into memory.
+// Ref: <https://git-scm.com/docs/gitformat-pack>
+type gitPack struct {
+	PackPath     string
+	fanout       [256]uint32
+	objectIDs    []byte // the raw object IDs of all objects in the pack, concatenated and sorted
+	offsets      []byte // 4 bytes per object
+	largeOffsets []byte // 8 bytes per object that is beyond the 2 GiB mark
+	hashSize     int
+}
+
+func (r *gitRepository) getPacks() ([]*gitPack, error) {
+	if packs, ok := r.packs.Unpack(); ok {
+		return packs, nil
+	}
+
+	var packs []*gitPack
+	for _, objectDir := range r.objectDirs {
+		indexPaths, err := filepath.Glob(filepath.Join(objectDir, "pack", "*.idx"))
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(indexPaths)
+		for _, indexPath := range indexPaths {
+			pack, err := readGitPackIndex(indexPath, r.hashSize)
+			if err != nil {
+				return nil, fmt.Errorf("while reading %s: %w", indexPath, err)
+			}
+			packs = append(packs, pack)
+		}
+	}
+	r.packs = Some(packs)
+	return packs, nil
+}
+
+func readGitPackIndex(indexPath string, hashSize int) (*gitPack, error) {
+	buf, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 8+256*4 || !bytes.Equal(buf[0:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, errors.New("unsupported pack index format (only version 2 is supported)")
+	}
+
+	pack := &gitPack{
+		PackPath: strings.TrimSuffix(indexPath, ".idx") + ".pack",
+		hashSize: hashSize,
+	}
+	for idx := range pack.fanout {
+		pack.fanout[idx] = binary.BigEndian.Uint32(buf[8+4*idx:])
+	}
+	count := int(pack.fanout[255])
+
+	// after the fanout table, there are the object IDs, then the CRC32 checksums, then the offsets
+	pos := 8 + 256*4
+	if len(buf) < pos+count*(hashSize+4+4)+2*hashSize {
+		return nil, errors.New("unexpected end of file")
+	}
+	pack.objectIDs = buf[pos : pos+count*hashSize]
+	pos += count*hashSize + count*4
+	pack.offsets = buf[pos : pos+count*4]
+	pos += count * 4
+	pack.largeOffsets = buf[pos : len(buf)-2*hashSize]
+	return pack, nil
+}
+
+// FindOffset returns the offset of the given object within the pack file, if the object is in the pack.
+func (p *gitPack) FindOffset(rawObjectID []byte) (int64, bool) {
+	start := 0
+	if rawObjectID[0] > 0 {
+		start = int(p.fanout[rawObjectID[0]-1])
+	}
+	end := int(p.fanout[rawObjectID[0]])
+	idx := start + sort.Search(end-start, func(idx int) bool {
+		offset := (start + idx) * p.hashSize
+		return bytes.Compare(p.objectIDs[offset:offset+p.hashSize], rawObjectID) >= 0
+	})
+	if idx >= end || !bytes.Equal(p.objectIDs[idx*p.hashSize:(idx+1)*p.hashSize], rawObjectID) {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(p.offsets[4*idx:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	largeIdx := int(offset & 0x7fffffff)
+	if len(p.largeOffsets) < 8*(largeIdx+1) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.largeOffsets[8*largeIdx:])), true //nolint:gosec // pack files larger than 8 EiB are not a concern
+}
+
+// ReadObjectAt reads the object at the given offset in the pack file.
+func (p *gitPack) ReadObjectAt(repo *gitRepository, offset int64) (gitObject, error) {
+	f, err := os.Open(p.PackPath)
+	if err != nil {
+		return gitObject{}, err
+	}
+	defer f.Close()
+	return p.readObjectFromFile(repo, f, offset, 0)
+}
+
+// Type names for the object type numbers used in pack files.
+var gitPackObjectTypes = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+func (p *gitPack) readObjectFromFile(repo *gitRepository, f *os.File, offset int64, depth int) (gitObject, error) {
+	if depth > 100 {
+		return gitObject{}, errors.New("delta chain is too long")
+	}
+
+	// the object header contains the type and the size in a variable-length encoding, and the delta base for delta objects
+	var header [64]byte
+	n, err := f.ReadAt(header[:], offset)
+	if err != nil && !(errors.Is(err, io.EOF) && n > 0) {
+		return gitObject{}, err
+	}
+	pos := 0
+	nextByte := func() (byte, error) {
+		if pos >= n {
+			return 0, fmt.Errorf("malformed object header at offset %d", offset)
+		}
+		pos++
+		return header[pos-1], nil
+	}
+
+	c, err := nextByte()
+	if err != nil {
+		return gitObject{}, err
+	}
+	objectType := (c >> 4) & 7
+	size := uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		c, err = nextByte()
+		if err != nil {
+			return gitObject{}, err
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+
+	var (
+		baseOffset   int64
+		baseObjectID string
+	)
+	switch objectType {
+	case 6: // OBJ_OFS_DELTA
+		var distance uint64
+		distance, pos, err = readGitOffsetVarint(header[:n], pos)
+		if err != nil {
+			return gitObject{}, fmt.Errorf("malformed delta base offset at offset %d", offset)
+		}
+		baseOffset = offset - int64(distance) //nolint:gosec // overflow is caught by the range check below
+		if baseOffset < 0 || baseOffset >= offset {
+			return gitObject{}, fmt.Errorf("invalid delta base offset at offset %d", offset)
+		}
+	case 7: // OBJ_REF_DELTA
+		if pos+p.hashSize > n {
+			return gitObject{}, fmt.Errorf("malformed object header at offset %d", offset)
+		}
+		baseObjectID = hex.EncodeToString(header[pos : pos+p.hashSize])
+		pos += p.hashSize
+	}
+
+	zr, err := zlib.NewReader(io.NewSectionReader(f, offset+int64(pos), 1<<62))
+	if err != nil {
+		return gitObject{}, err
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(zr, data)
+	if err != nil {
+		return gitObject{}, fmt.Errorf("while decompressing object at offset %d: %w", offset, err)
+	}
+
+	var base gitObject
+	switch objectType {
+	case 1, 2, 3, 4:
+		return gitObject{Type: gitPackObjectTypes[objectType], Data: data}, nil
+	case 6:
+		base, err = p.readObjectFromFile(repo, f, baseOffset, depth+1)
+	case 7:
+		base, err = repo.ReadObject(baseObjectID)
+	default:
+		return gitObject{}, fmt.Errorf("unknown object type %d at offset %d", objectType, offset)
+	}
+	if err != nil {
+		return gitObject{}, err
+	}
+	result, err := applyGitDelta(base.Data, data)
+	if err != nil {
+		return gitObject{}, fmt.Errorf("while applying delta at offset %d: %w", offset, err)
+	}
+	return gitObject{Type: base.Type, Data: result}, nil
+}
+
+// Decodes the variable-length integer encoding that is used for delta base offsets in packs, and for path prefixes in index v4.
+// Returns the value and the position after it.
+func readGitOffsetVarint(buf []byte, pos int) (uint64, int, error) {
+	if pos >= len(buf) {
+		return 0, pos, io.ErrUnexpectedEOF
+	}
+	c := buf[pos]
+	pos++
+	value := uint64(c & 0x7f)
+	for c&0x80 != 0 {
+		if pos >= len(buf) {
+			return 0, pos, io.ErrUnexpectedEOF
+		}
+		c = buf[pos]
+		pos++
+		value = ((value + 1) << 7) | uint64(c&0x7f)
+	}
+	return value, pos, nil
+}
+
+// Reconstructs an object from its delta base and a delta.
+// Ref: <https://git-scm.com/docs/gitformat-pack#_deltified_representation>
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	readSize := func() (uint64, error) {
+		var size uint64
+		for shift := 0; ; shift += 7 {
+			if len(delta) == 0 || shift > 63 {
+				return 0, errors.New("malformed size in delta header")
+			}
+			c := delta[0]
+			delta = delta[1:]
+			size |= uint64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return size, nil
+			}
+		}
+	}
+	baseSize, err := readSize()
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("expected delta base of size %d, but got size %d", baseSize, len(base))
+	}
+	resultSize, err := readSize()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, resultSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// copy from base: the lower 4 bits select which offset bytes follow, the next 3 bits select which size bytes follow
+			var offset, size uint64
+			for idx := range 7 {
+				if op&(1<<idx) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("unexpected end of delta")
+				}
+				if idx < 4 {
+					offset |= uint64(delta[0]) << (8 * idx)
+				} else {
+					size |= uint64(delta[0]) << (8 * (idx - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, errors.New("delta copies from beyond the end of the base object")
+			}
+			result = append(result, base[offset:offset+size]...)
+		case op != 0:
+			// insert the next `op` bytes from the delta
+			if int(op) > len(delta) {
+				return nil, errors.New("unexpected end of delta")
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("invalid opcode 0 in delta")
+		}
+	}
+	if uint64(len(result)) != resultSize {
+		return nil, fmt.Errorf("expected delta result of size %d, but got size %d", resultSize, len(result))
+	}
+	return result, nil
+}
@@ -0,0 +1,782 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "go.xyrillian.de/gg/option"
+)
+
+// gitRepository provides read-only access to a Git repository by reading the files below `.git` directly.
+// This is used by TryGetGitLocation(), so that bundling does not require the `git` command.
+//
+// Only the parts of the repository format that we need are supported: loose and packed refs,
+// loose and packed objects, the config (without following [include] and [includeIf] sections), the index, and gitignore files.
+// Ref: <https://git-scm.com/docs/gitrepository-layout>
+type gitRepository struct {
+	// The root directory of the working tree.
+	WorktreePath string
+	// The Git directory of this working tree (usually "$WorktreePath/.git").
+	// For linked worktrees and submodules, this is a directory within the Git directory of the main repository instead.
+	GitDir string
+	// The Git directory containing the objects, refs and config that are shared between all worktrees.
+	// This is the same as GitDir, except for linked worktrees.
+	CommonDir string
+	// The merged configuration from the system-wide, user-wide and repository-specific config files.
+	Config gitConfig
+
+	hashSize   int // in bytes (20 for SHA-1, 32 for SHA-256)
+	objectDirs []string
+	packs      Option[[]*gitPack]
+	packedRefs Option[gitPackedRefs]
+	// The commits listed in `.git/shallow` (if this is a shallow clone).
+	shallowCommits Option[map[string]bool]
+	commitCache    map[string]gitCommit
+}
+
+// Finds the Git repository that the given directory belongs to by looking for `.git` in it and in all its parent directories.
+// Returns None if the directory is not inside the working tree of a Git repository.
+func openGitRepository(path string) (Option[*gitRepository], error) {
+	dirPath, err := filepath.Abs(path)
+	if err != nil {
+		return None[*gitRepository](), err
+	}
+	dirPath, err = filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		return None[*gitRepository](), err
+	}
+
+	for {
+		dotGitPath := filepath.Join(dirPath, ".git")
+		fi, err := os.Stat(dotGitPath)
+		switch {
+		case err == nil:
+			repo, err := newGitRepository(dirPath, dotGitPath, fi.IsDir())
+			if err != nil {
+				return None[*gitRepository](), fmt.Errorf("while reading Git repository at %s: %w", dirPath, err)
+			}
+			return Some(repo), nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return None[*gitRepository](), err
+		}
+
+		parentPath := filepath.Dir(dirPath)
+		if parentPath == dirPath {
+			return None[*gitRepository](), nil // reached the root directory without finding a repository
+		}
+		dirPath = parentPath
+	}
+}
+
+func newGitRepository(worktreePath, dotGitPath string, isDir bool) (*gitRepository, error) {
+	// in linked worktrees and submodules, `.git` is a file like "gitdir: ../.git/modules/foo"
+	gitDir := dotGitPath
+	if !isDir {
+		buf, err := os.ReadFile(dotGitPath)
+		if err != nil {
+			return nil, err
+		}
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(buf)), "gitdir:")
+		if !ok {
+			return nil, fmt.Errorf("invalid format in %s (expected \"gitdir: <path>\")", dotGitPath)
+		}
+		gitDir = resolvePathRelativeTo(worktreePath, strings.TrimSpace(target))
+	}
+	_, err := os.Stat(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return nil, fmt.Errorf("%s does not look like a Git directory: %w", gitDir, err)
+	}
+
+	// in linked worktrees, most things are shared with the main repository
+	commonDir := gitDir
+	buf, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	switch {
+	case err == nil:
+		commonDir = resolvePathRelativeTo(gitDir, strings.TrimSpace(string(buf)))
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	cfg, err := readGitConfig(commonDir, gitDir)
+	if err != nil {
+		return nil, err
+	}
+	repo := &gitRepository{
+		WorktreePath: worktreePath,
+		GitDir:       gitDir,
+		CommonDir:    commonDir,
+		Config:       cfg,
+		commitCache:  make(map[string]gitCommit),
+	}
+
+	switch format := strings.ToLower(cfg.Get("extensions.objectformat")); format {
+	case "", "sha1":
+		repo.hashSize = 20
+	case "sha256":
+		repo.hashSize = 32
+	default:
+		return nil, fmt.Errorf("unsupported object format %q", format)
+	}
+	if storage := strings.ToLower(cfg.Get("extensions.refstorage")); storage != "" && storage != "files" {
+		return nil, fmt.Errorf("unsupported ref storage format %q", storage)
+	}
+
+	repo.objectDirs, err = collectGitObjectDirs(filepath.Join(commonDir, "objects"), 0)
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Returns the given object directory, followed by all alternate object directories that it refers to.
+// Ref: <https://git-scm.com/docs/gitrepository-layout#Documentation/gitrepository-layout.txt-objectsinfoalternates>
+func collectGitObjectDirs(objectDir string, depth int) ([]string, error) {
+	result := []string{objectDir}
+	if depth >= 5 {
+		return result, nil // same limit as in Git itself
+	}
+	buf, err := os.ReadFile(filepath.Join(objectDir, "info", "alternates"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return result, err
+	}
+	for line := range strings.SplitSeq(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		moreDirs, err := collectGitObjectDirs(resolvePathRelativeTo(objectDir, line), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, moreDirs...)
+	}
+	return result, nil
+}
+
+func resolvePathRelativeTo(basePath, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(basePath, path)
+}
+
+// RelativePath returns the path of the given directory relative to the root of the working tree, with slashes as separators.
+// For the root directory itself, "" is returned.
+func (r *gitRepository) RelativePath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	absPath, err = filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(r.WorktreePath, absPath)
+	if err != nil {
+		return "", err
+	}
+	if relPath == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// config
+
+// gitConfig holds the values from Git config files.
+// Keys are of the form "section.name" or "section.subsection.name", where section and name are lowercase.
+// Since keys can be given multiple times, each key can have multiple values, with the last one taking precedence.
+type gitConfig map[string][]string
+
+// Reads the config files that apply to a repository, in the order of increasing precedence.
+// Ref: <https://git-scm.com/docs/git-config#FILES>
+func readGitConfig(commonDir, gitDir string) (gitConfig, error) {
+	var paths []string
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		paths = append(paths, "/etc/gitconfig")
+	}
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		paths = append(paths, path)
+	} else {
+		paths = append(paths, filepath.Join(getXDGConfigHome(), "git", "config"))
+		homeDir, err := os.UserHomeDir()
+		if err == nil {
+			paths = append(paths, filepath.Join(homeDir, ".gitconfig"))
+		}
+	}
+	paths = append(paths, filepath.Join(commonDir, "config"))
+
+	cfg := make(gitConfig)
+	for _, path := range paths {
+		err := cfg.readFileIfExists(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.GetBool("extensions.worktreeconfig", false) {
+		err := cfg.readFileIfExists(filepath.Join(gitDir, "config.worktree"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func getXDGConfigHome() string {
+	if path := os.Getenv("XDG_CONFIG_HOME"); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".config")
+}
+
+func (cfg gitConfig) readFileIfExists(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	err = parseGitConfig(string(buf), func(key, value string) error {
+		cfg[key] = append(cfg[key], value)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("while parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Parses the contents of a config file, and calls the given function for each value in order.
+// Ref: <https://git-scm.com/docs/git-config#_syntax>
+func parseGitConfig(input string, addValue func(key, value string) error) error {
+	section := ""
+	pos := 0
+	for pos < len(input) {
+		switch c := input[pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			pos++
+		case c == '#' || c == ';':
+			pos = skipToNextLine(input, pos)
+		case c == '[':
+			end := strings.IndexByte(input[pos:], ']')
+			if end < 0 {
+				return fmt.Errorf("unterminated section header: %q", strings.TrimSpace(input[pos:skipToNextLine(input, pos)]))
+			}
+			var err error
+			section, err = parseGitConfigSectionHeader(input[pos+1 : pos+end])
+			if err != nil {
+				return err
+			}
+			pos += end + 1
+		default:
+			start := pos
+			for pos < len(input) && (isASCIIAlphanumeric(input[pos]) || input[pos] == '-') {
+				pos++
+			}
+			if pos == start || section == "" {
+				return fmt.Errorf("invalid line: %q", strings.TrimSpace(input[start:skipToNextLine(input, start)]))
+			}
+			key := section + "." + strings.ToLower(input[start:pos])
+
+			for pos < len(input) && (input[pos] == ' ' || input[pos] == '\t') {
+				pos++
+			}
+			if pos < len(input) && input[pos] == '=' {
+				var (
+					value string
+					err   error
+				)
+				value, pos, err = parseGitConfigValue(input, pos+1)
+				if err != nil {
+					return fmt.Errorf("invalid value for %s: %w", key, err)
+				}
+				err = addValue(key, value)
+				if err != nil {
+					return err
+				}
+			} else {
+				// a key without value is a boolean true
+				err := addValue(key, "true")
+				if err != nil {
+					return err
+				}
+				pos = skipToNextLine(input, pos)
+			}
+		}
+	}
+	return nil
+}
+
+// Parses a header like `core` or `remote "origin"` into "core" or "remote.origin", respectively.
+func parseGitConfigSectionHeader(header string) (string, error) {
+	name, subsection, hasSubsection := strings.Cut(header, "\"")
+	if !hasSubsection {
+		// this includes the deprecated syntax `[section.subsection]`, where the subsection is case-insensitive
+		return strings.ToLower(strings.TrimSpace(header)), nil
+	}
+
+	var buf strings.Builder
+	for idx := 0; idx < len(subsection); idx++ {
+		switch subsection[idx] {
+		case '"':
+			if strings.TrimSpace(subsection[idx+1:]) != "" {
+				return "", fmt.Errorf("invalid section header: %q", header)
+			}
+			return strings.ToLower(strings.TrimSpace(name)) + "." + buf.String(), nil
+		case '\\':
+			idx++
+			if idx < len(subsection) {
+				buf.WriteByte(subsection[idx])
+			}
+		default:
+			buf.WriteByte(subsection[idx])
+		}
+	}
+	return "", fmt.Errorf("invalid section header: %q", header)
+}
+
+// Parses a value starting at input[pos], until the end of the line (or of the last continuation line).
+// Returns the value and the position after it.
+func parseGitConfigValue(input string, pos int) (string, int, error) {
+	var (
+		buf             strings.Builder
+		pendingSpace    strings.Builder // whitespace is only kept if it is not at the start or end of the value
+		isQuoted        bool
+		hasStartedValue bool
+	)
+	flushSpace := func() {
+		if hasStartedValue {
+			buf.WriteString(pendingSpace.String())
+		}
+		pendingSpace.Reset()
+		hasStartedValue = true
+	}
+
+	for pos < len(input) {
+		c := input[pos]
+		switch {
+		case c == '\n':
+			if isQuoted {
+				return "", pos, errors.New("unterminated quote")
+			}
+			return buf.String(), pos + 1, nil
+		case (c == '#' || c == ';') && !isQuoted:
+			return buf.String(), skipToNextLine(input, pos), nil
+		case c == '"':
+			flushSpace()
+			isQuoted = !isQuoted
+		case c == '\\':
+			pos++
+			if pos >= len(input) {
+				return "", pos, errors.New("unexpected end of input after backslash")
+			}
+			switch input[pos] {
+			case '\n':
+				// line continuation
+			case '\r':
+				if pos+1 < len(input) && input[pos+1] == '\n' {
+					pos++ // line continuation with CRLF line endings
+				}
+			case 'n':
+				flushSpace()
+				buf.WriteByte('\n')
+			case 't':
+				flushSpace()
+				buf.WriteByte('\t')
+			case 'b':
+				flushSpace()
+				buf.WriteByte('\b')
+			case '\\', '"':
+				flushSpace()
+				buf.WriteByte(input[pos])
+			default:
+				return "", pos, fmt.Errorf("invalid escape sequence \"\\%c\"", input[pos])
+			}
+		case (c == ' ' || c == '\t' || c == '\r') && !isQuoted:
+			pendingSpace.WriteByte(c)
+		default:
+			flushSpace()
+			buf.WriteByte(c)
+		}
+		pos++
+	}
+	if isQuoted {
+		return "", pos, errors.New("unterminated quote")
+	}
+	return buf.String(), pos, nil
+}
+
+func skipToNextLine(input string, pos int) int {
+	idx := strings.IndexByte(input[pos:], '\n')
+	if idx < 0 {
+		return len(input)
+	}
+	return pos + idx + 1
+}
+
+func isASCIIAlphanumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Get returns the last value for the given key, or "" if there is none.
+func (cfg gitConfig) Get(key string) string {
+	values := cfg[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// HasIncludes returns whether the config contains [include] or [includeIf] sections.
+// These are not followed, so values from the included files are missing from the config.
+func (cfg gitConfig) HasIncludes() bool {
+	for key := range cfg {
+		if key == "include.path" || (strings.HasPrefix(key, "includeif.") && strings.HasSuffix(key, ".path")) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBool returns the last value for the given key as a boolean, or the given default value if there is none.
+func (cfg gitConfig) GetBool(key string, defaultValue bool) bool {
+	if len(cfg[key]) == 0 {
+		return defaultValue
+	}
+	switch strings.ToLower(cfg.Get(key)) {
+	case "false", "no", "off", "0", "":
+		return false
+	default:
+		return true
+	}
+}
+
+// RewriteURL applies the "url.<base>.insteadOf" rules from the config to the given URL, like `git remote get-url` does.
+func (cfg gitConfig) RewriteURL(url string) string {
+	var bestBase, bestPrefix string
+	for _, key := range slices.Sorted(maps.Keys(cfg)) {
+		base, ok := strings.CutPrefix(key, "url.")
+		if !ok {
+			continue
+		}
+		base, ok = strings.CutSuffix(base, ".insteadof")
+		if !ok {
+			continue
+		}
+		for _, prefix := range cfg[key] {
+			if strings.HasPrefix(url, prefix) && len(prefix) > len(bestPrefix) {
+				bestBase, bestPrefix = base, prefix
+			}
+		}
+	}
+	if bestPrefix == "" {
+		return url
+	}
+	return bestBase + strings.TrimPrefix(url, bestPrefix)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// refs
+
+// gitPackedRefs holds the contents of the `packed-refs` file.
+type gitPackedRefs struct {
+	ObjectIDs map[string]string // key = ref name
+	// For annotated tags, the ID of the object that the tag object points to (if recorded in the file).
+	PeeledObjectIDs map[string]string
+	// Whether PeeledObjectIDs is guaranteed to contain an entry for each annotated tag.
+	IsFullyPeeled bool
+}
+
+func (r *gitRepository) readPackedRefs() (gitPackedRefs, error) {
+	if packedRefs, ok := r.packedRefs.Unpack(); ok {
+		return packedRefs, nil
+	}
+
+	packedRefs := gitPackedRefs{
+		ObjectIDs:       make(map[string]string),
+		PeeledObjectIDs: make(map[string]string),
+	}
+	buf, err := os.ReadFile(filepath.Join(r.CommonDir, "packed-refs"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return gitPackedRefs{}, err
+	}
+
+	lastRefName := ""
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			// the header line lists traits like "# pack-refs with: peeled fully-peeled sorted"
+			if traits, ok := strings.CutPrefix(line, "# pack-refs with:"); ok {
+				packedRefs.IsFullyPeeled = slices.Contains(strings.Fields(traits), "fully-peeled")
+			}
+		case strings.HasPrefix(line, "^"):
+			if lastRefName == "" {
+				return gitPackedRefs{}, fmt.Errorf("malformed line in %s: %q", filepath.Join(r.CommonDir, "packed-refs"), line)
+			}
+			packedRefs.PeeledObjectIDs[lastRefName] = strings.TrimPrefix(line, "^")
+		default:
+			objectID, refName, ok := strings.Cut(line, " ")
+			if !ok || !r.isObjectID(objectID) {
+				return gitPackedRefs{}, fmt.Errorf("malformed line in %s: %q", filepath.Join(r.CommonDir, "packed-refs"), line)
+			}
+			packedRefs.ObjectIDs[refName] = objectID
+			lastRefName = refName
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return gitPackedRefs{}, err
+	}
+
+	r.packedRefs = Some(packedRefs)
+	return packedRefs, nil
+}
+
+// Returns the path where the given ref is stored as a loose ref.
+// Pseudo-refs like HEAD and some special refs are per-worktree, all others are shared between all worktrees.
+// Ref: <https://git-scm.com/docs/git-worktree#_refs>
+func (r *gitRepository) looseRefPath(refName string) string {
+	isPerWorktree := !strings.HasPrefix(refName, "refs/") ||
+		strings.HasPrefix(refName, "refs/bisect/") ||
+		strings.HasPrefix(refName, "refs/worktree/") ||
+		strings.HasPrefix(refName, "refs/rewritten/")
+	if isPerWorktree {
+		return filepath.Join(r.GitDir, filepath.FromSlash(refName))
+	}
+	return filepath.Join(r.CommonDir, filepath.FromSlash(refName))
+}
+
+// Returns the contents of the given ref without following symbolic refs,
+// i.e. either an object ID or a string like "ref: refs/heads/main". Returns "" if the ref does not exist.
+func (r *gitRepository) readRef(refName string) (string, error) {
+	buf, err := os.ReadFile(r.looseRefPath(refName))
+	if err == nil {
+		return strings.TrimSpace(string(buf)), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		fi, statErr := os.Stat(r.looseRefPath(refName))
+		if statErr != nil || !fi.IsDir() {
+			return "", err
+		}
+		// e.g. when looking for "refs/heads/foo" while "refs/heads/foo/bar" exists
+	}
+
+	packedRefs, err := r.readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+	return packedRefs.ObjectIDs[refName], nil
+}
+
+// ResolveRef follows the given ref (through any symbolic refs) to an object ID.
+// Returns the name of the last ref in the chain, and the object ID (or "" if the last ref does not exist, e.g. on an unborn branch).
+func (r *gitRepository) ResolveRef(refName string) (targetRefName, objectID string, err error) {
+	for range 5 { // same limit as in Git itself
+		content, err := r.readRef(refName)
+		if err != nil {
+			return "", "", fmt.Errorf("while reading ref %s: %w", refName, err)
+		}
+		target, isSymbolic := strings.CutPrefix(content, "ref:")
+		if !isSymbolic {
+			if content != "" && !r.isObjectID(content) {
+				return "", "", fmt.Errorf("while reading ref %s: malformed object ID %q", refName, content)
+			}
+			return refName, content, nil
+		}
+		refName = strings.TrimSpace(target)
+	}
+	return "", "", fmt.Errorf("while reading ref %s: too many levels of symbolic refs", refName)
+}
+
+// Returns the object IDs of all refs whose names start with the given prefix (e.g. "refs/tags/").
+func (r *gitRepository) listRefs(prefix string) (map[string]string, error) {
+	packedRefs, err := r.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for refName, objectID := range packedRefs.ObjectIDs {
+		if strings.HasPrefix(refName, prefix) {
+			result[refName] = objectID
+		}
+	}
+
+	// loose refs take precedence over packed refs
+	baseDir := filepath.Join(r.CommonDir, filepath.FromSlash(prefix))
+	err = filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == baseDir {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return err
+		}
+		_, objectID, err := r.ResolveRef(prefix + filepath.ToSlash(relPath))
+		if err != nil {
+			return err
+		}
+		if objectID != "" {
+			result[prefix+filepath.ToSlash(relPath)] = objectID
+		}
+		return nil
+	})
+	return result, err
+}
+
+// FindTagsPointingAt returns the names of all tags that point to the given commit, either directly or via annotated tag objects.
+// This is similar to `git tag --points-at <commit>`.
+func (r *gitRepository) FindTagsPointingAt(commitID string) ([]string, error) {
+	refs, err := r.listRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	packedRefs, err := r.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, refName := range slices.Sorted(maps.Keys(refs)) {
+		objectID := refs[refName]
+		if objectID != commitID {
+			switch {
+			case packedRefs.ObjectIDs[refName] != objectID:
+				// not from packed-refs, so we need to look at the object itself
+				objectID, err = r.peelObject(objectID)
+				if err != nil {
+					return nil, fmt.Errorf("while reading %s: %w", refName, err)
+				}
+			case packedRefs.PeeledObjectIDs[refName] != "":
+				objectID = packedRefs.PeeledObjectIDs[refName]
+			case !packedRefs.IsFullyPeeled:
+				objectID, err = r.peelObject(objectID)
+				if err != nil {
+					return nil, fmt.Errorf("while reading %s: %w", refName, err)
+				}
+			}
+		}
+		if objectID == commitID {
+			result = append(result, strings.TrimPrefix(refName, "refs/tags/"))
+		}
+	}
+	return result, nil
+}
+
+// FindTrackingBranchContaining returns the name of the first local branch (in alphabetical order)
+// that has an upstream branch configured and contains the given commit, or "" if there is no such branch.
+// This is similar to the first line of `git branch --contains <commit> --format='%(if)%(upstream)%(then)%(refname:short)%(end)'`.
+func (r *gitRepository) FindTrackingBranchContaining(commitID string) (string, error) {
+	refs, err := r.listRefs("refs/heads/")
+	if err != nil {
+		return "", err
+	}
+	for _, refName := range slices.Sorted(maps.Keys(refs)) {
+		branchName := strings.TrimPrefix(refName, "refs/heads/")
+		if r.Config.Get("branch."+branchName+".merge") == "" {
+			continue
+		}
+		isContained, err := r.isAncestor(commitID, refs[refName])
+		if err != nil {
+			return "", fmt.Errorf("while inspecting history of branch %q: %w", branchName, err)
+		}
+		if isContained {
+			return branchName, nil
+		}
+	}
+	return "", nil
+}
+
+// Checks whether the commit with the ID `ancestorID` is reachable from the commit with the ID `tipID`.
+//
+// In shallow clones, the history is not followed beyond the commits listed in `.git/shallow`,
+// since their parents are not present in the repository. This matches how Git itself treats shallow clones.
+func (r *gitRepository) isAncestor(ancestorID, tipID string) (bool, error) {
+	isShallow, err := r.readShallowCommits()
+	if err != nil {
+		return false, err
+	}
+
+	queue := []string{tipID}
+	isVisited := map[string]bool{tipID: true}
+	for len(queue) > 0 {
+		commitID := queue[0]
+		queue = queue[1:]
+		if commitID == ancestorID {
+			return true, nil
+		}
+		if isShallow[commitID] {
+			continue
+		}
+
+		commit, err := r.ReadCommit(commitID)
+		if err != nil {
+			return false, err
+		}
+		for _, parentID := range commit.ParentIDs {
+			if !isVisited[parentID] {
+				isVisited[parentID] = true
+				queue = append(queue, parentID)
+			}
+		}
+	}
+	return false, nil
+}
+
+// Returns the set of commits that form the boundary of a shallow clone, i.e. whose parents are not present in the repository.
+// For a complete clone, this set is empty.
+// Ref: <https://git-scm.com/docs/gitrepository-layout#Documentation/gitrepository-layout.txt-shallow>
+func (r *gitRepository) readShallowCommits() (map[string]bool, error) {
+	if isShallow, ok := r.shallowCommits.Unpack(); ok {
+		return isShallow, nil
+	}
+
+	isShallow := make(map[string]bool)
+	filePath := filepath.Join(r.CommonDir, "shallow")
+	buf, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for line := range strings.SplitSeq(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !r.isObjectID(line) {
+			return nil, fmt.Errorf("malformed line in %s: %q", filePath, line)
+		}
+		isShallow[line] = true
+	}
+
+	r.shallowCommits = Some(isShallow)
+	return isShallow, nil
+}
@@ -0,0 +1,672 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // not used for security, but because Git uses it for object IDs
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FindUncommittedChanges lists all uncommitted changes (including untracked files that are not ignored) below the given directory,
+// compared to the tree of the HEAD commit. The directory is given relative to the root of the working tree, like from RelativePath().
+// Each change is reported in the style of `git status --porcelain`, e.g. "M foo/values.yaml" or "?? foo/new.txt".
+//
+// Content conversions from gitattributes or core.autocrlf (e.g. for line endings or Git LFS) are not replicated,
+// and neither are [include] and [includeIf] sections in the Git config. If these could affect the result,
+// and no changes were found otherwise, a gitStatusUnknownError is returned. Submodules are only checked for whether they exist.
+func (r *gitRepository) FindUncommittedChanges(headTreeID, dirPath string) ([]string, error) {
+	// included config files could change any of the settings that we look at (e.g. core.filemode, core.excludesFile or filters)
+	if r.Config.HasIncludes() {
+		return nil, gitStatusUnknownError{"the Git config contains [include] or [includeIf] sections, which are not evaluated"}
+	}
+
+	pathPrefix := ""
+	if dirPath != "" {
+		pathPrefix = dirPath + "/"
+	}
+	index, err := r.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	headEntries, err := r.readTreeRecursively(headTreeID, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// each changed path gets a status code like "M " for staged changes or " M" for unstaged changes
+	statusCodes := make(map[string][2]byte)
+	mark := func(path string, codeIdx int, code byte) {
+		status, exists := statusCodes[path]
+		if !exists {
+			status = [2]byte{' ', ' '}
+		}
+		status[codeIdx] = code
+		statusCodes[path] = status
+	}
+
+	conversions, err := r.newGitConversionChecker()
+	if err != nil {
+		return nil, err
+	}
+	var unknownErr error // the first file that we could not check (if any)
+
+	isTracked := make(map[string]bool)
+	for _, entry := range index.Entries {
+		if !strings.HasPrefix(entry.Path, pathPrefix) {
+			continue
+		}
+		isTracked[entry.Path] = true
+		if entry.Stage != 0 {
+			// unresolved merge conflict
+			statusCodes[entry.Path] = [2]byte{'U', 'U'}
+			continue
+		}
+
+		// compare index with HEAD
+		headEntry, exists := headEntries[entry.Path]
+		switch {
+		case !exists:
+			mark(entry.Path, 0, 'A')
+		case headEntry.Mode != entry.Mode || headEntry.ObjectID != entry.ObjectID:
+			mark(entry.Path, 0, 'M')
+		}
+
+		// compare working tree with index
+		if entry.IsSkipWorktree || entry.Mode == 0o160000 {
+			continue
+		}
+		isModified, err := r.isModifiedInWorktree(entry, index.ModTime, conversions)
+		var statusErr gitStatusUnknownError
+		switch {
+		case errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR):
+			mark(entry.Path, 1, 'D')
+		case errors.As(err, &statusErr):
+			if unknownErr == nil {
+				unknownErr = statusErr
+			}
+		case err != nil:
+			return nil, err
+		case isModified:
+			mark(entry.Path, 1, 'M')
+		}
+	}
+	for entryPath := range headEntries {
+		if !isTracked[entryPath] {
+			mark(entryPath, 0, 'D')
+		}
+	}
+
+	// look for untracked files
+	err = r.findUntrackedFiles(dirPath, isTracked, func(path string) {
+		statusCodes[path] = [2]byte{'?', '?'}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// if there are changes, it does not matter whether some other files might also have changed
+	if len(statusCodes) == 0 && unknownErr != nil {
+		return nil, unknownErr
+	}
+	var result []string
+	for _, path := range slices.Sorted(maps.Keys(statusCodes)) {
+		status := statusCodes[path]
+		result = append(result, strings.TrimSpace(string(status[:]))+" "+path)
+	}
+	return result, nil
+}
+
+// gitStatusUnknownError is returned by FindUncommittedChanges() if the repository uses features that we do not replicate,
+// so we cannot tell whether there are uncommitted changes.
+type gitStatusUnknownError struct {
+	Reason string
+}
+
+// Error implements the builtin/error interface.
+func (e gitStatusUnknownError) Error() string {
+	return "cannot tell whether there are uncommitted changes since " + e.Reason
+}
+
+// Checks whether the file for the given index entry has different contents or a different mode than recorded in the index.
+func (r *gitRepository) isModifiedInWorktree(entry gitIndexEntry, indexModTime time.Time, conversions *gitConversionChecker) (bool, error) {
+	filePath := filepath.Join(r.WorktreePath, filepath.FromSlash(entry.Path))
+	fi, err := os.Lstat(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	var mode uint32
+	switch {
+	case fi.Mode()&fs.ModeSymlink != 0:
+		mode = 0o120000
+	case fi.Mode().IsRegular() && fi.Mode()&0o111 != 0:
+		mode = 0o100755
+	case fi.Mode().IsRegular():
+		mode = 0o100644
+	default:
+		return true, nil // e.g. a directory where a file is expected
+	}
+	if !r.Config.GetBool("core.filemode", true) && mode != 0o120000 && entry.Mode != 0o120000 {
+		mode = entry.Mode // ignore the executable bit
+	}
+	if mode != entry.Mode {
+		return true, nil
+	}
+
+	// like Git, we do not look at the contents if the size differs (the size in the index is that of the file in the working tree,
+	// so this holds even if content conversions are in effect); a size of 0 in the index means that the size was never recorded
+	size := uint32(fi.Size()) //nolint:gosec // truncation is intended, the index stores sizes as uint32
+	if size != entry.Size && entry.Size != 0 {
+		return true, nil
+	}
+
+	// if the metadata matches, we trust that the contents have not changed, unless the file
+	// was modified so shortly before the index was written that the timestamps cannot tell the difference
+	// (this is the same "racy Git" check that Git itself performs)
+	if size == entry.Size && fi.ModTime().Equal(entry.ModTime) && fi.ModTime().Before(indexModTime) {
+		return false, nil
+	}
+
+	var contents []byte
+	if mode == 0o120000 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return false, err
+		}
+		contents = []byte(target)
+	} else {
+		// comparing contents only works if Git stores the file as-is
+		mayConvert, err := conversions.MayConvert(entry.Path)
+		if err != nil {
+			return false, err
+		}
+		if mayConvert {
+			return false, gitStatusUnknownError{fmt.Sprintf(
+				"%s may be subject to content conversions from gitattributes or core.autocrlf, which are not replicated", entry.Path)}
+		}
+		contents, err = os.ReadFile(filePath)
+		if err != nil {
+			return false, err
+		}
+	}
+	return r.computeBlobID(contents) != entry.ObjectID, nil
+}
+
+// Computes the ID that a blob with the given contents has in the object database.
+func (r *gitRepository) computeBlobID(contents []byte) string {
+	var h hash.Hash
+	if r.hashSize == 32 {
+		h = sha256.New()
+	} else {
+		h = sha1.New() //nolint:gosec // see import
+	}
+	fmt.Fprintf(h, "blob %d\x00", len(contents))
+	h.Write(contents)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// gitConversionChecker finds out whether Git might convert file contents when adding them to the index.
+// This is the case when core.autocrlf is enabled, or when any gitattributes file applies to the file in question.
+// We do not evaluate the gitattributes files themselves, since most attributes (e.g. "filter" for Git LFS)
+// cannot be replicated without running external programs anyway.
+// Ref: <https://git-scm.com/docs/gitattributes>
+type gitConversionChecker struct {
+	worktreePath string
+	// Whether core.autocrlf or a gitattributes file outside of the working tree applies to all files.
+	appliesEverywhere bool
+	// Whether a .gitattributes file exists, keyed by the directory path relative to the root of the working tree.
+	hasDirFile map[string]bool
+}
+
+func (r *gitRepository) newGitConversionChecker() (*gitConversionChecker, error) {
+	c := &gitConversionChecker{
+		worktreePath:      r.WorktreePath,
+		appliesEverywhere: r.Config.GetBool("core.autocrlf", false), // "input" counts as true here
+		hasDirFile:        make(map[string]bool),
+	}
+
+	filePaths := []string{filepath.Join(r.CommonDir, "info", "attributes")}
+	if os.Getenv("GIT_ATTR_NOSYSTEM") == "" {
+		filePaths = append(filePaths, "/etc/gitattributes")
+	}
+	globalFilePath := r.Config.Get("core.attributesfile")
+	if globalFilePath == "" {
+		globalFilePath = filepath.Join(getXDGConfigHome(), "git", "attributes")
+	} else if rest, ok := strings.CutPrefix(globalFilePath, "~/"); ok {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		globalFilePath = filepath.Join(homeDir, rest)
+	}
+	filePaths = append(filePaths, globalFilePath)
+
+	for _, filePath := range filePaths {
+		exists, err := isNonEmptyFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		c.appliesEverywhere = c.appliesEverywhere || exists
+	}
+	return c, nil
+}
+
+// MayConvert returns whether Git might convert the contents of the file at the given path (relative to the root of the working tree).
+func (c *gitConversionChecker) MayConvert(filePath string) (bool, error) {
+	if c.appliesEverywhere {
+		return true, nil
+	}
+	dirPath := path.Dir(filePath)
+	for {
+		if dirPath == "." {
+			dirPath = ""
+		}
+		hasFile, exists := c.hasDirFile[dirPath]
+		if !exists {
+			var err error
+			hasFile, err = isNonEmptyFile(filepath.Join(c.worktreePath, filepath.FromSlash(dirPath), ".gitattributes"))
+			if err != nil {
+				return false, err
+			}
+			c.hasDirFile[dirPath] = hasFile
+		}
+		if hasFile {
+			return true, nil
+		}
+		if dirPath == "" {
+			return false, nil
+		}
+		dirPath = path.Dir(dirPath)
+	}
+}
+
+func isNonEmptyFile(filePath string) (bool, error) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			err = nil
+		}
+		return false, err
+	}
+	return fi.Mode().IsRegular() && fi.Size() > 0, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// index
+
+// gitIndex contains the parts of the index file that we need.
+type gitIndex struct {
+	Entries []gitIndexEntry
+	ModTime time.Time // of the index file itself
+}
+
+// gitIndexEntry is an entry in the index file.
+type gitIndexEntry struct {
+	Path           string
+	Mode           uint32
+	ObjectID       string
+	Size           uint32
+	ModTime        time.Time
+	Stage          int // 0 for regular entries, 1-3 for unresolved merge conflicts
+	IsSkipWorktree bool
+}
+
+// Reads the index file of this working tree. Index versions 2 through 4 are supported.
+// Ref: <https://git-scm.com/docs/index-format>
+func (r *gitRepository) readIndex() (gitIndex, error) {
+	indexPath := filepath.Join(r.GitDir, "index")
+	fi, err := os.Stat(indexPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil // e.g. in a fresh repository where nothing has been added yet
+		}
+		return gitIndex{}, err
+	}
+	buf, err := os.ReadFile(indexPath)
+	if err != nil {
+		return gitIndex{}, err
+	}
+	index, err := parseGitIndex(buf, r.hashSize)
+	if err != nil {
+		return gitIndex{}, fmt.Errorf("while parsing %s: %w", indexPath, err)
+	}
+	index.ModTime = fi.ModTime()
+	return index, nil
+}
+
+func parseGitIndex(buf []byte, hashSize int) (gitIndex, error) {
+	if len(buf) < 12+hashSize || !bytes.Equal(buf[0:4], []byte("DIRC")) {
+		return gitIndex{}, errors.New("not an index file")
+	}
+	version := binary.BigEndian.Uint32(buf[4:8])
+	if version < 2 || version > 4 {
+		return gitIndex{}, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := int(binary.BigEndian.Uint32(buf[8:12]))
+	data := buf[:len(buf)-hashSize] // without the trailing checksum
+
+	// each entry starts with 40 bytes of stat data, then the object ID and 2 bytes of flags
+	var index gitIndex
+	pos := 12
+	lastPath := ""
+	for range count {
+		start := pos
+		if pos+40+hashSize+2 > len(data) {
+			return gitIndex{}, errors.New("unexpected end of file")
+		}
+		mtimeSecs := binary.BigEndian.Uint32(data[pos+8:])
+		mtimeNanos := binary.BigEndian.Uint32(data[pos+12:])
+		entry := gitIndexEntry{
+			Mode:     binary.BigEndian.Uint32(data[pos+24:]),
+			Size:     binary.BigEndian.Uint32(data[pos+36:]),
+			ModTime:  time.Unix(int64(mtimeSecs), int64(mtimeNanos)),
+			ObjectID: hex.EncodeToString(data[pos+40 : pos+40+hashSize]),
+		}
+		pos += 40 + hashSize
+		flags := binary.BigEndian.Uint16(data[pos:])
+		pos += 2
+		entry.Stage = int(flags>>12) & 3
+		if flags&0x4000 != 0 && version >= 3 {
+			if pos+2 > len(data) {
+				return gitIndex{}, errors.New("unexpected end of file")
+			}
+			extendedFlags := binary.BigEndian.Uint16(data[pos:])
+			entry.IsSkipWorktree = extendedFlags&0x4000 != 0
+			pos += 2
+		}
+
+		// in version 4, paths are prefix-compressed relative to the previous entry's path;
+		// in earlier versions, entries are padded with NUL bytes to a multiple of 8 bytes
+		if version == 4 {
+			strip, newPos, err := readGitOffsetVarint(data, pos)
+			if err != nil || strip > uint64(len(lastPath)) {
+				return gitIndex{}, errors.New("malformed path in version 4 index entry")
+			}
+			pos = newPos
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return gitIndex{}, errors.New("unexpected end of file")
+			}
+			entry.Path = lastPath[:len(lastPath)-int(strip)] + string(data[pos:pos+end]) //nolint:gosec // range was checked above
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return gitIndex{}, errors.New("unexpected end of file")
+			}
+			entry.Path = string(data[pos : pos+end])
+			pos = start + (pos+end-start+8)&^7
+		}
+		lastPath = entry.Path
+
+		// sparse directory entries only occur in sparse indexes, which are rejected below because of their "sdir" extension
+		index.Entries = append(index.Entries, entry)
+	}
+
+	// extensions with a lowercase signature are required for understanding the index, so we cannot ignore them
+	for pos+8 <= len(data) {
+		signature := data[pos : pos+4]
+		size := int(binary.BigEndian.Uint32(data[pos+4:]))
+		if signature[0] >= 'a' && signature[0] <= 'z' {
+			return gitIndex{}, fmt.Errorf("unsupported index extension %q (split index or sparse index?)", string(signature))
+		}
+		pos += 8 + size
+	}
+	return index, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// untracked and ignored files
+
+// Walks through the given directory (relative to the root of the working tree),
+// and reports all files that are neither tracked nor ignored, with paths relative to the root of the working tree.
+// Nested repositories that are not registered as submodules are reported as a whole, with a trailing slash.
+func (r *gitRepository) findUntrackedFiles(dirPath string, isTracked map[string]bool, report func(string)) error {
+	// collect ignore rules from outside the working tree
+	var ignoreLists []gitIgnoreList
+	excludesFilePath := r.Config.Get("core.excludesfile")
+	if excludesFilePath == "" {
+		excludesFilePath = filepath.Join(getXDGConfigHome(), "git", "ignore")
+	} else if rest, ok := strings.CutPrefix(excludesFilePath, "~/"); ok {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		excludesFilePath = filepath.Join(homeDir, rest)
+	}
+	for _, filePath := range []string{excludesFilePath, filepath.Join(r.CommonDir, "info", "exclude")} {
+		list, err := readGitIgnoreFile(filePath, "")
+		if err != nil {
+			return err
+		}
+		ignoreLists = append(ignoreLists, list)
+	}
+
+	// collect ignore rules from the directories above the directory in question
+	// (if any of these directories is ignored, then all untracked files below it are ignored as well)
+	var dirNames []string
+	if dirPath != "" {
+		dirNames = strings.Split(dirPath, "/")
+	}
+	currentPath := ""
+	for idx := 0; ; idx++ {
+		list, err := readGitIgnoreFile(filepath.Join(r.WorktreePath, filepath.FromSlash(currentPath), ".gitignore"), currentPath)
+		if err != nil {
+			return err
+		}
+		ignoreLists = append(ignoreLists, list)
+		if idx == len(dirNames) {
+			break
+		}
+		currentPath = path.Join(currentPath, dirNames[idx])
+		if isIgnoredByGit(ignoreLists, currentPath, true) {
+			return nil
+		}
+	}
+
+	return r.walkUntrackedFiles(dirPath, ignoreLists, isTracked, report)
+}
+
+func (r *gitRepository) walkUntrackedFiles(dirPath string, ignoreLists []gitIgnoreList, isTracked map[string]bool, report func(string)) error {
+	entries, err := os.ReadDir(filepath.Join(r.WorktreePath, filepath.FromSlash(dirPath)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		entryPath := path.Join(dirPath, entry.Name())
+		if isTracked[entryPath] || isIgnoredByGit(ignoreLists, entryPath, entry.IsDir()) {
+			continue
+		}
+		if !entry.IsDir() {
+			report(entryPath)
+			continue
+		}
+
+		_, err := os.Lstat(filepath.Join(r.WorktreePath, filepath.FromSlash(entryPath), ".git"))
+		if err == nil {
+			report(entryPath + "/")
+			continue
+		}
+		list, err := readGitIgnoreFile(filepath.Join(r.WorktreePath, filepath.FromSlash(entryPath), ".gitignore"), entryPath)
+		if err != nil {
+			return err
+		}
+		err = r.walkUntrackedFiles(entryPath, append(slices.Clip(ignoreLists), list), isTracked, report)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitIgnoreList contains the patterns from one gitignore file.
+type gitIgnoreList struct {
+	// The directory containing the gitignore file, relative to the root of the working tree.
+	// Patterns only apply to paths below this directory.
+	BaseDirPath string
+	Patterns    []gitIgnorePattern
+}
+
+type gitIgnorePattern struct {
+	Regexp        *regexp.Regexp // matches paths relative to BaseDirPath
+	IsNegated     bool
+	IsForDirsOnly bool
+}
+
+// Reads a gitignore file. If the file does not exist, an empty list is returned.
+// Ref: <https://git-scm.com/docs/gitignore#_pattern_format>
+func readGitIgnoreFile(filePath, baseDirPath string) (gitIgnoreList, error) {
+	list := gitIgnoreList{BaseDirPath: baseDirPath}
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			err = nil
+		}
+		return list, err
+	}
+
+	for line := range strings.SplitSeq(string(buf), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// trailing spaces are ignored, unless escaped with a backslash
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+
+		var pattern gitIgnorePattern
+		line, pattern.IsNegated = strings.CutPrefix(line, "!")
+		line, pattern.IsForDirsOnly = strings.CutSuffix(line, "/")
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, "/") {
+			// patterns with a slash at the start or in the middle are relative to the gitignore file's directory
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			// patterns without slash can match at any level below the gitignore file's directory
+			line = "**/" + line
+		}
+		pattern.Regexp, err = regexp.Compile(translateGitIgnoreGlob(line))
+		if err != nil {
+			continue // Git also ignores malformed patterns
+		}
+		list.Patterns = append(list.Patterns, pattern)
+	}
+	return list, nil
+}
+
+// Translates a gitignore pattern into a regex.
+func translateGitIgnoreGlob(pattern string) string {
+	var rx strings.Builder
+	rx.WriteString("^")
+	for idx := 0; idx < len(pattern); idx++ {
+		isSegmentStart := idx == 0 || pattern[idx-1] == '/'
+		switch {
+		case isSegmentStart && strings.HasPrefix(pattern[idx:], "**/"):
+			// leading "**/" and "/**/" match any number of directories (including zero)
+			rx.WriteString("(?:.*/)?")
+			idx += 2
+		case isSegmentStart && pattern[idx:] == "**":
+			// trailing "/**" matches everything inside
+			rx.WriteString(".*")
+			idx++
+		case pattern[idx] == '*':
+			rx.WriteString("[^/]*")
+		case pattern[idx] == '?':
+			rx.WriteString("[^/]")
+		case pattern[idx] == '[':
+			end := findGlobBracketEnd(pattern, idx)
+			if end < 0 {
+				rx.WriteString(`\[`)
+				continue
+			}
+			class := pattern[idx+1 : end]
+			if rest, ok := strings.CutPrefix(class, "!"); ok {
+				class = "^" + rest
+			}
+			rx.WriteString("[" + class + "]")
+			idx = end
+		case pattern[idx] == '\\' && idx+1 < len(pattern):
+			idx++
+			rx.WriteString(regexp.QuoteMeta(pattern[idx : idx+1]))
+		default:
+			rx.WriteString(regexp.QuoteMeta(pattern[idx : idx+1]))
+		}
+	}
+	rx.WriteString("$")
+	return rx.String()
+}
+
+// Returns the index of the "]" that closes the bracket expression starting at pattern[start], or -1 if there is none.
+func findGlobBracketEnd(pattern string, start int) int {
+	idx := start + 1
+	if idx < len(pattern) && (pattern[idx] == '!' || pattern[idx] == '^') {
+		idx++
+	}
+	if idx < len(pattern) && pattern[idx] == ']' {
+		idx++ // a "]" at the start of the bracket expression is taken literally
+	}
+	for idx < len(pattern) {
+		switch {
+		case pattern[idx] == ']':
+			return idx
+		case strings.HasPrefix(pattern[idx:], "[:"):
+			// skip over character classes like "[:alpha:]"
+			end := strings.Index(pattern[idx+2:], ":]")
+			if end < 0 {
+				return -1
+			}
+			idx += end + 4
+		default:
+			idx++
+		}
+	}
+	return -1
+}
+
+// Checks whether the given path (relative to the root of the working tree) is ignored by any of the given gitignore files.
+// The lists must be ordered by increasing precedence, i.e. deeper directories last.
+func isIgnoredByGit(lists []gitIgnoreList, path string, isDir bool) bool {
+	for _, list := range slices.Backward(lists) {
+		relPath := path
+		if list.BaseDirPath != "" {
+			var ok bool
+			relPath, ok = strings.CutPrefix(path, list.BaseDirPath+"/")
+			if !ok {
+				continue
+			}
+		}
+		// within each list, the last matching pattern decides
+		for _, pattern := range slices.Backward(list.Patterns) {
+			if (isDir || !pattern.IsForDirsOnly) && pattern.Regexp.MatchString(relPath) {
+				return !pattern.IsNegated
+			}
+		}
+	}
+	return false
+}
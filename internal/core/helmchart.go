@@ -7,16 +7,22 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sapcc/go-bits/logg"
+	. "go.xyrillian.de/gg/option"
+	"gopkg.in/yaml.v3"
 
 	"github.com/sapcc/ocm-helm-toolbox/internal/util"
 )
@@ -27,22 +33,67 @@ type HelmChart struct {
 	// the path where this chart resides in the filesystem
 	ChartPath string `yaml:"-"`
 
-	APIVersion   string                    `yaml:"apiVersion"`
-	Name         string                    `yaml:"name"`
-	Version      string                    `yaml:"version"`
+	APIVersion string `yaml:"apiVersion"`
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	// For charts with apiVersion v1, this is filled from requirements.yaml instead.
 	Dependencies []DeclaredChartDependency `yaml:"dependencies"`
 }
 
-// DeclaredChartDependency appears in Chart.yaml of a Helm chart.
+// DeclaredChartDependency appears in Chart.yaml (or requirements.yaml for apiVersion v1) of a Helm chart.
 type DeclaredChartDependency struct {
 	Name       string `yaml:"name"`
 	Repository string `yaml:"repository"`
 
 	// This field may contain a match expression like "^1.1" instead of a concrete version like "1.1.5".
 	VersionMatchExpression string `yaml:"version"`
+
+	// If set, the subchart is included under this name instead of its own name.
+	// This allows including the same chart multiple times.
+	Alias string `yaml:"alias"`
+
+	// These fields control whether and how the subchart is enabled at install time.
+	// `helm dep build` downloads all dependencies regardless of them, so they do not affect which files appear in `charts/`.
+	Condition    string   `yaml:"condition"`
+	Tags         []string `yaml:"tags"`
+	ImportValues []any    `yaml:"import-values"`
+}
+
+// EffectiveName returns the name under which this dependency is included into the parent chart.
+func (d DeclaredChartDependency) EffectiveName() string {
+	if d.Alias != "" {
+		return d.Alias
+	}
+	return d.Name
+}
+
+// Returns a description of this dependency for use in error messages.
+func (d DeclaredChartDependency) describe() string {
+	if d.Alias != "" {
+		return fmt.Sprintf("%q (alias %q)", d.Name, d.Alias)
+	}
+	return strconv.Quote(d.Name)
+}
+
+// Validates the structure of the import-values field, which Helm would otherwise only check at install time.
+func (d DeclaredChartDependency) validateImportValues() error {
+	for _, entry := range d.ImportValues {
+		switch entry := entry.(type) {
+		case string:
+			continue
+		case map[string]any:
+			child, childOK := entry["child"].(string)
+			parent, parentOK := entry["parent"].(string)
+			if childOK && parentOK && child != "" && parent != "" && len(entry) == 2 {
+				continue
+			}
+		}
+		return fmt.Errorf("invalid entry in import-values: %v (expected either a string, or an object with the string fields \"child\" and \"parent\")", entry)
+	}
+	return nil
 }
 
-// ComputedChartDependency appears in Chart.lock of a Helm chart.
+// ComputedChartDependency appears in Chart.lock (or requirements.lock for apiVersion v1) of a Helm chart.
 type ComputedChartDependency struct {
 	Name       string `yaml:"name"`
 	Repository string `yaml:"repository"`
@@ -52,8 +103,11 @@ type ComputedChartDependency struct {
 }
 
 // ParseHelmChartYAML parses the Chart.yaml file below the given path.
+// For charts with apiVersion v1, dependencies are read from requirements.yaml instead.
 func ParseHelmChartYAML(chartPath string) (HelmChart, error) {
-	result, err := util.ReadYAMLFile[HelmChart](filepath.Join(chartPath, "Chart.yaml"))
+	result, err := parseHelmChartMetadata(chartPath, func(fileName string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(chartPath, fileName))
+	})
 	if err != nil {
 		return HelmChart{}, err
 	}
@@ -61,6 +115,41 @@ func ParseHelmChartYAML(chartPath string) (HelmChart, error) {
 	return result, nil
 }
 
+// Parses Chart.yaml, and for charts with apiVersion v1 also requirements.yaml, using the given function to read files.
+// All places that read chart metadata go through this function, so that v1 charts are handled consistently.
+// The `location` is only used in error messages.
+func parseHelmChartMetadata(location string, readFile func(fileName string) ([]byte, error)) (HelmChart, error) {
+	buf, err := readFile("Chart.yaml")
+	if err != nil {
+		return HelmChart{}, err
+	}
+	var result HelmChart
+	err = yaml.Unmarshal(buf, &result)
+	if err != nil {
+		return HelmChart{}, fmt.Errorf("while parsing %s: %w", filepath.Join(location, "Chart.yaml"), err)
+	}
+
+	if result.APIVersion == "v1" {
+		buf, err := readFile("requirements.yaml")
+		switch {
+		case err == nil:
+			var requirements struct {
+				Dependencies []DeclaredChartDependency `yaml:"dependencies"`
+			}
+			err = yaml.Unmarshal(buf, &requirements)
+			if err != nil {
+				return HelmChart{}, fmt.Errorf("while parsing %s: %w", filepath.Join(location, "requirements.yaml"), err)
+			}
+			result.Dependencies = requirements.Dependencies
+		case errors.Is(err, os.ErrNotExist):
+			result.Dependencies = nil // requirements.yaml is optional
+		default:
+			return HelmChart{}, err
+		}
+	}
+	return result, nil
+}
+
 // AddTimestampToVersion contains the logic for the `add-timestamp-to-version` subcommand.
 func (c *HelmChart) AddTimestampToVersion() error {
 	if strings.Contains(c.Version, "+") {
@@ -102,7 +191,8 @@ func (c *HelmChart) AddTimestampToVersion() error {
 }
 
 // AsOCMResource returns a resource declaration for this Helm chart.
-func (c HelmChart) AsOCMResource() (OCMResourceDeclaration, error) {
+// If the chart is in a Git checkout, its Git location (as returned by TryGetGitLocation()) is recorded in a label.
+func (c HelmChart) AsOCMResource(gitLocation Option[GitLocation]) (OCMResourceDeclaration, error) {
 	decl := OCMResourceDeclaration{
 		Name:    "helm-chart-" + c.Name,
 		Type:    "helmChart",
@@ -113,18 +203,15 @@ func (c HelmChart) AsOCMResource() (OCMResourceDeclaration, error) {
 		},
 	}
 
-	gitLocation, err := TryGetGitLocation(c.ChartPath)
-	if err != nil {
-		return OCMResourceDeclaration{}, err
-	}
 	if loc, ok := gitLocation.Unpack(); ok {
 		buf, err := json.Marshal(loc)
 		if err != nil {
 			return OCMResourceDeclaration{}, err
 		}
 		decl.Labels = []OCMLabel{{
-			Name:  GitLocationLabelName,
-			Value: string(buf),
+			Name:    GitLocationLabelName,
+			Value:   string(buf),
+			Signing: true,
 		}}
 	}
 
@@ -135,42 +222,29 @@ func (c HelmChart) AsOCMResource() (OCMResourceDeclaration, error) {
 // If this is not the case, then bundling the chart might not include all relevant subcharts.
 // Ref: <https://github.com/open-component-model/ocm/issues/1007>
 func (c HelmChart) ValidateDependencies() error {
-	// This will contain all the files that we expect directly below `charts/` as keys.
-	expectedFiles := make(map[string]struct{})
+	// This will contain all the files that we expect directly below `charts/` as keys,
+	// and the subchart name and version that we expect inside them as values
+	// (since subcharts may also be present as unpacked directories instead).
+	expectedFiles := make(map[string]ComputedChartDependency)
 
-	switch c.APIVersion {
-	case "v1":
-		// in v1, dependencies are declared in a different way
-		// (using `requirements.{yaml,lock}` instead of `Chart.{yaml,lock}`)
-		// which we don't bother to support
-		return fmt.Errorf("cannot validate chart dependencies for %s with apiVersion: v1 (please upgrade to v2; see <%s> for details)",
-			c.ChartPath, "https://helm.sh/docs/topics/charts/#the-apiversion-field",
-		)
-	case "v2":
-		// ok
-	default:
-		return fmt.Errorf("cannot validate chart dependencies for %s with apiVersion: %s (this tool only supports v2)",
-			c.ChartPath, c.APIVersion)
+	files, err := c.getDependencyFiles()
+	if err != nil {
+		return err
 	}
 
 	// if there are dependencies, Chart.lock will tell us the exact versions
 	if len(c.Dependencies) > 0 {
-		type chartLockContents struct {
-			// NOTE: unused fields omitted
-			Dependencies []ComputedChartDependency `yaml:"dependencies"`
-		}
-		chartLock, err := util.ReadYAMLFile[chartLockContents](filepath.Join(c.ChartPath, "Chart.lock"))
+		chartLock, err := c.readValidatedLockFile(files)
 		if err != nil {
 			return err
 		}
-		err = validateDependencyCoherence(c.Dependencies, chartLock.Dependencies)
-		if err != nil {
-			return fmt.Errorf("Chart.yaml and Chart.lock in %s do not agree: %w", c.ChartPath, err) //nolint:staticcheck // Chart.yaml is capitalized for a reason
-		}
-
 		for _, dep := range chartLock.Dependencies {
-			fileName := fmt.Sprintf("%s-%s.tgz", dep.Name, dep.Version)
-			expectedFiles[fileName] = struct{}{}
+			subchart, err := c.getPackagedDependency(dep)
+			if err != nil {
+				return fmt.Errorf("while validating subcharts of %s: %w", c.ChartPath, err)
+			}
+			fileName := fmt.Sprintf("%s-%s.tgz", subchart.Name, subchart.Version)
+			expectedFiles[fileName] = subchart
 		}
 	}
 
@@ -179,63 +253,254 @@ func (c HelmChart) ValidateDependencies() error {
 	if err != nil {
 		return err
 	}
+	foundIn := make(map[string]string) // key = expected file name, value = relPath of the entry that satisfies it
 	for _, entry := range entries {
 		relPath := filepath.Join("charts", entry.Name())
-		if !entry.Type().IsRegular() {
-			return fmt.Errorf("while validating subcharts of %s: expected only regular files, but %s is %s",
+		fileName := entry.Name()
+		switch {
+		case entry.IsDir():
+			// subcharts may also be given as unpacked directories
+			subchart, err := ParseHelmChartYAML(filepath.Join(c.ChartPath, relPath))
+			if err != nil {
+				return fmt.Errorf("while validating subcharts of %s: found directory %s, but could not read it as a chart: %w", c.ChartPath, relPath, err)
+			}
+			fileName = fmt.Sprintf("%s-%s.tgz", subchart.Name, subchart.Version)
+			if _, exists := expectedFiles[fileName]; !exists {
+				return fmt.Errorf("while validating subcharts of %s: found unexpected directory %s (containing chart %q in version %s)",
+					c.ChartPath, relPath, subchart.Name, subchart.Version)
+			}
+		case !entry.Type().IsRegular():
+			return fmt.Errorf("while validating subcharts of %s: expected only regular files and directories, but %s is %s",
 				c.ChartPath, relPath, entry.Type().String(),
 			)
+		default:
+			if _, exists := expectedFiles[fileName]; !exists {
+				return fmt.Errorf("while validating subcharts of %s: found unexpected file %s", c.ChartPath, relPath)
+			}
 		}
-		_, exists := expectedFiles[entry.Name()]
-		if !exists {
-			return fmt.Errorf("while validating subcharts of %s: found unexpected file %s", c.ChartPath, relPath)
+		if otherPath, exists := foundIn[fileName]; exists {
+			return fmt.Errorf("while validating subcharts of %s: found %s and %s, which both contain chart %q in version %s",
+				c.ChartPath, otherPath, relPath, expectedFiles[fileName].Name, expectedFiles[fileName].Version)
 		}
-		delete(expectedFiles, entry.Name())
+		foundIn[fileName] = relPath
 	}
 	for fileName := range expectedFiles {
-		return fmt.Errorf("while validating subcharts of %s: did not find expected file %s",
-			c.ChartPath, filepath.Join("charts", fileName))
+		if _, exists := foundIn[fileName]; !exists {
+			return fmt.Errorf("while validating subcharts of %s: did not find expected file %s",
+				c.ChartPath, filepath.Join("charts", fileName))
+		}
 	}
 	return nil
 }
 
-// Validate that the `dependencies` sections of Chart.yaml and Chart.lock agree with each other.
-func validateDependencyCoherence(declaredDeps []DeclaredChartDependency, computedDeps []ComputedChartDependency) error {
-	declaredSet := make(map[string]DeclaredChartDependency, len(declaredDeps))
+// Returns the names of the files where this chart declares its dependencies.
+func (c HelmChart) getDependencyFiles() (chartDependencyFiles, error) {
+	switch c.APIVersion {
+	case "v1":
+		// in v1, dependencies are declared in a different way
+		// (using `requirements.{yaml,lock}` instead of `Chart.{yaml,lock}`),
+		// but ParseHelmChartYAML() has already mapped them into the same model
+		return chartDependencyFiles{Declared: "requirements.yaml", Lock: "requirements.lock"}, nil
+	case "v2":
+		return chartDependencyFiles{Declared: "Chart.yaml", Lock: "Chart.lock"}, nil
+	default:
+		return chartDependencyFiles{}, fmt.Errorf("cannot validate chart dependencies for %s with apiVersion: %s (this tool only supports v1 and v2)",
+			c.ChartPath, c.APIVersion)
+	}
+}
+
+// chartLockContents is the contents of Chart.lock (or requirements.lock for apiVersion v1).
+type chartLockContents struct {
+	// NOTE: unused fields omitted
+	Dependencies []ComputedChartDependency `yaml:"dependencies"`
+	Digest       string                    `yaml:"digest"`
+}
+
+// Reads Chart.lock, and validates that it agrees with the dependencies declared in Chart.yaml.
+func (c HelmChart) readValidatedLockFile(files chartDependencyFiles) (chartLockContents, error) {
+	err := files.validateDeclarations(c.Dependencies)
+	if err != nil {
+		return chartLockContents{}, fmt.Errorf("while validating chart dependencies for %s: %w", c.ChartPath, err)
+	}
+	chartLock, err := util.ReadYAMLFile[chartLockContents](filepath.Join(c.ChartPath, files.Lock))
+	if err != nil {
+		return chartLockContents{}, err
+	}
+	err = files.validateCoherence(c.Dependencies, chartLock.Dependencies)
+	if err != nil {
+		return chartLockContents{}, fmt.Errorf("%s and %s in %s do not agree: %w", files.Declared, files.Lock, c.ChartPath, err)
+	}
+	err = files.validateDigest(c.APIVersion, c.Dependencies, chartLock.Dependencies, chartLock.Digest)
+	if err != nil {
+		return chartLockContents{}, fmt.Errorf("%s in %s is out of sync with %s: %w (please run `helm dependency update`)", files.Lock, c.ChartPath, files.Declared, err)
+	}
+	return chartLock, nil
+}
+
+// Returns the name and version of the chart that `helm dep build` places into `charts/` for the given dependency.
+//
+// This is usually the same as what Chart.lock says, except for dependencies from `file://` repositories.
+// For those, `helm dep build` packages the referenced chart directory as-is, so its Chart.yaml is authoritative.
+func (c HelmChart) getPackagedDependency(dep ComputedChartDependency) (ComputedChartDependency, error) {
+	localPath, isLocal := strings.CutPrefix(dep.Repository, "file://")
+	if !isLocal {
+		return dep, nil
+	}
+	if !filepath.IsAbs(localPath) {
+		localPath = filepath.Join(c.ChartPath, localPath)
+	}
+	localChart, err := ParseHelmChartYAML(localPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// the chart may have been copied away from its original location after `helm dep build`
+		logg.Debug("cannot find source of dependency %q at %s, assuming that charts/ contains it as recorded in the lockfile", dep.Name, localPath)
+		return dep, nil
+	}
+	if err != nil {
+		return ComputedChartDependency{}, err
+	}
+	if localChart.Version != dep.Version {
+		return ComputedChartDependency{}, fmt.Errorf("dependency %q is recorded with version %s, but the chart at %s has version %s",
+			dep.Name, dep.Version, dep.Repository, localChart.Version)
+	}
+	return ComputedChartDependency{Name: localChart.Name, Repository: dep.Repository, Version: localChart.Version}, nil
+}
+
+// chartDependencyFiles contains the names of the files where a chart declares its dependencies,
+// for use in error messages.
+type chartDependencyFiles struct {
+	Declared string // e.g. "Chart.yaml"
+	Lock     string // e.g. "Chart.lock"
+}
+
+// Validate the `dependencies` section of Chart.yaml on its own.
+func (f chartDependencyFiles) validateDeclarations(declaredDeps []DeclaredChartDependency) error {
+	isEffectiveName := make(map[string]bool, len(declaredDeps))
 	for _, dep := range declaredDeps {
-		declaredSet[dep.Name] = dep
+		if isEffectiveName[dep.EffectiveName()] {
+			return fmt.Errorf("%s declares multiple dependencies named %q (use `alias` to include the same chart multiple times)", f.Declared, dep.EffectiveName())
+		}
+		isEffectiveName[dep.EffectiveName()] = true
+		err := dep.validateImportValues()
+		if err != nil {
+			return fmt.Errorf("%s declares dependency %s with %w", f.Declared, dep.describe(), err)
+		}
 	}
-	computedSet := make(map[string]ComputedChartDependency, len(computedDeps))
+	return nil
+}
+
+// Validate that the `dependencies` sections of Chart.yaml and Chart.lock agree with each other.
+//
+// Chart.lock identifies dependencies only by their chart name, not by their alias.
+// When the same chart is included multiple times with different aliases,
+// the respective entries in Chart.lock appear in the same order as in Chart.yaml, so they are paired up in order.
+func (f chartDependencyFiles) validateCoherence(declaredDeps []DeclaredChartDependency, computedDeps []ComputedChartDependency) error {
+	computedByName := make(map[string][]ComputedChartDependency, len(computedDeps))
 	for _, dep := range computedDeps {
-		computedSet[dep.Name] = dep
+		computedByName[dep.Name] = append(computedByName[dep.Name], dep)
 	}
 
-	for depName, declaredDep := range declaredSet {
-		computedDep, exists := computedSet[depName]
-		if !exists {
-			return fmt.Errorf("Chart.yaml declares a dependency on %q, but Chart.lock does not have this dependency", depName) //nolint:staticcheck // Chart.yaml is capitalized for a reason
+	for _, declaredDep := range declaredDeps {
+		depName := declaredDep.describe()
+		candidates := computedByName[declaredDep.Name]
+		if len(candidates) == 0 {
+			return fmt.Errorf("%s declares a dependency on %s, but %s does not have this dependency", f.Declared, depName, f.Lock)
 		}
+		computedDep := candidates[0]
+		computedByName[declaredDep.Name] = candidates[1:]
+
 		if computedDep.Repository != declaredDep.Repository {
-			return fmt.Errorf("Chart.yaml declares dependency %q as coming from %s, but Chart.lock has it coming from %s", //nolint:staticcheck // Chart.yaml is capitalized for a reason
-				depName, declaredDep.Repository, computedDep.Repository)
+			return fmt.Errorf("%s declares dependency %s as coming from %s, but %s has it coming from %s",
+				f.Declared, depName, declaredDep.Repository, f.Lock, computedDep.Repository)
+		}
+		// In CI, `helm dep build` would fail before us because of a contradiction here,
+		// but in interactive use, it is easy to forget updating Chart.lock after editing Chart.yaml.
+		constraint, err := parseSemverConstraint(declaredDep.VersionMatchExpression)
+		if err != nil {
+			return fmt.Errorf("%s declares dependency %s with %w", f.Declared, depName, err)
+		}
+		version, err := parseSemverVersion(computedDep.Version)
+		if err != nil {
+			return fmt.Errorf("%s declares dependency %s with %w", f.Lock, depName, err)
+		}
+		if !constraint.Matches(version) {
+			return fmt.Errorf("%s has dependency %s at version %s, which does not satisfy the version constraint %q from %s",
+				f.Lock, depName, computedDep.Version, declaredDep.VersionMatchExpression, f.Declared)
 		}
-		// TODO: validate that computedDep.Version matches declaredDep.Version
-		//
-		// (This is mostly relevant for interactive use, and thus omitted for now.
-		// In CI, `helm dep build` categorically has to run to populate `charts/`,
-		// so `helm dep build` will fail before us because of the contradiction.)
-
-		delete(declaredSet, depName)
-		delete(computedSet, depName)
 	}
 
-	for depName := range computedSet {
-		return fmt.Errorf("Chart.lock declares a dependency on %q, but Chart.yaml does not have this dependency", depName) //nolint:staticcheck // Chart.lock is capitalized for a reason
+	for _, depName := range slices.Sorted(maps.Keys(computedByName)) {
+		if len(computedByName[depName]) > 0 {
+			return fmt.Errorf("%s declares a dependency on %q, but %s does not have this dependency", f.Lock, depName, f.Declared)
+		}
 	}
 
 	return nil
 }
 
+// Validate that the digest in Chart.lock matches the `dependencies` sections of Chart.yaml and Chart.lock,
+// in the same way as `helm dep build` does.
+func (f chartDependencyFiles) validateDigest(apiVersion string, declaredDeps []DeclaredChartDependency, computedDeps []ComputedChartDependency, digest string) error {
+	// Helm computes the digest after resolving repository aliases like "@stable" into URLs using its local repository config,
+	// which we do not have access to
+	for _, dep := range declaredDeps {
+		if strings.HasPrefix(dep.Repository, "@") || strings.HasPrefix(dep.Repository, "alias:") {
+			logg.Debug("not validating digest in %s since dependency %q refers to repository alias %q", f.Lock, dep.Name, dep.Repository)
+			return nil
+		}
+	}
+
+	// This replicates Helm's serialization of chart.Dependency, including the field order.
+	type helmDependency struct {
+		Name         string   `json:"name"`
+		Version      string   `json:"version,omitempty"`
+		Repository   string   `json:"repository"`
+		Condition    string   `json:"condition,omitempty"`
+		Tags         []string `json:"tags,omitempty"`
+		Enabled      bool     `json:"enabled,omitempty"`
+		ImportValues []any    `json:"import-values,omitempty"`
+		Alias        string   `json:"alias,omitempty"`
+	}
+	var data [2][]helmDependency
+	for _, dep := range declaredDeps {
+		data[0] = append(data[0], helmDependency{
+			Name:         dep.Name,
+			Version:      dep.VersionMatchExpression,
+			Repository:   dep.Repository,
+			Condition:    dep.Condition,
+			Tags:         dep.Tags,
+			ImportValues: dep.ImportValues,
+			Alias:        dep.Alias,
+		})
+	}
+	for _, dep := range computedDeps {
+		data[1] = append(data[1], helmDependency{
+			Name:       dep.Name,
+			Version:    dep.Version,
+			Repository: dep.Repository,
+		})
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	expectedDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(buf))
+	if digest == expectedDigest {
+		return nil
+	}
+
+	// requirements.lock files that were written by Helm 2 use a digest over requirements.yaml only
+	if apiVersion == "v1" {
+		buf, err := json.Marshal(map[string]any{"dependencies": data[0]})
+		if err != nil {
+			return err
+		}
+		if digest == fmt.Sprintf("sha256:%x", sha256.Sum256(buf)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("digest is %q, but should be %q", digest, expectedDigest)
+}
+
 // UnpackHelmChartTarball takes the binary contents of a chart.tar file and
 // unpacks them into the given output path.
 func UnpackHelmChartTarball(buf []byte, outputDirPath string) error {
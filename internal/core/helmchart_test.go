@@ -0,0 +1,354 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes the given files into a new temporary directory, and returns its path.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dirPath := t.TempDir()
+	for relPath, contents := range files {
+		path := filepath.Join(dirPath, filepath.FromSlash(relPath))
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dirPath
+}
+
+// Writes the given files into a new chart directory, and parses it.
+func newTestChart(t *testing.T, files map[string]string) HelmChart {
+	t.Helper()
+	chart, err := ParseHelmChartYAML(writeTestFiles(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chart
+}
+
+// Runs readValidatedLockFile() on the given chart files.
+func validateTestChartLock(t *testing.T, files map[string]string) error {
+	t.Helper()
+	chart := newTestChart(t, files)
+	depFiles, err := chart.getDependencyFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chart.readValidatedLockFile(depFiles)
+	return err
+}
+
+// These files are in the format written by `helm dependency update`.
+// The digests were computed in the same way as in Helm's resolver.HashReq(),
+// by marshaling the dependencies into JSON using Helm's chart.Dependency type.
+const (
+	testChartYAML = `apiVersion: v2
+name: keystone
+version: 1.2.3
+dependencies:
+  - name: postgresql
+    repository: oci://registry-1.docker.io/bitnamicharts
+    version: ~16.7
+    condition: postgresql.enabled
+  - name: memcached
+    repository: https://charts.example.org/stable
+    version: ^7.0.0
+    alias: cache
+    tags:
+      - caching
+    import-values:
+      - child: exports.data
+        parent: memcached
+      - defaults
+`
+	testChartLock = `dependencies:
+- name: postgresql
+  repository: oci://registry-1.docker.io/bitnamicharts
+  version: 16.7.4
+- name: memcached
+  repository: https://charts.example.org/stable
+  version: 7.8.6
+digest: sha256:40d7a174a6069a09faab899cec6edfc1899c191f8685bd9af342dcfbdef06ea3
+generated: "2025-06-11T14:02:31.184512+02:00"
+`
+	testChartYAMLv1 = `apiVersion: v1
+name: legacy
+version: 0.4.0
+`
+	testRequirementsYAML = `dependencies:
+- name: mariadb
+  version: 5.x.x
+  repository: https://charts.example.org/legacy
+  condition: mariadb.enabled
+`
+	// as written by Helm 3, with a digest over requirements.yaml and requirements.lock
+	testRequirementsLockFromHelm3 = `dependencies:
+- name: mariadb
+  repository: https://charts.example.org/legacy
+  version: 5.11.3
+digest: sha256:1a92296e5c039e8debb6a81bfab09514f6519cfc3bf0da0cc2468bf37a6c286b
+generated: "2025-06-11T14:05:12.902174+02:00"
+`
+	// as written by Helm 2, with a digest over requirements.yaml only
+	testRequirementsLockFromHelm2 = `dependencies:
+- name: mariadb
+  repository: https://charts.example.org/legacy
+  version: 5.11.3
+digest: sha256:d489a1ad8881809c5c445f5f44d9a6cb1b88bcb907455c65f2c9d1a76d0a9fd0
+generated: 2019-08-27T11:49:33.102563+02:00
+`
+)
+
+func TestValidateChartLock(t *testing.T) {
+	err := validateTestChartLock(t, map[string]string{
+		"Chart.yaml": testChartYAML,
+		"Chart.lock": testChartLock,
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	// changing a field that only appears in Chart.yaml invalidates the digest
+	err = validateTestChartLock(t, map[string]string{
+		"Chart.yaml": strings.Replace(testChartYAML, "postgresql.enabled", "database.enabled", 1),
+		"Chart.lock": testChartLock,
+	})
+	if err == nil || !strings.Contains(err.Error(), "Chart.lock in ") || !strings.Contains(err.Error(), "is out of sync with Chart.yaml: digest is") {
+		t.Errorf("expected digest mismatch, but got %v", err)
+	}
+
+	// changing a version in Chart.lock invalidates the digest
+	err = validateTestChartLock(t, map[string]string{
+		"Chart.yaml": testChartYAML,
+		"Chart.lock": strings.Replace(testChartLock, "7.8.6", "7.8.5", 1),
+	})
+	if err == nil || !strings.Contains(err.Error(), "is out of sync with Chart.yaml: digest is") {
+		t.Errorf("expected digest mismatch, but got %v", err)
+	}
+
+	// versions in Chart.lock must satisfy the constraints in Chart.yaml (this is checked before the digest)
+	err = validateTestChartLock(t, map[string]string{
+		"Chart.yaml": testChartYAML,
+		"Chart.lock": strings.Replace(testChartLock, "16.7.4", "16.8.0", 1),
+	})
+	if err == nil || !strings.Contains(err.Error(), `has dependency "postgresql" at version 16.8.0, which does not satisfy the version constraint "~16.7"`) {
+		t.Errorf("expected constraint violation, but got %v", err)
+	}
+
+	// the digest cannot be checked for repository aliases, since those are resolved using Helm's local repository config
+	err = validateTestChartLock(t, map[string]string{
+		"Chart.yaml": strings.ReplaceAll(testChartYAML, "https://charts.example.org/stable", `"@stable"`),
+		"Chart.lock": strings.ReplaceAll(strings.Replace(testChartLock, "sha256:40d7", "sha256:0000", 1), "https://charts.example.org/stable", `'@stable'`),
+	})
+	if err != nil {
+		t.Errorf("unexpected error for chart with repository alias: %s", err.Error())
+	}
+}
+
+func TestValidateRequirementsLock(t *testing.T) {
+	for _, lockContents := range []string{testRequirementsLockFromHelm3, testRequirementsLockFromHelm2} {
+		err := validateTestChartLock(t, map[string]string{
+			"Chart.yaml":        testChartYAMLv1,
+			"requirements.yaml": testRequirementsYAML,
+			"requirements.lock": lockContents,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+
+		err = validateTestChartLock(t, map[string]string{
+			"Chart.yaml":        testChartYAMLv1,
+			"requirements.yaml": strings.Replace(testRequirementsYAML, "mariadb.enabled", "db.enabled", 1),
+			"requirements.lock": lockContents,
+		})
+		if err == nil || !strings.Contains(err.Error(), "is out of sync with requirements.yaml: digest is") {
+			t.Errorf("expected digest mismatch, but got %v", err)
+		}
+	}
+}
+
+func TestParseHelmChartYAMLv1(t *testing.T) {
+	files := map[string]string{
+		"Chart.yaml":        testChartYAMLv1,
+		"requirements.yaml": testRequirementsYAML,
+	}
+	checkDeps := func(location string, chart HelmChart) {
+		t.Helper()
+		if len(chart.Dependencies) != 1 || chart.Dependencies[0].Name != "mariadb" || chart.Dependencies[0].VersionMatchExpression != "5.x.x" {
+			t.Errorf("expected dependencies from requirements.yaml when reading %s, but got %#v", location, chart.Dependencies)
+		}
+	}
+
+	// from disk
+	checkDeps("from disk", newTestChart(t, files))
+
+	// from a set of files (e.g. a subchart in a chart tarball)
+	filesAsBytes := make(map[string][]byte, len(files))
+	for name, contents := range files {
+		filesAsBytes[name] = []byte(contents)
+	}
+	chart, err := readChartMetadataFromFiles(filesAsBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDeps("from a set of files", chart)
+
+	// requirements.yaml is optional
+	chart = newTestChart(t, map[string]string{"Chart.yaml": testChartYAMLv1})
+	if len(chart.Dependencies) != 0 {
+		t.Errorf("expected no dependencies without requirements.yaml, but got %#v", chart.Dependencies)
+	}
+	_, err = readChartMetadataFromFiles(map[string][]byte{"Chart.yaml": []byte(testChartYAMLv1)})
+	if err != nil {
+		t.Errorf("unexpected error without requirements.yaml: %s", err.Error())
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	// this chart includes the same chart twice under different aliases, and also has a dependency from a `file://` repository
+	chartYAML := `apiVersion: v2
+name: app
+version: 1.0.0
+dependencies:
+  - name: memcached
+    repository: https://charts.example.org/stable
+    version: ^7.0.0
+    alias: sessions
+    condition: sessions.enabled
+  - name: memcached
+    repository: https://charts.example.org/stable
+    version: ~6.0
+    alias: cache
+    tags:
+      - caching
+  - name: common
+    repository: file://../common
+    version: 1.x
+`
+	// entries for the same chart appear in the same order as in Chart.yaml (the digest was computed in the same way as for testChartLock)
+	chartLock := `dependencies:
+- name: memcached
+  repository: https://charts.example.org/stable
+  version: 7.8.6
+- name: memcached
+  repository: https://charts.example.org/stable
+  version: 6.0.2
+- name: common
+  repository: file://../common
+  version: 1.4.0
+digest: sha256:2c053341770bda7364e0f1aa01e521df84d41b47109db9e54ddba7e1ef8acacb
+generated: "2025-06-12T09:14:27.551803+02:00"
+`
+	baseFiles := map[string]string{
+		"app/Chart.yaml":                 chartYAML,
+		"app/Chart.lock":                 chartLock,
+		"app/values.yaml":                "sessions:\n  enabled: false\n",
+		"app/charts/memcached-7.8.6.tgz": "",
+		"app/charts/memcached-6.0.2.tgz": "",
+		"app/charts/common-1.4.0.tgz":    "",
+		"common/Chart.yaml":              "apiVersion: v2\nname: common\nversion: 1.4.0\n",
+	}
+
+	testCases := []struct {
+		Name          string
+		Files         map[string]string
+		ExpectedError string
+	}{
+		{
+			// the condition and tags do not matter, since `helm dep build` downloads all dependencies regardless
+			Name: "all subcharts packaged",
+		},
+		{
+			Name: "some subcharts unpacked",
+			Files: map[string]string{
+				"app/charts/memcached-7.8.6.tgz":  "<delete>",
+				"app/charts/memcached/Chart.yaml": "apiVersion: v2\nname: memcached\nversion: 7.8.6\n",
+				"app/charts/common-1.4.0.tgz":     "<delete>",
+				"app/charts/common/Chart.yaml":    "apiVersion: v2\nname: common\nversion: 1.4.0\n",
+			},
+		},
+		{
+			Name:          "subchart of disabled dependency is missing",
+			Files:         map[string]string{"app/charts/memcached-7.8.6.tgz": "<delete>"},
+			ExpectedError: "did not find expected file charts/memcached-7.8.6.tgz",
+		},
+		{
+			Name: "subchart both packaged and unpacked",
+			Files: map[string]string{
+				"app/charts/memcached/Chart.yaml": "apiVersion: v2\nname: memcached\nversion: 6.0.2\n",
+			},
+			ExpectedError: `found charts/memcached and charts/memcached-6.0.2.tgz, which both contain chart "memcached" in version 6.0.2`,
+		},
+		{
+			Name: "unpacked subchart with unexpected version",
+			Files: map[string]string{
+				"app/charts/memcached-6.0.2.tgz":  "",
+				"app/charts/memcached/Chart.yaml": "apiVersion: v2\nname: memcached\nversion: 6.0.3\n",
+			},
+			ExpectedError: `found unexpected directory charts/memcached (containing chart "memcached" in version 6.0.3)`,
+		},
+		{
+			Name:          "unexpected file",
+			Files:         map[string]string{"app/charts/redis-1.0.0.tgz": ""},
+			ExpectedError: "found unexpected file charts/redis-1.0.0.tgz",
+		},
+		{
+			Name: "aliased entries in Chart.lock in the wrong order",
+			Files: map[string]string{
+				"app/Chart.lock": strings.NewReplacer("version: 7.8.6", "version: 6.0.2", "version: 6.0.2", "version: 7.8.6").Replace(chartLock),
+			},
+			ExpectedError: `Chart.lock has dependency "memcached" (alias "sessions") at version 6.0.2, which does not satisfy the version constraint "^7.0.0"`,
+		},
+		{
+			Name:          "same alias used twice",
+			Files:         map[string]string{"app/Chart.yaml": strings.Replace(chartYAML, "alias: cache", "alias: sessions", 1)},
+			ExpectedError: `Chart.yaml declares multiple dependencies named "sessions"`,
+		},
+		{
+			// `helm dep build` packages the chart directory as-is, so it must still have the version from Chart.lock
+			Name:          "file:// dependency with mismatched version",
+			Files:         map[string]string{"common/Chart.yaml": "apiVersion: v2\nname: common\nversion: 1.5.0\n"},
+			ExpectedError: `dependency "common" is recorded with version 1.4.0, but the chart at file://../common has version 1.5.0`,
+		},
+		{
+			// the chart directory may have been moved away from its source after `helm dep build`
+			Name:  "file:// dependency without source",
+			Files: map[string]string{"common/Chart.yaml": "<delete>"},
+		},
+	}
+
+	for _, tc := range testCases {
+		files := maps.Clone(baseFiles)
+		for path, contents := range tc.Files {
+			if contents == "<delete>" {
+				delete(files, path)
+			} else {
+				files[path] = contents
+			}
+		}
+		chart, err := ParseHelmChartYAML(filepath.Join(writeTestFiles(t, files), "app"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = chart.ValidateDependencies()
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tc.Name, err.Error())
+		case tc.ExpectedError != "" && (err == nil || !strings.Contains(err.Error(), tc.ExpectedError)):
+			t.Errorf("%s: expected error %q, but got %v", tc.Name, tc.ExpectedError, err)
+		}
+	}
+}
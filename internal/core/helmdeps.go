@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sapcc/go-bits/logg"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// Media type of the layer containing the chart tarball in a Helm chart stored in an OCI registry.
+const helmChartContentMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// BuildDependencies implements `bundle --build-dependencies`.
+// Like `helm dependency build`, it places the exact dependency versions listed in Chart.lock into `charts/`.
+//
+// Dependencies are fetched from HTTP chart repositories (verifying the digest from the repository's index.yaml),
+// from OCI registries (verifying the digests from the manifest), or packaged from local directories for `file://` repositories.
+// Subcharts that are already present as unpacked directories below `charts/` are left alone.
+// Chart tarballs below `charts/` that do not belong to any dependency are removed.
+func (c HelmChart) BuildDependencies(ctx context.Context) error {
+	if len(c.Dependencies) == 0 {
+		return nil
+	}
+	files, err := c.getDependencyFiles()
+	if err != nil {
+		return err
+	}
+	chartLock, err := c.readValidatedLockFile(files)
+	if err != nil {
+		return err
+	}
+	chartsDirPath := filepath.Join(c.ChartPath, "charts")
+	err = os.MkdirAll(chartsDirPath, 0777) // NOTE: final mode is subject to umask
+	if err != nil {
+		return err
+	}
+
+	// find subcharts that are present as unpacked directories
+	entries, err := os.ReadDir(chartsDirPath)
+	if err != nil {
+		return err
+	}
+	isUnpacked := make(map[string]bool) // key = file name that the subchart would have as a tarball
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		subchart, err := ParseHelmChartYAML(filepath.Join(chartsDirPath, entry.Name()))
+		if err == nil {
+			isUnpacked[fmt.Sprintf("%s-%s.tgz", subchart.Name, subchart.Version)] = true
+		}
+	}
+
+	// fetch all dependencies
+	isWanted := make(map[string]bool)
+	for _, dep := range chartLock.Dependencies {
+		subchart, err := c.getPackagedDependency(dep)
+		if err != nil {
+			return fmt.Errorf("while building dependencies of %s: %w", c.ChartPath, err)
+		}
+		fileName := fmt.Sprintf("%s-%s.tgz", subchart.Name, subchart.Version)
+		if isWanted[fileName] || isUnpacked[fileName] {
+			isWanted[fileName] = true
+			continue
+		}
+		isWanted[fileName] = true
+
+		logg.Info("fetching dependency %s %s from %s...", subchart.Name, subchart.Version, subchart.Repository)
+		buf, err := c.fetchDependency(ctx, subchart)
+		if err != nil {
+			return fmt.Errorf("while fetching dependency %q of %s: %w", dep.Name, c.ChartPath, err)
+		}
+		err = os.WriteFile(filepath.Join(chartsDirPath, fileName), buf, 0666) // NOTE: final mode is subject to umask
+		if err != nil {
+			return err
+		}
+	}
+
+	// remove stale tarballs
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".tgz") && !isWanted[entry.Name()] {
+			logg.Info("removing stale dependency %s", filepath.Join(chartsDirPath, entry.Name()))
+			err := os.Remove(filepath.Join(chartsDirPath, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the contents of the chart tarball for the given dependency.
+func (c HelmChart) fetchDependency(ctx context.Context, dep ComputedChartDependency) ([]byte, error) {
+	var (
+		buf []byte
+		err error
+	)
+	switch {
+	case strings.HasPrefix(dep.Repository, "file://"):
+		buf, err = c.packageLocalDependency(dep)
+	case strings.HasPrefix(dep.Repository, "oci://"):
+		buf, err = fetchDependencyFromRegistry(ctx, dep)
+	case strings.HasPrefix(dep.Repository, "http://"), strings.HasPrefix(dep.Repository, "https://"):
+		buf, err = fetchDependencyFromChartRepository(ctx, dep)
+	default:
+		return nil, fmt.Errorf("unsupported repository %q (expected an http://, https://, oci:// or file:// URL)", dep.Repository)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// check that we got what we asked for
+	files, err := readChartTarball(buf)
+	if err != nil {
+		return nil, fmt.Errorf("while reading chart tarball: %w", err)
+	}
+	subchart, err := readChartMetadataFromFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("while reading chart tarball: %w", err)
+	}
+	if subchart.Name != dep.Name || subchart.Version != dep.Version {
+		return nil, fmt.Errorf("expected chart %q in version %s, but got chart %q in version %s",
+			dep.Name, dep.Version, subchart.Name, subchart.Version)
+	}
+	return buf, nil
+}
+
+// Packages the chart from a `file://` repository into a tarball, like `helm dep build` would.
+// Like in `helm package`, files matched by the chart's .helmignore file are not included.
+func (c HelmChart) packageLocalDependency(dep ComputedChartDependency) ([]byte, error) {
+	localPath := strings.TrimPrefix(dep.Repository, "file://")
+	if !filepath.IsAbs(localPath) {
+		localPath = filepath.Join(c.ChartPath, localPath)
+	}
+	files, err := readChartDirectory(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// by convention, all files in a chart tarball are below a directory named after the chart
+	prefixedFiles := make(map[string][]byte, len(files))
+	for relPath, buf := range files {
+		prefixedFiles[dep.Name+"/"+relPath] = buf
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	err = oci.WriteTarball(gz, prefixedFiles)
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	return buf.Bytes(), err
+}
+
+// Pulls a chart from an OCI registry, in the same location where `helm push` would have put it.
+func fetchDependencyFromRegistry(ctx context.Context, dep ComputedChartDependency) ([]byte, error) {
+	host, repoPath, _ := strings.Cut(strings.TrimPrefix(dep.Repository, "oci://"), "/")
+	repoPath = strings.Trim(repoPath+"/"+dep.Name, "/")
+	// OCI tags cannot contain "+", so Helm replaces it with "_"
+	tag := strings.ReplaceAll(dep.Version, "+", "_")
+
+	registry, err := oci.NewRegistry(host)
+	if err != nil {
+		return nil, err
+	}
+	repo := registry.Repository(repoPath)
+	_, manifestBuf, err := repo.GetManifest(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	var manifest oci.Manifest
+	err = json.Unmarshal(manifestBuf, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing manifest of %s/%s:%s: %w", host, repoPath, tag, err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == helmChartContentMediaType {
+			// NOTE: ReadBlob() verifies the digest
+			return oci.ReadBlob(ctx, repo, layer.Digest)
+		}
+	}
+	return nil, fmt.Errorf("%s/%s:%s is not a Helm chart (no layer with media type %q)", host, repoPath, tag, helmChartContentMediaType)
+}
+
+// Downloads a chart from an HTTP chart repository, using the repository's index.yaml to find it.
+func fetchDependencyFromChartRepository(ctx context.Context, dep ComputedChartDependency) ([]byte, error) {
+	repoURL, err := url.Parse(strings.TrimSuffix(dep.Repository, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL %q: %w", dep.Repository, err)
+	}
+	creds, err := findChartRepositoryCredentials(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	indexURL := repoURL.JoinPath("index.yaml")
+	indexBuf, err := httpGet(ctx, indexURL, creds)
+	if err != nil {
+		return nil, err
+	}
+	type indexEntry struct {
+		Version string   `yaml:"version"`
+		URLs    []string `yaml:"urls"`
+		Digest  string   `yaml:"digest"`
+	}
+	var index struct {
+		Entries map[string][]indexEntry `yaml:"entries"`
+	}
+	err = yaml.Unmarshal(indexBuf, &index)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %w", indexURL.String(), err)
+	}
+
+	for _, entry := range index.Entries[dep.Name] {
+		if entry.Version != dep.Version {
+			continue
+		}
+		if len(entry.URLs) == 0 {
+			return nil, fmt.Errorf("%s does not list any URLs for chart %q in version %s", indexURL.String(), dep.Name, dep.Version)
+		}
+		if entry.Digest == "" {
+			return nil, fmt.Errorf("%s does not list a digest for chart %q in version %s", indexURL.String(), dep.Name, dep.Version)
+		}
+		chartURL, err := repoURL.Parse(entry.URLs[0]) // URLs may be relative to the repository
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q in %s: %w", entry.URLs[0], indexURL.String(), err)
+		}
+		// like Helm, only send credentials to other hosts if explicitly allowed
+		chartCreds := creds
+		if creds != nil && !creds.PassCredentialsAll && (chartURL.Scheme != repoURL.Scheme || chartURL.Host != repoURL.Host) {
+			chartCreds = nil
+		}
+		buf, err := httpGet(ctx, chartURL, chartCreds)
+		if err != nil {
+			return nil, err
+		}
+		err = oci.VerifyDigest(buf, "sha256:"+entry.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("while downloading %s: %w", chartURL.String(), err)
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%s does not list chart %q in version %s", indexURL.String(), dep.Name, dep.Version)
+}
+
+// chartRepositoryCredentials is an entry in Helm's repositories.yaml, as written by `helm repo add --username ... --password ...`.
+type chartRepositoryCredentials struct {
+	URL                string `yaml:"url"`
+	Username           string `yaml:"username"`
+	Password           string `yaml:"password"`
+	PassCredentialsAll bool   `yaml:"pass_credentials_all"`
+}
+
+// Finds credentials for the given HTTP chart repository in the repository config file of the Helm CLI.
+// Returns nil if there are none, in which case requests are sent without credentials.
+func findChartRepositoryCredentials(repoURL *url.URL) (*chartRepositoryCredentials, error) {
+	// this is the same lookup order as in Helm's pkg/helmpath
+	configPath := os.Getenv("HELM_REPOSITORY_CONFIG")
+	if configPath == "" {
+		configDir := os.Getenv("HELM_CONFIG_HOME")
+		if configDir == "" {
+			userConfigDir, err := os.UserConfigDir()
+			if err != nil {
+				return nil, nil //nolint:nilerr // no config directory means no Helm config, which is not an error
+			}
+			if runtime.GOOS == "darwin" {
+				// Helm deviates from os.UserConfigDir() on macOS
+				userConfigDir = filepath.Join(filepath.Dir(userConfigDir), "Preferences")
+			}
+			configDir = filepath.Join(userConfigDir, "helm")
+		}
+		configPath = filepath.Join(configDir, "repositories.yaml")
+	}
+	buf, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var data struct {
+		Repositories []chartRepositoryCredentials `yaml:"repositories"`
+	}
+	err = yaml.Unmarshal(buf, &data)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %w", configPath, err)
+	}
+	for _, entry := range data.Repositories {
+		if strings.TrimSuffix(entry.URL, "/") == strings.TrimSuffix(repoURL.String(), "/") && entry.Username != "" {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+func httpGet(ctx context.Context, u *url.URL, creds *chartRepositoryCredentials) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned unexpected status %s", u.String(), resp.Status)
+	}
+	return buf, nil
+}
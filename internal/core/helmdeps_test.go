@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// Builds a minimal chart tarball, like `helm package` would produce.
+func makeTestChartTarball(t *testing.T, name, version string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	err := oci.WriteTarball(gz, map[string][]byte{
+		name + "/Chart.yaml": fmt.Appendf(nil, "apiVersion: v2\nname: %s\nversion: %s\n", name, version),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchDependencyFromChartRepository(t *testing.T) {
+	tarball := makeTestChartTarball(t, "memcached", "7.8.6")
+	otherTarball := makeTestChartTarball(t, "redis", "1.0.0")
+
+	// this server hosts charts for the repository below, and must not receive its credentials
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "" {
+			http.Error(w, "unexpected credentials", http.StatusBadRequest)
+			return
+		}
+		w.Write(otherTarball)
+	}))
+	t.Cleanup(otherServer.Close)
+
+	index := fmt.Sprintf(`apiVersion: v1
+entries:
+  memcached:
+  - name: memcached
+    version: 7.8.6
+    urls: [ charts/memcached-7.8.6.tgz ]
+    digest: %[1]s
+  - name: memcached
+    version: 7.8.5
+    urls: [ charts/memcached-7.8.6.tgz ]
+    digest: %[2]s
+  redis:
+  - name: redis
+    version: 1.0.0
+    urls: [ %[3]s/redis-1.0.0.tgz ]
+    digest: %[4]s
+`,
+		strings.TrimPrefix(oci.DigestOf(tarball), "sha256:"),
+		strings.TrimPrefix(oci.DigestOf(otherTarball), "sha256:"),
+		otherServer.URL,
+		strings.TrimPrefix(oci.DigestOf(otherTarball), "sha256:"),
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != testRegistryUser || password != testRegistryPassword {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/stable/index.yaml":
+			w.Write([]byte(index))
+		case "/stable/charts/memcached-7.8.6.tgz":
+			w.Write(tarball)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	// credentials are taken from Helm's repository config
+	configPath := filepath.Join(t.TempDir(), "repositories.yaml")
+	t.Setenv("HELM_REPOSITORY_CONFIG", configPath)
+	err := os.WriteFile(configPath, fmt.Appendf(nil,
+		"apiVersion: \"\"\nrepositories:\n- name: stable\n  url: %s/stable/\n  username: %s\n  password: %s\n",
+		server.URL, testRegistryUser, testRegistryPassword,
+	), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name            string
+		Version         string
+		ExpectedTarball []byte
+		ExpectedError   string
+	}{
+		// the URL in the index is relative to the repository
+		{"memcached", "7.8.6", tarball, ""},
+		{"memcached", "7.8.5", nil, "while downloading " + server.URL + "/stable/charts/memcached-7.8.6.tgz: expected digest"},
+		{"memcached", "9.9.9", nil, server.URL + `/stable/index.yaml does not list chart "memcached" in version 9.9.9`},
+		// credentials are not sent to other hosts
+		{"redis", "1.0.0", otherTarball, ""},
+	}
+	for _, tc := range testCases {
+		dep := ComputedChartDependency{Name: tc.Name, Repository: server.URL + "/stable", Version: tc.Version}
+		buf, err := fetchDependencyFromChartRepository(t.Context(), dep)
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("%s %s: unexpected error: %s", tc.Name, tc.Version, err.Error())
+		case tc.ExpectedError == "" && !bytes.Equal(buf, tc.ExpectedTarball):
+			t.Errorf("%s %s: got unexpected chart tarball", tc.Name, tc.Version)
+		case tc.ExpectedError != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.ExpectedError)):
+			t.Errorf("%s %s: expected error %q, but got %v", tc.Name, tc.Version, tc.ExpectedError, err)
+		}
+	}
+
+	// without credentials, the request fails
+	t.Setenv("HELM_REPOSITORY_CONFIG", filepath.Join(t.TempDir(), "does-not-exist.yaml"))
+	dep := ComputedChartDependency{Name: "memcached", Repository: server.URL + "/stable", Version: "7.8.6"}
+	_, err = fetchDependencyFromChartRepository(t.Context(), dep)
+	expectedError := "GET " + server.URL + "/stable/index.yaml returned unexpected status 401 Unauthorized"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+}
+
+func TestFetchDependencyFromRegistry(t *testing.T) {
+	tarball := makeTestChartTarball(t, "memcached", "7.8.6+build.1")
+	makeManifest := func(layers ...oci.Descriptor) testManifest {
+		buf, err := json.Marshal(oci.Manifest{SchemaVersion: 2, MediaType: oci.ImageManifestMediaType, Layers: layers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testManifest{MediaType: oci.ImageManifestMediaType, Contents: buf}
+	}
+	chartLayer := oci.Descriptor{MediaType: helmChartContentMediaType, Digest: oci.DigestOf(tarball), Size: int64(len(tarball))}
+	corruptLayer := oci.Descriptor{MediaType: helmChartContentMediaType, Digest: oci.DigestOf([]byte("corrupt")), Size: int64(len(tarball))}
+	imageLayer := oci.Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: oci.DigestOf(tarball), Size: int64(len(tarball))}
+
+	registry := &testRegistry{
+		Manifests: map[string]testManifest{
+			// OCI tags cannot contain "+", so Helm replaces it with "_"
+			"charts/memcached:7.8.6_build.1": makeManifest(chartLayer),
+			"charts/memcached:7.8.7":         makeManifest(corruptLayer),
+			"charts/memcached:7.8.8":         makeManifest(imageLayer),
+		},
+		Blobs: map[string][]byte{
+			chartLayer.Digest:   tarball,
+			corruptLayer.Digest: tarball,
+		},
+	}
+	host := registry.Start(t)
+
+	testCases := []struct {
+		Version       string
+		ExpectedError string
+	}{
+		{"7.8.6+build.1", ""},
+		{"7.8.7", "expected digest " + corruptLayer.Digest},
+		{"7.8.8", host + "/charts/memcached:7.8.8 is not a Helm chart"},
+		{"7.8.9", "manifest charts/memcached:7.8.9 in " + host + " not found"},
+	}
+	for _, tc := range testCases {
+		dep := ComputedChartDependency{Name: "memcached", Repository: "oci://" + host + "/charts", Version: tc.Version}
+		buf, err := fetchDependencyFromRegistry(t.Context(), dep)
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tc.Version, err.Error())
+		case tc.ExpectedError == "" && !bytes.Equal(buf, tarball):
+			t.Errorf("%s: got unexpected chart tarball", tc.Version)
+		case tc.ExpectedError != "" && (err == nil || !strings.Contains(err.Error(), tc.ExpectedError)):
+			t.Errorf("%s: expected error %q, but got %v", tc.Version, tc.ExpectedError, err)
+		}
+	}
+}
+
+func TestBuildDependencies(t *testing.T) {
+	rootPath := writeTestFiles(t, map[string]string{
+		"parent/Chart.yaml": `apiVersion: v2
+name: parent
+version: 1.0.0
+dependencies:
+  - name: child
+    version: ~1.0
+    repository: file://../child
+`,
+		"parent/Chart.lock": `dependencies:
+- name: child
+  repository: file://../child
+  version: 1.0.0
+digest: sha256:17636c497a83914a64c2de7b807926be45fa55b037cf0e22f74d8993b3e1ef44
+generated: "2025-06-11T14:02:31.184512+02:00"
+`,
+		"parent/charts/child-0.9.0.tgz": "stale tarball from a previous version",
+		"parent/charts/README.md":       "not a tarball, so this is not touched",
+
+		"child/Chart.yaml":                 "apiVersion: v2\nname: child\nversion: 1.0.0\n",
+		"child/.helmignore":                "# backup files\n*.bak\ntests/\n",
+		"child/values.yaml":                "replicas: 1\n",
+		"child/values.yaml.bak":            "replicas: 0\n",
+		"child/tests/test.yaml":            "ignored because of .helmignore\n",
+		"child/templates/.editorconfig":    "ignored by default\n",
+		"child/templates/deployment.yaml":  "kind: Deployment\n",
+		"child/templates/tests/test.yaml":  "also ignored because of .helmignore\n",
+		"child/templates/_helpers.tpl.bak": "ignored because of .helmignore\n",
+	})
+	chart, err := ParseHelmChartYAML(filepath.Join(rootPath, "parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = chart.BuildDependencies(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale tarball is removed, and the dependency is packaged
+	entries, err := os.ReadDir(filepath.Join(rootPath, "parent", "charts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entryNames []string
+	for _, entry := range entries {
+		entryNames = append(entryNames, entry.Name())
+	}
+	if strings.Join(entryNames, ", ") != "README.md, child-1.0.0.tgz" {
+		t.Errorf("unexpected entries in charts/: %v", entryNames)
+	}
+
+	// files matched by .helmignore are not packaged
+	buf, err := os.ReadFile(filepath.Join(rootPath, "parent", "charts", "child-1.0.0.tgz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := readChartTarball(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNames := slices.Sorted(maps.Keys(files))
+	if strings.Join(fileNames, ", ") != ".helmignore, Chart.yaml, templates/deployment.yaml, values.yaml" {
+		t.Errorf("unexpected files in packaged dependency: %v", fileNames)
+	}
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// helmIgnoreRules are the rules from a .helmignore file.
+//
+// The semantics are ported from Helm's pkg/ignore, including its quirks (e.g. "**" is not supported),
+// so that we package exactly the same files as `helm package` would.
+type helmIgnoreRules []helmIgnorePattern
+
+type helmIgnorePattern struct {
+	Pattern string
+	// If true, the pattern is matched against the full path below the chart directory.
+	// Otherwise, it is matched against the file name only.
+	MatchFullPath bool
+	// If true, the pattern started with "!".
+	Negate bool
+	// If true, the pattern ended with "/" and thus only matches directories.
+	MustBeDir bool
+}
+
+// Helm ignores these in addition to what the chart's .helmignore says.
+var defaultHelmIgnoreRules = []string{"templates/.?*"}
+
+// Parses the .helmignore file in the given chart directory, if there is one.
+func readHelmIgnoreFile(chartPath string) (helmIgnoreRules, error) {
+	filePath := filepath.Join(chartPath, ".helmignore")
+	buf, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	lines := append(strings.Split(string(buf), "\n"), defaultHelmIgnoreRules...)
+	var rules helmIgnoreRules
+	for idx, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "**") {
+			return nil, fmt.Errorf("while parsing %s: double-star (**) syntax is not supported in line %d", filePath, idx+1)
+		}
+		_, err := path.Match(line, "abc")
+		if err != nil {
+			return nil, fmt.Errorf("while parsing %s: invalid pattern in line %d: %w", filePath, idx+1, err)
+		}
+
+		var p helmIgnorePattern
+		p.Pattern, p.Negate = strings.CutPrefix(line, "!")
+		p.Pattern, p.MustBeDir = strings.CutSuffix(p.Pattern, "/")
+		var isRooted bool
+		p.Pattern, isRooted = strings.CutPrefix(p.Pattern, "/")
+		p.MatchFullPath = isRooted || strings.Contains(p.Pattern, "/")
+		rules = append(rules, p)
+	}
+	return rules, nil
+}
+
+// Returns whether the given path (relative to the chart directory, with forward slashes) shall be ignored.
+func (rules helmIgnoreRules) Ignores(relPath string, isDir bool) bool {
+	for _, p := range rules {
+		name := relPath
+		if !p.MatchFullPath {
+			name = path.Base(relPath)
+		}
+		isMatch, _ := path.Match(p.Pattern, name) //nolint:errcheck // patterns were validated in readHelmIgnoreFile()
+
+		// NOTE: This is how Helm evaluates negated patterns: Everything that does not match them is ignored.
+		if p.Negate {
+			if (p.MustBeDir && !isDir) || !isMatch {
+				return true
+			}
+			continue
+		}
+		if p.MustBeDir && !isDir {
+			continue
+		}
+		if isMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// Like readDirectoryRecursively(), but skips files that are excluded by the chart's .helmignore file.
+func readChartDirectory(chartPath string) (map[string][]byte, error) {
+	rules, err := readHelmIgnoreFile(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	err = filepath.WalkDir(chartPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(chartPath, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		switch {
+		case relPath == ".":
+			return nil
+		case entry.IsDir():
+			if rules.Ignores(relPath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		case rules.Ignores(relPath, false):
+			return nil
+		case entry.Type().IsRegular():
+			files[relPath], err = os.ReadFile(filePath)
+			return err
+		default:
+			return fmt.Errorf("cannot package %s: expected only regular files and directories, but found %s", filePath, entry.Type().String())
+		}
+	})
+	return files, err
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelmIgnoreRules(t *testing.T) {
+	chartPath := writeTestFiles(t, map[string]string{
+		".helmignore": strings.Join([]string{
+			"# patterns without slash match the file name anywhere",
+			"*.bak",
+			"# patterns with slash match the full path",
+			"docs/*.md",
+			"/secrets",
+			"# patterns with trailing slash only match directories",
+			"tmp/",
+			"",
+		}, "\n"),
+	})
+	rules, err := readHelmIgnoreFile(chartPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Path     string
+		IsDir    bool
+		Expected bool
+	}{
+		{"values.yaml", false, false},
+		{"values.yaml.bak", false, true},
+		{"templates/deployment.yaml.bak", false, true},
+		{"docs/README.md", false, true},
+		{"docs/examples/README.md", false, false},
+		{"secrets", true, true},
+		{"templates/secrets", true, false},
+		{"tmp", true, true},
+		{"tmp", false, false},
+		// default rule: hidden files in templates/ are always ignored
+		{"templates/.editorconfig", false, true},
+		{"templates/_helpers.tpl", false, false},
+	}
+	for _, tc := range testCases {
+		actual := rules.Ignores(tc.Path, tc.IsDir)
+		if actual != tc.Expected {
+			t.Errorf("expected Ignores(%q, %t) = %t, but got %t", tc.Path, tc.IsDir, tc.Expected, actual)
+		}
+	}
+
+	// like in Helm, "**" is rejected instead of being silently misinterpreted
+	chartPath = writeTestFiles(t, map[string]string{".helmignore": "docs/**/*.md\n"})
+	_, err = readHelmIgnoreFile(chartPath)
+	if err == nil || !strings.Contains(err.Error(), "double-star (**) syntax is not supported in line 1") {
+		t.Errorf("expected error for double-star pattern, but got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/util"
+)
+
+// RenderedImage is an image reference that was found in the rendered manifests of a Helm chart.
+type RenderedImage struct {
+	Kind          string // e.g. "Deployment"
+	ObjectName    string // e.g. "gatekeeper-controller-manager"
+	ContainerName string
+	Image         string // as written in the manifest
+}
+
+// String returns a human-readable description of where the image was found.
+func (i RenderedImage) String() string {
+	return fmt.Sprintf("image %s in container %q of %s %q", i.Image, i.ContainerName, i.Kind, i.ObjectName)
+}
+
+// RenderHelmChart runs `helm template` on the given Helm chart, and returns the rendered manifests.
+// The given values files are applied in order, after the chart's own values.yaml.
+func RenderHelmChart(ctx context.Context, chart HelmChart, valuesFilePaths []string) ([]byte, error) {
+	args := []string{"template", chart.Name, chart.ChartPath}
+	for _, path := range valuesFilePaths {
+		args = append(args, "--values", path)
+	}
+	return util.ExecHelm(ctx, args...)
+}
+
+// Where pod specs are located within objects of the respective kinds.
+var podSpecPathsByKind = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// FindImagesInManifests collects all container images from pod specs in the given multi-document YAML stream,
+// e.g. as produced by RenderHelmChart().
+func FindImagesInManifests(buf []byte) ([]RenderedImage, error) {
+	type container struct {
+		Name  string `yaml:"name"`
+		Image string `yaml:"image"`
+	}
+	type podSpec struct {
+		Containers          []container `yaml:"containers"`
+		InitContainers      []container `yaml:"initContainers"`
+		EphemeralContainers []container `yaml:"ephemeralContainers"`
+	}
+
+	var result []RenderedImage
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	for {
+		var obj map[string]any
+		err := dec.Decode(&obj)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("while parsing rendered manifests: %w", err)
+		}
+
+		kind, _ := obj["kind"].(string)
+		podSpecPath, ok := podSpecPathsByKind[kind]
+		if !ok {
+			continue
+		}
+		var objectName string
+		if metadata, ok := obj["metadata"].(map[string]any); ok {
+			objectName, _ = metadata["name"].(string)
+		}
+
+		// navigate to pod spec
+		var node any = obj
+		for _, key := range podSpecPath {
+			m, ok := node.(map[string]any)
+			if !ok {
+				break
+			}
+			node = m[key]
+		}
+		if node == nil {
+			continue
+		}
+
+		// round-trip through YAML to decode into a typed structure
+		podSpecBuf, err := yaml.Marshal(node)
+		if err != nil {
+			return nil, err
+		}
+		var spec podSpec
+		err = yaml.Unmarshal(podSpecBuf, &spec)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing pod spec in %s %q: %w", kind, objectName, err)
+		}
+		for _, c := range slices.Concat(spec.Containers, spec.InitContainers, spec.EphemeralContainers) {
+			if c.Image != "" {
+				result = append(result, RenderedImage{Kind: kind, ObjectName: objectName, ContainerName: c.Name, Image: c.Image})
+			}
+		}
+	}
+}
+
+// FindUncoveredImages returns all images that do not match the image reference of any of the given image relations.
+//
+// An image matches if it has the same repository, and the same digest (if the image has one) or the same tag.
+// Images without tag and digest are considered to have the tag "latest", like the container runtime would.
+func FindUncoveredImages(images []RenderedImage, rels ImageRelations) []RenderedImage {
+	var result []RenderedImage
+	for _, img := range images {
+		if !slices.ContainsFunc(rels, func(rel *ImageRelation) bool { return imageMatches(img.Image, rel.ImageReference) }) {
+			result = append(result, img)
+		}
+	}
+	return result
+}
+
+func imageMatches(imageStr string, ref reference.Named) bool {
+	parsed, err := reference.ParseNormalizedNamed(imageStr)
+	if err != nil || parsed.Name() != ref.Name() {
+		return false
+	}
+	if digested, ok := parsed.(reference.Digested); ok {
+		refDigested, ok := ref.(reference.Digested)
+		return ok && refDigested.Digest() == digested.Digest()
+	}
+	tagged, ok := reference.TagNameOnly(parsed).(reference.Tagged)
+	if !ok {
+		return false
+	}
+	refTagged, ok := reference.TagNameOnly(ref).(reference.Tagged)
+	return ok && refTagged.Tag() == tagged.Tag()
+}
+
+// FindUncoveredImagesInChart renders the given chart with RenderHelmChart(), and returns all images in the rendered manifests
+// that are not covered by the given image relations, as determined by FindUncoveredImages().
+func FindUncoveredImagesInChart(ctx context.Context, chart HelmChart, rels ImageRelations, valuesFilePaths []string) ([]RenderedImage, error) {
+	buf, err := RenderHelmChart(ctx, chart, valuesFilePaths)
+	if err != nil {
+		return nil, err
+	}
+	images, err := FindImagesInManifests(buf)
+	if err != nil {
+		return nil, fmt.Errorf("while inspecting rendered manifests of chart %q: %w", chart.Name, err)
+	}
+	return FindUncoveredImages(images, rels), nil
+}
+
+// VerifyHermeticity implements `bundle --strict`.
+// It renders this chart with a localized-values.yaml like `unbundle` would produce for the given image relations,
+// and returns an error if the rendered manifests contain any images that are not entirely taken from localized-values.yaml.
+//
+// To tell apart images from localized-values.yaml from images that are hardcoded in the chart (even if they are identical to a related image),
+// each related image is replaced by a sentinel reference for this purpose, see withSentinelImages().
+func (c HelmChart) VerifyHermeticity(ctx context.Context, rels ImageRelations) error {
+	sentinelRels, err := rels.withSentinelImages()
+	if err != nil {
+		return fmt.Errorf("while checking hermeticity of chart %q: %w", c.Name, err)
+	}
+	localizedValues, err := sentinelRels.BuildLocalizedValues()
+	if err != nil {
+		return fmt.Errorf("could not build localized-values.yaml for chart %q: %w", c.Name, err)
+	}
+	buf, err := yaml.Marshal(localizedValues)
+	if err != nil {
+		return fmt.Errorf("could not marshal localized-values.yaml for chart %q: %w", c.Name, err)
+	}
+	file, err := os.CreateTemp("", "localized-values-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(buf)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	buf, err = RenderHelmChart(ctx, c, []string{file.Name()})
+	if err != nil {
+		return fmt.Errorf("while checking hermeticity of chart %q: %w", c.Name, err)
+	}
+	return c.verifyHermeticityOfManifests(buf, sentinelRels)
+}
+
+// Checks that all images in the given rendered manifests are equal to the sentinel images from the given image relations.
+func (c HelmChart) verifyHermeticityOfManifests(buf []byte, sentinelRels ImageRelations) error {
+	images, err := FindImagesInManifests(buf)
+	if err != nil {
+		return fmt.Errorf("while inspecting rendered manifests of chart %q: %w", c.Name, err)
+	}
+	uncoveredImages := FindUncoveredImages(images, sentinelRels)
+	if len(uncoveredImages) == 0 {
+		return nil
+	}
+	descriptions := make([]string, len(uncoveredImages))
+	for idx, img := range uncoveredImages {
+		descriptions[idx] = img.String()
+	}
+	return fmt.Errorf("chart %q is not hermetic: the following images are not entirely taken from image relations: %s "+
+		"(for this check, related images were replaced by references like %s, so parts of references that look like this were taken from image relations, but the other parts were not)",
+		c.Name, strings.Join(descriptions, "; "), hermeticitySentinelRegistry+"/sentinel-1:sentinel-1")
+}
+
+// The registry of the sentinel images used by VerifyHermeticity().
+// The ".invalid" TLD is reserved by RFC 2606, so this cannot collide with a real registry.
+const hermeticitySentinelRegistry = "hermeticity-check.invalid"
+
+// Returns a copy of these image relations where each distinct image is replaced by a sentinel image.
+// Each sentinel has the same shape as the image that it replaces (i.e. it has a tag or digest if and only if the original has one),
+// but each of its parts differs from the original and from every other sentinel.
+// A rendered image can therefore only be equal to a sentinel if it was entirely taken from localized-values.yaml.
+func (rels ImageRelations) withSentinelImages() (ImageRelations, error) {
+	sentinels := make(map[string]reference.Named)
+	result := make(ImageRelations, len(rels))
+	for idx, rel := range rels {
+		sentinel, exists := sentinels[rel.ImageReference.String()]
+		if !exists {
+			sentinelStr := fmt.Sprintf("%s/sentinel-%d", hermeticitySentinelRegistry, len(sentinels)+1)
+			if _, ok := rel.ImageReference.(reference.Tagged); ok {
+				sentinelStr += fmt.Sprintf(":sentinel-%d", len(sentinels)+1)
+			}
+			if _, ok := rel.ImageReference.(reference.Digested); ok {
+				sentinelStr += "@" + digest.FromString(sentinelStr).String()
+			}
+			var err error
+			sentinel, err = reference.ParseNormalizedNamed(sentinelStr)
+			if err != nil {
+				return nil, fmt.Errorf("could not build sentinel for image %s: %w", rel.ImageReference.String(), err)
+			}
+			sentinels[rel.ImageReference.String()] = sentinel
+		}
+		clonedRel := *rel
+		clonedRel.ImageReference = sentinel
+		result[idx] = &clonedRel
+	}
+	return result, nil
+}
@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func mustParseImageRelations(t *testing.T, inputs ...string) ImageRelations {
+	t.Helper()
+	rels, err := ParseImageRelations(t.Context(), inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rels
+}
+
+func TestFindImagesInManifests(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	testCases := []struct {
+		Name     string
+		Manifest string
+		Expected []RenderedImage
+	}{
+		{
+			Name: "Pod",
+			Manifest: `
+apiVersion: v1
+kind: Pod
+metadata: { name: example }
+spec:
+  containers:
+    - { name: app, image: "quay.io/foo/app:1.0" }
+`,
+			Expected: []RenderedImage{{"Pod", "example", "app", "quay.io/foo/app:1.0"}},
+		},
+		{
+			Name: "Deployment with init and ephemeral containers",
+			Manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: { name: example }
+spec:
+  template:
+    spec:
+      ephemeralContainers:
+        - { name: debug, image: "busybox" }
+      initContainers:
+        - { name: migrate, image: "quay.io/foo/app@` + digest + `" }
+      containers:
+        - { name: app, image: "quay.io/foo/app:1.0@` + digest + `" }
+        - { name: sidecar, image: "quay.io/foo/sidecar:2.0" }
+`,
+			// containers are reported first, then init containers, then ephemeral containers
+			Expected: []RenderedImage{
+				{"Deployment", "example", "app", "quay.io/foo/app:1.0@" + digest},
+				{"Deployment", "example", "sidecar", "quay.io/foo/sidecar:2.0"},
+				{"Deployment", "example", "migrate", "quay.io/foo/app@" + digest},
+				{"Deployment", "example", "debug", "busybox"},
+			},
+		},
+		{
+			Name: "CronJob",
+			Manifest: `
+apiVersion: batch/v1
+kind: CronJob
+metadata: { name: example }
+spec:
+  schedule: "@hourly"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - { name: job, image: "quay.io/foo/job:1.0" }
+`,
+			Expected: []RenderedImage{{"CronJob", "example", "job", "quay.io/foo/job:1.0"}},
+		},
+		{
+			Name: "multiple documents with other kinds",
+			Manifest: `
+apiVersion: v1
+kind: ConfigMap
+metadata: { name: example }
+data:
+  image: quay.io/foo/not-an-image:1.0
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: { name: example }
+spec:
+  template:
+    spec:
+      containers:
+        - { name: db, image: "docker.io/library/postgres:17" }
+---
+# empty documents (e.g. from templates that render nothing) are skipped
+---
+apiVersion: batch/v1
+kind: Job
+metadata: { name: example }
+spec:
+  template:
+    spec:
+      containers:
+        - { name: job, image: "quay.io/foo/job:1.0" }
+`,
+			Expected: []RenderedImage{
+				{"StatefulSet", "example", "db", "docker.io/library/postgres:17"},
+				{"Job", "example", "job", "quay.io/foo/job:1.0"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		images, err := FindImagesInManifests([]byte(tc.Manifest))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.Name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(images, tc.Expected) {
+			t.Errorf("%s: expected %#v, but got %#v", tc.Name, tc.Expected, images)
+		}
+	}
+}
+
+func TestVerifyHermeticityOfManifests(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	rels := mustParseImageRelations(t,
+		".Values.image.repository is repository of quay.io/foo/app:1.0",
+		".Values.image.tag is tag of quay.io/foo/app:1.0",
+		".Values.sidecar.image is reference of quay.io/foo/sidecar@"+digest,
+	)
+	sentinelRels, err := rels.withSentinelImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each distinct image gets its own sentinel with the same shape, and the original relations are unchanged
+	appSentinel := sentinelRels[0].ImageReference.String()
+	sidecarSentinel := sentinelRels[2].ImageReference.String()
+	if appSentinel != "hermeticity-check.invalid/sentinel-1:sentinel-1" || sentinelRels[1].ImageReference.String() != appSentinel {
+		t.Errorf("unexpected sentinel for app image: %q", appSentinel)
+	}
+	if !strings.HasPrefix(sidecarSentinel, "hermeticity-check.invalid/sentinel-2@sha256:") {
+		t.Errorf("unexpected sentinel for sidecar image: %q", sidecarSentinel)
+	}
+	if rels[0].ImageReference.String() != "quay.io/foo/app:1.0" {
+		t.Errorf("original image relation was modified: %#v", rels[0])
+	}
+
+	makeManifest := func(images ...string) string {
+		var sb strings.Builder
+		sb.WriteString("apiVersion: v1\nkind: Pod\nmetadata: { name: example }\nspec:\n  containers:\n")
+		for idx, image := range images {
+			sb.WriteString("    - { name: c" + strconv.Itoa(idx) + ", image: \"" + image + "\" }\n")
+		}
+		return sb.String()
+	}
+	chart := HelmChart{Name: "example"}
+	testCases := []struct {
+		Name          string
+		Manifest      string
+		ExpectedError string
+	}{
+		{
+			Name:     "fully localized",
+			Manifest: makeManifest(appSentinel, sidecarSentinel),
+		},
+		{
+			// this is the case that a plain comparison with the related images cannot detect
+			Name:          "hardcoded image that is identical to a related image",
+			Manifest:      makeManifest(appSentinel, "quay.io/foo/sidecar@"+digest),
+			ExpectedError: `image quay.io/foo/sidecar@` + digest + ` in container "c1" of Pod "example"`,
+		},
+		{
+			Name:          "hardcoded image that is unrelated",
+			Manifest:      makeManifest("busybox:1.36", sidecarSentinel),
+			ExpectedError: `image busybox:1.36 in container "c0" of Pod "example"`,
+		},
+		{
+			// e.g. if the chart ignores .Values.image.repository, and only takes the tag from the values
+			Name:          "partially localized image",
+			Manifest:      makeManifest("quay.io/foo/app:sentinel-1", sidecarSentinel),
+			ExpectedError: `image quay.io/foo/app:sentinel-1 in container "c0" of Pod "example"`,
+		},
+	}
+	for _, tc := range testCases {
+		err := chart.verifyHermeticityOfManifests([]byte(tc.Manifest), sentinelRels)
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tc.Name, err.Error())
+		case tc.ExpectedError != "" && (err == nil || !strings.Contains(err.Error(), tc.ExpectedError)):
+			t.Errorf("%s: expected error %q, but got %v", tc.Name, tc.ExpectedError, err)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/sapcc/go-bits/logg"
+	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// DiscoverImageRelations walks through the values.yaml of this chart (and of all subcharts below `charts/`),
+// and proposes image relations for all values that look like image references.
+// This is used by `bundle --discover-images`.
+//
+// The following well-known shapes are recognized:
+//
+//	image:
+//	  registry: quay.io # optional
+//	  repository: example/foo
+//	  tag: "1.0"
+//	  digest: sha256:... # optional
+//
+//	image: quay.io/example/foo:1.0
+//
+// In the second shape, the key may be anything ending in "image" or "Image" (e.g. "sidecarImage").
+// Both shapes are also recognized within lists, e.g. at `.Values.sidecars[0].image`.
+// Values of subcharts are discovered below the subchart's name (or alias), where Helm expects overrides for them.
+// Values from the parent chart take precedence over the subchart's own values, in the same way as Helm merges them.
+// Subcharts that are disabled by their `condition` are skipped.
+//
+// Tags written as numbers (e.g. `tag: 1.10`) are discovered with their original spelling.
+// Helm itself would render this example as "1.1", but the image relation will localize the tag as the string "1.10".
+func (c HelmChart) DiscoverImageRelations() (ImageRelations, error) {
+	files, err := readDirectoryRecursively(c.ChartPath)
+	if err != nil {
+		return nil, err
+	}
+	_, values, err := computeEffectiveValues(files, true)
+	if err != nil {
+		return nil, fmt.Errorf("while reading values of %s: %w", c.ChartPath, err)
+	}
+	disabledPaths, err := findDisabledSubcharts(files, values, nil)
+	if err != nil {
+		return nil, fmt.Errorf("while reading values of %s: %w", c.ChartPath, err)
+	}
+
+	var result ImageRelations
+	d := imageDiscovery{
+		skippedPaths: make(map[string]bool, len(disabledPaths)),
+		report: func(rel ImageRelation) {
+			rel.ChartName = c.Name
+			result = append(result, &rel)
+		},
+	}
+	for _, path := range disabledPaths {
+		d.skippedPaths[path.String()] = true
+	}
+	d.discoverInValues(values, nil)
+	return result, nil
+}
+
+// Returns the chart's name and its values, with the values of all subcharts merged in.
+// The input contains the chart's files, with paths relative to the chart's root directory.
+//
+// If numericTagsAsText is true, numeric values for keys called "tag" are returned as strings with their original spelling in values.yaml.
+// This is used by DiscoverImageRelations() to recover image tags like `tag: 1.10`,
+// which would otherwise be indistinguishable from `tag: 1.1` once parsed.
+func computeEffectiveValues(files map[string][]byte, numericTagsAsText bool) (chartName string, values map[string]any, err error) {
+	chart, err := readChartMetadataFromFiles(files)
+	if err != nil {
+		return "", nil, err
+	}
+	var doc yaml.Node
+	err = yaml.Unmarshal(files["values.yaml"], &doc)
+	if err == nil && len(doc.Content) > 0 {
+		if numericTagsAsText {
+			markNumericTagsAsText(&doc)
+		}
+		err = doc.Decode(&values)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("while parsing values.yaml of chart %q: %w", chart.Name, err)
+	}
+	if values == nil {
+		values = make(map[string]any)
+	}
+
+	subchartFiles, err := collectSubchartFiles(files)
+	if err != nil {
+		return "", nil, fmt.Errorf("in chart %q: %w", chart.Name, err)
+	}
+
+	// merge values of subcharts into our own
+	for _, key := range slices.Sorted(maps.Keys(subchartFiles)) {
+		subchartName, subchartValues, err := computeEffectiveValues(subchartFiles[key], numericTagsAsText)
+		if err != nil {
+			return "", nil, fmt.Errorf("while reading charts/%s in chart %q: %w", key, chart.Name, err)
+		}
+
+		for _, valuesKey := range chart.subchartValuesKeys(subchartName) {
+			overrides, _ := values[valuesKey].(map[string]any)
+			values[valuesKey] = coalesceValues(overrides, subchartValues)
+		}
+	}
+	return chart.Name, values, nil
+}
+
+// Changes the YAML tag of all numeric values for keys called "tag", so that they decode into strings with their original spelling.
+func markNumericTagsAsText(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+			if key.Value == "tag" && value.Kind == yaml.ScalarNode && (value.ShortTag() == "!!int" || value.ShortTag() == "!!float") {
+				value.Tag = "!!str"
+			}
+		}
+	}
+	for _, child := range node.Content {
+		markNumericTagsAsText(child)
+	}
+}
+
+// Returns the value paths (relative to the values of the top-level chart) of all subcharts that are disabled by their condition.
+// The values are the effective values as returned by computeEffectiveValues().
+func findDisabledSubcharts(files map[string][]byte, values map[string]any, path ValuePath) ([]ValuePath, error) {
+	chart, err := readChartMetadataFromFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	subchartFiles, err := collectSubchartFiles(files)
+	if err != nil {
+		return nil, fmt.Errorf("in chart %q: %w", chart.Name, err)
+	}
+
+	var result []ValuePath
+	for _, key := range slices.Sorted(maps.Keys(subchartFiles)) {
+		subchart, err := readChartMetadataFromFiles(subchartFiles[key])
+		if err != nil {
+			return nil, fmt.Errorf("while reading charts/%s in chart %q: %w", key, chart.Name, err)
+		}
+		for _, valuesKey := range chart.subchartValuesKeys(subchart.Name) {
+			subchartPath := path.Append(ValuePathElement{Key: valuesKey})
+			condition := ""
+			for _, dep := range chart.Dependencies {
+				if dep.Name == subchart.Name && dep.EffectiveName() == valuesKey {
+					condition = dep.Condition
+				}
+			}
+			if !isConditionEnabled(condition, values) {
+				logg.Debug("not discovering images in .Values.%s since the subchart is disabled by its condition %q", subchartPath.String(), condition)
+				result = append(result, subchartPath)
+				continue
+			}
+
+			subchartValues, _ := values[valuesKey].(map[string]any)
+			disabledPaths, err := findDisabledSubcharts(subchartFiles[key], subchartValues, subchartPath)
+			if err != nil {
+				return nil, fmt.Errorf("while reading charts/%s in chart %q: %w", key, chart.Name, err)
+			}
+			result = append(result, disabledPaths...)
+		}
+	}
+	return result, nil
+}
+
+// Evaluates the `condition` of a dependency in the same way as Helm:
+// The condition is a comma-separated list of value paths, and the first one that refers to a boolean value decides.
+// If none of them do, the dependency is enabled.
+func isConditionEnabled(condition string, values map[string]any) bool {
+	if strings.TrimSpace(condition) == "" {
+		return true
+	}
+	for _, valuePath := range strings.Split(strings.TrimSpace(condition), ",") {
+		var value any = values
+		for key := range strings.SplitSeq(valuePath, ".") {
+			obj, ok := value.(map[string]any)
+			if !ok {
+				value = nil
+				break
+			}
+			value = obj[key]
+		}
+		if enabled, ok := value.(bool); ok {
+			return enabled
+		}
+	}
+	return true
+}
+
+// Returns the keys below which the values of the given subchart appear in the values of this chart.
+// If the subchart is included with aliases, its values appear once for each alias.
+func (c HelmChart) subchartValuesKeys(subchartName string) []string {
+	var valuesKeys []string
+	for _, dep := range c.Dependencies {
+		if dep.Name == subchartName {
+			valuesKeys = append(valuesKeys, dep.EffectiveName())
+		}
+	}
+	if len(valuesKeys) == 0 {
+		valuesKeys = []string{subchartName}
+	}
+	return valuesKeys
+}
+
+// Collects the files of all subcharts of a chart (either as directories or as tarballs below `charts/`).
+// The input contains the chart's files, with paths relative to the chart's root directory.
+// The result is keyed by the directory entry below `charts/`, e.g. "foo" or "foo-1.0.0.tgz".
+func collectSubchartFiles(files map[string][]byte) (map[string]map[string][]byte, error) {
+	subchartFiles := make(map[string]map[string][]byte)
+	for filePath, buf := range files {
+		subPath, ok := strings.CutPrefix(filePath, "charts/")
+		if !ok {
+			continue
+		}
+		dirName, subSubPath, isInDir := strings.Cut(subPath, "/")
+		switch {
+		case isInDir:
+			if subchartFiles[dirName] == nil {
+				subchartFiles[dirName] = make(map[string][]byte)
+			}
+			subchartFiles[dirName][subSubPath] = buf
+		case strings.HasSuffix(subPath, ".tgz"):
+			var err error
+			subchartFiles[subPath], err = readChartTarball(buf)
+			if err != nil {
+				return nil, fmt.Errorf("while reading charts/%s: %w", subPath, err)
+			}
+		}
+	}
+	return subchartFiles, nil
+}
+
+// Reads all files from a chart tarball like `charts/foo-1.0.0.tgz`.
+// By convention, all files are below a single top-level directory named after the chart, which is removed from the paths.
+func readChartTarball(buf []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, filePath, ok := strings.Cut(path.Clean(strings.TrimPrefix(hdr.Name, "./")), "/")
+		if !ok {
+			continue
+		}
+		files[filePath], err = io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Merges two value trees. Values from `overrides` take precedence over those from `defaults`.
+// Maps are merged recursively. All other values (including lists) are replaced wholesale, like Helm does.
+func coalesceValues(overrides, defaults map[string]any) map[string]any {
+	result := maps.Clone(defaults)
+	if result == nil {
+		result = make(map[string]any)
+	}
+	for key, value := range overrides {
+		valueMap, isValueMap := value.(map[string]any)
+		defaultMap, isDefaultMap := result[key].(map[string]any)
+		if isValueMap && isDefaultMap {
+			result[key] = coalesceValues(valueMap, defaultMap)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// imageDiscovery holds the state of DiscoverImageRelations() while walking through a value tree.
+type imageDiscovery struct {
+	skippedPaths map[string]bool // value paths (in the format of ValuePath.String()) that shall not be walked into
+	report       func(ImageRelation)
+}
+
+// Walks through a value tree in a deterministic order, and reports each discovered image relation.
+func (d imageDiscovery) discoverInValues(tree any, path ValuePath) {
+	switch tree := tree.(type) {
+	case map[string]any:
+		if d.discoverInImageObject(tree, path) {
+			return
+		}
+		for _, key := range slices.Sorted(maps.Keys(tree)) {
+			subpath := path.Append(ValuePathElement{Key: key})
+			if d.skippedPaths[subpath.String()] {
+				continue
+			}
+			value, isString := tree[key].(string)
+			if isString && (strings.HasSuffix(key, "image") || strings.HasSuffix(key, "Image")) {
+				ref, err := reference.ParseNormalizedNamed(value)
+				if err != nil || reference.IsNameOnly(ref) {
+					continue
+				}
+				d.report(ImageRelation{
+					TargetPath:     subpath.String(),
+					Attribute:      "reference",
+					ImageReference: ref,
+				})
+			} else {
+				d.discoverInValues(tree[key], subpath)
+			}
+		}
+	case []any:
+		for idx, value := range tree {
+			d.discoverInValues(value, path.Append(ValuePathElement{Index: idx, IsIndex: true}))
+		}
+	}
+}
+
+// Handles the `{repository, tag, digest}` shape for discoverInValues().
+// Returns whether the given object matched that shape.
+func (d imageDiscovery) discoverInImageObject(obj map[string]any, path ValuePath) bool {
+	repository, ok := obj["repository"].(string)
+	if !ok || repository == "" || len(path) == 0 {
+		return false
+	}
+	tag, _ := obj["tag"].(string) // NOTE: numeric tags were converted into strings by computeEffectiveValues()
+	digest, _ := obj["digest"].(string)
+	if tag == "" && digest == "" {
+		logg.Debug("not discovering image at .Values.%s since neither tag nor digest is set", path.String())
+		return false
+	}
+	registry, _ := obj["registry"].(string)
+
+	// keys in `obj` that shall be localized, and the attributes that shall be written into them
+	attributes := map[string]string{"repository": "repository"}
+	refStr := repository
+	if registry != "" {
+		attributes["registry"] = "registry"
+		attributes["repository"] = "path"
+		refStr = registry + "/" + repository
+	}
+	if tag != "" {
+		refStr += ":" + tag
+	}
+	if digest != "" {
+		refStr += "@" + digest
+	}
+	ref, err := reference.ParseNormalizedNamed(refStr)
+	if err != nil {
+		logg.Debug("not discovering image at .Values.%s: %s", path.String(), err.Error())
+		return false
+	}
+
+	if registry != "" && reference.Domain(ref) != registry {
+		// e.g. `{registry: myregistry, repository: foo}` would be parsed as "docker.io/myregistry/foo"
+		logg.Debug("not discovering image at .Values.%s since %q is not recognized as a registry", path.String(), registry)
+		return false
+	}
+
+	if tag != "" {
+		attributes["tag"] = "tag"
+	}
+	if digest != "" {
+		attributes["digest"] = "digest"
+	}
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		d.report(ImageRelation{
+			TargetPath:     path.Append(ValuePathElement{Key: key}).String(),
+			Attribute:      attributes[key],
+			ImageReference: ref,
+		})
+	}
+	return true
+}
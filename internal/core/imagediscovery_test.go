@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+func TestDiscoverImageRelations(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	chart := newTestChart(t, map[string]string{
+		"Chart.yaml": `apiVersion: v2
+name: app
+version: 1.0.0
+dependencies:
+  - name: postgresql
+    version: 1.0.0
+    condition: postgresql.enabled
+  - name: redis
+    version: 1.0.0
+    alias: cache
+    condition: cache.enabled,global.cache.enabled
+`,
+		"values.yaml": `
+image:
+  repository: example/app
+  tag: 1.10 # written as a number, but must not be discovered as "1.1"
+sidecarImage: quay.io/example/sidecar:2.0
+initImage: busybox # not discovered since it has neither tag nor digest
+jobs:
+  - image:
+      registry: ghcr.io
+      repository: example/job
+      digest: ` + digest + `
+  - image:
+      registry: myregistry # not discovered since this is not recognized as a registry
+      repository: example/job
+      tag: "1.0"
+postgresql:
+  enabled: false
+cache:
+  image:
+    tag: "7.4"
+global:
+  cache:
+    enabled: false # not considered since the first path in the condition already has a value
+`,
+		"charts/postgresql/Chart.yaml":  "apiVersion: v2\nname: postgresql\nversion: 1.0.0\n",
+		"charts/postgresql/values.yaml": "image:\n  repository: bitnami/postgresql\n  tag: 17.2.0\n",
+		"charts/redis/Chart.yaml":       "apiVersion: v2\nname: redis\nversion: 1.0.0\n",
+		"charts/redis/values.yaml":      "enabled: true\nimage:\n  repository: bitnami/redis\n  tag: 7.2.0\n",
+	})
+
+	rels, err := chart.DiscoverImageRelations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, rel := range rels {
+		if rel.ChartName != "app" {
+			t.Errorf("expected chart name %q, but got %q", "app", rel.ChartName)
+		}
+		actual = append(actual, fmt.Sprintf(".Values.%s is %s of %s", rel.TargetPath, rel.Attribute, rel.ImageReference.String()))
+	}
+	expected := []string{
+		// the subchart is discovered below its alias, with the parent chart's override applied
+		".Values.cache.image.repository is repository of docker.io/bitnami/redis:7.4",
+		".Values.cache.image.tag is tag of docker.io/bitnami/redis:7.4",
+		".Values.image.repository is repository of docker.io/example/app:1.10",
+		".Values.image.tag is tag of docker.io/example/app:1.10",
+		".Values.jobs[0].image.digest is digest of ghcr.io/example/job@" + digest,
+		".Values.jobs[0].image.registry is registry of ghcr.io/example/job@" + digest,
+		".Values.jobs[0].image.repository is path of ghcr.io/example/job@" + digest,
+		".Values.sidecarImage is reference of quay.io/example/sidecar:2.0",
+		// nothing is discovered for .Values.postgresql since that subchart is disabled
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected image relations:\n\t%s\nbut got:\n\t%s", strings.Join(expected, "\n\t"), strings.Join(actual, "\n\t"))
+	}
+}
+
+func TestIsConditionEnabled(t *testing.T) {
+	values := map[string]any{
+		"postgresql": map[string]any{"enabled": false, "name": "db"},
+		"redis":      map[string]any{"enabled": true},
+		"global":     map[string]any{"postgresql": map[string]any{"enabled": true}},
+	}
+	testCases := []struct {
+		Condition string
+		Expected  bool
+	}{
+		{"", true},
+		{"postgresql.enabled", false},
+		{"redis.enabled", true},
+		// paths that do not exist, or do not refer to a boolean, are skipped
+		{"memcached.enabled", true},
+		{"postgresql.name", true},
+		{"postgresql.name.enabled", true},
+		{"memcached.enabled,postgresql.enabled", false},
+		// the first boolean value decides
+		{"global.postgresql.enabled,postgresql.enabled", true},
+		{"postgresql.enabled,global.postgresql.enabled", false},
+	}
+	for _, tc := range testCases {
+		if actual := isConditionEnabled(tc.Condition, values); actual != tc.Expected {
+			t.Errorf("expected condition %q to evaluate to %t, but got %t", tc.Condition, tc.Expected, actual)
+		}
+	}
+}
+
+func TestComputeEffectiveValues(t *testing.T) {
+	// subcharts may also be included as tarballs
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	err := oci.WriteTarball(gz, map[string][]byte{
+		"memcached/Chart.yaml":  []byte("apiVersion: v2\nname: memcached\nversion: 1.0.0\n"),
+		"memcached/values.yaml": []byte("image:\n  repository: bitnami/memcached\n  tag: 1.6\nextraArgs: [-v]\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"Chart.yaml": []byte(`apiVersion: v2
+name: app
+version: 1.0.0
+dependencies:
+  - name: memcached
+    version: 1.0.0
+    alias: sessions
+  - name: memcached
+    version: 1.0.0
+    alias: cache
+`),
+		"values.yaml": []byte(`
+replicas: 2
+sessions:
+  image:
+    tag: "1.6.38"
+  extraArgs: [-m, "64"]
+`),
+		"charts/memcached-1.0.0.tgz": buf.Bytes(),
+	}
+
+	// the subchart's values appear once for each alias, and the parent's overrides take precedence (with lists being replaced, not merged)
+	chartName, values, err := computeEffectiveValues(files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chartName != "app" {
+		t.Errorf("expected chart name %q, but got %q", "app", chartName)
+	}
+	expected := map[string]any{
+		"replicas": 2,
+		"sessions": map[string]any{
+			"image":     map[string]any{"repository": "bitnami/memcached", "tag": "1.6.38"},
+			"extraArgs": []any{"-m", "64"},
+		},
+		"cache": map[string]any{
+			"image":     map[string]any{"repository": "bitnami/memcached", "tag": 1.6},
+			"extraArgs": []any{"-v"},
+		},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %#v, but got %#v", expected, values)
+	}
+
+	// for image discovery, numeric tags retain their original spelling (but other numbers are unaffected)
+	_, values, err = computeEffectiveValues(files, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected["cache"].(map[string]any)["image"].(map[string]any)["tag"] = "1.6"
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %#v, but got %#v", expected, values)
+	}
+
+	// an empty values.yaml is fine
+	files["values.yaml"] = nil
+	_, values, err = computeEffectiveValues(files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["sessions"] == nil || values["cache"] == nil {
+		t.Errorf("expected only the subchart values, but got %#v", values)
+	}
+}
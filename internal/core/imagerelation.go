@@ -9,7 +9,10 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/sapcc/go-bits/logg"
 	"github.com/sapcc/go-bits/osext"
@@ -19,9 +22,16 @@ import (
 // ImageRelation contains a parsed `--image-relation` value.
 type ImageRelation struct {
 	// these fields are filled in parseImageRelation()
-	TargetPath     string          `json:"target-path"` // which Helm value to overwrite
-	Attribute      string          `json:"attribute"`   // one of: "repository", "digest", "tag", "reference"
+	ChartName      string          `json:"-"`                  // which Helm chart this relation applies to (may be empty if there is only one chart)
+	TargetPath     string          `json:"target-path"`        // which Helm value to overwrite (in the format understood by ParseValuePath)
+	Attribute      string          `json:"attribute"`          // one of the ImageRelationAttributes, or "template"
+	Template       string          `json:"template,omitempty"` // only if Attribute == "template"
 	ImageReference reference.Named `json:"-"`
+	ResourceType   string          `json:"-"` // either "ociImage" (for --image-relation) or "ociArtifact" (for --artifact-relation)
+	// these fields are only filled for relations that were declared for a subchart, e.g. with the "charts/postgresql:" prefix;
+	// TargetPath is then translated into the parent chart's values by ResolveSubchartRelations()
+	Subchart           string `json:"subchart,omitempty"`             // e.g. "charts/postgresql" or "charts/postgresql/charts/common"
+	SubchartTargetPath string `json:"subchart-target-path,omitempty"` // the original TargetPath, relative to the subchart's values
 	// this field is filled during bundling
 	ImageResourceName string `json:"image-resource-name"`
 }
@@ -29,10 +39,119 @@ type ImageRelation struct {
 var (
 	variableReferenceRx   = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
 	commandSubstitutionRx = regexp.MustCompile(`\$\(([^)]*)\)`)
-	imageRelationRx       = regexp.MustCompile(`^\.Values\.(\S+)\s+is\s+(repository|tag|digest|reference)\s+of\s+(\S+)$`)
+	imageRelationRx       = regexp.MustCompile(`^(?:([^\s:]+):\s+)?\.Values\.((?:[^\s"]|"(?:[^"\\]|\\.)*")+)\s+is\s+([a-z-]+|"(?:[^"\\]|\\.)*")\s+of\s+(\S+)$`)
 )
 
-func parseImageRelation(ctx context.Context, input string) (ImageRelation, error) {
+// ImageRelationAttributes are the values for ImageRelation.Attribute that refer to a specific part of the image reference.
+// For example, for the image reference "quay.io/foo/bar:1.0@sha256:abcd...":
+//   - "reference" is the entire image reference
+//   - "repository" is "quay.io/foo/bar"
+//   - "registry" is "quay.io"
+//   - "path" is "foo/bar"
+//   - "tag" is "1.0"
+//   - "digest" is "sha256:abcd..."
+//   - "tag-and-digest" is "1.0@sha256:abcd..."
+var ImageRelationAttributes = []string{"reference", "repository", "registry", "path", "tag", "digest", "tag-and-digest"}
+
+func parseImageRelation(ctx context.Context, input, resourceType string) (ImageRelation, error) {
+	input, err := expandSubstitutions(ctx, input)
+	if err != nil {
+		return ImageRelation{}, err
+	}
+
+	// parse relation
+	match := imageRelationRx.FindStringSubmatch(input)
+	if match == nil {
+		return ImageRelation{}, fmt.Errorf("does not match expected format /%s/ (pre-processed input was %q)",
+			imageRelationRx.String(), input)
+	}
+
+	// parse target path
+	targetPath, err := ParseValuePath(match[2])
+	if err != nil {
+		return ImageRelation{}, err
+	}
+
+	// parse image reference (only artifact references may carry an "oci://" prefix)
+	rawRef := match[4]
+	if resourceType == "ociArtifact" {
+		rawRef = strings.TrimPrefix(rawRef, "oci://")
+	}
+	named, err := reference.ParseNormalizedNamed(rawRef)
+	if err != nil {
+		return ImageRelation{}, fmt.Errorf("%w (raw reference was %q)",
+			err, match[4])
+	}
+	chartName, subchart, err := parseRelationScope(match[1])
+	if err != nil {
+		return ImageRelation{}, err
+	}
+	rel := ImageRelation{
+		ChartName:      chartName,
+		Subchart:       subchart,
+		TargetPath:     targetPath.String(),
+		ImageReference: named,
+		ResourceType:   resourceType,
+	}
+
+	// parse attribute (either a plain attribute name or a quoted template)
+	if strings.HasPrefix(match[3], `"`) {
+		tmpl, err := strconv.Unquote(match[3])
+		if err != nil {
+			return ImageRelation{}, fmt.Errorf("malformed template %s: %w", match[3], err)
+		}
+		err = rel.setTemplate(tmpl)
+		return rel, err
+	}
+	rel.Attribute = match[3]
+	if !slices.Contains(ImageRelationAttributes, rel.Attribute) {
+		return ImageRelation{}, fmt.Errorf("unknown attribute %q (expected one of: %s, or a quoted template)",
+			rel.Attribute, strings.Join(ImageRelationAttributes, ", "))
+	}
+	return rel, nil
+}
+
+// Parses the optional "[<chart-name>/]charts/<subchart-name>" prefix of an image relation
+// into the chart name and the subchart path (each of which may be empty).
+func parseRelationScope(input string) (chartName, subchart string, err error) {
+	if input == "" {
+		return "", "", nil
+	}
+	if input != "charts" && !strings.HasPrefix(input, "charts/") {
+		chartName, subchart, _ = strings.Cut(input, "/")
+	} else {
+		subchart = input
+	}
+	if subchart == "" {
+		return chartName, "", nil
+	}
+
+	// subchart path must alternate between "charts" and subchart names
+	elems := strings.Split(subchart, "/")
+	if len(elems)%2 != 0 || slices.Contains(elems, "") {
+		return "", "", fmt.Errorf(`invalid chart name %q (expected "<chart-name>", "[<chart-name>/]charts/<subchart-name>" or similar)`, input)
+	}
+	for idx := 0; idx < len(elems); idx += 2 {
+		if elems[idx] != "charts" {
+			return "", "", fmt.Errorf(`invalid chart name %q (expected "<chart-name>", "[<chart-name>/]charts/<subchart-name>" or similar)`, input)
+		}
+	}
+	return chartName, subchart, nil
+}
+
+// Sets Attribute and Template for a templated image relation, and validates the template syntax.
+func (rel *ImageRelation) setTemplate(tmpl string) error {
+	_, err := template.New("").Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("could not parse template %q: %w", tmpl, err)
+	}
+	rel.Attribute = "template"
+	rel.Template = tmpl
+	return nil
+}
+
+// Resolves ${VARIABLE_REFERENCES} and $(command substitutions) in an image relation declaration.
+func expandSubstitutions(ctx context.Context, input string) (string, error) {
 	// resolve variable references
 	var err error
 	input, err = replaceUnlessError(variableReferenceRx, input, func(match []string) (string, error) {
@@ -40,7 +159,7 @@ func parseImageRelation(ctx context.Context, input string) (ImageRelation, error
 		return strings.TrimSpace(val), err
 	})
 	if err != nil {
-		return ImageRelation{}, err
+		return "", err
 	}
 
 	// resolve command substitutions
@@ -59,28 +178,7 @@ func parseImageRelation(ctx context.Context, input string) (ImageRelation, error
 		buf, err := cmd.Output()
 		return strings.TrimSpace(string(buf)), err
 	})
-	if err != nil {
-		return ImageRelation{}, err
-	}
-
-	// parse relation
-	match := imageRelationRx.FindStringSubmatch(input)
-	if match == nil {
-		return ImageRelation{}, fmt.Errorf("does not match expected format /%s/ (pre-processed input was %q)",
-			imageRelationRx.String(), input)
-	}
-
-	// parse image reference
-	named, err := reference.ParseNormalizedNamed(match[3])
-	if err != nil {
-		return ImageRelation{}, fmt.Errorf("%w (raw reference was %q)",
-			err, match[3])
-	}
-	return ImageRelation{
-		TargetPath:     match[1],
-		Attribute:      match[2],
-		ImageReference: named,
-	}, nil
+	return input, err
 }
 
 // Like Regexp.ReplaceAllStringFunc(), but propagates errors, and provides a full submatch list to the predicate.
@@ -102,23 +200,118 @@ func replaceUnlessError(rx *regexp.Regexp, input string, replace func([]string)
 }
 
 // GetValue reads the attribute named by the Attribute field from the image reference in the ImageReference field.
+// For templated image relations, the template is executed with an ImageAttributes instance.
 func (rel ImageRelation) GetValue() (string, error) {
-	ref := rel.ImageReference
+	attrs := ImageAttributes{rel.ImageReference}
 	switch rel.Attribute {
 	case "reference":
-		return ref.String(), nil
+		return attrs.Reference(), nil
 	case "repository":
-		return ref.Name(), nil
+		return attrs.Repository(), nil
+	case "registry":
+		return attrs.Registry(), nil
+	case "path":
+		return attrs.Path(), nil
+	case "tag":
+		return attrs.Tag()
 	case "digest":
-		digested, ok := ref.(reference.Digested)
-		if ok {
-			return digested.Digest().String(), nil
+		return attrs.Digest()
+	case "tag-and-digest":
+		return attrs.TagAndDigest()
+	case "template":
+		tmpl, err := template.New("").Option("missingkey=error").Parse(rel.Template)
+		if err != nil {
+			return "", fmt.Errorf("could not parse template %q: %w", rel.Template, err)
 		}
-	case "tag":
-		tagged, ok := ref.(reference.Tagged)
-		if ok {
-			return tagged.Tag(), nil
+		var sb strings.Builder
+		err = tmpl.Execute(&sb, attrs)
+		if err != nil {
+			return "", fmt.Errorf("could not execute template %q: %w", rel.Template, err)
 		}
+		return sb.String(), nil
+	default:
+		return "", fmt.Errorf("unknown attribute %q for image reference %q", rel.Attribute, rel.ImageReference.String())
+	}
+}
+
+// ImageAttributes provides the parts of an image reference that image relations can refer to.
+// It is the data object for templated image relations, e.g. `.Values.image is "{{.Registry}}/{{.Path}}" of ...`.
+// See ImageRelationAttributes for examples.
+type ImageAttributes struct {
+	ref reference.Named
+}
+
+// Reference returns the entire image reference.
+func (a ImageAttributes) Reference() string {
+	return a.ref.String()
+}
+
+// Repository returns the fully-qualified repository name.
+func (a ImageAttributes) Repository() string {
+	return a.ref.Name()
+}
+
+// Registry returns the domain part of the repository name.
+func (a ImageAttributes) Registry() string {
+	return reference.Domain(a.ref)
+}
+
+// Path returns the repository name without the domain part.
+func (a ImageAttributes) Path() string {
+	return reference.Path(a.ref)
+}
+
+// Tag returns the tag, or an error if the image reference does not have one.
+func (a ImageAttributes) Tag() (string, error) {
+	tagged, ok := a.ref.(reference.Tagged)
+	if !ok {
+		return "", fmt.Errorf("could not find attribute %q in image reference %q", "tag", a.ref.String())
+	}
+	return tagged.Tag(), nil
+}
+
+// Digest returns the digest, or an error if the image reference does not have one.
+func (a ImageAttributes) Digest() (string, error) {
+	digested, ok := a.ref.(reference.Digested)
+	if !ok {
+		return "", fmt.Errorf("could not find attribute %q in image reference %q", "digest", a.ref.String())
+	}
+	return digested.Digest().String(), nil
+}
+
+// TagAndDigest returns tag and digest in the form "<tag>@<digest>",
+// or an error if the image reference does not have both.
+func (a ImageAttributes) TagAndDigest() (string, error) {
+	tag, err := a.Tag()
+	if err != nil {
+		return "", err
+	}
+	digest, err := a.Digest()
+	if err != nil {
+		return "", err
+	}
+	return tag + "@" + digest, nil
+}
+
+// AsFlagValue renders this relation in the format accepted by `bundle --image-relation`.
+// The chart name is only included if `withChartName` is true.
+func (rel ImageRelation) AsFlagValue(withChartName bool) string {
+	attribute := rel.Attribute
+	if attribute == "template" {
+		attribute = strconv.Quote(rel.Template)
+	}
+	targetPath := rel.TargetPath
+	if rel.Subchart != "" && rel.SubchartTargetPath != "" {
+		targetPath = rel.SubchartTargetPath
+	}
+	result := fmt.Sprintf(".Values.%s is %s of %s", targetPath, attribute, rel.ImageReference.String())
+	switch {
+	case withChartName && rel.ChartName != "" && rel.Subchart != "":
+		result = rel.ChartName + "/" + rel.Subchart + ": " + result
+	case withChartName && rel.ChartName != "":
+		result = rel.ChartName + ": " + result
+	case rel.Subchart != "":
+		result = rel.Subchart + ": " + result
 	}
-	return "", fmt.Errorf("could not find attribute %q in image reference %q", rel.Attribute, ref.String())
+	return result
 }
@@ -9,32 +9,41 @@ import (
 	"fmt"
 	"maps"
 	"path"
-	"regexp"
 	"slices"
 	"strings"
 
 	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
 )
 
-var imageRelationSeparatorRx = regexp.MustCompile(`,|\n`)
-
 // ImageRelations is a set of parsed `--image-relation` value.
 // This is the payload type for an "image-relations.json" file.
 type ImageRelations []*ImageRelation
 
 // ParseImageRelations parses the --image-relation options of the `bundle` subcommand.
 func ParseImageRelations(ctx context.Context, inputs []string) (ImageRelations, error) {
+	return parseRelations(ctx, inputs, "--image-relation", "ociImage")
+}
+
+// ParseArtifactRelations parses the --artifact-relation options of the `bundle` subcommand.
+// These work like image relations, except that they refer to OCI artifacts other than images (e.g. OPA bundles).
+// Artifact references may be prefixed with "oci://".
+func ParseArtifactRelations(ctx context.Context, inputs []string) (ImageRelations, error) {
+	return parseRelations(ctx, inputs, "--artifact-relation", "ociArtifact")
+}
+
+func parseRelations(ctx context.Context, inputs []string, flagName, resourceType string) (ImageRelations, error) {
 	var result ImageRelations
 	for _, input := range inputs {
-		for _, in := range imageRelationSeparatorRx.Split(input, -1) {
+		for _, in := range splitRelationList(input) {
 			in = strings.TrimSpace(in)
 			if in == "" {
 				// allow e.g. trailing comma at the end of a list inside an --image-relation value
 				continue
 			}
-			rel, err := parseImageRelation(ctx, in)
+			rel, err := parseImageRelation(ctx, in, resourceType)
 			if err != nil {
-				return nil, fmt.Errorf("while parsing --image-relation %q: %w", in, err)
+				return nil, fmt.Errorf("while parsing %s %q: %w", flagName, in, err)
 			}
 			result = append(result, &rel)
 		}
@@ -42,6 +51,69 @@ func ParseImageRelations(ctx context.Context, inputs []string) (ImageRelations,
 	return result, nil
 }
 
+// Splits the value of an --image-relation or --artifact-relation option into individual relations.
+// Relations are separated by commas or newlines, except where those appear within quotes
+// (i.e. in quoted keys of the target path, or in a quoted template) or within command substitutions like "$(cat tags.txt)".
+func splitRelationList(input string) []string {
+	var (
+		result []string
+		start  = 0
+	)
+	for idx := 0; idx < len(input); idx++ {
+		switch {
+		case input[idx] == '"':
+			end := findEndOfQuotedString(input[idx:])
+			if end == -1 {
+				// unterminated quote: leave the rest as one relation, and let parseImageRelation() report the error
+				return append(result, input[start:])
+			}
+			idx += end
+		case strings.HasPrefix(input[idx:], "$("):
+			end := strings.IndexByte(input[idx:], ')')
+			if end == -1 {
+				return append(result, input[start:])
+			}
+			idx += end
+		case input[idx] == ',' || input[idx] == '\n':
+			result = append(result, input[start:idx])
+			start = idx + 1
+		}
+	}
+	return append(result, input[start:])
+}
+
+// AssignToCharts fills the ChartName field of each relation (where not done yet),
+// and validates that relations only refer to charts from the given list.
+//
+// If there is only one chart, relations without a chart name are assigned to it.
+// If there are multiple charts, each relation must explicitly name its chart.
+func (rels ImageRelations) AssignToCharts(chartNames []string) error {
+	for _, rel := range rels {
+		switch {
+		case rel.ChartName == "" && len(chartNames) == 1:
+			rel.ChartName = chartNames[0]
+		case rel.ChartName == "":
+			return fmt.Errorf("image relation for .Values.%s does not specify which Helm chart it applies to (expected one of: %s)",
+				rel.TargetPath, strings.Join(chartNames, ", "))
+		case !slices.Contains(chartNames, rel.ChartName):
+			return fmt.Errorf("image relation for .Values.%s refers to unknown Helm chart %q (expected one of: %s)",
+				rel.TargetPath, rel.ChartName, strings.Join(chartNames, ", "))
+		}
+	}
+	return nil
+}
+
+// SelectChart returns the subset of relations that apply to the Helm chart with the given name.
+func (rels ImageRelations) SelectChart(chartName string) ImageRelations {
+	var result ImageRelations
+	for _, rel := range rels {
+		if rel.ChartName == chartName {
+			result = append(result, rel)
+		}
+	}
+	return result
+}
+
 // AssignResourceNames fills the ImageResourceName field of each relation (where not done yet),
 // such that there is a unique mapping between ImageResourceName and ImageReference.
 func (rels ImageRelations) AssignResourceNames() {
@@ -67,14 +139,18 @@ func (rels ImageRelations) AssignResourceNames() {
 		fullRepoName := rel.ImageReference.Name() // e.g. "quay.io/prometheuscommunity/postgres_exporter"
 		repoName := path.Base(fullRepoName)       // e.g. "postgres_exporter"
 
-		// we prefer the resource name "image-${repoName}", but if that would not be unique,
-		// we add "-1", "-2", etc. to disambiguate
-		resName := "image-" + repoName
+		// we prefer the resource name "image-${repoName}" (or "artifact-${repoName}" for non-image artifacts),
+		// but if that would not be unique, we add "-1", "-2", etc. to disambiguate
+		prefix := "image-"
+		if rel.ResourceType == "ociArtifact" {
+			prefix = "artifact-"
+		}
+		resName := prefix + repoName
 		counter := 0
 		for {
 			if hasResName[resName] {
 				counter++
-				resName = fmt.Sprintf("image-%s-%d", repoName, counter)
+				resName = fmt.Sprintf("%s%s-%d", prefix, repoName, counter)
 			} else {
 				break
 			}
@@ -91,15 +167,20 @@ func (rels ImageRelations) AssignResourceNames() {
 // The "unbundle" subcommand wants to find these as the `cloud.sap/image-relations` label on the Helm chart resource.
 //
 // Images that do not have a tag will use the provided `bundleVersion` as their version string.
-func (rels ImageRelations) AsOCMResources(bundleVersion string) (resources []OCMResourceDeclaration, imageRelationsJSON string, err error) {
+//
+// If `copyByValue` is true, the resources declare the images as an input instead of an access.
+// Image contents will then be stored inside the component version, instead of only being referenced.
+func (rels ImageRelations) AsOCMResources(bundleVersion string, copyByValue bool) (resources []OCMResourceDeclaration, imageRelationsJSON string, err error) {
 	if len(rels) == 0 {
 		return nil, "[]", nil
 	}
 
 	rels.AssignResourceNames()
 	imageRefForResourceName := make(map[string]reference.Named, len(rels))
+	resourceTypeForResourceName := make(map[string]string, len(rels))
 	for _, rel := range rels {
 		imageRefForResourceName[rel.ImageResourceName] = rel.ImageReference
+		resourceTypeForResourceName[rel.ImageResourceName] = rel.ResourceType
 	}
 
 	// serialize image-relations.json
@@ -116,51 +197,88 @@ func (rels ImageRelations) AsOCMResources(bundleVersion string) (resources []OCM
 			version = tagged.Tag()
 		}
 
-		resources = append(resources, OCMResourceDeclaration{
+		resourceType := resourceTypeForResourceName[resName]
+		if resourceType == "" {
+			resourceType = "ociImage"
+		}
+
+		res := OCMResourceDeclaration{
 			Name:    resName,
-			Type:    "ociImage",
+			Type:    resourceType,
 			Version: version,
-			Access: map[string]any{
+		}
+		if copyByValue {
+			res.Input = map[string]any{
+				"type":       resourceType,
+				"path":       imageRef.String(),
+				"repository": reference.Path(imageRef), // used by OCM as a hint for where to put the image when copying it out of the component version
+			}
+		} else {
+			res.Access = map[string]any{
 				"type":           "ociArtifact",
 				"imageReference": imageRef.String(),
-			},
-		})
+			}
+		}
+		resources = append(resources, res)
 	}
 	return resources, string(buf), nil
 }
 
 // BuildLocalizedValues builds the contents of localized-values.yaml during unbundling.
 func (rels ImageRelations) BuildLocalizedValues() (map[string]any, error) {
-	out := make(map[string]any)
+	v, err := rels.buildValuesTree(ImageRelation.GetValue)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	err = v.Root.Decode(&out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CheckTargetPaths is called during bundling to validate that BuildLocalizedValues() will be able to work with the target paths.
+// Returns an error for target paths that are contradictory or that leave holes in lists.
+//
+// Since Helm does not merge lists, but replaces them wholesale, the lists in localized-values.yaml
+// will replace the respective lists in values.yaml and in any user-supplied values files.
+// This function returns a warning for each such list.
+func (rels ImageRelations) CheckTargetPaths() (warnings []string, err error) {
+	_, err = rels.buildValuesTree(func(ImageRelation) (string, error) { return "", nil })
+	if err != nil {
+		return nil, err
+	}
+
+	isListPrefix := make(map[string]bool)
 	for _, rel := range rels {
-		value, err := rel.GetValue()
+		path, err := ParseValuePath(rel.TargetPath)
 		if err != nil {
 			return nil, err
 		}
-		err = insertIntoMapRecursively(out, rel.TargetPath, value)
-		if err != nil {
-			return nil, err
+		for _, prefix := range path.ListPrefixes() {
+			if !isListPrefix[prefix.String()] {
+				isListPrefix[prefix.String()] = true
+				warnings = append(warnings, fmt.Sprintf(
+					"chart %q has image relations targeting elements of the list .Values.%s: since Helm replaces lists wholesale, "+
+						"localized-values.yaml will replace this entire list, and user-supplied values for other fields of its elements will be lost",
+					rel.ChartName, prefix.String()))
+			}
 		}
 	}
-
-	return out, nil
+	return warnings, nil
 }
 
-func insertIntoMapRecursively(target map[string]any, targetPath string, value any) error {
-	key, subpath, found := strings.Cut(targetPath, ".")
-	if !found {
-		target[key] = value
-		return nil
+func (rels ImageRelations) buildValuesTree(getValue func(ImageRelation) (string, error)) (valuesTree, error) {
+	v := valuesTree{Root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}}
+	err := v.InsertImageRelations(rels, getValue)
+	if err != nil {
+		return valuesTree{}, err
 	}
-
-	switch subtarget := target[key].(type) {
-	case map[string]any:
-		return insertIntoMapRecursively(subtarget, subpath, value)
-	case nil:
-		newSubtarget := make(map[string]any)
-		target[key] = newSubtarget
-		return insertIntoMapRecursively(newSubtarget, subpath, value)
-	default:
-		return fmt.Errorf("cannot insert value into subpath %q of value with type %T", subpath, subtarget)
+	holes := v.UnfilledHoles()
+	if len(holes) > 0 {
+		return valuesTree{}, fmt.Errorf("no image relation targets the list element .Values.%s, but a later element in the same list is targeted "+
+			"(since Helm replaces lists wholesale, all elements up to the last targeted one must be targeted)", holes[0].String())
 	}
+	return v, nil
 }
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckTargetPaths(t *testing.T) {
+	// attributes are only resolved during unbundling, so the image reference does not need to have a digest yet
+	rels := mustParseImageRelations(t,
+		".Values.image.digest is digest of quay.io/foo/app:1.0",
+		`.Values.image.full is "{{.Repository}}@{{.Digest}}" of quay.io/foo/app:1.0`,
+		".Values.sidecars[0].image is reference of quay.io/foo/sidecar:1.0",
+		".Values.sidecars[1].image is reference of quay.io/foo/sidecar:2.0",
+		".Values.sidecars[1].ports[0].name is tag of quay.io/foo/sidecar:2.0",
+	)
+	warnings, err := rels.CheckTargetPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one warning per list, not per list element
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "list .Values.sidecars:") || !strings.Contains(warnings[1], "list .Values.sidecars[1].ports:") {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	// holes in lists are errors
+	rels = mustParseImageRelations(t,
+		".Values.sidecars[0].image is reference of quay.io/foo/sidecar:1.0",
+		".Values.sidecars[2].image is reference of quay.io/foo/sidecar:2.0",
+	)
+	_, err = rels.CheckTargetPaths()
+	if err == nil || !strings.Contains(err.Error(), "no image relation targets the list element .Values.sidecars[1]") {
+		t.Errorf("expected error about hole in list, but got %v", err)
+	}
+
+	// contradictory target paths are errors
+	rels = mustParseImageRelations(t,
+		".Values.image is reference of quay.io/foo/app:1.0",
+		".Values.image[0] is reference of quay.io/foo/app:1.0",
+	)
+	_, err = rels.CheckTargetPaths()
+	if err == nil || !strings.Contains(err.Error(), "cannot insert list element into .Values.image") {
+		t.Errorf("expected error about contradictory target paths, but got %v", err)
+	}
+}
+
+func TestParseRelationsWithOCIScheme(t *testing.T) {
+	// artifact references may use the "oci://" prefix that Helm and ORAS use
+	rels, err := ParseArtifactRelations(t.Context(), []string{".Values.opa.bundle is reference of oci://ghcr.io/example/policies:1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rels) != 1 || rels[0].ImageReference.String() != "ghcr.io/example/policies:1.0" || rels[0].ResourceType != "ociArtifact" {
+		t.Errorf("unexpected artifact relations: %#v", rels)
+	}
+
+	// image references may not
+	_, err = ParseImageRelations(t.Context(), []string{".Values.image is reference of oci://quay.io/foo/app:1.0"})
+	if err == nil || !strings.Contains(err.Error(), `raw reference was "oci://quay.io/foo/app:1.0"`) {
+		t.Errorf("expected error for image reference with oci:// prefix, but got %v", err)
+	}
+}
+
+func TestParseRelationLists(t *testing.T) {
+	testCases := []struct {
+		Input    string
+		Expected []string // target path and attribute of each relation
+	}{
+		{
+			Input:    ".Values.image.repository is repository of quay.io/foo/app:1.0, .Values.image.tag is tag of quay.io/foo/app:1.0,",
+			Expected: []string{"image.repository (repository)", "image.tag (tag)"},
+		},
+		{
+			Input:    ".Values.image.repository is repository of quay.io/foo/app:1.0\n.Values.image.tag is tag of quay.io/foo/app:1.0\n",
+			Expected: []string{"image.repository (repository)", "image.tag (tag)"},
+		},
+		{
+			// commas in quoted keys are not separators (the canonical form of the path only quotes keys where necessary)
+			Input:    `.Values."a,b".image is reference of quay.io/foo/app:1.0,.Values."c\",d".image is reference of quay.io/foo/app:1.0`,
+			Expected: []string{`a,b.image (reference)`, `"c\",d".image (reference)`},
+		},
+		{
+			// commas in templates are not separators, even if the template contains escaped quotes
+			Input:    `.Values.images is "{{.Repository}},{{.Tag}}" of quay.io/foo/app:1.0, .Values.other is "{{printf \"%s,%s\" .Repository .Tag}}" of quay.io/foo/app:1.0`,
+			Expected: []string{"images (template)", "other (template)"},
+		},
+	}
+	for _, tc := range testCases {
+		rels, err := ParseImageRelations(t.Context(), []string{tc.Input})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.Input, err.Error())
+			continue
+		}
+		var actual []string
+		for _, rel := range rels {
+			actual = append(actual, fmt.Sprintf("%s (%s)", rel.TargetPath, rel.Attribute))
+		}
+		if strings.Join(actual, "; ") != strings.Join(tc.Expected, "; ") {
+			t.Errorf("%q: expected %q, but got %q", tc.Input, tc.Expected, actual)
+		}
+	}
+
+	// the template must be retained verbatim
+	rels, err := ParseImageRelations(t.Context(), []string{`.Values.images is "{{.Repository}},{{.Tag}}" of quay.io/foo/app:1.0`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := rels[0].GetValue(); err != nil || value != "quay.io/foo/app,1.0" {
+		t.Errorf("unexpected value for templated relation: %q (error: %v)", value, err)
+	}
+
+	// an unterminated quote does not cause the remainder to be split
+	_, err = ParseImageRelations(t.Context(), []string{`.Values."a,b.image is reference of quay.io/foo/app:1.0`})
+	if err == nil || !strings.Contains(err.Error(), `"a,b.image is reference of quay.io/foo/app:1.0`) {
+		t.Errorf("expected error for unterminated quote, but got %v", err)
+	}
+}
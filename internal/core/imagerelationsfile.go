@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// ReadImageRelationsFile parses a file given in the --image-relations-file option of the `bundle` subcommand.
+// The file must contain a YAML list of objects like this:
+//
+//   - chart: gatekeeper   # optional, same meaning as the "<chart-name>:" prefix in --image-relation (may also refer to a subchart)
+//     target: .Values.image.tag
+//     attribute: tag       # or alternatively, template: "{{.Tag}}"
+//     image: openpolicyagent/gatekeeper:v3.19.1
+//     type: ociImage       # optional, or "ociArtifact" for the same meaning as --artifact-relation
+//
+// Variable references and command substitutions in each field are resolved in the same way as for --image-relation.
+// Errors report the line number in the file where the respective declaration is located.
+func ReadImageRelationsFile(ctx context.Context, filePath string) (ImageRelations, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %w", filePath, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("while parsing %s: line %d: expected a list of image relations", filePath, root.Line)
+	}
+
+	var result ImageRelations
+	for _, node := range root.Content {
+		rel, err := parseImageRelationNode(ctx, node)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing %s: %w", filePath, err)
+		}
+		result = append(result, &rel)
+	}
+	return result, nil
+}
+
+// All errors returned by this function are prefixed with the respective line number.
+func parseImageRelationNode(ctx context.Context, node *yaml.Node) (ImageRelation, error) {
+	if node.Kind != yaml.MappingNode {
+		return ImageRelation{}, fmt.Errorf("line %d: expected an object with the fields: chart, target, attribute, template, image, type", node.Line)
+	}
+
+	// NOTE: Decoding into a struct would lose the line numbers of individual fields, so we walk the mapping ourselves.
+	fields := make(map[string]string)
+	fieldLines := make(map[string]int)
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key, value := node.Content[idx], node.Content[idx+1]
+		switch key.Value {
+		case "chart", "target", "attribute", "template", "image", "type":
+			// continue below
+		default:
+			return ImageRelation{}, fmt.Errorf("line %d: unknown field %q (expected one of: chart, target, attribute, template, image, type)", key.Line, key.Value)
+		}
+		if value.Kind != yaml.ScalarNode {
+			return ImageRelation{}, fmt.Errorf("line %d: expected a string value for field %q", value.Line, key.Value)
+		}
+		expanded, err := expandSubstitutions(ctx, value.Value)
+		if err != nil {
+			return ImageRelation{}, fmt.Errorf("line %d: in field %q: %w", value.Line, key.Value, err)
+		}
+		fields[key.Value] = strings.TrimSpace(expanded)
+		fieldLines[key.Value] = value.Line
+	}
+
+	for _, field := range []string{"target", "image"} {
+		if fields[field] == "" {
+			return ImageRelation{}, fmt.Errorf("line %d: missing value for required field %q", node.Line, field)
+		}
+	}
+	if (fields["attribute"] == "") == (fields["template"] == "") {
+		return ImageRelation{}, fmt.Errorf("line %d: exactly one of the fields \"attribute\" and \"template\" must be given", node.Line)
+	}
+	resourceType := fields["type"]
+	switch resourceType {
+	case "":
+		resourceType = "ociImage"
+	case "ociImage", "ociArtifact":
+		// ok
+	default:
+		return ImageRelation{}, fmt.Errorf(`line %d: invalid type %q (expected "ociImage" or "ociArtifact")`, fieldLines["type"], resourceType)
+	}
+	rawTargetPath, ok := strings.CutPrefix(fields["target"], ".Values.")
+	if !ok {
+		return ImageRelation{}, fmt.Errorf("line %d: invalid target %q (expected something like \".Values.image.tag\")",
+			fieldLines["target"], fields["target"])
+	}
+	targetPath, err := ParseValuePath(rawTargetPath)
+	if err != nil {
+		return ImageRelation{}, fmt.Errorf("line %d: invalid target %q (expected something like \".Values.image.tag\"): %w",
+			fieldLines["target"], fields["target"], err)
+	}
+	// like for --artifact-relation, only artifact references may carry an "oci://" prefix
+	rawRef := fields["image"]
+	if resourceType == "ociArtifact" {
+		rawRef = strings.TrimPrefix(rawRef, "oci://")
+	}
+	named, err := reference.ParseNormalizedNamed(rawRef)
+	if err != nil {
+		return ImageRelation{}, fmt.Errorf("line %d: %w (raw reference was %q)", fieldLines["image"], err, fields["image"])
+	}
+	chartName, subchart, err := parseRelationScope(fields["chart"])
+	if err != nil {
+		return ImageRelation{}, fmt.Errorf("line %d: %w", fieldLines["chart"], err)
+	}
+	rel := ImageRelation{
+		ChartName:      chartName,
+		Subchart:       subchart,
+		TargetPath:     targetPath.String(),
+		Attribute:      fields["attribute"],
+		ImageReference: named,
+		ResourceType:   resourceType,
+	}
+
+	if fields["template"] != "" {
+		err = rel.setTemplate(fields["template"])
+		if err != nil {
+			return ImageRelation{}, fmt.Errorf("line %d: %w", fieldLines["template"], err)
+		}
+	} else if !slices.Contains(ImageRelationAttributes, rel.Attribute) {
+		return ImageRelation{}, fmt.Errorf("line %d: invalid attribute %q (expected one of: %s)",
+			fieldLines["attribute"], rel.Attribute, strings.Join(ImageRelationAttributes, ", "))
+	}
+	return rel, nil
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadImageRelationsFile(t *testing.T) {
+	t.Setenv("GATEKEEPER_VERSION", "v3.19.1")
+	dirPath := writeTestFiles(t, map[string]string{
+		"relations.yaml": `
+- chart: gatekeeper
+  target: .Values.image.tag
+  attribute: tag
+  image: openpolicyagent/gatekeeper:${GATEKEEPER_VERSION}
+- chart: charts/postgresql
+  target: .Values.image
+  template: "{{.Repository}}:{{.Tag}}"
+  image: docker.io/library/postgres:17
+- target: .Values.opa.bundle
+  attribute: reference
+  image: oci://ghcr.io/example/policies:1.0
+  type: ociArtifact
+`,
+		"empty.yaml": "",
+	})
+
+	rels, err := ReadImageRelationsFile(t.Context(), filepath.Join(dirPath, "relations.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rels) != 3 {
+		t.Fatalf("expected 3 image relations, but got %d", len(rels))
+	}
+	rel := rels[0]
+	if rel.ChartName != "gatekeeper" || rel.TargetPath != "image.tag" || rel.Attribute != "tag" ||
+		rel.ImageReference.String() != "docker.io/openpolicyagent/gatekeeper:v3.19.1" || rel.ResourceType != "ociImage" {
+		t.Errorf("unexpected first image relation: %#v", rel)
+	}
+	rel = rels[1]
+	if rel.ChartName != "" || rel.Subchart != "charts/postgresql" || rel.TargetPath != "image" || rel.Attribute != "template" ||
+		rel.Template != "{{.Repository}}:{{.Tag}}" || rel.ImageReference.String() != "docker.io/library/postgres:17" || rel.ResourceType != "ociImage" {
+		t.Errorf("unexpected second image relation: %#v", rel)
+	}
+	rel = rels[2]
+	if rel.TargetPath != "opa.bundle" || rel.Attribute != "reference" ||
+		rel.ImageReference.String() != "ghcr.io/example/policies:1.0" || rel.ResourceType != "ociArtifact" {
+		t.Errorf("unexpected third image relation: %#v", rel)
+	}
+
+	rels, err = ReadImageRelationsFile(t.Context(), filepath.Join(dirPath, "empty.yaml"))
+	if err != nil || len(rels) != 0 {
+		t.Errorf("expected no image relations in empty file, but got %#v and error %v", rels, err)
+	}
+}
+
+func TestReadImageRelationsFileErrors(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Contents      string
+		ExpectedError string
+	}{
+		{
+			Name:          "not a list",
+			Contents:      "target: .Values.image\n",
+			ExpectedError: "line 1: expected a list of image relations",
+		},
+		{
+			Name:          "not an object",
+			Contents:      "- .Values.image is reference of quay.io/foo/app:1.0\n",
+			ExpectedError: "line 1: expected an object with the fields",
+		},
+		{
+			Name:          "unknown field",
+			Contents:      "- target: .Values.image\n  attribute: reference\n  image: quay.io/foo/app:1.0\n  repository: quay.io/foo/app\n",
+			ExpectedError: `line 4: unknown field "repository"`,
+		},
+		{
+			Name:          "missing target",
+			Contents:      "- image: quay.io/foo/app:1.0\n  attribute: reference\n- attribute: reference\n  image: quay.io/foo/app:1.0\n",
+			ExpectedError: `line 1: missing value for required field "target"`,
+		},
+		{
+			Name:          "missing target in second entry",
+			Contents:      "- target: .Values.image\n  attribute: reference\n  image: quay.io/foo/app:1.0\n- attribute: reference\n  image: quay.io/foo/app:1.0\n",
+			ExpectedError: `line 4: missing value for required field "target"`,
+		},
+		{
+			Name:          "target without .Values. prefix",
+			Contents:      "- attribute: reference\n  image: quay.io/foo/app:1.0\n  target: image.tag\n",
+			ExpectedError: `line 3: invalid target "image.tag" (expected something like ".Values.image.tag")`,
+		},
+		{
+			Name:          "both attribute and template",
+			Contents:      "- target: .Values.image\n  attribute: reference\n  template: \"{{.Tag}}\"\n  image: quay.io/foo/app:1.0\n",
+			ExpectedError: `line 1: exactly one of the fields "attribute" and "template" must be given`,
+		},
+		{
+			Name:          "invalid attribute",
+			Contents:      "- target: .Values.image\n  image: quay.io/foo/app:1.0\n  attribute: version\n",
+			ExpectedError: `line 3: invalid attribute "version"`,
+		},
+		{
+			Name:          "invalid type",
+			Contents:      "- target: .Values.image\n  attribute: reference\n  image: quay.io/foo/app:1.0\n  type: helmChart\n",
+			ExpectedError: `line 4: invalid type "helmChart"`,
+		},
+		{
+			Name:          "oci:// prefix on image",
+			Contents:      "- target: .Values.image\n  attribute: reference\n  image: oci://quay.io/foo/app:1.0\n",
+			ExpectedError: `line 3: `,
+		},
+		{
+			Name:          "non-string value",
+			Contents:      "- target: .Values.image\n  attribute: reference\n  image:\n    repository: quay.io/foo/app\n",
+			ExpectedError: `line 4: expected a string value for field "image"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		filePath := filepath.Join(writeTestFiles(t, map[string]string{"relations.yaml": tc.Contents}), "relations.yaml")
+		_, err := ReadImageRelationsFile(t.Context(), filePath)
+		expectedError := "while parsing " + filePath + ": " + tc.ExpectedError
+		if err == nil || !strings.HasPrefix(err.Error(), expectedError) {
+			t.Errorf("%s: expected error %q, but got %v", tc.Name, expectedError, err)
+		}
+	}
+}
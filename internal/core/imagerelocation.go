@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
+)
+
+// ImageRelocation contains a parsed `--relocate` value.
+type ImageRelocation struct {
+	// prefix of a fully-qualified repository name, e.g. "quay.io" or "docker.io/library"
+	From string
+	// what to replace the prefix with, e.g. "mirror.internal/quay"
+	To string
+}
+
+// ImageRelocations is a set of parsed `--relocate` values.
+type ImageRelocations []ImageRelocation
+
+// ParseImageRelocations parses the --relocate options of the `unbundle` subcommand.
+// Each input must have the form "<from>=><to>".
+func ParseImageRelocations(inputs []string) (ImageRelocations, error) {
+	var result ImageRelocations
+	for _, input := range inputs {
+		from, to, ok := strings.Cut(input, "=>")
+		if !ok {
+			return nil, fmt.Errorf(`while parsing --relocate %q: expected the form "<from>=><to>"`, input)
+		}
+		reloc, err := newImageRelocation(from, to)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing --relocate %q: %w", input, err)
+		}
+		result = append(result, reloc)
+	}
+	return result, nil
+}
+
+// ReadImageRelocationsFile parses a file given in the --relocation-file option of the `unbundle` subcommand.
+// The file must contain a YAML object mapping source prefixes to target prefixes, for example:
+//
+//	quay.io: mirror.internal/quay
+//	docker.io/library: mirror.internal/dockerhub
+func ReadImageRelocationsFile(filePath string) (ImageRelocations, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var mapping yaml.Node
+	err = yaml.Unmarshal(buf, &mapping)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %w", filePath, err)
+	}
+	if len(mapping.Content) == 0 {
+		return nil, nil // empty file
+	}
+	root := mapping.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("while parsing %s: line %d: expected a mapping of source prefixes to target prefixes", filePath, root.Line)
+	}
+
+	// NOTE: Decoding into yaml.Node instead of map[string]string retains the order of entries and their line numbers.
+	var result ImageRelocations
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		key, value := root.Content[idx], root.Content[idx+1]
+		if key.Kind != yaml.ScalarNode || value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("while parsing %s: line %d: expected a mapping of source prefixes to target prefixes", filePath, key.Line)
+		}
+		reloc, err := newImageRelocation(key.Value, value.Value)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing %s: line %d: %w", filePath, key.Line, err)
+		}
+		result = append(result, reloc)
+	}
+	return result, nil
+}
+
+func newImageRelocation(from, to string) (ImageRelocation, error) {
+	from = strings.TrimSuffix(strings.TrimSpace(from), "/")
+	to = strings.TrimSuffix(strings.TrimSpace(to), "/")
+	if from == "" || to == "" {
+		return ImageRelocation{}, fmt.Errorf("source and target prefix may not be empty (got %q and %q)", from, to)
+	}
+	// check that the target prefix can be used as part of an image reference
+	_, err := reference.ParseNormalizedNamed(to + "/test")
+	if err != nil {
+		return ImageRelocation{}, fmt.Errorf("invalid target prefix %q: %w", to, err)
+	}
+	// Without a registry hostname, a target prefix like "mirror/quay" would be interpreted as a repository on Docker Hub.
+	// This is almost certainly not what the user intended, so we require the hostname to be spelled out.
+	if !isRegistryHostname(strings.SplitN(to, "/", 2)[0]) {
+		return ImageRelocation{}, fmt.Errorf("invalid target prefix %q: must start with a registry hostname (e.g. %q)", to, "registry.example.org/"+to)
+	}
+	return ImageRelocation{From: from, To: to}, nil
+}
+
+// Returns whether the first path element of an image reference names a registry.
+// This uses the same rule as reference.ParseNormalizedNamed(), which otherwise defaults to Docker Hub.
+func isRegistryHostname(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// Apply rewrites the given image reference according to the relocation rules.
+//
+// Source prefixes are matched against the fully-qualified repository name (e.g. "docker.io/library/nginx" for "nginx:1.0"),
+// and only on boundaries between path elements (e.g. "quay.io/foo" matches "quay.io/foo/bar", but not "quay.io/foobar").
+// If multiple rules match, the one with the longest source prefix wins.
+// Tag and digest of the image reference are retained.
+// If no rule matches, the image reference is returned unchanged.
+func (relocs ImageRelocations) Apply(ref reference.Named) (reference.Named, error) {
+	name := ref.Name()
+	var (
+		bestMatch ImageRelocation
+		found     bool
+	)
+	for _, reloc := range relocs {
+		if name != reloc.From && !strings.HasPrefix(name, reloc.From+"/") {
+			continue
+		}
+		if !found || len(reloc.From) > len(bestMatch.From) {
+			bestMatch = reloc
+			found = true
+		}
+	}
+	if !found {
+		return ref, nil
+	}
+
+	newName := bestMatch.To + strings.TrimPrefix(name, bestMatch.From)
+	result, err := reference.ParseNormalizedNamed(newName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot relocate image %s to %s: %w", ref.String(), newName, err)
+	}
+	if tagged, ok := ref.(reference.Tagged); ok {
+		result, err = reference.WithTag(result, tagged.Tag())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if digested, ok := ref.(reference.Digested); ok {
+		result, err = reference.WithDigest(result, digested.Digest())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
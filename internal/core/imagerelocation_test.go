@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"go.podman.io/image/v5/docker/reference"
+)
+
+func TestParseImageRelocations(t *testing.T) {
+	testCases := []struct {
+		Input         string
+		ExpectedError string
+	}{
+		{"quay.io=>mirror.internal/quay", ""},
+		{"quay.io/=>mirror.internal/quay/", ""},
+		{"docker.io/library=>localhost/dockerhub", ""},
+		{"docker.io/library=>localhost:5000/dockerhub", ""},
+		{"quay.io=>registry.example.org", ""},
+		{"quay.io", `expected the form "<from>=><to>"`},
+		{"=>mirror.internal/quay", "may not be empty"},
+		{"quay.io=>mirror.internal/Quay", "invalid target prefix"},
+		// without a hostname, the target would refer to Docker Hub
+		{"quay.io=>mirror/quay", `must start with a registry hostname (e.g. "registry.example.org/mirror/quay")`},
+		{"quay.io=>mirror", "must start with a registry hostname"},
+	}
+
+	for _, tc := range testCases {
+		relocs, err := ParseImageRelocations([]string{tc.Input})
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("expected %q to parse, but got error: %s", tc.Input, err.Error())
+		case tc.ExpectedError == "" && len(relocs) != 1:
+			t.Errorf("expected %q to parse into 1 rule, but got %#v", tc.Input, relocs)
+		case tc.ExpectedError != "" && err == nil:
+			t.Errorf("expected %q to fail with %q, but got %#v", tc.Input, tc.ExpectedError, relocs)
+		case tc.ExpectedError != "" && !strings.Contains(err.Error(), tc.ExpectedError):
+			t.Errorf("expected %q to fail with %q, but got error: %s", tc.Input, tc.ExpectedError, err.Error())
+		}
+	}
+}
+
+func TestApplyImageRelocations(t *testing.T) {
+	relocs, err := ParseImageRelocations([]string{
+		"quay.io=>mirror.internal/quay",
+		"quay.io/special=>special-mirror.internal",
+		"docker.io/library=>mirror.internal/dockerhub",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"quay.io/foo/bar:1.0", "mirror.internal/quay/foo/bar:1.0"},
+		{"quay.io/special/bar:1.0", "special-mirror.internal/bar:1.0"},
+		{"quay.io/specialty/bar:1.0", "mirror.internal/quay/specialty/bar:1.0"},
+		{"nginx:1.27", "mirror.internal/dockerhub/nginx:1.27"},
+		{"bitnami/postgresql:17.5.0", "docker.io/bitnami/postgresql:17.5.0"},
+		{
+			"quay.io/foo/bar:1.0@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			"mirror.internal/quay/foo/bar:1.0@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+	}
+	for _, tc := range testCases {
+		ref, err := reference.ParseNormalizedNamed(tc.Input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := relocs.Apply(ref)
+		if err != nil {
+			t.Errorf("while relocating %s: %s", tc.Input, err.Error())
+			continue
+		}
+		if result.String() != tc.Expected {
+			t.Errorf("expected %s to be relocated to %s, but got %s", tc.Input, tc.Expected, result.String())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"go.podman.io/image/v5/docker/reference"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// Downloads an image from its registry, and renders it into an OCI image layout for storage as a local blob.
+func fetchImageAsLocalBlob(ctx context.Context, imageRefStr, repositoryHint string) (renderedInput, error) {
+	imageRef, err := reference.ParseNormalizedNamed(imageRefStr)
+	if err != nil {
+		return renderedInput{}, fmt.Errorf("could not parse image reference %q: %w", imageRefStr, err)
+	}
+	registry, err := oci.NewRegistry(reference.Domain(imageRef))
+	if err != nil {
+		return renderedInput{}, err
+	}
+
+	// when the digest is known, prefer it over the tag, to get exactly what was asked for
+	var manifestRef string
+	switch ref := imageRef.(type) {
+	case reference.Digested:
+		manifestRef = ref.Digest().String()
+	case reference.Tagged:
+		manifestRef = ref.Tag()
+	default:
+		return renderedInput{}, fmt.Errorf("cannot copy image %q: neither tag nor digest is given", imageRefStr)
+	}
+
+	logg.Info("copying image %s into the component version...", imageRef.String())
+	artifact, err := oci.FetchArtifact(ctx, registry.Repository(reference.Path(imageRef)), manifestRef)
+	if err != nil {
+		return renderedInput{}, fmt.Errorf("could not download image %q: %w", imageRefStr, err)
+	}
+
+	referenceName := repositoryHint
+	if tagged, ok := imageRef.(reference.Tagged); ok && referenceName != "" {
+		referenceName += ":" + tagged.Tag()
+	}
+	return renderedInput{
+		MediaType: artifact.ImageLayoutTarballMediaType(),
+		WritePayload: func(w io.Writer) error {
+			// NOTE: Layers are only downloaded at this point, and are streamed directly into the output.
+			err := artifact.WriteImageLayoutTarball(ctx, w, referenceName)
+			if err != nil {
+				return fmt.Errorf("could not download image %q: %w", imageRefStr, err)
+			}
+			return nil
+		},
+		ReferenceName: referenceName,
+		GlobalAccess: map[string]any{
+			"type":           "ociArtifact",
+			"imageReference": imageRef.String(),
+		},
+	}, nil
+}
+
+// PushBundledImage takes the payload of an image that was stored as a local blob in a component version,
+// and pushes it into the given target. The original reference of the image must be given as well.
+// Returns the reference under which the image can be found afterwards.
+//
+// The target may either be a registry with an optional path prefix (e.g. "registry.example.org/mirror"),
+// or a path to an OCI image layout directory with a prefix of "oci:" (e.g. "oci:./images").
+//
+// When pushing into a registry, the image retains its original repository path and tag, but gets the prefix prepended.
+// For example, if the image was originally "quay.io/foo/bar:1.0" and the target is "registry.example.org/mirror",
+// the image is pushed as "registry.example.org/mirror/foo/bar:1.0".
+//
+// When pushing into an OCI image layout, the image is annotated with its repository path and tag (e.g. "foo/bar:1.0"),
+// but since images in an OCI image layout cannot be pulled by reference, the original reference is returned unchanged.
+//
+// The payload is streamed into a temporary directory while pushing, so it is never held in memory as a whole.
+func PushBundledImage(ctx context.Context, payload io.Reader, originalRef reference.Named, target string) (result reference.Named, returnedErr error) {
+	tempDir, err := os.MkdirTemp("", "ocm-helm-toolbox-image-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if returnedErr == nil {
+			returnedErr = err
+		}
+	}()
+	artifact, _, err := oci.ExtractImageLayoutTarball(ctx, payload, tempDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundled image %s: %w", originalRef.String(), err)
+	}
+	// if the payload is verified while streaming, the verification only happens once EOF is reached,
+	// so we need to consume any trailing data that ExtractImageLayoutTarball() did not need to read
+	_, err = io.Copy(io.Discard, payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bundled image %s: %w", originalRef.String(), err)
+	}
+	imagePath := reference.Path(originalRef)
+	tag := ""
+	if tagged, ok := originalRef.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+
+	// option 1: push into OCI image layout
+	if layoutPath, ok := strings.CutPrefix(target, "oci:"); ok {
+		layout, err := oci.OpenImageLayout(layoutPath)
+		if err != nil {
+			return nil, err
+		}
+		refName := imagePath
+		if tag != "" {
+			refName += ":" + tag
+		}
+		logg.Info("writing image %s into %s...", originalRef.String(), layoutPath)
+		err = artifact.PushTo(ctx, layout, refName)
+		if err != nil {
+			return nil, fmt.Errorf("could not write image %s into %s: %w", originalRef.String(), layoutPath, err)
+		}
+		return originalRef, nil
+	}
+
+	// option 2: push into registry
+	targetRepo, err := reference.ParseNormalizedNamed(strings.TrimSuffix(target, "/") + "/" + imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot push image %s into %s: %w", originalRef.String(), target, err)
+	}
+	registry, err := oci.NewRegistry(reference.Domain(targetRepo))
+	if err != nil {
+		return nil, err
+	}
+	logg.Info("pushing image %s to %s...", originalRef.String(), targetRepo.String())
+	err = artifact.PushTo(ctx, registry.Repository(reference.Path(targetRepo)), tag)
+	if err != nil {
+		return nil, fmt.Errorf("could not push image %s to %s: %w", originalRef.String(), targetRepo.String(), err)
+	}
+
+	result = targetRepo
+	if tag != "" {
+		result, err = reference.WithTag(result, tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reference.WithDigest(result, digest.Digest(artifact.Root.Digest))
+}
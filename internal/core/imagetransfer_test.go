@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.podman.io/image/v5/docker/reference"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// testRegistry is a minimal OCI registry that serves manifests and blobs, and requires token authentication.
+type testRegistry struct {
+	// key = "repo:tag"
+	Manifests map[string]testManifest
+	// key = digest (blobs are served in all repositories)
+	Blobs map[string][]byte
+	// repositories in which HEAD responses do not include the Docker-Content-Digest header
+	OmitDigestHeaderIn map[string]bool
+
+	mutex    sync.Mutex
+	requests []string // e.g. "HEAD /v2/foo/app/manifests/1.0"
+}
+
+type testManifest struct {
+	MediaType string
+	Contents  []byte
+}
+
+func (m testManifest) Digest() string {
+	sum := sha256.Sum256(m.Contents)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+const (
+	testRegistryToken    = "s3cr3t-t0k3n"
+	testRegistryUser     = "alice"
+	testRegistryPassword = "swordfish"
+)
+
+// Starts the registry, and writes a Docker config with credentials for it.
+// Returns the host of the registry.
+func (r *testRegistry) Start(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	configDir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte(testRegistryUser + ":" + testRegistryPassword))
+	err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"auths":{"`+host+`":{"auth":"`+auth+`"}}}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", configDir)
+	return host
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	r.mutex.Unlock()
+
+	// token endpoint: requires Basic auth with the credentials from the Docker config
+	if req.URL.Path == "/token" {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != testRegistryUser || password != testRegistryPassword {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(req.URL.Query().Get("scope"), "repository:") || req.URL.Query().Get("service") != "test-registry" {
+			http.Error(w, "invalid token request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token":"` + testRegistryToken + `"}`))
+		return
+	}
+
+	// API endpoints: require a token
+	if req.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+		w.Header().Set("Www-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="test-registry"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if repoName, dgst, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/"); ok && req.Method == http.MethodGet {
+		blob, ok := r.Blobs[dgst]
+		if !ok || repoName == "" {
+			http.NotFound(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(blob)
+		return
+	}
+	repoName, ref, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/")
+	if !ok || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		http.NotFound(w, req)
+		return
+	}
+	manifest, ok := r.Manifests[repoName+":"+ref]
+	for key, m := range r.Manifests {
+		if !ok && strings.HasPrefix(key, repoName+":") && m.Digest() == ref {
+			manifest, ok = m, true
+		}
+	}
+	// like real registries, only serve manifests of a media type that the client declared as acceptable
+	if !ok || !strings.Contains(req.Header.Get("Accept"), manifest.MediaType) {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", manifest.MediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifest.Contents)))
+	if req.Method == http.MethodGet || !r.OmitDigestHeaderIn[repoName] {
+		w.Header().Set("Docker-Content-Digest", manifest.Digest())
+	}
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(manifest.Contents)
+	}
+}
+
+func (r *testRegistry) Requests() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests
+}
+
+func TestUnbundleImageWithoutBuffering(t *testing.T) {
+	// serve an image with a large layer (random data, so that compression does not make it small)
+	layer := make([]byte, 16<<20)
+	rand.NewChaCha8([32]byte{}).Read(layer) //nolint:errcheck // never fails
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layerDesc := oci.Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: oci.DigestOf(layer), Size: int64(len(layer))}
+	configDesc := oci.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: oci.DigestOf(config), Size: int64(len(config))}
+	manifestBuf, err := json.Marshal(oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ImageManifestMediaType,
+		Config:        &configDesc,
+		Layers:        []oci.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := &testRegistry{
+		Manifests: map[string]testManifest{"foo/bar:1.0": {MediaType: oci.ImageManifestMediaType, Contents: manifestBuf}},
+		Blobs:     map[string][]byte{layerDesc.Digest: layer, configDesc.Digest: config},
+	}
+	host := registry.Start(t)
+
+	// bundle the image into a CTF archive
+	archivePath := filepath.Join(t.TempDir(), "ctf")
+	component := OCMComponentDeclaration{
+		Name:     "example.org/bar",
+		Version:  "1.0.0",
+		Provider: map[string]any{"name": "example.org"},
+		Resources: []OCMResourceDeclaration{{
+			Name:    "image-bar",
+			Type:    "ociImage",
+			Version: "1.0",
+			Input:   map[string]any{"type": "ociImage", "path": host + "/foo/bar:1.0", "repository": "foo/bar"},
+		}},
+	}
+	err = component.WriteCTF(t.Context(), archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewOCMClient("native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := client.ListResources(t.Context(), archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].Digest == nil {
+		t.Fatalf("unexpected resources: %#v", resources)
+	}
+	imageRef, err := reference.ParseNormalizedNamed(host + "/foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// we write a digest of the blob, but the `ocm` CLI records the digest of the image manifest, so both must be verified while streaming
+	manifestDigest := strings.TrimPrefix(oci.DigestOf(manifestBuf), "sha256:")
+	digests := []OCMDigest{
+		*resources[0].Digest,
+		{HashAlgorithm: "SHA-256", NormalisationAlgorithm: "ociArtifactDigest/v1", Value: manifestDigest},
+	}
+	for _, digest := range digests {
+		res := resources[0]
+		res.Digest = &digest
+
+		// unbundle the image into an OCI image layout, and check that its payload was never held in memory
+		layoutPath := t.TempDir()
+		var statsBefore, statsAfter runtime.MemStats
+		runtime.ReadMemStats(&statsBefore)
+		payload, err := res.GetPayloadFrom(t.Context(), client, archivePath)
+		if err != nil {
+			t.Fatalf("%s: %s", digest.NormalisationAlgorithm, err.Error())
+		}
+		_, err = PushBundledImage(t.Context(), payload, imageRef, "oci:"+layoutPath)
+		if err != nil {
+			t.Fatalf("%s: %s", digest.NormalisationAlgorithm, err.Error())
+		}
+		err = payload.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", digest.NormalisationAlgorithm, err.Error())
+		}
+		runtime.ReadMemStats(&statsAfter)
+		allocated := statsAfter.TotalAlloc - statsBefore.TotalAlloc
+		if allocated > uint64(len(layer)/4) {
+			t.Errorf("%s: expected the image to be streamed, but %d bytes were allocated for a layer of %d bytes",
+				digest.NormalisationAlgorithm, allocated, len(layer))
+		}
+
+		// check that the image arrived intact
+		layout, err := oci.OpenImageLayout(layoutPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		artifact, err := oci.FetchArtifact(t.Context(), layout, "foo/bar:1.0")
+		if err != nil {
+			t.Fatalf("%s: %s", digest.NormalisationAlgorithm, err.Error())
+		}
+		if len(artifact.Manifests) != 1 || artifact.Manifests[0].Digest != "sha256:"+manifestDigest {
+			t.Errorf("%s: unexpected manifests in unbundled image: %#v", digest.NormalisationAlgorithm, artifact.Manifests)
+		}
+		buf, err := oci.ReadBlob(t.Context(), layout, layerDesc.Digest)
+		if err != nil {
+			t.Fatalf("%s: %s", digest.NormalisationAlgorithm, err.Error())
+		}
+		if !bytes.Equal(buf, layer) {
+			t.Errorf("%s: layer of unbundled image does not match the original", digest.NormalisationAlgorithm)
+		}
+	}
+}
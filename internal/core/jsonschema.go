@@ -0,0 +1,508 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// This file contains a validator for the subset of JSON Schema that is commonly used in the values.schema.json of Helm charts.
+// It is used by `bundle --validate-schema`.
+//
+// All validation keywords of JSON Schema draft-07 are supported (as well as "prefixItems" from later drafts),
+// except for "dependencies" and "contentMediaType"/"contentEncoding".
+// Annotations like "title", "description", "default" or "format" are ignored, as is usual for validators.
+// References ("$ref") are only supported within the same document, i.e. in the form "#/definitions/foo".
+// If "$schema" declares draft-07 or earlier, keywords next to "$ref" are ignored, as those drafts require.
+// Regexes in "pattern" and "patternProperties" are evaluated with Go's regexp package instead of ECMA-262 semantics.
+// Patterns that it cannot compile (e.g. because of lookahead or backreferences) are skipped and reported as warnings.
+
+// Recursive references in a schema can make validation run forever; this is where we cut it off.
+const jsonSchemaMaxDepth = 100
+
+// Matches the "$schema" of draft-07 and earlier, e.g. "http://json-schema.org/draft-07/schema#".
+var jsonSchemaOldDraftRx = regexp.MustCompile(`^https?://json-schema\.org/draft-0[3-7]/schema#?$`)
+
+// jsonSchema is a parsed JSON schema document.
+type jsonSchema struct {
+	root any
+	// true if the schema declares draft-07 or earlier, where "$ref" overrides all other keywords next to it
+	refOverridesSiblings bool
+	// compiled regexes from "pattern" and "patternProperties" (nil if the pattern could not be compiled)
+	patterns map[string]*regexp.Regexp
+	// errors for patterns that could not be compiled, reported by Warnings()
+	patternErrors map[string]error
+}
+
+func parseJSONSchema(buf []byte) (jsonSchema, error) {
+	var root any
+	err := json.Unmarshal(buf, &root)
+	if err != nil {
+		return jsonSchema{}, err
+	}
+	switch root := root.(type) {
+	case map[string]any, bool:
+		schemaURI := ""
+		if keywords, ok := root.(map[string]any); ok {
+			schemaURI, _ = keywords["$schema"].(string)
+		}
+		return jsonSchema{
+			root:                 root,
+			refOverridesSiblings: jsonSchemaOldDraftRx.MatchString(schemaURI),
+			patterns:             make(map[string]*regexp.Regexp),
+			patternErrors:        make(map[string]error),
+		}, nil
+	default:
+		return jsonSchema{}, fmt.Errorf("expected a JSON object, but got %s", jsonTypeOf(root))
+	}
+}
+
+// Validate checks the given value tree against this schema.
+// The path is where the value tree is located within the values of the chart.
+// Returns a human-readable message for each violation that was found.
+func (s jsonSchema) Validate(value any, path ValuePath) []string {
+	return s.validate(s.root, normalizeJSONValue(value), path, 0)
+}
+
+// Warnings returns a human-readable message for each pattern that was skipped during validation since it could not be compiled.
+func (s jsonSchema) Warnings() []string {
+	var warnings []string
+	for _, pattern := range slices.Sorted(maps.Keys(s.patternErrors)) {
+		warnings = append(warnings, fmt.Sprintf("cannot check values against the pattern %q: %s", pattern, s.patternErrors[pattern].Error()))
+	}
+	return warnings
+}
+
+// Returns the compiled regex for a "pattern" or "patternProperties" keyword,
+// or false if the pattern uses ECMA-262 features that Go's regexp package does not support.
+func (s jsonSchema) compilePattern(pattern string) (*regexp.Regexp, bool) {
+	rx, exists := s.patterns[pattern]
+	if !exists {
+		var err error
+		rx, err = regexp.Compile(pattern)
+		if err != nil {
+			s.patternErrors[pattern] = err
+		}
+		s.patterns[pattern] = rx
+	}
+	return rx, rx != nil
+}
+
+func (s jsonSchema) validate(schema, value any, path ValuePath, depth int) (errs []string) {
+	report := func(msg string, args ...any) {
+		errs = append(errs, fmt.Sprintf("at %s: %s", describeValuePath(path), fmt.Sprintf(msg, args...)))
+	}
+	if depth > jsonSchemaMaxDepth {
+		report("schema is nested too deeply (is there a reference cycle?)")
+		return errs
+	}
+
+	var keywords map[string]any
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			report("no value is allowed here")
+		}
+		return errs
+	case map[string]any:
+		keywords = schema
+	default:
+		report("cannot validate against malformed schema of type %s", jsonTypeOf(schema))
+		return errs
+	}
+
+	if ref, ok := keywords["$ref"].(string); ok {
+		subschema, err := s.resolveReference(ref)
+		if err != nil {
+			report("%s", err.Error())
+		} else {
+			errs = append(errs, s.validate(subschema, value, path, depth+1)...)
+		}
+		if s.refOverridesSiblings {
+			return errs
+		}
+	}
+
+	// keywords for all types
+	if types := jsonSchemaTypes(keywords["type"]); len(types) > 0 {
+		actualType := jsonTypeOf(value)
+		isMatch := slices.Contains(types, actualType) || (actualType == "integer" && slices.Contains(types, "number"))
+		if !isMatch {
+			report("expected %s, but got %s", strings.Join(types, " or "), actualType)
+			return errs // other keywords would only produce confusing follow-up errors
+		}
+	}
+	if enum, ok := keywords["enum"].([]any); ok {
+		if !slices.ContainsFunc(enum, func(option any) bool { return reflect.DeepEqual(option, value) }) {
+			report("expected one of %s, but got %s", encodeJSONForMessage(enum), encodeJSONForMessage(value))
+		}
+	}
+	if constValue, ok := keywords["const"]; ok && !reflect.DeepEqual(constValue, value) {
+		report("expected %s, but got %s", encodeJSONForMessage(constValue), encodeJSONForMessage(value))
+	}
+
+	// type-specific keywords
+	switch value := value.(type) {
+	case float64:
+		errs = append(errs, s.validateNumber(keywords, value, path)...)
+	case string:
+		errs = append(errs, s.validateString(keywords, value, path)...)
+	case []any:
+		errs = append(errs, s.validateArray(keywords, value, path, depth)...)
+	case map[string]any:
+		errs = append(errs, s.validateObject(keywords, value, path, depth)...)
+	}
+
+	// combinators
+	if subschemas, ok := keywords["allOf"].([]any); ok {
+		for _, subschema := range subschemas {
+			errs = append(errs, s.validate(subschema, value, path, depth+1)...)
+		}
+	}
+	if subschemas, ok := keywords["anyOf"].([]any); ok {
+		if !slices.ContainsFunc(subschemas, func(subschema any) bool { return len(s.validate(subschema, value, path, depth+1)) == 0 }) {
+			report(`does not match any of the schemas in "anyOf"`)
+		}
+	}
+	if subschemas, ok := keywords["oneOf"].([]any); ok {
+		matchCount := 0
+		for _, subschema := range subschemas {
+			if len(s.validate(subschema, value, path, depth+1)) == 0 {
+				matchCount++
+			}
+		}
+		if matchCount != 1 {
+			report(`matches %d of the schemas in "oneOf", but must match exactly one`, matchCount)
+		}
+	}
+	if subschema, ok := keywords["not"]; ok && len(s.validate(subschema, value, path, depth+1)) == 0 {
+		report(`must not match the schema in "not"`)
+	}
+	if condition, ok := keywords["if"]; ok {
+		if len(s.validate(condition, value, path, depth+1)) == 0 {
+			if subschema, ok := keywords["then"]; ok {
+				errs = append(errs, s.validate(subschema, value, path, depth+1)...)
+			}
+		} else if subschema, ok := keywords["else"]; ok {
+			errs = append(errs, s.validate(subschema, value, path, depth+1)...)
+		}
+	}
+	return errs
+}
+
+func (s jsonSchema) validateNumber(keywords map[string]any, value float64, path ValuePath) (errs []string) {
+	report := func(msg string, args ...any) {
+		errs = append(errs, fmt.Sprintf("at %s: %s", describeValuePath(path), fmt.Sprintf(msg, args...)))
+	}
+
+	// NOTE: In draft-04, "exclusiveMinimum" and "exclusiveMaximum" were booleans that modified "minimum" and "maximum".
+	if limit, ok := keywords["minimum"].(float64); ok {
+		if keywords["exclusiveMinimum"] == true {
+			if value <= limit {
+				report("must be greater than %v, but is %v", limit, value)
+			}
+		} else if value < limit {
+			report("must be at least %v, but is %v", limit, value)
+		}
+	}
+	if limit, ok := keywords["exclusiveMinimum"].(float64); ok && value <= limit {
+		report("must be greater than %v, but is %v", limit, value)
+	}
+	if limit, ok := keywords["maximum"].(float64); ok {
+		if keywords["exclusiveMaximum"] == true {
+			if value >= limit {
+				report("must be less than %v, but is %v", limit, value)
+			}
+		} else if value > limit {
+			report("must be at most %v, but is %v", limit, value)
+		}
+	}
+	if limit, ok := keywords["exclusiveMaximum"].(float64); ok && value >= limit {
+		report("must be less than %v, but is %v", limit, value)
+	}
+	if divisor, ok := keywords["multipleOf"].(float64); ok && divisor > 0 {
+		quotient := value / divisor
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			report("must be a multiple of %v, but is %v", divisor, value)
+		}
+	}
+	return errs
+}
+
+func (s jsonSchema) validateString(keywords map[string]any, value string, path ValuePath) (errs []string) {
+	report := func(msg string, args ...any) {
+		errs = append(errs, fmt.Sprintf("at %s: %s", describeValuePath(path), fmt.Sprintf(msg, args...)))
+	}
+
+	length := utf8.RuneCountInString(value)
+	if limit, ok := keywords["minLength"].(float64); ok && float64(length) < limit {
+		report("must be at least %v characters long, but is %q", limit, value)
+	}
+	if limit, ok := keywords["maxLength"].(float64); ok && float64(length) > limit {
+		report("must be at most %v characters long, but is %q", limit, value)
+	}
+	if pattern, ok := keywords["pattern"].(string); ok {
+		rx, ok := s.compilePattern(pattern)
+		if ok && !rx.MatchString(value) {
+			report("must match the pattern %q, but is %q", pattern, value)
+		}
+	}
+	return errs
+}
+
+func (s jsonSchema) validateArray(keywords map[string]any, value []any, path ValuePath, depth int) (errs []string) {
+	report := func(msg string, args ...any) {
+		errs = append(errs, fmt.Sprintf("at %s: %s", describeValuePath(path), fmt.Sprintf(msg, args...)))
+	}
+
+	if limit, ok := keywords["minItems"].(float64); ok && float64(len(value)) < limit {
+		report("must have at least %v elements, but has %d", limit, len(value))
+	}
+	if limit, ok := keywords["maxItems"].(float64); ok && float64(len(value)) > limit {
+		report("must have at most %v elements, but has %d", limit, len(value))
+	}
+	if keywords["uniqueItems"] == true {
+		for idx := range value {
+			if slices.ContainsFunc(value[:idx], func(other any) bool { return reflect.DeepEqual(other, value[idx]) }) {
+				report("must not contain duplicate elements, but element %d is a duplicate", idx)
+				break
+			}
+		}
+	}
+
+	// find which schema applies to which element
+	var (
+		tupleSchemas []any // for the first elements
+		restSchema   any   // for all other elements
+	)
+	if prefixItems, ok := keywords["prefixItems"].([]any); ok {
+		// since draft 2020-12, "prefixItems" is the tuple form and "items" applies to the rest
+		tupleSchemas, restSchema = prefixItems, keywords["items"]
+	} else if items, ok := keywords["items"].([]any); ok {
+		// up to draft 2019-09, "items" can be the tuple form and "additionalItems" applies to the rest
+		tupleSchemas, restSchema = items, keywords["additionalItems"]
+	} else {
+		restSchema = keywords["items"]
+	}
+	for idx, elem := range value {
+		elemPath := path.Append(ValuePathElement{Index: idx, IsIndex: true})
+		switch {
+		case idx < len(tupleSchemas):
+			errs = append(errs, s.validate(tupleSchemas[idx], elem, elemPath, depth+1)...)
+		case restSchema != nil:
+			errs = append(errs, s.validate(restSchema, elem, elemPath, depth+1)...)
+		}
+	}
+
+	if subschema, ok := keywords["contains"]; ok {
+		isMatch := func(elem any) bool { return len(s.validate(subschema, elem, path, depth+1)) == 0 }
+		if !slices.ContainsFunc(value, isMatch) {
+			report(`must contain an element matching the schema in "contains"`)
+		}
+	}
+	return errs
+}
+
+func (s jsonSchema) validateObject(keywords map[string]any, value map[string]any, path ValuePath, depth int) (errs []string) {
+	report := func(msg string, args ...any) {
+		errs = append(errs, fmt.Sprintf("at %s: %s", describeValuePath(path), fmt.Sprintf(msg, args...)))
+	}
+
+	if limit, ok := keywords["minProperties"].(float64); ok && float64(len(value)) < limit {
+		report("must have at least %v keys, but has %d", limit, len(value))
+	}
+	if limit, ok := keywords["maxProperties"].(float64); ok && float64(len(value)) > limit {
+		report("must have at most %v keys, but has %d", limit, len(value))
+	}
+	if required, ok := keywords["required"].([]any); ok {
+		for _, key := range required {
+			key, ok := key.(string)
+			if _, exists := value[key]; ok && !exists {
+				report("missing required key %q", key)
+			}
+		}
+	}
+
+	properties, _ := keywords["properties"].(map[string]any)
+	patternProperties, _ := keywords["patternProperties"].(map[string]any)
+	for _, key := range slices.Sorted(maps.Keys(value)) {
+		keyPath := path.Append(ValuePathElement{Key: key})
+		if subschema, ok := keywords["propertyNames"]; ok {
+			if len(s.validate(subschema, key, path, depth+1)) > 0 {
+				report(`key %q does not match the schema in "propertyNames"`, key)
+			}
+		}
+
+		// each value is checked against all matching "properties" and "patternProperties",
+		// and only against "additionalProperties" if none of those match
+		isCovered := false
+		if subschema, ok := properties[key]; ok {
+			isCovered = true
+			errs = append(errs, s.validate(subschema, value[key], keyPath, depth+1)...)
+		}
+		for _, pattern := range slices.Sorted(maps.Keys(patternProperties)) {
+			rx, ok := s.compilePattern(pattern)
+			if !ok {
+				// we cannot know whether the key matches, so do not fall back to "additionalProperties" either
+				isCovered = true
+				continue
+			}
+			if rx.MatchString(key) {
+				isCovered = true
+				errs = append(errs, s.validate(patternProperties[pattern], value[key], keyPath, depth+1)...)
+			}
+		}
+		if subschema, ok := keywords["additionalProperties"]; ok && !isCovered {
+			if subschema == false {
+				errs = append(errs, fmt.Sprintf("at %s: key is not allowed here", describeValuePath(keyPath)))
+			} else {
+				errs = append(errs, s.validate(subschema, value[key], keyPath, depth+1)...)
+			}
+		}
+	}
+	return errs
+}
+
+// Resolves a reference like "#/definitions/foo" within this schema.
+func (s jsonSchema) resolveReference(ref string) (any, error) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("cannot resolve $ref %q (only references within values.schema.json are supported)", ref)
+	}
+	pointer, err := url.PathUnescape(pointer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve $ref %q: %w", ref, err)
+	}
+	if pointer == "" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("cannot resolve $ref %q (only JSON pointers are supported)", ref)
+	}
+
+	current := s.root
+	for token := range strings.SplitSeq(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		var found bool
+		switch obj := current.(type) {
+		case map[string]any:
+			current, found = obj[token]
+		case []any:
+			idx, err := strconv.Atoi(token)
+			if err == nil && idx >= 0 && idx < len(obj) {
+				current, found = obj[idx], true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("cannot resolve $ref %q: no such location in values.schema.json", ref)
+		}
+	}
+	return current, nil
+}
+
+// Returns the list of types from a "type" keyword, which may be either a single string or a list of strings.
+func jsonSchemaTypes(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var result []string
+		for _, elem := range value {
+			if elem, ok := elem.(string); ok {
+				result = append(result, elem)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// Returns the JSON Schema type name for a value from normalizeJSONValue().
+func jsonTypeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// Converts a value tree as decoded by yaml.Unmarshal() into the shape that json.Unmarshal() would produce,
+// in the same way as Helm converts values to JSON before validating them against the schema.
+func normalizeJSONValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(value))
+		for key, elem := range value {
+			result[key] = normalizeJSONValue(elem)
+		}
+		return result
+	case map[any]any:
+		result := make(map[string]any, len(value))
+		for key, elem := range value {
+			result[fmt.Sprint(key)] = normalizeJSONValue(elem)
+		}
+		return result
+	case []any:
+		result := make([]any, len(value))
+		for idx, elem := range value {
+			result[idx] = normalizeJSONValue(elem)
+		}
+		return result
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	default:
+		return value
+	}
+}
+
+// Renders a value for inclusion in an error message.
+func encodeJSONForMessage(value any) string {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(buf)
+}
+
+// Renders a ValuePath for inclusion in an error message.
+func describeValuePath(path ValuePath) string {
+	if len(path) == 0 {
+		return ".Values"
+	}
+	return ".Values." + path.String()
+}
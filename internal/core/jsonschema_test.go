@@ -0,0 +1,440 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// These test cases use the format of the JSON-Schema-Test-Suite (https://github.com/json-schema-org/JSON-Schema-Test-Suite),
+// and are modeled on the cases in its tests/draft7 directory for the keywords that we support.
+const jsonSchemaTestSuite = `[
+	{
+		"description": "type: integer",
+		"schema": {"type": "integer"},
+		"tests": [
+			{"description": "an integer is an integer", "data": 1, "valid": true},
+			{"description": "a float with zero fractional part is an integer", "data": 1.0, "valid": true},
+			{"description": "a float is not an integer", "data": 1.1, "valid": false},
+			{"description": "a string is not an integer", "data": "foo", "valid": false},
+			{"description": "a string is still not an integer, even if it looks like one", "data": "1", "valid": false},
+			{"description": "an object is not an integer", "data": {}, "valid": false},
+			{"description": "an array is not an integer", "data": [], "valid": false},
+			{"description": "a boolean is not an integer", "data": true, "valid": false},
+			{"description": "null is not an integer", "data": null, "valid": false}
+		]
+	},
+	{
+		"description": "type: number",
+		"schema": {"type": "number"},
+		"tests": [
+			{"description": "an integer is a number", "data": 1, "valid": true},
+			{"description": "a float is a number", "data": 1.1, "valid": true},
+			{"description": "a string is not a number", "data": "1", "valid": false},
+			{"description": "null is not a number", "data": null, "valid": false}
+		]
+	},
+	{
+		"description": "type: multiple types",
+		"schema": {"type": ["integer", "string"]},
+		"tests": [
+			{"description": "an integer is valid", "data": 1, "valid": true},
+			{"description": "a string is valid", "data": "foo", "valid": true},
+			{"description": "a float is invalid", "data": 1.1, "valid": false},
+			{"description": "an object is invalid", "data": {}, "valid": false},
+			{"description": "null is invalid", "data": null, "valid": false}
+		]
+	},
+	{
+		"description": "type: null",
+		"schema": {"type": "null"},
+		"tests": [
+			{"description": "null is null", "data": null, "valid": true},
+			{"description": "zero is not null", "data": 0, "valid": false},
+			{"description": "an empty string is not null", "data": "", "valid": false},
+			{"description": "false is not null", "data": false, "valid": false}
+		]
+	},
+	{
+		"description": "boolean schema: true",
+		"schema": true,
+		"tests": [
+			{"description": "number is valid", "data": 1, "valid": true},
+			{"description": "object is valid", "data": {"foo": "bar"}, "valid": true},
+			{"description": "null is valid", "data": null, "valid": true}
+		]
+	},
+	{
+		"description": "boolean schema: false",
+		"schema": false,
+		"tests": [
+			{"description": "number is invalid", "data": 1, "valid": false},
+			{"description": "empty object is invalid", "data": {}, "valid": false},
+			{"description": "null is invalid", "data": null, "valid": false}
+		]
+	},
+	{
+		"description": "enum: heterogeneous",
+		"schema": {"enum": [6, "foo", [], true, {"foo": 12}, null]},
+		"tests": [
+			{"description": "one of the enum is valid", "data": [], "valid": true},
+			{"description": "null is valid", "data": null, "valid": true},
+			{"description": "objects are deep compared", "data": {"foo": false}, "valid": false},
+			{"description": "valid object matches", "data": {"foo": 12}, "valid": true},
+			{"description": "extra properties in object is invalid", "data": {"foo": 12, "boo": 42}, "valid": false},
+			{"description": "something else is invalid", "data": 7, "valid": false}
+		]
+	},
+	{
+		"description": "enum: numbers and booleans are distinct",
+		"schema": {"enum": [false, 1.0]},
+		"tests": [
+			{"description": "false is valid", "data": false, "valid": true},
+			{"description": "integer one is valid", "data": 1, "valid": true},
+			{"description": "integer zero is invalid", "data": 0, "valid": false},
+			{"description": "true is invalid", "data": true, "valid": false}
+		]
+	},
+	{
+		"description": "const",
+		"schema": {"const": {"foo": "bar", "baz": "bax"}},
+		"tests": [
+			{"description": "same object is valid", "data": {"foo": "bar", "baz": "bax"}, "valid": true},
+			{"description": "same object with different property order is valid", "data": {"baz": "bax", "foo": "bar"}, "valid": true},
+			{"description": "another object is invalid", "data": {"foo": "bar"}, "valid": false},
+			{"description": "another type is invalid", "data": [1, 2], "valid": false}
+		]
+	},
+	{
+		"description": "minimum and maximum",
+		"schema": {"minimum": -2, "maximum": 3.0},
+		"tests": [
+			{"description": "within range is valid", "data": 2.6, "valid": true},
+			{"description": "boundary points are valid", "data": -2, "valid": true},
+			{"description": "below the minimum is invalid", "data": -2.0001, "valid": false},
+			{"description": "above the maximum is invalid", "data": 3.5, "valid": false},
+			{"description": "ignores non-numbers", "data": "x", "valid": true}
+		]
+	},
+	{
+		"description": "exclusiveMinimum and exclusiveMaximum",
+		"schema": {"exclusiveMinimum": 1.1, "exclusiveMaximum": 3.0},
+		"tests": [
+			{"description": "within range is valid", "data": 1.2, "valid": true},
+			{"description": "minimum boundary point is invalid", "data": 1.1, "valid": false},
+			{"description": "maximum boundary point is invalid", "data": 3.0, "valid": false},
+			{"description": "below the minimum is invalid", "data": 0.6, "valid": false}
+		]
+	},
+	{
+		"description": "multipleOf: integer divisor",
+		"schema": {"multipleOf": 2},
+		"tests": [
+			{"description": "int by int", "data": 10, "valid": true},
+			{"description": "int by int fail", "data": 7, "valid": false},
+			{"description": "ignores non-numbers", "data": "foo", "valid": true}
+		]
+	},
+	{
+		"description": "multipleOf: small number divisor",
+		"schema": {"multipleOf": 0.0001},
+		"tests": [
+			{"description": "0.0075 is a multiple of 0.0001", "data": 0.0075, "valid": true},
+			{"description": "0.00751 is not a multiple of 0.0001", "data": 0.00751, "valid": false}
+		]
+	},
+	{
+		"description": "minLength and maxLength",
+		"schema": {"minLength": 2, "maxLength": 3},
+		"tests": [
+			{"description": "within range is valid", "data": "fo", "valid": true},
+			{"description": "too short is invalid", "data": "f", "valid": false},
+			{"description": "too long is invalid", "data": "fooo", "valid": false},
+			{"description": "supplementary code points count as one character each", "data": "💩💩", "valid": true},
+			{"description": "ignores non-strings", "data": 1, "valid": true}
+		]
+	},
+	{
+		"description": "pattern",
+		"schema": {"pattern": "^a*$"},
+		"tests": [
+			{"description": "a matching pattern is valid", "data": "aaa", "valid": true},
+			{"description": "a non-matching pattern is invalid", "data": "abc", "valid": false},
+			{"description": "ignores non-strings", "data": true, "valid": true}
+		]
+	},
+	{
+		"description": "pattern is not anchored",
+		"schema": {"pattern": "a+"},
+		"tests": [
+			{"description": "matches a substring", "data": "xxaayy", "valid": true}
+		]
+	},
+	{
+		"description": "items and additionalItems",
+		"schema": {"items": [{"type": "integer"}, {"type": "string"}], "additionalItems": false},
+		"tests": [
+			{"description": "correct types are valid", "data": [1, "foo"], "valid": true},
+			{"description": "fewer items are valid", "data": [1], "valid": true},
+			{"description": "wrong types are invalid", "data": ["foo", 1], "valid": false},
+			{"description": "additional items are invalid", "data": [1, "foo", true], "valid": false}
+		]
+	},
+	{
+		"description": "additionalItems is ignored without tuple-form items",
+		"schema": {"items": {"type": "integer"}, "additionalItems": {"type": "string"}},
+		"tests": [
+			{"description": "all items are checked against items", "data": [1, 2, 3], "valid": true},
+			{"description": "additionalItems does not apply", "data": [1, "foo"], "valid": false}
+		]
+	},
+	{
+		"description": "items: false",
+		"schema": {"items": false},
+		"tests": [
+			{"description": "any non-empty array is invalid", "data": [1], "valid": false},
+			{"description": "empty array is valid", "data": [], "valid": true}
+		]
+	},
+	{
+		"description": "minItems, maxItems and uniqueItems",
+		"schema": {"minItems": 1, "maxItems": 3, "uniqueItems": true},
+		"tests": [
+			{"description": "unique elements are valid", "data": [1, "1", true], "valid": true},
+			{"description": "empty array is invalid", "data": [], "valid": false},
+			{"description": "too many elements are invalid", "data": [1, 2, 3, 4], "valid": false},
+			{"description": "numbers are not unique if mathematically equal", "data": [1.0, 1.00, 1], "valid": false},
+			{"description": "non-unique objects are invalid", "data": [{"foo": "bar"}, {"foo": "bar"}], "valid": false},
+			{"description": "arrays with different contents are unique", "data": [[1], [true]], "valid": true}
+		]
+	},
+	{
+		"description": "contains",
+		"schema": {"contains": {"minimum": 5}},
+		"tests": [
+			{"description": "array with item matching schema is valid", "data": [3, 4, 5], "valid": true},
+			{"description": "array without items matching schema is invalid", "data": [2, 3, 4], "valid": false},
+			{"description": "empty array is invalid", "data": [], "valid": false},
+			{"description": "not array is valid", "data": {}, "valid": true}
+		]
+	},
+	{
+		"description": "required and properties",
+		"schema": {
+			"properties": {"foo": {"type": "integer"}, "bar": {"type": "string"}},
+			"required": ["foo"]
+		},
+		"tests": [
+			{"description": "both present and valid", "data": {"foo": 1, "bar": "baz"}, "valid": true},
+			{"description": "one property invalid", "data": {"foo": 1, "bar": {}}, "valid": false},
+			{"description": "required property missing", "data": {"bar": "baz"}, "valid": false},
+			{"description": "additional properties are allowed by default", "data": {"foo": 1, "quux": []}, "valid": true},
+			{"description": "ignores arrays", "data": [], "valid": true}
+		]
+	},
+	{
+		"description": "minProperties and maxProperties",
+		"schema": {"minProperties": 1, "maxProperties": 2},
+		"tests": [
+			{"description": "within range is valid", "data": {"foo": 1}, "valid": true},
+			{"description": "too few properties are invalid", "data": {}, "valid": false},
+			{"description": "too many properties are invalid", "data": {"foo": 1, "bar": 2, "baz": 3}, "valid": false}
+		]
+	},
+	{
+		"description": "additionalProperties with properties and patternProperties",
+		"schema": {
+			"properties": {"foo": {}, "bar": {}},
+			"patternProperties": {"^v": {}},
+			"additionalProperties": false
+		},
+		"tests": [
+			{"description": "no additional properties is valid", "data": {"foo": 1}, "valid": true},
+			{"description": "an additional property is invalid", "data": {"foo": 1, "bar": 2, "quux": "boom"}, "valid": false},
+			{"description": "patternProperties are not additional properties", "data": {"foo": 1, "vroom": 2}, "valid": true}
+		]
+	},
+	{
+		"description": "additionalProperties as schema",
+		"schema": {"properties": {"foo": {}}, "additionalProperties": {"type": "boolean"}},
+		"tests": [
+			{"description": "an additional valid property is valid", "data": {"foo": 1, "bar": true}, "valid": true},
+			{"description": "an additional invalid property is invalid", "data": {"foo": 1, "bar": 1}, "valid": false}
+		]
+	},
+	{
+		"description": "patternProperties: multiple simultaneous matches",
+		"schema": {"patternProperties": {"a*": {"type": "integer"}, "aaa*": {"maximum": 20}}},
+		"tests": [
+			{"description": "a single valid match is valid", "data": {"a": 21}, "valid": true},
+			{"description": "a simultaneous match is valid", "data": {"aaaa": 18}, "valid": true},
+			{"description": "an invalid due to one is invalid", "data": {"a": "bar"}, "valid": false},
+			{"description": "an invalid due to the other is invalid", "data": {"aaaa": 31}, "valid": false}
+		]
+	},
+	{
+		"description": "propertyNames",
+		"schema": {"propertyNames": {"maxLength": 3}},
+		"tests": [
+			{"description": "all property names valid", "data": {"f": {}, "foo": {}}, "valid": true},
+			{"description": "some property names invalid", "data": {"foo": {}, "foobar": {}}, "valid": false},
+			{"description": "ignores non-objects", "data": "foobar", "valid": true}
+		]
+	},
+	{
+		"description": "allOf, anyOf, oneOf and not",
+		"schema": {
+			"allOf": [{"type": "integer"}],
+			"anyOf": [{"minimum": 2}, {"maximum": -2}],
+			"oneOf": [{"multipleOf": 2}, {"multipleOf": 3}],
+			"not": {"const": 10}
+		},
+		"tests": [
+			{"description": "matches everything", "data": 3, "valid": true},
+			{"description": "mismatches allOf", "data": 3.5, "valid": false},
+			{"description": "mismatches anyOf", "data": 0, "valid": false},
+			{"description": "matches both schemas in oneOf", "data": 6, "valid": false},
+			{"description": "matches neither schema in oneOf", "data": 5, "valid": false},
+			{"description": "matches not", "data": 10, "valid": false}
+		]
+	},
+	{
+		"description": "if, then and else",
+		"schema": {"if": {"exclusiveMaximum": 0}, "then": {"minimum": -10}, "else": {"multipleOf": 2}},
+		"tests": [
+			{"description": "valid through then", "data": -1, "valid": true},
+			{"description": "invalid through then", "data": -100, "valid": false},
+			{"description": "valid through else", "data": 4, "valid": true},
+			{"description": "invalid through else", "data": 3, "valid": false}
+		]
+	},
+	{
+		"description": "root pointer ref",
+		"schema": {"properties": {"foo": {"$ref": "#"}}, "additionalProperties": false},
+		"tests": [
+			{"description": "match", "data": {"foo": false}, "valid": true},
+			{"description": "recursive match", "data": {"foo": {"foo": false}}, "valid": true},
+			{"description": "mismatch", "data": {"bar": false}, "valid": false},
+			{"description": "recursive mismatch", "data": {"foo": {"bar": false}}, "valid": false}
+		]
+	},
+	{
+		"description": "escaped pointer ref",
+		"schema": {
+			"definitions": {"tilde~field": {"type": "integer"}, "slash/field": {"type": "integer"}, "percent%field": {"type": "integer"}},
+			"properties": {
+				"tilde": {"$ref": "#/definitions/tilde~0field"},
+				"slash": {"$ref": "#/definitions/slash~1field"},
+				"percent": {"$ref": "#/definitions/percent%25field"}
+			}
+		},
+		"tests": [
+			{"description": "slash valid", "data": {"slash": 123}, "valid": true},
+			{"description": "slash invalid", "data": {"slash": "aoeu"}, "valid": false},
+			{"description": "tilde invalid", "data": {"tilde": "aoeu"}, "valid": false},
+			{"description": "percent invalid", "data": {"percent": "aoeu"}, "valid": false}
+		]
+	},
+	{
+		"description": "ref overrides any sibling keywords in draft-07",
+		"schema": {
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"definitions": {"reffed": {"type": "array"}},
+			"properties": {"foo": {"$ref": "#/definitions/reffed", "maxItems": 2}}
+		},
+		"tests": [
+			{"description": "ref valid", "data": {"foo": []}, "valid": true},
+			{"description": "ref valid, maxItems ignored", "data": {"foo": [1, 2, 3]}, "valid": true},
+			{"description": "ref invalid", "data": {"foo": "string"}, "valid": false}
+		]
+	},
+	{
+		"description": "ref is combined with sibling keywords in later drafts",
+		"schema": {
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"$defs": {"reffed": {"type": "array"}},
+			"properties": {"foo": {"$ref": "#/$defs/reffed", "maxItems": 2}}
+		},
+		"tests": [
+			{"description": "ref valid, maxItems valid", "data": {"foo": [1, 2]}, "valid": true},
+			{"description": "ref valid, maxItems invalid", "data": {"foo": [1, 2, 3]}, "valid": false}
+		]
+	},
+	{
+		"description": "prefixItems from draft 2020-12",
+		"schema": {"prefixItems": [{"type": "integer"}], "items": {"type": "string"}},
+		"tests": [
+			{"description": "correct types are valid", "data": [1, "foo", "bar"], "valid": true},
+			{"description": "wrong type in rest is invalid", "data": [1, 2], "valid": false}
+		]
+	}
+]`
+
+func TestJSONSchemaTestSuite(t *testing.T) {
+	var groups []struct {
+		Description string          `json:"description"`
+		Schema      json.RawMessage `json:"schema"`
+		Tests       []struct {
+			Description string `json:"description"`
+			Data        any    `json:"data"`
+			Valid       bool   `json:"valid"`
+		} `json:"tests"`
+	}
+	err := json.Unmarshal([]byte(jsonSchemaTestSuite), &groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, group := range groups {
+		schema, err := parseJSONSchema(group.Schema)
+		if err != nil {
+			t.Errorf("%s: cannot parse schema: %s", group.Description, err.Error())
+			continue
+		}
+		for _, tc := range group.Tests {
+			violations := schema.Validate(tc.Data, nil)
+			if tc.Valid && len(violations) > 0 {
+				t.Errorf("%s: %s: expected valid, but got %q", group.Description, tc.Description, violations)
+			}
+			if !tc.Valid && len(violations) == 0 {
+				t.Errorf("%s: %s: expected invalid, but got no violations", group.Description, tc.Description)
+			}
+		}
+	}
+}
+
+func TestJSONSchemaUnsupportedPatterns(t *testing.T) {
+	// lookahead and backreferences are valid in ECMA-262, but not in Go's regexp package
+	schema, err := parseJSONSchema([]byte(`{
+		"properties": {
+			"password": {"type": "string", "pattern": "^(?=.*[0-9]).{8,}$"}
+		},
+		"patternProperties": {"^(a)\\1$": {"type": "integer"}},
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// patterns that cannot be compiled are skipped instead of being reported as violations,
+	// and keys that might match them are not considered additional properties
+	violations := schema.Validate(map[string]any{"password": "hunter2", "aa": "foo"}, nil)
+	if len(violations) > 0 {
+		t.Errorf("expected no violations, but got %q", violations)
+	}
+	warnings := schema.Warnings()
+	if len(warnings) != 2 ||
+		!strings.HasPrefix(warnings[0], `cannot check values against the pattern "^(?=.*[0-9]).{8,}$": `) ||
+		!strings.HasPrefix(warnings[1], `cannot check values against the pattern "^(a)\\1$": `) {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	// other keywords are still checked
+	violations = schema.Validate(map[string]any{"password": 42}, nil)
+	if len(violations) != 1 || violations[0] != "at .Values.password: expected string, but got integer" {
+		t.Errorf("unexpected violations: %q", violations)
+	}
+}
@@ -4,10 +4,20 @@
 package core
 
 import (
-	"encoding/json"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
 
-	"github.com/sapcc/ocm-helm-toolbox/internal/util"
+	"github.com/opencontainers/go-digest"
+	"github.com/sapcc/go-bits/logg"
+	"go.podman.io/image/v5/docker/reference"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
 )
 
 // OCMComponentDeclaration is the `components[]` section of a component-constructor.yaml file.
@@ -39,42 +49,59 @@ type OCMResourceDeclaration struct {
 type OCMLabel struct {
 	Name  OCMLabelName `yaml:"name"`
 	Value any          `yaml:"value"` // type is intentional; they REALLY allow arbitrary YAML here
+	// If true, the label is covered by signatures on the component version.
+	Signing bool `yaml:"signing,omitempty"`
 }
 
 // OCMLabelName enumerates known OCMLabel names.
 type OCMLabelName string
 
 const (
+	FileNameLabelName       OCMLabelName = "cloud.sap/file-name"
 	GitLocationLabelName    OCMLabelName = "cloud.sap/git-location"
 	ImageRelationsLabelName OCMLabelName = "cloud.sap/image-relations"
+	InstallOrderLabelName   OCMLabelName = "cloud.sap/install-order"
+	SBOMForLabelName        OCMLabelName = "cloud.sap/sbom-for"
 )
 
 // OCMResourceInfoSet contains information about several resources,
-// as reported by `ocm get resources -o json`.
+// as reported by `ocm get resources -o json` or as listed in a component descriptor.
 type OCMResourceInfoSet []OCMResourceInfo
 
-// GetOCMResources lists the resources in the given component version.
-func GetOCMResources(componentVersionRef string) (OCMResourceInfoSet, error) {
-	buf, err := util.ExecOCM("get", "resources", "-o", "json", componentVersionRef)
-	if err != nil {
-		return nil, err
-	}
+// OCMClient reads component versions from OCM repositories.
+//
+// Component versions are referred to by the same syntax that the `ocm` CLI understands:
+// either as the path to a CTF archive on the filesystem, or as "$OCI_REGISTRY//$COMPONENT_NAME:$COMPONENT_VERSION".
+type OCMClient interface {
+	// ListResources lists the resources in the given component version.
+	ListResources(ctx context.Context, componentVersionRef string) (OCMResourceInfoSet, error)
+	// DownloadResource opens the payload of a resource in the given component version for reading.
+	DownloadResource(ctx context.Context, componentVersionRef string, res OCMResourceInfo) (io.ReadCloser, error)
+	// GetComponentDescriptor retrieves the component descriptor of the given component version, in YAML or JSON format.
+	GetComponentDescriptor(ctx context.Context, componentVersionRef string) ([]byte, error)
+}
 
-	var data struct {
-		Items []struct {
-			Element OCMResourceInfo `json:"element"`
-		} `json:"items"`
-	}
-	err = json.Unmarshal(buf, &data)
-	if err != nil {
-		return nil, fmt.Errorf("could not unpack output from `ocm get resources -o json`: %w", err)
-	}
+// OCMClientBackends lists the acceptable arguments for NewOCMClient().
+var OCMClientBackends = []string{"native", "exec"}
 
-	result := make([]OCMResourceInfo, len(data.Items))
-	for idx, item := range data.Items {
-		result[idx] = item.Element
+// NewOCMClient builds an OCMClient with the given backend:
+//
+//   - "native" accesses CTF archives and OCI registries directly.
+//   - "exec" delegates to the `ocm` CLI, which must be installed.
+func NewOCMClient(backend string) (OCMClient, error) {
+	switch backend {
+	case "native":
+		return newNativeOCMClient(), nil
+	case "exec":
+		return execOCMClient{}, nil
+	default:
+		return nil, fmt.Errorf("unknown OCM client backend %q (expected one of: %s)", backend, strings.Join(OCMClientBackends, ", "))
 	}
-	return result, nil
+}
+
+// GetOCMResources lists the resources in the given component version.
+func GetOCMResources(ctx context.Context, client OCMClient, componentVersionRef string) (OCMResourceInfoSet, error) {
+	return client.ListResources(ctx, componentVersionRef)
 }
 
 // FindExactlyOneWith returns the only resource that matches the predicate.
@@ -96,36 +123,243 @@ func (r OCMResourceInfoSet) FindExactlyOneWith(description string, match func(OC
 	}
 }
 
+// FindHelmChartsInInstallOrder returns all Helm chart resources,
+// sorted by the install order that was recorded by the "bundle" subcommand.
+func (r OCMResourceInfoSet) FindHelmChartsInInstallOrder() ([]OCMResourceInfo, error) {
+	var result []OCMResourceInfo
+	installOrder := make(map[string]int)
+	for _, res := range r {
+		if res.Type != "helmChart" {
+			continue
+		}
+		value, ok := res.GetLabel(InstallOrderLabelName)
+		if ok {
+			// NOTE: Depending on how the label was deserialized, the number could have any numeric type.
+			switch value := value.(type) {
+			case int:
+				installOrder[res.Name] = value
+			case float64:
+				installOrder[res.Name] = int(value)
+			default:
+				return nil, fmt.Errorf("could not read label %q on resource %q: expected integer value, but got %#v",
+					InstallOrderLabelName, res.Name, value)
+			}
+		}
+		result = append(result, res)
+	}
+
+	switch {
+	case len(result) == 0:
+		return nil, errors.New(`did not find any resource with type: "helmChart"`)
+	case len(result) == 1:
+		// component versions bundled with older versions of this tool do not have the install-order label,
+		// but if there is only one chart, the order does not matter anyway
+		return result, nil
+	case len(installOrder) < len(result):
+		return nil, fmt.Errorf("found %d resources with type: \"helmChart\", but not all of them have the %q label",
+			len(result), InstallOrderLabelName)
+	}
+
+	slices.SortStableFunc(result, func(lhs, rhs OCMResourceInfo) int {
+		return cmp.Compare(installOrder[lhs.Name], installOrder[rhs.Name])
+	})
+	for idx := 1; idx < len(result); idx++ {
+		if installOrder[result[idx-1].Name] == installOrder[result[idx].Name] {
+			return nil, fmt.Errorf("resources %q and %q have the same value for the %q label, so their install order is ambiguous",
+				result[idx-1].Name, result[idx].Name, InstallOrderLabelName)
+		}
+	}
+	return result, nil
+}
+
 // OCMResourceInfo contains information about an existing resource,
-// as reported by `ocm get resources -o json`.
+// as reported by `ocm get resources -o json` or as listed in a component descriptor.
 //
 // This is a heavily abridged type declaration that only contains the fields we need.
 type OCMResourceInfo struct {
-	Name    string            `json:"name"`
-	Version string            `json:"version"`
-	Type    string            `json:"type"` // e.g. "file" or "helmChart" or "ociArtifact"
-	Labels  []OCMLabel        `yaml:"labels,omitempty"`
-	Access  OCMResourceAccess `json:"access"`
-}
-
-// GetPayloadFrom retrieves the resource's payload from the store holding the component version.
-func (r OCMResourceInfo) GetPayloadFrom(componentVersionRef string) ([]byte, error) {
-	buf, err := util.ExecOCM(
-		"download", "resource", "-O", "-",
-		componentVersionRef, r.Name,
-	)
+	Name    string            `json:"name" yaml:"name"`
+	Version string            `json:"version" yaml:"version"`
+	Type    string            `json:"type" yaml:"type"` // e.g. "file" or "helmChart" or "ociArtifact"
+	Labels  []OCMLabel        `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Access  OCMResourceAccess `json:"access" yaml:"access"`
+	Digest  *OCMDigest        `json:"digest,omitempty" yaml:"digest,omitempty"`
+}
+
+// GetLabel returns the value of the label with the given name, if the resource has it.
+func (r OCMResourceInfo) GetLabel(name OCMLabelName) (any, bool) {
+	for _, label := range r.Labels {
+		if label.Name == name {
+			return label.Value, true
+		}
+	}
+	return nil, false
+}
+
+// GetPayloadFrom opens the resource's payload from the store holding the component version for reading.
+// If the component descriptor records a digest for this resource, the payload is verified against it.
+//
+// The payload is streamed instead of being read into memory, since it may be a large image.
+// For generic blobs, the digest is checked when reaching EOF: If it does not match, the final Read() returns an error instead of io.EOF.
+// For images, the payload is first copied into a temporary file to verify the digest of the image manifest,
+// and only returned if it matches.
+func (r OCMResourceInfo) GetPayloadFrom(ctx context.Context, client OCMClient, componentVersionRef string) (io.ReadCloser, error) {
+	reader, err := client.DownloadResource(ctx, componentVersionRef, r)
 	if err != nil {
 		return nil, fmt.Errorf("could not download resource %q: %w", r.Name, err)
 	}
-	return buf, nil
+	if r.Digest == nil {
+		logg.Debug("not verifying payload of resource %q since the component descriptor does not record a digest for it", r.Name)
+		return reader, nil
+	}
+	result, err := r.verifyPayload(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not download resource %q from %s: %w", r.Name, componentVersionRef, err)
+	}
+	return result, nil
+}
+
+// Wraps the given payload of this resource such that it is checked against the digest recorded in the component descriptor.
+// Takes ownership of the given reader.
+func (r OCMResourceInfo) verifyPayload(reader io.ReadCloser) (io.ReadCloser, error) {
+	if r.Digest.HashAlgorithm != "SHA-256" {
+		reader.Close()
+		return nil, fmt.Errorf("cannot verify payload: unsupported hash algorithm %q", r.Digest.HashAlgorithm)
+	}
+
+	switch r.Digest.NormalisationAlgorithm {
+	case "genericBlobDigest/v1":
+		return &payloadVerifier{reader, r.Digest.Value, digest.SHA256.Digester()}, nil
+	case "ociArtifactDigest/v1":
+		// for images, the digest of the image manifest is recorded
+		defer reader.Close()
+		return verifyImagePayload(reader, r.Digest.Value)
+	default:
+		reader.Close()
+		return nil, fmt.Errorf("cannot verify payload: unsupported normalisation algorithm %q", r.Digest.NormalisationAlgorithm)
+	}
+}
+
+func newPayloadDigestMismatchError(expected, actual string) error {
+	return fmt.Errorf("payload does not match the digest in the component descriptor (expected sha256:%s, but got sha256:%s)", expected, actual)
+}
+
+// payloadVerifier wraps the payload of a resource with a genericBlobDigest/v1,
+// and checks the digest once EOF is reached.
+type payloadVerifier struct {
+	io.ReadCloser
+	expected string // hex-encoded SHA-256 digest
+	digester digest.Digester
+}
+
+// Read implements the io.Reader interface.
+func (v *payloadVerifier) Read(buf []byte) (int, error) {
+	n, err := v.ReadCloser.Read(buf)
+	v.digester.Hash().Write(buf[:n])
+	if errors.Is(err, io.EOF) {
+		actual := v.digester.Digest().Encoded()
+		if actual != v.expected {
+			return n, newPayloadDigestMismatchError(v.expected, actual)
+		}
+	}
+	return n, err
+}
+
+// Copies the payload of a resource with an ociArtifactDigest/v1 into a temporary file while checking its digest.
+// If the digest matches, the temporary file is returned for reading, and is deleted when closed.
+func verifyImagePayload(reader io.Reader, expected string) (result io.ReadCloser, returnedErr error) {
+	file, err := os.CreateTemp("", "ocm-helm-toolbox-payload-")
+	if err != nil {
+		return nil, err
+	}
+	tempFile := &tempFileReader{file}
+	defer func() {
+		if returnedErr != nil {
+			tempFile.Close()
+		}
+	}()
+
+	root, err := oci.VerifyImageLayoutTarball(io.TeeReader(reader, file))
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify payload: %w", err)
+	}
+	actual := strings.TrimPrefix(root.Digest, "sha256:")
+	if actual != expected {
+		return nil, newPayloadDigestMismatchError(expected, actual)
+	}
+
+	// the tarball may have trailing data that VerifyImageLayoutTarball() did not need to read
+	_, err = io.Copy(file, reader)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return tempFile, nil
+}
+
+// tempFileReader is an *os.File that is deleted when closed.
+type tempFileReader struct {
+	*os.File
+}
+
+// Close implements the io.Closer interface.
+func (f *tempFileReader) Close() error {
+	err := f.File.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(f.Name())
+}
+
+// VerifyImageReference checks that the given reference of an image that is not stored within the component version
+// is pinned to the digest recorded in the component descriptor.
+// Since access specifications are not covered by signatures, this ensures that the signature applies to the referenced image.
+func (r OCMResourceInfo) VerifyImageReference(imageRef reference.Named) error {
+	if r.Digest == nil {
+		return fmt.Errorf("resource %q does not have a digest, so its image reference cannot be verified", r.Name)
+	}
+	if r.Digest.HashAlgorithm != "SHA-256" || r.Digest.NormalisationAlgorithm != "ociArtifactDigest/v1" {
+		return fmt.Errorf("cannot verify image reference of resource %q: unsupported digest algorithm %s with %s",
+			r.Name, r.Digest.HashAlgorithm, r.Digest.NormalisationAlgorithm)
+	}
+	digested, ok := imageRef.(reference.Digested)
+	if !ok || digested.Digest().String() != "sha256:"+r.Digest.Value {
+		return fmt.Errorf("image reference %s of resource %q does not match its digest (expected sha256:%s)",
+			imageRef.String(), r.Name, r.Digest.Value)
+	}
+	return nil
 }
 
 // OCMResourceAccess appears in type OCMResourceInfo.
 //
 // This is a heavily abridged type declaration that only contains the fields we need.
 type OCMResourceAccess struct {
-	Type           string `json:"type"`           // e.g. "localBlob" or "ociArtifact"
-	ImageReference string `json:"imageReference"` // only for .Type == "ociArtifact"
-	MediaType      string `json:"mediaType"`      // only for .Type == "localBlob"
-	LocalReference string `json:"localReference"` // only for .Type == "localBlob"
+	Type           string             `json:"type" yaml:"type"`                                         // e.g. "localBlob" or "ociArtifact"
+	ImageReference string             `json:"imageReference,omitempty" yaml:"imageReference,omitempty"` // only for .Type == "ociArtifact"
+	MediaType      string             `json:"mediaType,omitempty" yaml:"mediaType,omitempty"`           // only for .Type == "localBlob"
+	LocalReference string             `json:"localReference,omitempty" yaml:"localReference,omitempty"` // only for .Type == "localBlob"
+	ReferenceName  string             `json:"referenceName,omitempty" yaml:"referenceName,omitempty"`   // only for .Type == "localBlob"
+	GlobalAccess   *OCMResourceAccess `json:"globalAccess,omitempty" yaml:"globalAccess,omitempty"`     // only for .Type == "localBlob"
+}
+
+// IsLocalBlob returns whether this access refers to a blob that is stored within the component version.
+func (a OCMResourceAccess) IsLocalBlob() bool {
+	return a.Type == "localBlob" || a.Type == "localBlob/v1"
+}
+
+// GetImageReference returns the reference of the OCI image that this access refers to.
+// For images stored as local blobs, this is the reference of the image from where it was originally copied.
+func (a OCMResourceAccess) GetImageReference() (string, bool) {
+	switch {
+	case a.Type == "ociArtifact" || a.Type == "ociArtifact/v1":
+		return a.ImageReference, a.ImageReference != ""
+	case a.IsLocalBlob() && a.GlobalAccess != nil:
+		return a.GlobalAccess.GetImageReference()
+	case a.IsLocalBlob():
+		return a.ReferenceName, a.ReferenceName != ""
+	default:
+		return "", false
+	}
 }
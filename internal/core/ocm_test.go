@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// Builds a Helm chart resource with the given value for the install-order label (or without that label if the value is nil).
+func makeTestChartResource(name string, installOrder any) OCMResourceInfo {
+	res := OCMResourceInfo{Name: name, Version: "1.0.0", Type: "helmChart"}
+	if installOrder != nil {
+		res.Labels = []OCMLabel{{Name: InstallOrderLabelName, Value: installOrder}}
+	}
+	return res
+}
+
+func TestFindHelmChartsInInstallOrder(t *testing.T) {
+	testCases := []struct {
+		Description   string
+		Resources     OCMResourceInfoSet
+		ExpectedNames string
+		ExpectedError string
+	}{
+		{
+			Description: "charts are sorted by their install order, regardless of how the number was deserialized",
+			Resources: OCMResourceInfoSet{
+				makeTestChartResource("third", 2),
+				{Name: "image", Version: "1.0.0", Type: "ociImage"},
+				makeTestChartResource("first", float64(0)),
+				makeTestChartResource("second", 1),
+			},
+			ExpectedNames: "first, second, third",
+		},
+		{
+			Description:   "a single chart does not need the label",
+			Resources:     OCMResourceInfoSet{makeTestChartResource("only", nil)},
+			ExpectedNames: "only",
+		},
+		{
+			Description:   "without charts, there is nothing to unbundle",
+			Resources:     OCMResourceInfoSet{{Name: "image", Version: "1.0.0", Type: "ociImage"}},
+			ExpectedError: `did not find any resource with type: "helmChart"`,
+		},
+		{
+			Description:   "multiple charts need the label",
+			Resources:     OCMResourceInfoSet{makeTestChartResource("first", 0), makeTestChartResource("unknown", nil)},
+			ExpectedError: `found 2 resources with type: "helmChart", but not all of them have the "cloud.sap/install-order" label`,
+		},
+		{
+			Description:   "the label must be a number",
+			Resources:     OCMResourceInfoSet{makeTestChartResource("first", 0), makeTestChartResource("second", "1")},
+			ExpectedError: `could not read label "cloud.sap/install-order" on resource "second": expected integer value, but got "1"`,
+		},
+		{
+			Description:   "ties are rejected since the install order would be ambiguous",
+			Resources:     OCMResourceInfoSet{makeTestChartResource("first", 0), makeTestChartResource("second", 1), makeTestChartResource("also-second", 1)},
+			ExpectedError: `resources "second" and "also-second" have the same value for the "cloud.sap/install-order" label, so their install order is ambiguous`,
+		},
+	}
+
+	for _, tc := range testCases {
+		result, err := tc.Resources.FindHelmChartsInInstallOrder()
+		if tc.ExpectedError != "" {
+			if err == nil || err.Error() != tc.ExpectedError {
+				t.Errorf("%s: expected error %q, but got %v", tc.Description, tc.ExpectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.Description, err.Error())
+			continue
+		}
+		var names []string
+		for _, res := range result {
+			names = append(names, res.Name)
+		}
+		if strings.Join(names, ", ") != tc.ExpectedNames {
+			t.Errorf("%s: expected %s, but got %s", tc.Description, tc.ExpectedNames, strings.Join(names, ", "))
+		}
+	}
+}
+
+// An OCMClient that serves the same payload for every resource.
+type staticPayloadClient struct {
+	OCMClient // not implemented
+	Payload   []byte
+}
+
+func (c staticPayloadClient) DownloadResource(_ context.Context, _ string, _ OCMResourceInfo) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(c.Payload)), nil
+}
+
+// Builds the files of an OCI image layout containing a single image with the given layer.
+// Returns the files, the path of the layer blob, and the digest of the image manifest.
+func buildTestImageLayout(t *testing.T, layer []byte) (files map[string][]byte, layerPath, manifestDigest string) {
+	t.Helper()
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layerDesc := oci.Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: oci.DigestOf(layer), Size: int64(len(layer))}
+	configDesc := oci.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: oci.DigestOf(config), Size: int64(len(config))}
+	manifestBuf, err := json.Marshal(oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ImageManifestMediaType,
+		Config:        &configDesc,
+		Layers:        []oci.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDesc := oci.Descriptor{MediaType: oci.ImageManifestMediaType, Digest: oci.DigestOf(manifestBuf), Size: int64(len(manifestBuf))}
+	indexBuf, err := json.Marshal(oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ImageIndexMediaType,
+		Manifests:     []oci.Descriptor{manifestDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobPath := func(digest string) string { return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:") }
+	files = map[string][]byte{
+		"oci-layout":                  []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json":                  indexBuf,
+		blobPath(manifestDesc.Digest): manifestBuf,
+		blobPath(configDesc.Digest):   config,
+		blobPath(layerDesc.Digest):    layer,
+	}
+	return files, blobPath(layerDesc.Digest), manifestDesc.Digest
+}
+
+// Packs the given files into a gzipped tarball.
+func buildTestTarball(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	err := oci.WriteTarball(gz, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Returns a copy of the given buffer with one byte changed.
+func flipByte(buf []byte, idx int) []byte {
+	result := bytes.Clone(buf)
+	result[idx] ^= 0x01
+	return result
+}
+
+func TestGetPayloadFromWithGenericBlobDigest(t *testing.T) {
+	payload := []byte("Hello World!\n")
+	res := OCMResourceInfo{
+		Name:   "notes",
+		Digest: &OCMDigest{HashAlgorithm: "SHA-256", NormalisationAlgorithm: "genericBlobDigest/v1", Value: strings.TrimPrefix(oci.DigestOf(payload), "sha256:")},
+	}
+
+	// the intact payload is returned as-is
+	buf, err := readTestPayloadFrom(t, res, payload)
+	if err != nil {
+		t.Errorf("unexpected error for intact payload: %s", err.Error())
+	}
+	if !bytes.Equal(buf, payload) {
+		t.Errorf("expected payload %q, but got %q", payload, buf)
+	}
+
+	// since the payload is streamed, a mismatch is reported when the reader reaches EOF
+	for _, idx := range []int{0, len(payload) / 2, len(payload) - 1} {
+		_, err := readTestPayloadFrom(t, res, flipByte(payload, idx))
+		if err == nil || !strings.Contains(err.Error(), "payload does not match the digest in the component descriptor") {
+			t.Errorf("expected digest mismatch after flipping byte %d, but got %v", idx, err)
+		}
+	}
+}
+
+func TestGetPayloadFromWithOCIArtifactDigest(t *testing.T) {
+	layer := []byte("this is not actually a tar file, but that does not matter for verification")
+	files, layerPath, manifestDigest := buildTestImageLayout(t, layer)
+	payload := buildTestTarball(t, files)
+	res := OCMResourceInfo{
+		Name:   "image-foo",
+		Digest: &OCMDigest{HashAlgorithm: "SHA-256", NormalisationAlgorithm: "ociArtifactDigest/v1", Value: strings.TrimPrefix(manifestDigest, "sha256:")},
+	}
+
+	// the intact payload is returned as-is
+	buf, err := readTestPayloadFrom(t, res, payload)
+	if err != nil {
+		t.Errorf("unexpected error for intact payload: %s", err.Error())
+	}
+	if !bytes.Equal(buf, payload) {
+		t.Error("payload was modified during verification")
+	}
+
+	// the image layout is fully verified before the payload is returned, so a mismatch is reported by GetPayloadFrom() itself
+	client := staticPayloadClient{}
+	manifestPath := "blobs/sha256/" + strings.TrimPrefix(manifestDigest, "sha256:")
+	for _, path := range []string{layerPath, manifestPath} {
+		tamperedFiles := maps.Clone(files)
+		tamperedFiles[path] = flipByte(files[path], len(files[path])/2)
+		client.Payload = buildTestTarball(t, tamperedFiles)
+		_, err := res.GetPayloadFrom(t.Context(), client, "ctf")
+		var dme *oci.DigestMismatchError
+		if !errors.As(err, &dme) || dme.Expected != "sha256:"+strings.TrimPrefix(path, "blobs/sha256/") {
+			t.Errorf("expected digest mismatch after flipping a byte in %s, but got %v", path, err)
+		}
+	}
+
+	// an intact image layout of a different image is also rejected
+	otherFiles, _, otherDigest := buildTestImageLayout(t, flipByte(layer, 0))
+	client.Payload = buildTestTarball(t, otherFiles)
+	_, err = res.GetPayloadFrom(t.Context(), client, "ctf")
+	expectedError := "payload does not match the digest in the component descriptor (expected " + manifestDigest + ", but got " + otherDigest + ")"
+	if err == nil || !strings.Contains(err.Error(), expectedError) {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+}
+
+// Runs GetPayloadFrom() on a client serving the given payload, and reads the payload to the end.
+func readTestPayloadFrom(t *testing.T, res OCMResourceInfo, payload []byte) ([]byte, error) {
+	t.Helper()
+	reader, err := res.GetPayloadFrom(t.Context(), staticPayloadClient{Payload: payload}, "ctf")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/util"
+)
+
+// execOCMClient is an OCMClient that delegates to the `ocm` CLI.
+type execOCMClient struct{}
+
+// ListResources implements the OCMClient interface.
+func (execOCMClient) ListResources(ctx context.Context, componentVersionRef string) (OCMResourceInfoSet, error) {
+	buf, err := util.ExecOCM(ctx, "get", "resources", "-o", "json", componentVersionRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Items []struct {
+			Element OCMResourceInfo `json:"element"`
+		} `json:"items"`
+	}
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return nil, fmt.Errorf("could not unpack output from `ocm get resources -o json`: %w", err)
+	}
+
+	result := make([]OCMResourceInfo, len(data.Items))
+	for idx, item := range data.Items {
+		result[idx] = item.Element
+	}
+	return result, nil
+}
+
+// DownloadResource implements the OCMClient interface.
+func (execOCMClient) DownloadResource(ctx context.Context, componentVersionRef string, res OCMResourceInfo) (io.ReadCloser, error) {
+	return util.StreamOCM(ctx,
+		"download", "resource", "-O", "-",
+		componentVersionRef, res.Name,
+	)
+}
+
+// GetComponentDescriptor implements the OCMClient interface.
+func (execOCMClient) GetComponentDescriptor(ctx context.Context, componentVersionRef string) ([]byte, error) {
+	buf, err := util.ExecOCM(ctx, "get", "componentversions", "-o", "yaml", componentVersionRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// when listing multiple component versions, the output is wrapped in a list
+	var data struct {
+		Items []any `yaml:"items"`
+	}
+	err = yaml.Unmarshal(buf, &data)
+	if err != nil {
+		return nil, fmt.Errorf("could not unpack output from `ocm get componentversions -o yaml`: %w", err)
+	}
+	switch len(data.Items) {
+	case 0:
+		return buf, nil
+	case 1:
+		return yaml.Marshal(data.Items[0])
+	default:
+		return nil, fmt.Errorf("expected `ocm get componentversions -o yaml` to report 1 component version, but got %d", len(data.Items))
+	}
+}
@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sapcc/go-bits/logg"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// Media types used by OCM when storing component versions in OCI repositories.
+// Ref: <https://github.com/open-component-model/ocm-spec/blob/main/doc/04-extensions/03-storage-backends/oci.md>
+const (
+	ocmComponentConfigMediaType          = "application/vnd.ocm.software.component.config.v1+json"
+	ocmComponentDescriptorTarMediaType   = "application/vnd.ocm.software.component-descriptor.v2+yaml+tar"
+	ocmComponentDescriptorFileNameInTar  = "component-descriptor.yaml"
+	ocmComponentDescriptorRepoNamePrefix = "component-descriptors/"
+)
+
+// OCMComponentDescriptor is the contents of a component-descriptor.yaml file (schema version v2).
+//
+// This is a heavily abridged type declaration that only contains the fields we need.
+type OCMComponentDescriptor struct {
+	Meta struct {
+		SchemaVersion string `yaml:"schemaVersion"`
+	} `yaml:"meta"`
+	Component struct {
+		Name      string             `yaml:"name"`
+		Version   string             `yaml:"version"`
+		Resources OCMResourceInfoSet `yaml:"resources"`
+	} `yaml:"component"`
+}
+
+// ocmComponentConfig is the contents of the config blob of an OCI manifest containing an OCM component version.
+type ocmComponentConfig struct {
+	ComponentDescriptorLayer *oci.Descriptor `json:"componentDescriptorLayer"`
+}
+
+// Returns the name of the OCI repository that holds the given component.
+func ocmRepositoryNameForComponent(componentName string) string {
+	return ocmComponentDescriptorRepoNamePrefix + componentName
+}
+
+// Returns the OCI tag that holds the given component version.
+// OCI tags may not contain "+", so OCM replaces the build-metadata separator with something else.
+func ocmTagForComponentVersion(version string) string {
+	return strings.ReplaceAll(version, "+", ".build-")
+}
+
+// ocmComponentVersionRef is a parsed reference to a component version, as understood by the `ocm` CLI.
+type ocmComponentVersionRef struct {
+	RepositorySpec string // either the path to a CTF archive, or an OCI registry URL (optionally with a path prefix)
+	ComponentName  string // may be empty when referring to a CTF archive containing only one component version
+	Version        string // may be empty when referring to a CTF archive containing only one component version
+}
+
+func parseOCMComponentVersionRef(input string) (ocmComponentVersionRef, error) {
+	spec := input
+	for _, scheme := range []string{"https://", "http://", "oci://"} {
+		spec = strings.TrimPrefix(spec, scheme)
+	}
+	repoSpec, componentSpec, found := strings.Cut(spec, "//")
+	if !found {
+		return ocmComponentVersionRef{RepositorySpec: input}, nil
+	}
+
+	name, version, found := strings.Cut(componentSpec, ":")
+	if !found || name == "" || version == "" {
+		return ocmComponentVersionRef{}, fmt.Errorf(`malformed component version reference %q (expected "$REPOSITORY//$COMPONENT_NAME:$COMPONENT_VERSION")`, input)
+	}
+	if repoSpec == "" {
+		return ocmComponentVersionRef{}, fmt.Errorf("malformed component version reference %q (repository is missing)", input)
+	}
+	return ocmComponentVersionRef{
+		RepositorySpec: repoSpec,
+		ComponentName:  name,
+		Version:        version,
+	}, nil
+}
+
+// nativeOCMClient is an OCMClient that accesses CTF archives and OCI registries directly.
+type nativeOCMClient struct {
+	// cache for component versions that have already been loaded (key = componentVersionRef)
+	cache      map[string]nativeComponentVersion
+	cacheMutex sync.Mutex
+}
+
+type nativeComponentVersion struct {
+	Repository    oci.Repository // holds both the component descriptor and all local blobs
+	Descriptor    OCMComponentDescriptor
+	DescriptorBuf []byte // the component descriptor in its original serialization
+}
+
+func newNativeOCMClient() *nativeOCMClient {
+	return &nativeOCMClient{cache: make(map[string]nativeComponentVersion)}
+}
+
+// ListResources implements the OCMClient interface.
+func (c *nativeOCMClient) ListResources(ctx context.Context, componentVersionRef string) (OCMResourceInfoSet, error) {
+	cv, err := c.getComponentVersion(ctx, componentVersionRef)
+	if err != nil {
+		return nil, err
+	}
+	return cv.Descriptor.Component.Resources, nil
+}
+
+// DownloadResource implements the OCMClient interface.
+func (c *nativeOCMClient) DownloadResource(ctx context.Context, componentVersionRef string, res OCMResourceInfo) (io.ReadCloser, error) {
+	cv, err := c.getComponentVersion(ctx, componentVersionRef)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Access.IsLocalBlob() {
+		return nil, fmt.Errorf("downloading resources with access type %q is not supported", res.Access.Type)
+	}
+	return cv.Repository.OpenBlob(ctx, res.Access.LocalReference)
+}
+
+// GetComponentDescriptor implements the OCMClient interface.
+func (c *nativeOCMClient) GetComponentDescriptor(ctx context.Context, componentVersionRef string) ([]byte, error) {
+	cv, err := c.getComponentVersion(ctx, componentVersionRef)
+	if err != nil {
+		return nil, err
+	}
+	return cv.DescriptorBuf, nil
+}
+
+func (c *nativeOCMClient) getComponentVersion(ctx context.Context, componentVersionRef string) (nativeComponentVersion, error) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	if cv, ok := c.cache[componentVersionRef]; ok {
+		return cv, nil
+	}
+
+	ref, err := parseOCMComponentVersionRef(componentVersionRef)
+	if err != nil {
+		return nativeComponentVersion{}, err
+	}
+	repo, tag, err := openOCMRepository(&ref)
+	if err != nil {
+		return nativeComponentVersion{}, err
+	}
+	desc, descBuf, err := readOCMComponentDescriptor(ctx, repo, tag)
+	if err != nil {
+		return nativeComponentVersion{}, fmt.Errorf("while reading component descriptor for %s:%s: %w", ref.ComponentName, tag, err)
+	}
+	if ref.Version == "" {
+		ref.Version = desc.Component.Version
+	}
+	if desc.Component.Name != ref.ComponentName || desc.Component.Version != ref.Version {
+		return nativeComponentVersion{}, fmt.Errorf("expected component descriptor for %s:%s, but found %s:%s",
+			ref.ComponentName, ref.Version, desc.Component.Name, desc.Component.Version)
+	}
+
+	cv := nativeComponentVersion{Repository: repo, Descriptor: desc, DescriptorBuf: descBuf}
+	c.cache[componentVersionRef] = cv
+	return cv, nil
+}
+
+// Opens the OCI repository that holds the referenced component, and returns the tag of the referenced component version.
+// If the reference points to a CTF archive without naming a component version, the component name is filled in.
+// In this case, the version will only be known after reading the component descriptor.
+func openOCMRepository(ref *ocmComponentVersionRef) (repo oci.Repository, tag string, err error) {
+	_, err = os.Stat(ref.RepositorySpec)
+	switch {
+	case err == nil:
+		ctf, err := oci.OpenCTF(ref.RepositorySpec)
+		if err != nil {
+			return nil, "", err
+		}
+		tag = ocmTagForComponentVersion(ref.Version)
+		if ref.ComponentName == "" {
+			tag, err = ref.fillFromCTF(ctf)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		logg.Debug("reading component version %s:%s from CTF archive %s", ref.ComponentName, tag, ctf.Path)
+		return ctf.Repository(ocmRepositoryNameForComponent(ref.ComponentName)), tag, nil
+
+	case errors.Is(err, os.ErrNotExist):
+		if ref.ComponentName == "" {
+			return nil, "", fmt.Errorf(`%s does not exist (expected either the path to a CTF archive, or "$OCI_REGISTRY//$COMPONENT_NAME:$COMPONENT_VERSION")`, ref.RepositorySpec)
+		}
+		host, pathPrefix, _ := strings.Cut(ref.RepositorySpec, "/")
+		registry, err := oci.NewRegistry(host)
+		if err != nil {
+			return nil, "", err
+		}
+		repoName := ocmRepositoryNameForComponent(ref.ComponentName)
+		if pathPrefix != "" {
+			repoName = strings.TrimSuffix(pathPrefix, "/") + "/" + repoName
+		}
+		tag = ocmTagForComponentVersion(ref.Version)
+		logg.Debug("reading component version %s:%s from %s/%s", ref.ComponentName, tag, host, repoName)
+		return registry.Repository(repoName), tag, nil
+
+	default:
+		return nil, "", err
+	}
+}
+
+// Fills ComponentName from the only component version contained in the given CTF archive, and returns its tag.
+func (ref *ocmComponentVersionRef) fillFromCTF(ctf *oci.CTF) (tag string, err error) {
+	var candidates []oci.ArtifactIndexEntry
+	for _, entry := range ctf.Index.Artifacts {
+		if strings.HasPrefix(entry.Repository, ocmComponentDescriptorRepoNamePrefix) && entry.Tag != "" {
+			candidates = append(candidates, entry)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("CTF archive %s does not contain any component versions", ctf.Path)
+	case 1:
+		// NOTE: The tag is not a reliable source for the version because of the "+" replacement in ocmTagForComponentVersion(),
+		// so ref.Version is left empty to be filled from the component descriptor.
+		ref.ComponentName = strings.TrimPrefix(candidates[0].Repository, ocmComponentDescriptorRepoNamePrefix)
+		return candidates[0].Tag, nil
+	default:
+		return "", fmt.Errorf(`CTF archive %s contains %d component versions, so a specific one must be selected with "%s//$COMPONENT_NAME:$COMPONENT_VERSION"`,
+			ctf.Path, len(candidates), ctf.Path)
+	}
+}
+
+// Reads the component descriptor from the OCI manifest with the given tag.
+// Returns both the parsed descriptor and its original serialization.
+func readOCMComponentDescriptor(ctx context.Context, repo oci.Repository, tag string) (OCMComponentDescriptor, []byte, error) {
+	_, buf, err := repo.GetManifest(ctx, tag)
+	if err != nil {
+		return OCMComponentDescriptor{}, nil, err
+	}
+	var manifest oci.Manifest
+	err = json.Unmarshal(buf, &manifest)
+	if err != nil {
+		return OCMComponentDescriptor{}, nil, fmt.Errorf("could not parse manifest: %w", err)
+	}
+	if manifest.Config == nil || manifest.Config.MediaType != ocmComponentConfigMediaType {
+		return OCMComponentDescriptor{}, nil, fmt.Errorf("manifest does not have a config blob of type %s", ocmComponentConfigMediaType)
+	}
+
+	buf, err = oci.ReadBlob(ctx, repo, manifest.Config.Digest)
+	if err != nil {
+		return OCMComponentDescriptor{}, nil, err
+	}
+	var config ocmComponentConfig
+	err = json.Unmarshal(buf, &config)
+	if err != nil {
+		return OCMComponentDescriptor{}, nil, fmt.Errorf("could not parse config blob: %w", err)
+	}
+	if config.ComponentDescriptorLayer == nil {
+		return OCMComponentDescriptor{}, nil, errors.New("config blob does not refer to a component descriptor")
+	}
+
+	buf, err = oci.ReadBlob(ctx, repo, config.ComponentDescriptorLayer.Digest)
+	if err != nil {
+		return OCMComponentDescriptor{}, nil, err
+	}
+	if strings.HasSuffix(config.ComponentDescriptorLayer.MediaType, "+tar") {
+		buf, err = extractFileFromTarball(buf, ocmComponentDescriptorFileNameInTar)
+		if err != nil {
+			return OCMComponentDescriptor{}, nil, err
+		}
+	}
+
+	// NOTE: JSON is a subset of YAML, so this also works for component descriptors in JSON format.
+	var desc OCMComponentDescriptor
+	err = yaml.Unmarshal(buf, &desc)
+	if err != nil {
+		return OCMComponentDescriptor{}, nil, fmt.Errorf("could not parse component descriptor: %w", err)
+	}
+	if desc.Meta.SchemaVersion != "v2" {
+		return OCMComponentDescriptor{}, nil, fmt.Errorf("component descriptor has unsupported schema version %q (only v2 is supported)", desc.Meta.SchemaVersion)
+	}
+	return desc, buf, nil
+}
+
+func extractFileFromTarball(buf []byte, fileName string) ([]byte, error) {
+	tr := tar.NewReader(bytes.NewReader(buf))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("tarball does not contain %s", fileName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(hdr.Name, "./") == fileName {
+			return io.ReadAll(tr)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Algorithm names used in OCM signatures.
+// Ref: <https://github.com/open-component-model/ocm-spec/blob/main/doc/02-processing/02-signing.md>
+const (
+	ocmNormalisationAlgorithm = "jsonNormalisation/v2"
+	ocmSignatureAlgorithmRSA  = "RSASSA-PKCS1-V1_5"
+	ocmSignatureMediaTypeRSA  = "application/vnd.ocm.signature.rsa"
+)
+
+// OCM does not define a signature algorithm for Ed25519 keys, so these names are our own.
+// Signatures made with Ed25519 keys can be checked by `unbundle --verify-key`, but not by OCM's own tooling.
+const (
+	ed25519SignatureAlgorithm = "Ed25519"
+	ed25519SignatureMediaType = "application/vnd.ocm-helm-toolbox.signature.ed25519"
+)
+
+// OCMSignature is the `signatures[]` section of a component descriptor.
+type OCMSignature struct {
+	Name      string              `json:"name" yaml:"name"`
+	Digest    OCMDigest           `json:"digest" yaml:"digest"`
+	Signature OCMSignatureContent `json:"signature" yaml:"signature"`
+}
+
+// OCMSignatureContent is the `signatures[].signature` section of a component descriptor.
+type OCMSignatureContent struct {
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	MediaType string `json:"mediaType" yaml:"mediaType"`
+	Value     string `json:"value" yaml:"value"`
+}
+
+// OCMSigner holds the private key for signing component versions, as well as the name under which the signature is recorded.
+type OCMSigner struct {
+	Name string
+	Key  crypto.Signer
+}
+
+// ReadOCMPrivateKey reads a PEM-encoded RSA or Ed25519 private key from the given file.
+// The key may be in PKCS#1 format ("RSA PRIVATE KEY") or PKCS#8 format ("PRIVATE KEY").
+func ReadOCMPrivateKey(filePath string) (crypto.Signer, error) {
+	block, err := readPEMFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("while reading %s: expected a private key, but found a PEM block of type %q", filePath, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", filePath, err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("while reading %s: unsupported key type %T (only RSA and Ed25519 keys are supported)", filePath, key)
+	}
+}
+
+// ReadOCMPublicKey reads a PEM-encoded RSA or Ed25519 public key from the given file.
+// The key may be in PKIX format ("PUBLIC KEY") or PKCS#1 format ("RSA PUBLIC KEY").
+func ReadOCMPublicKey(filePath string) (crypto.PublicKey, error) {
+	block, err := readPEMFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("while reading %s: expected a public key, but found a PEM block of type %q", filePath, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", filePath, err)
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return key, nil
+	case ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("while reading %s: unsupported key type %T (only RSA and Ed25519 keys are supported)", filePath, key)
+	}
+}
+
+func readPEMFile(filePath string) (*pem.Block, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("while reading %s: no PEM block found", filePath)
+	}
+	return block, nil
+}
+
+// Sign computes the digest of the given component descriptor and signs it.
+func (s OCMSigner) Sign(desc any) (OCMSignature, error) {
+	digest, err := computeOCMDescriptorDigest(desc)
+	if err != nil {
+		return OCMSignature{}, err
+	}
+	result := OCMSignature{
+		Name: s.Name,
+		Digest: OCMDigest{
+			HashAlgorithm:          "SHA-256",
+			NormalisationAlgorithm: ocmNormalisationAlgorithm,
+			Value:                  hex.EncodeToString(digest),
+		},
+	}
+
+	var signature []byte
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = s.Key.Sign(rand.Reader, digest, crypto.SHA256)
+		result.Signature.Algorithm = ocmSignatureAlgorithmRSA
+		result.Signature.MediaType = ocmSignatureMediaTypeRSA
+	case ed25519.PrivateKey:
+		// NOTE: Ed25519 signs messages instead of digests, so the digest is signed as the message.
+		signature, err = s.Key.Sign(rand.Reader, digest, crypto.Hash(0))
+		result.Signature.Algorithm = ed25519SignatureAlgorithm
+		result.Signature.MediaType = ed25519SignatureMediaType
+	default:
+		return OCMSignature{}, fmt.Errorf("unsupported key type %T (only RSA and Ed25519 keys are supported)", s.Key)
+	}
+	if err != nil {
+		return OCMSignature{}, fmt.Errorf("while signing component descriptor: %w", err)
+	}
+	result.Signature.Value = hex.EncodeToString(signature)
+	return result, nil
+}
+
+// VerifyOCMComponentDescriptor checks that the given component descriptor (in YAML or JSON format)
+// carries at least one signature that was made with the given public key over the current contents of the descriptor.
+//
+// Since the digests of all resources are part of the signed contents, resource payloads are covered by the signature
+// as long as they are checked against their digests (which GetPayloadFrom() does).
+func VerifyOCMComponentDescriptor(buf []byte, publicKey crypto.PublicKey) error {
+	var desc map[string]any
+	err := yaml.Unmarshal(buf, &desc)
+	if err != nil {
+		return fmt.Errorf("could not parse component descriptor: %w", err)
+	}
+	var signatures struct {
+		Signatures []OCMSignature `yaml:"signatures"`
+	}
+	err = yaml.Unmarshal(buf, &signatures)
+	if err != nil {
+		return fmt.Errorf("could not parse signatures in component descriptor: %w", err)
+	}
+	if len(signatures.Signatures) == 0 {
+		return errors.New("component version is not signed")
+	}
+
+	digest, err := computeOCMDescriptorDigest(desc)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, sig := range signatures.Signatures {
+		err := sig.verify(digest, publicKey)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("signature %q %s", sig.Name, err.Error()))
+	}
+	return fmt.Errorf("component version does not have a valid signature for the given key: %s", strings.Join(errs, "; "))
+}
+
+func (s OCMSignature) verify(digest []byte, publicKey crypto.PublicKey) error {
+	if s.Digest.HashAlgorithm != "SHA-256" || s.Digest.NormalisationAlgorithm != ocmNormalisationAlgorithm {
+		return fmt.Errorf("uses an unsupported digest algorithm (expected %s with %s, but got %s with %s)",
+			"SHA-256", ocmNormalisationAlgorithm, s.Digest.HashAlgorithm, s.Digest.NormalisationAlgorithm)
+	}
+	if s.Digest.Value != hex.EncodeToString(digest) {
+		return fmt.Errorf("does not match the contents of the component descriptor (signed digest is %s, but actual digest is %s)",
+			s.Digest.Value, hex.EncodeToString(digest))
+	}
+	signature, err := hex.DecodeString(s.Signature.Value)
+	if err != nil {
+		return fmt.Errorf("has a malformed value: %w", err)
+	}
+
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if s.Signature.Algorithm != ocmSignatureAlgorithmRSA {
+			return fmt.Errorf("uses algorithm %q, but an RSA key was given", s.Signature.Algorithm)
+		}
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest, signature)
+	case ed25519.PublicKey:
+		if s.Signature.Algorithm != ed25519SignatureAlgorithm {
+			return fmt.Errorf("uses algorithm %q, but an Ed25519 key was given", s.Signature.Algorithm)
+		}
+		if !ed25519.Verify(publicKey, digest, signature) {
+			err = errors.New("ed25519: verification error")
+		}
+	default:
+		return fmt.Errorf("cannot be checked with key type %T", publicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("is not valid for the given key: %w", err)
+	}
+	return nil
+}
+
+// Computes the SHA-256 digest of the normalized form of a component descriptor.
+// The input can be anything that marshals into the YAML form of the component descriptor.
+//
+// Normalization is modeled on OCM's "jsonNormalisation/v2", but is not verified to produce identical results:
+// After removing all fields that are not covered by the signature, the descriptor is rendered
+// as JSON with sorted keys and without insignificant whitespace.
+// Not covered by the signature are
+// the signatures themselves, the repository contexts, the access specifications of resources and sources,
+// resources and sources with an access of type "none", and all labels that are not marked with `signing: true`.
+func computeOCMDescriptorDigest(desc any) ([]byte, error) {
+	// convert into a generic structure
+	buf, err := yaml.Marshal(desc)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]any
+	err = yaml.Unmarshal(buf, &generic)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(generic, "signatures")
+	delete(generic, "nestedDigests")
+	if component, ok := generic["component"].(map[string]any); ok {
+		delete(component, "repositoryContexts")
+		excludeUnsignedLabels(component)
+		if provider, ok := component["provider"].(map[string]any); ok {
+			excludeUnsignedLabels(provider)
+		}
+		for _, key := range []string{"resources", "sources"} {
+			artifacts, _ := component[key].([]any)
+			var kept []any
+			for _, artifact := range artifacts {
+				artifact, ok := artifact.(map[string]any)
+				if !ok {
+					continue
+				}
+				if access, ok := artifact["access"].(map[string]any); ok && (access["type"] == "none" || access["type"] == "None") {
+					continue
+				}
+				delete(artifact, "access")
+				delete(artifact, "srcRefs")
+				excludeUnsignedLabels(artifact)
+				kept = append(kept, artifact)
+			}
+			if kept != nil {
+				component[key] = kept
+			}
+		}
+		references, _ := component["componentReferences"].([]any)
+		for _, reference := range references {
+			if reference, ok := reference.(map[string]any); ok {
+				excludeUnsignedLabels(reference)
+			}
+		}
+	}
+
+	normalized, err := marshalCanonicalJSON(removeNullValues(generic))
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(normalized)
+	return digest[:], nil
+}
+
+// Removes all labels from the given object that are not marked with `signing: true`.
+func excludeUnsignedLabels(obj map[string]any) {
+	labels, _ := obj["labels"].([]any)
+	labels = slices.DeleteFunc(slices.Clone(labels), func(label any) bool {
+		labelMap, ok := label.(map[string]any)
+		return !ok || labelMap["signing"] != true
+	})
+	if len(labels) == 0 {
+		delete(obj, "labels")
+	} else {
+		obj["labels"] = labels
+	}
+}
+
+// Removes all map entries with null values, so that an absent field and a null field are normalized identically.
+func removeNullValues(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(value))
+		for key, elem := range value {
+			if elem != nil {
+				result[key] = removeNullValues(elem)
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, len(value))
+		for idx, elem := range value {
+			result[idx] = removeNullValues(elem)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// Renders JSON with sorted keys, without insignificant whitespace, and without escaping HTML characters.
+func marshalCanonicalJSON(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(normalizeJSONValue(value))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
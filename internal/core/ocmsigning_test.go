@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testComponentDescriptor = `meta:
+  schemaVersion: v2
+component:
+  name: example.org/keystone
+  version: 1.2.3
+  provider: example.org
+  repositoryContexts:
+    - type: OCIRegistry
+      baseUrl: registry.example.org
+  sources: []
+  componentReferences: []
+  resources:
+    - name: keystone
+      version: 1.2.3
+      type: helmChart
+      relation: local
+      labels:
+        - name: example.org/signed-label
+          value: foo
+          signing: true
+        - name: example.org/unsigned-label
+          value: bar
+      access:
+        type: localBlob
+        localReference: sha256:5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f
+        mediaType: application/vnd.cncf.helm.chart.content.v1.tar+gzip
+      digest:
+        hashAlgorithm: SHA-256
+        normalisationAlgorithm: genericBlobDigest/v1
+        value: 5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f
+`
+
+// Writes the given key into a PEM file, and returns its path.
+func writeTestPEMFile(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "key.pem")
+	err := os.WriteFile(filePath, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+// Signs testComponentDescriptor with the given key, and returns the signed descriptor.
+func signTestComponentDescriptor(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	var desc map[string]any
+	err := yaml.Unmarshal([]byte(testComponentDescriptor), &desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := OCMSigner{Name: "test", Key: key}.Sign(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc["signatures"] = []OCMSignature{signature}
+	buf, err := yaml.Marshal(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestOCMSigningRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519PKCS8, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPKIX, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519PKIX, err := x509.MarshalPKIXPublicKey(ed25519Key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Description       string
+		PrivateKeyPath    string
+		PublicKeyPath     string
+		ExpectedAlgorithm string
+	}{
+		{
+			Description:       "RSA in PKCS#1 format",
+			PrivateKeyPath:    writeTestPEMFile(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			PublicKeyPath:     writeTestPEMFile(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+			ExpectedAlgorithm: "algorithm: RSASSA-PKCS1-V1_5",
+		},
+		{
+			Description:       "RSA in PKCS#8/PKIX format",
+			PrivateKeyPath:    writeTestPEMFile(t, "PRIVATE KEY", rsaPKCS8),
+			PublicKeyPath:     writeTestPEMFile(t, "PUBLIC KEY", rsaPKIX),
+			ExpectedAlgorithm: "algorithm: RSASSA-PKCS1-V1_5",
+		},
+		{
+			Description:       "Ed25519",
+			PrivateKeyPath:    writeTestPEMFile(t, "PRIVATE KEY", ed25519PKCS8),
+			PublicKeyPath:     writeTestPEMFile(t, "PUBLIC KEY", ed25519PKIX),
+			ExpectedAlgorithm: "algorithm: Ed25519",
+		},
+	}
+
+	for _, tc := range testCases {
+		privateKey, err := ReadOCMPrivateKey(tc.PrivateKeyPath)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.Description, err.Error())
+		}
+		publicKey, err := ReadOCMPublicKey(tc.PublicKeyPath)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.Description, err.Error())
+		}
+		signed := signTestComponentDescriptor(t, privateKey)
+		if !strings.Contains(signed, tc.ExpectedAlgorithm) {
+			t.Errorf("%s: expected %q in signed descriptor:\n%s", tc.Description, tc.ExpectedAlgorithm, signed)
+		}
+
+		// fields that are not covered by the signature may change
+		for _, modified := range []string{
+			signed,
+			strings.Replace(signed, "value: bar", "value: changed", 1),
+			strings.Replace(signed, "baseUrl: registry.example.org", "baseUrl: mirror.example.org", 1),
+			strings.Replace(signed, "localReference: sha256:5e6a", "localReference: sha256:0000", 1),
+		} {
+			err := VerifyOCMComponentDescriptor([]byte(modified), publicKey)
+			if err != nil {
+				t.Errorf("%s: unexpected verification error: %s", tc.Description, err.Error())
+			}
+		}
+
+		// fields that are covered by the signature may not
+		for _, modified := range []string{
+			strings.Replace(signed, "value: foo", "value: changed", 1),
+			strings.Replace(signed, "signing: true", "signing: false", 1),
+			strings.Replace(signed, "value: 5e6a5e5b2a3d", "value: 000000000000", 1),
+			strings.Replace(signed, "version: 1.2.3", "version: 1.2.4", 1),
+		} {
+			if modified == signed {
+				t.Fatalf("%s: test case did not modify the descriptor", tc.Description)
+			}
+			err := VerifyOCMComponentDescriptor([]byte(modified), publicKey)
+			if err == nil || !strings.Contains(err.Error(), `signature "test" does not match the contents of the component descriptor`) {
+				t.Errorf("%s: expected digest mismatch, but got %v", tc.Description, err)
+			}
+		}
+	}
+
+	// a signature is only accepted for the key that made it
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyOCMComponentDescriptor([]byte(signTestComponentDescriptor(t, rsaKey)), otherKey.Public())
+	if err == nil || !strings.Contains(err.Error(), `signature "test" is not valid for the given key: crypto/rsa: verification error`) {
+		t.Errorf("expected verification error for other key, but got %v", err)
+	}
+	err = VerifyOCMComponentDescriptor([]byte(signTestComponentDescriptor(t, rsaKey)), ed25519Key.Public())
+	if err == nil || !strings.Contains(err.Error(), `signature "test" uses algorithm "RSASSA-PKCS1-V1_5", but an Ed25519 key was given`) {
+		t.Errorf("expected algorithm mismatch, but got %v", err)
+	}
+
+	// tampering with the signature value itself is detected
+	signed := signTestComponentDescriptor(t, ed25519Key)
+	var desc struct {
+		Signatures []OCMSignature `yaml:"signatures"`
+	}
+	err = yaml.Unmarshal([]byte(signed), &desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := desc.Signatures[0].Signature.Value
+	tampered := strings.Replace(signed, value, strings.Repeat("0", len(value)), 1)
+	err = VerifyOCMComponentDescriptor([]byte(tampered), ed25519Key.Public())
+	if err == nil || !strings.Contains(err.Error(), `signature "test" is not valid for the given key: ed25519: verification error`) {
+		t.Errorf("expected verification error for tampered signature, but got %v", err)
+	}
+
+	// unsigned descriptors are rejected
+	err = VerifyOCMComponentDescriptor([]byte(testComponentDescriptor), ed25519Key.Public())
+	if err == nil || err.Error() != "component version is not signed" {
+		t.Errorf("expected error for unsigned descriptor, but got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/docker/reference"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sapcc/ocm-helm-toolbox/internal/oci"
+)
+
+// OCMDigest is the `component.resources[].digest` section of a component descriptor.
+type OCMDigest struct {
+	HashAlgorithm          string `json:"hashAlgorithm" yaml:"hashAlgorithm"`
+	NormalisationAlgorithm string `json:"normalisationAlgorithm" yaml:"normalisationAlgorithm"`
+	Value                  string `json:"value" yaml:"value"`
+}
+
+// Returns the OCMDigest that OCM computes for a resource stored as a local blob.
+func ocmDigestForBlob(desc oci.Descriptor) *OCMDigest {
+	return &OCMDigest{
+		HashAlgorithm:          "SHA-256",
+		NormalisationAlgorithm: "genericBlobDigest/v1",
+		Value:                  strings.TrimPrefix(desc.Digest, "sha256:"),
+	}
+}
+
+// Returns the OCMDigest that OCM computes for a resource that is only referenced, or nil if it cannot be known without downloading.
+// For images that are pinned to a digest, this is the digest of the image manifest.
+func ocmDigestForAccess(access map[string]any) *OCMDigest {
+	accessType, _ := access["type"].(string)
+	imageRefStr, _ := access["imageReference"].(string)
+	if accessType != "ociArtifact" || imageRefStr == "" {
+		return nil
+	}
+	imageRef, err := reference.ParseNormalizedNamed(imageRefStr)
+	if err != nil {
+		return nil
+	}
+	digested, ok := imageRef.(reference.Digested)
+	if !ok || digested.Digest().Algorithm() != digest.SHA256 {
+		return nil
+	}
+	return &OCMDigest{
+		HashAlgorithm:          "SHA-256",
+		NormalisationAlgorithm: "ociArtifactDigest/v1",
+		Value:                  digested.Digest().Encoded(),
+	}
+}
+
+// The component descriptor, as rendered by WriteCTF().
+// This is separate from type OCMComponentDescriptor because we need to retain more fields here.
+type ocmComponentDescriptorForWrite struct {
+	Meta struct {
+		SchemaVersion string `yaml:"schemaVersion"`
+	} `yaml:"meta"`
+	Component struct {
+		Name                string                `yaml:"name"`
+		Version             string                `yaml:"version"`
+		Provider            string                `yaml:"provider"`
+		RepositoryContexts  []any                 `yaml:"repositoryContexts"`
+		Sources             []any                 `yaml:"sources"`
+		ComponentReferences []any                 `yaml:"componentReferences"`
+		Resources           []ocmResourceForWrite `yaml:"resources"`
+	} `yaml:"component"`
+	Signatures []OCMSignature `yaml:"signatures,omitempty"`
+}
+
+type ocmResourceForWrite struct {
+	Name     string         `yaml:"name"`
+	Version  string         `yaml:"version"`
+	Type     string         `yaml:"type"`
+	Relation string         `yaml:"relation"`
+	Labels   []OCMLabel     `yaml:"labels,omitempty"`
+	Access   map[string]any `yaml:"access"`
+	Digest   *OCMDigest     `yaml:"digest,omitempty"`
+}
+
+// WriteCTF renders this component version into a new CTF archive at the given path,
+// equivalent to what `ocm add componentversions --create` does with a component-constructor.yaml file.
+//
+// Resources with an input are stored as local blobs within the archive.
+// Resources with an access are only referenced.
+//
+// If a signer is given, the component descriptor is signed.
+// This requires that the digests of all resources are known, so images that are only referenced must be pinned to a digest.
+func (c OCMComponentDeclaration) WriteCTF(ctx context.Context, archivePath string, signer *OCMSigner) error {
+	builder, err := oci.NewCTFBuilder(archivePath)
+	if err != nil {
+		return err
+	}
+	defer builder.Discard()
+
+	var desc ocmComponentDescriptorForWrite
+	desc.Meta.SchemaVersion = "v2"
+	desc.Component.Name = c.Name
+	desc.Component.Version = c.Version
+	providerName, ok := c.Provider["name"].(string)
+	if !ok {
+		return fmt.Errorf("cannot render component %s:%s: provider name is missing", c.Name, c.Version)
+	}
+	desc.Component.Provider = providerName
+	desc.Component.RepositoryContexts = []any{}
+	desc.Component.Sources = []any{}
+	desc.Component.ComponentReferences = []any{}
+
+	var localBlobs []oci.Descriptor
+	for _, res := range c.Resources {
+		entry := ocmResourceForWrite{
+			Name:    res.Name,
+			Version: res.Version,
+			Type:    res.Type,
+			Labels:  res.Labels,
+		}
+		switch {
+		case res.Input != nil:
+			input, err := res.renderInput(ctx)
+			if err != nil {
+				return fmt.Errorf("cannot render resource %q: %w", res.Name, err)
+			}
+			blob, err := builder.AddBlobFrom(input.MediaType, input.WritePayload)
+			if err != nil {
+				return fmt.Errorf("cannot render resource %q: %w", res.Name, err)
+			}
+			localBlobs = append(localBlobs, blob)
+			entry.Relation = "local"
+			entry.Access = map[string]any{
+				"type":           "localBlob",
+				"localReference": blob.Digest,
+				"mediaType":      blob.MediaType,
+			}
+			if input.ReferenceName != "" {
+				entry.Access["referenceName"] = input.ReferenceName
+			}
+			if input.GlobalAccess != nil {
+				entry.Access["globalAccess"] = input.GlobalAccess
+			}
+			entry.Digest = ocmDigestForBlob(blob)
+		case res.Access != nil:
+			entry.Relation = "external"
+			entry.Access = res.Access
+			entry.Digest = ocmDigestForAccess(res.Access)
+		default:
+			return fmt.Errorf("cannot render resource %q: neither input nor access is declared", res.Name)
+		}
+		desc.Component.Resources = append(desc.Component.Resources, entry)
+	}
+
+	if signer != nil {
+		for _, entry := range desc.Component.Resources {
+			if entry.Digest == nil {
+				return fmt.Errorf("cannot sign component %s:%s: digest of resource %q is not known (images must either be pinned to a digest or copied into the component version)",
+					c.Name, c.Version, entry.Name)
+			}
+		}
+		signature, err := signer.Sign(desc)
+		if err != nil {
+			return fmt.Errorf("cannot sign component %s:%s: %w", c.Name, c.Version, err)
+		}
+		desc.Signatures = []OCMSignature{signature}
+	}
+
+	// the component descriptor goes into a tarball, which is referenced by the config blob
+	descBuf, err := yaml.Marshal(desc)
+	if err != nil {
+		return fmt.Errorf("cannot render component descriptor for %s:%s: %w", c.Name, c.Version, err)
+	}
+	var tarBuf bytes.Buffer
+	err = oci.WriteTarball(&tarBuf, map[string][]byte{ocmComponentDescriptorFileNameInTar: descBuf})
+	if err != nil {
+		return err
+	}
+	descLayer, err := builder.AddBlob(ocmComponentDescriptorTarMediaType, tarBuf.Bytes())
+	if err != nil {
+		return err
+	}
+	configBuf, err := json.Marshal(ocmComponentConfig{ComponentDescriptorLayer: &descLayer})
+	if err != nil {
+		return err
+	}
+	config, err := builder.AddBlob(ocmComponentConfigMediaType, configBuf)
+	if err != nil {
+		return err
+	}
+
+	_, err = builder.AddManifest(ocmRepositoryNameForComponent(c.Name), ocmTagForComponentVersion(c.Version), oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ImageManifestMediaType,
+		Config:        &config,
+		Layers:        append([]oci.Descriptor{descLayer}, localBlobs...),
+	})
+	if err != nil {
+		return err
+	}
+	return builder.Finish()
+}
+
+// renderedInput is the result of OCMResourceDeclaration.renderInput().
+type renderedInput struct {
+	MediaType string
+	// writes the payload into the given writer (this is a callback rather than a []byte, since payloads like images can be huge)
+	WritePayload func(io.Writer) error
+	// optional extra fields for the localBlob access
+	ReferenceName string
+	GlobalAccess  map[string]any
+}
+
+// Renders the input of this resource into a blob, in the same way as `ocm add componentversions` would.
+func (r OCMResourceDeclaration) renderInput(ctx context.Context) (renderedInput, error) {
+	inputType, _ := r.Input["type"].(string)
+	inputPath, _ := r.Input["path"].(string)
+	switch inputType {
+	case "dir":
+		files, err := readDirectoryRecursively(inputPath)
+		if err != nil {
+			return renderedInput{}, err
+		}
+		return renderedInput{
+			MediaType:    "application/x-tar",
+			WritePayload: func(w io.Writer) error { return oci.WriteTarball(w, files) },
+		}, nil
+	case "file":
+		mediaType, _ := r.Input["mediaType"].(string)
+		return renderedInput{
+			MediaType: mediaType,
+			WritePayload: func(w io.Writer) error {
+				file, err := os.Open(inputPath)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				_, err = io.Copy(w, file)
+				return err
+			},
+		}, nil
+	case "binary":
+		data, _ := r.Input["data"].(string)
+		buf, err := base64.StdEncoding.DecodeString(data)
+		mediaType, _ := r.Input["mediaType"].(string)
+		return renderedInput{
+			MediaType: mediaType,
+			WritePayload: func(w io.Writer) error {
+				_, err := w.Write(buf)
+				return err
+			},
+		}, err
+	case "ociImage", "ociArtifact":
+		repositoryHint, _ := r.Input["repository"].(string)
+		return fetchImageAsLocalBlob(ctx, inputPath, repositoryHint)
+	default:
+		return renderedInput{}, fmt.Errorf("unsupported input type %q", inputType)
+	}
+}
+
+// Reads all files below the given directory.
+// The keys in the result are slash-separated paths relative to the directory.
+func readDirectoryRecursively(dirPath string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return nil
+		case entry.Type().IsRegular():
+			relPath, err := filepath.Rel(dirPath, filePath)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(relPath)], err = os.ReadFile(filePath)
+			return err
+		default:
+			return fmt.Errorf("cannot bundle %s: expected only regular files and directories, but found %s", filePath, entry.Type().String())
+		}
+	})
+	return files, err
+}
@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteCTFRoundTrip(t *testing.T) {
+	chartPath := writeTestFiles(t, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: keystone\nversion: 1.2.3\n",
+		"values.yaml": "replicas: 1\n",
+	})
+	component := OCMComponentDeclaration{
+		Name:     "example.org/keystone",
+		Version:  "1.2.3+build.4", // the "+" is not allowed in OCI tags, so this checks that the tag is derived correctly
+		Provider: map[string]any{"name": "example.org"},
+		Resources: []OCMResourceDeclaration{
+			{
+				Name:    "helm-chart-keystone",
+				Type:    "helmChart",
+				Version: "1.2.3",
+				Labels: []OCMLabel{
+					{Name: GitLocationLabelName, Value: `{"commit-id":"0123abcd"}`, Signing: true},
+					{Name: InstallOrderLabelName, Value: 0, Signing: true},
+				},
+				Input: map[string]any{"type": "dir", "path": chartPath},
+			},
+			{
+				Name:    "notes",
+				Type:    "file",
+				Version: "1.2.3",
+				Labels:  []OCMLabel{{Name: FileNameLabelName, Value: "notes.txt"}},
+				Input:   map[string]any{"type": "binary", "mediaType": "text/plain", "data": "SGVsbG8gV29ybGQhCg=="},
+			},
+			{
+				Name:    "image-keystone",
+				Type:    "ociImage",
+				Version: "1.2.3",
+				Access: map[string]any{
+					"type":           "ociArtifact",
+					"imageReference": "registry.example.org/keystone@sha256:5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f5e6a5e5b2a3d1c4f",
+				},
+			},
+		},
+	}
+
+	// both the directory format and the tarball format of CTF must be readable
+	for _, fileName := range []string{"ctf", "ctf.tgz"} {
+		archivePath := filepath.Join(t.TempDir(), fileName)
+		err := component.WriteCTF(t.Context(), archivePath, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", fileName, err.Error())
+		}
+
+		// the component version can be found without naming it, since it is the only one in the archive
+		client, err := NewOCMClient("native")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resources, err := client.ListResources(t.Context(), archivePath)
+		if err != nil {
+			t.Fatalf("%s: %s", fileName, err.Error())
+		}
+		if len(resources) != 3 {
+			t.Fatalf("%s: expected 3 resources, but got %#v", fileName, resources)
+		}
+		chartRes, notesRes, imageRes := resources[0], resources[1], resources[2]
+
+		// labels survive the round trip with their values
+		if value, _ := chartRes.GetLabel(GitLocationLabelName); value != `{"commit-id":"0123abcd"}` {
+			t.Errorf("%s: unexpected value for label %q: %#v", fileName, GitLocationLabelName, value)
+		}
+		if value, _ := chartRes.GetLabel(InstallOrderLabelName); value != 0 {
+			t.Errorf("%s: unexpected value for label %q: %#v", fileName, InstallOrderLabelName, value)
+		}
+		if value, _ := notesRes.GetLabel(FileNameLabelName); value != "notes.txt" {
+			t.Errorf("%s: unexpected value for label %q: %#v", fileName, FileNameLabelName, value)
+		}
+
+		// resources with an input are stored as local blobs, with a digest of the blob
+		if !notesRes.Access.IsLocalBlob() || notesRes.Access.MediaType != "text/plain" {
+			t.Errorf("%s: unexpected access for file resource: %#v", fileName, notesRes.Access)
+		}
+		if notesRes.Digest == nil || "sha256:"+notesRes.Digest.Value != notesRes.Access.LocalReference {
+			t.Errorf("%s: unexpected digest for file resource: %#v", fileName, notesRes.Digest)
+		}
+		buf, err := readTestPayload(t.Context(), client, archivePath, notesRes)
+		if err != nil {
+			t.Fatalf("%s: %s", fileName, err.Error())
+		}
+		if string(buf) != "Hello World!\n" {
+			t.Errorf("%s: unexpected payload for file resource: %q", fileName, string(buf))
+		}
+		buf, err = readTestPayload(t.Context(), client, archivePath, chartRes)
+		if err != nil {
+			t.Fatalf("%s: %s", fileName, err.Error())
+		}
+		buf, err = extractFileFromTarball(buf, "values.yaml")
+		if err != nil {
+			t.Fatalf("%s: %s", fileName, err.Error())
+		}
+		if string(buf) != "replicas: 1\n" {
+			t.Errorf("%s: unexpected values.yaml in chart resource: %q", fileName, string(buf))
+		}
+
+		// resources with an access are only referenced
+		if imageRes.Access.Type != "ociArtifact" || imageRes.Digest == nil || imageRes.Digest.NormalisationAlgorithm != "ociArtifactDigest/v1" {
+			t.Errorf("%s: unexpected access or digest for image resource: %#v, %#v", fileName, imageRes.Access, imageRes.Digest)
+		}
+
+		// the component descriptor is returned in its original serialization
+		buf, err = client.GetComponentDescriptor(t.Context(), archivePath)
+		if err != nil {
+			t.Fatalf("%s: %s", fileName, err.Error())
+		}
+		for _, expected := range []string{"name: example.org/keystone\n", "version: 1.2.3+build.4\n", "provider: example.org\n"} {
+			if !bytes.Contains(buf, []byte(expected)) {
+				t.Errorf("%s: expected %q in component descriptor, but got:\n%s", fileName, strings.TrimSpace(expected), string(buf))
+			}
+		}
+	}
+}
+
+func readTestPayload(ctx context.Context, client OCMClient, componentVersionRef string, res OCMResourceInfo) ([]byte, error) {
+	reader, err := client.DownloadResource(ctx, componentVersionRef, res)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
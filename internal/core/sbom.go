@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"go.podman.io/image/v5/docker/reference"
+	. "go.xyrillian.de/gg/option"
+)
+
+// Media type of the SBOM documents produced by BuildSBOM().
+const sbomMediaType = "application/vnd.cyclonedx+json"
+
+// The following types are a heavily abridged declaration of the CycloneDX 1.5 JSON format, containing only the fields we need.
+// Ref: <https://cyclonedx.org/docs/1.5/json/>
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Tools struct {
+		Components []cdxComponent `json:"components"`
+	} `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxComponent struct {
+	Type               string                 `json:"type"`
+	BOMRef             string                 `json:"bom-ref,omitempty"`
+	Name               string                 `json:"name"`
+	Version            string                 `json:"version,omitempty"`
+	Hashes             []cdxHash              `json:"hashes,omitempty"`
+	PURL               string                 `json:"purl,omitempty"`
+	ExternalReferences []cdxExternalReference `json:"externalReferences,omitempty"`
+	Properties         []cdxProperty          `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cdxExternalReference struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// BuildSBOM implements `bundle --sbom`.
+// It renders a CycloneDX document (in JSON format) that describes this chart as part of the given component version.
+// The document lists the exact versions of all subcharts from Chart.lock, as well as all related images and artifacts.
+// If the chart is located in a Git checkout, its Git location (as returned by TryGetGitLocation()) is recorded as the chart's source.
+//
+// The output is deterministic (it does not contain timestamps or random serial numbers),
+// so that bundling the same inputs twice yields the same document.
+func (c HelmChart) BuildSBOM(componentName, componentVersion string, rels ImageRelations, gitLocation Option[GitLocation]) ([]byte, error) {
+	chartRef := "helm-chart:" + c.Name
+	doc := cdxDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+	}
+	doc.Metadata.Tools.Components = []cdxComponent{{Type: "application", Name: "ocm-helm-toolbox"}}
+	doc.Metadata.Component = cdxComponent{
+		Type:    "application",
+		BOMRef:  chartRef,
+		Name:    c.Name,
+		Version: c.Version,
+		Properties: []cdxProperty{
+			{Name: "cloud.sap:ocm-component-name", Value: componentName},
+			{Name: "cloud.sap:ocm-component-version", Value: componentVersion},
+		},
+	}
+
+	// record the Git location as the source of the chart
+	if loc, ok := gitLocation.Unpack(); ok {
+		doc.Metadata.Component.ExternalReferences = []cdxExternalReference{{
+			Type:    "vcs",
+			URL:     loc.RepositoryURL,
+			Comment: "commit " + loc.CommitID,
+		}}
+		doc.Metadata.Component.Properties = append(doc.Metadata.Component.Properties,
+			cdxProperty{Name: "cloud.sap:git-commit-id", Value: loc.CommitID},
+		)
+		if loc.BranchName != "" {
+			doc.Metadata.Component.Properties = append(doc.Metadata.Component.Properties,
+				cdxProperty{Name: "cloud.sap:git-branch", Value: loc.BranchName},
+			)
+		}
+		if loc.DirectoryPath != "" {
+			doc.Metadata.Component.Properties = append(doc.Metadata.Component.Properties,
+				cdxProperty{Name: "cloud.sap:git-subpath", Value: loc.DirectoryPath},
+			)
+		}
+		for _, tag := range loc.Tags {
+			doc.Metadata.Component.Properties = append(doc.Metadata.Component.Properties,
+				cdxProperty{Name: "cloud.sap:git-tag", Value: tag},
+			)
+		}
+		switch isDirty, ok := loc.IsDirty.Unpack(); {
+		case !ok:
+			doc.Metadata.Component.Properties = append(doc.Metadata.Component.Properties,
+				cdxProperty{Name: "cloud.sap:git-dirty", Value: "unknown"},
+			)
+		case isDirty:
+			doc.Metadata.Component.Properties = append(doc.Metadata.Component.Properties,
+				cdxProperty{Name: "cloud.sap:git-dirty", Value: "true"},
+			)
+		}
+	}
+
+	// list subcharts with their exact versions from Chart.lock
+	var dependsOn []string
+	if len(c.Dependencies) > 0 {
+		files, err := c.getDependencyFiles()
+		if err != nil {
+			return nil, err
+		}
+		chartLock, err := c.readValidatedLockFile(files)
+		if err != nil {
+			return nil, err
+		}
+		isListed := make(map[string]bool)
+		for _, dep := range chartLock.Dependencies {
+			subchart, err := c.getPackagedDependency(dep)
+			if err != nil {
+				return nil, err
+			}
+			bomRef := fmt.Sprintf("helm-chart:%s/%s@%s", c.Name, subchart.Name, subchart.Version)
+			if isListed[bomRef] {
+				continue // the same subchart can be included multiple times with different aliases
+			}
+			isListed[bomRef] = true
+
+			component := cdxComponent{
+				Type:    "application",
+				BOMRef:  bomRef,
+				Name:    subchart.Name,
+				Version: subchart.Version,
+			}
+			if subchart.Repository != "" && !strings.HasPrefix(subchart.Repository, "file://") {
+				component.ExternalReferences = []cdxExternalReference{{Type: "distribution", URL: subchart.Repository}}
+			}
+			doc.Components = append(doc.Components, component)
+			dependsOn = append(dependsOn, bomRef)
+		}
+	}
+
+	// list related images and artifacts (each only once, even if there are multiple relations for it)
+	imageRefForResourceName := make(map[string]reference.Named, len(rels))
+	resourceTypeForResourceName := make(map[string]string, len(rels))
+	for _, rel := range rels {
+		imageRefForResourceName[rel.ImageResourceName] = rel.ImageReference
+		resourceTypeForResourceName[rel.ImageResourceName] = rel.ResourceType
+	}
+	for _, resName := range slices.Sorted(maps.Keys(imageRefForResourceName)) {
+		component := buildSBOMComponentForImage(imageRefForResourceName[resName], resourceTypeForResourceName[resName])
+		component.BOMRef = resName
+		doc.Components = append(doc.Components, component)
+		dependsOn = append(dependsOn, resName)
+	}
+
+	doc.Dependencies = []cdxDependency{{Ref: chartRef, DependsOn: dependsOn}}
+	if doc.Components == nil {
+		doc.Components = []cdxComponent{}
+	}
+	if dependsOn == nil {
+		doc.Dependencies[0].DependsOn = []string{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // for readability of URLs with query strings
+	enc.SetIndent("", "  ")
+	err := enc.Encode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not render SBOM for chart %q: %w", c.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the SBOM entry for a related image (or other OCI artifact).
+// If the image is pinned to a digest, the entry contains a package URL and the digest as a hash.
+func buildSBOMComponentForImage(imageRef reference.Named, resourceType string) cdxComponent {
+	component := cdxComponent{
+		Type: "container",
+		Name: imageRef.Name(),
+	}
+	if resourceType == "ociArtifact" {
+		component.Type = "file"
+	}
+	tagged, isTagged := imageRef.(reference.Tagged)
+	if isTagged {
+		component.Version = tagged.Tag()
+	}
+
+	digested, isDigested := imageRef.(reference.Digested)
+	if !isDigested {
+		return component
+	}
+	if !isTagged {
+		component.Version = digested.Digest().String()
+	}
+	if digested.Digest().Algorithm() == "sha256" {
+		component.Hashes = []cdxHash{{Algorithm: "SHA-256", Content: digested.Digest().Encoded()}}
+	}
+
+	// Ref: <https://github.com/package-url/purl-spec/blob/main/types-doc/oci-definition.md>
+	query := url.Values{"repository_url": {imageRef.Name()}}
+	if isTagged {
+		query.Set("tag", tagged.Tag())
+	}
+	component.PURL = fmt.Sprintf("pkg:oci/%s@%s?%s",
+		path.Base(imageRef.Name()), url.QueryEscape(digested.Digest().String()), query.Encode())
+	return component
+}
+
+// SBOMAsOCMResource returns a resource declaration for an SBOM that was built by BuildSBOM() for the given chart.
+// The SBOM is embedded into the declaration, so that it does not need to be written to disk.
+func SBOMAsOCMResource(chart HelmChart, buf []byte) OCMResourceDeclaration {
+	return OCMResourceDeclaration{
+		Name:    "sbom-" + chart.Name,
+		Type:    "sbom",
+		Version: chart.Version,
+		Labels: []OCMLabel{{
+			Name:    SBOMForLabelName,
+			Value:   "helm-chart-" + chart.Name,
+			Signing: true,
+		}},
+		Input: map[string]any{
+			"type":      "binary",
+			"mediaType": sbomMediaType,
+			"data":      base64.StdEncoding.EncodeToString(buf),
+		},
+	}
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "go.xyrillian.de/gg/option"
+)
+
+func TestBuildSBOM(t *testing.T) {
+	chart := newTestChart(t, map[string]string{
+		"Chart.yaml": testChartYAML,
+		"Chart.lock": testChartLock,
+	})
+	digest := "sha256:" + strings.Repeat("a", 64)
+	otherDigest := "sha256:" + strings.Repeat("b", 64)
+	rels := mustParseImageRelations(t,
+		".Values.image.repository is repository of quay.io/foo/keystone:1.0@"+digest,
+		".Values.image.tag is tag of quay.io/foo/keystone:1.0@"+digest,
+		".Values.sidecar.image is reference of quay.io/foo/sidecar:2.0",
+	)
+	artifactRels, err := ParseArtifactRelations(t.Context(), []string{".Values.opa.bundle is reference of ghcr.io/example/policies@" + otherDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels = append(rels, artifactRels...)
+	rels.AssignResourceNames()
+	gitLocation := GitLocation{
+		BranchName:    "main",
+		CommitID:      "0123456789abcdef0123456789abcdef01234567",
+		RepositoryURL: "https://github.com/example/charts",
+		DirectoryPath: "keystone",
+		Tags:          []string{"keystone-1.2.3"},
+		IsDirty:       Some(false),
+	}
+
+	buf, err := chart.BuildSBOM("example.org/keystone", "1.2.3", rels, Some(gitLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != expectedSBOM {
+		t.Errorf("expected SBOM:\n%s\nbut got:\n%s", expectedSBOM, string(buf))
+	}
+
+	// the output is deterministic
+	buf2, err := chart.BuildSBOM("example.org/keystone", "1.2.3", rels, Some(gitLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, buf2) {
+		t.Error("building the same SBOM twice did not yield the same document")
+	}
+
+	// the SBOM is embedded into its resource, which refers to the chart's resource through a signed label
+	chartRes, err := chart.AsOCMResource(None[GitLocation]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sbomRes := SBOMAsOCMResource(chart, buf)
+	if sbomRes.Name != "sbom-keystone" || sbomRes.Type != "sbom" || sbomRes.Version != chart.Version {
+		t.Errorf("unexpected identity for SBOM resource: %#v", sbomRes)
+	}
+	expectedLabels := []OCMLabel{{Name: SBOMForLabelName, Value: chartRes.Name, Signing: true}}
+	if !reflect.DeepEqual(sbomRes.Labels, expectedLabels) {
+		t.Errorf("expected labels %#v, but got %#v", expectedLabels, sbomRes.Labels)
+	}
+	expectedInput := map[string]any{
+		"type":      "binary",
+		"mediaType": "application/vnd.cyclonedx+json",
+		"data":      base64.StdEncoding.EncodeToString(buf),
+	}
+	if !reflect.DeepEqual(sbomRes.Input, expectedInput) {
+		t.Errorf("expected input %#v, but got %#v", expectedInput, sbomRes.Input)
+	}
+}
+
+// The SBOM for TestBuildSBOM:
+//   - The chart is the main component, with its Git location as the source.
+//   - Subcharts are listed with their exact versions from Chart.lock.
+//   - Each related image or artifact is listed once (sorted by resource name), with its digest as a hash if it has one.
+const expectedSBOM = `{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.5",
+  "version": 1,
+  "metadata": {
+    "tools": {
+      "components": [
+        {
+          "type": "application",
+          "name": "ocm-helm-toolbox"
+        }
+      ]
+    },
+    "component": {
+      "type": "application",
+      "bom-ref": "helm-chart:keystone",
+      "name": "keystone",
+      "version": "1.2.3",
+      "externalReferences": [
+        {
+          "type": "vcs",
+          "url": "https://github.com/example/charts",
+          "comment": "commit 0123456789abcdef0123456789abcdef01234567"
+        }
+      ],
+      "properties": [
+        {
+          "name": "cloud.sap:ocm-component-name",
+          "value": "example.org/keystone"
+        },
+        {
+          "name": "cloud.sap:ocm-component-version",
+          "value": "1.2.3"
+        },
+        {
+          "name": "cloud.sap:git-commit-id",
+          "value": "0123456789abcdef0123456789abcdef01234567"
+        },
+        {
+          "name": "cloud.sap:git-branch",
+          "value": "main"
+        },
+        {
+          "name": "cloud.sap:git-subpath",
+          "value": "keystone"
+        },
+        {
+          "name": "cloud.sap:git-tag",
+          "value": "keystone-1.2.3"
+        }
+      ]
+    }
+  },
+  "components": [
+    {
+      "type": "application",
+      "bom-ref": "helm-chart:keystone/postgresql@16.7.4",
+      "name": "postgresql",
+      "version": "16.7.4",
+      "externalReferences": [
+        {
+          "type": "distribution",
+          "url": "oci://registry-1.docker.io/bitnamicharts"
+        }
+      ]
+    },
+    {
+      "type": "application",
+      "bom-ref": "helm-chart:keystone/memcached@7.8.6",
+      "name": "memcached",
+      "version": "7.8.6",
+      "externalReferences": [
+        {
+          "type": "distribution",
+          "url": "https://charts.example.org/stable"
+        }
+      ]
+    },
+    {
+      "type": "file",
+      "bom-ref": "artifact-policies",
+      "name": "ghcr.io/example/policies",
+      "version": "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+      "hashes": [
+        {
+          "alg": "SHA-256",
+          "content": "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+        }
+      ],
+      "purl": "pkg:oci/policies@sha256%3Abbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb?repository_url=ghcr.io%2Fexample%2Fpolicies"
+    },
+    {
+      "type": "container",
+      "bom-ref": "image-keystone",
+      "name": "quay.io/foo/keystone",
+      "version": "1.0",
+      "hashes": [
+        {
+          "alg": "SHA-256",
+          "content": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+        }
+      ],
+      "purl": "pkg:oci/keystone@sha256%3Aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa?repository_url=quay.io%2Ffoo%2Fkeystone&tag=1.0"
+    },
+    {
+      "type": "container",
+      "bom-ref": "image-sidecar",
+      "name": "quay.io/foo/sidecar",
+      "version": "2.0"
+    }
+  ],
+  "dependencies": [
+    {
+      "ref": "helm-chart:keystone",
+      "dependsOn": [
+        "helm-chart:keystone/postgresql@16.7.4",
+        "helm-chart:keystone/memcached@7.8.6",
+        "artifact-policies",
+        "image-keystone",
+        "image-sidecar"
+      ]
+    }
+  ]
+}
+`
@@ -0,0 +1,328 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// This file contains an implementation of semantic versions and version constraints
+// that behaves like the library used by Helm (github.com/Masterminds/semver/v3).
+// It is used to check versions in Chart.lock against the version constraints in Chart.yaml.
+
+// semverVersion is a parsed semantic version.
+type semverVersion struct {
+	Major, Minor, Patch uint64
+	Prerelease          string // without leading "-"
+}
+
+// Matches versions in the same lenient format that Helm accepts:
+// a leading "v" is allowed, minor and patch version may be omitted, and leading zeroes are allowed (except in the pre-release).
+var semverVersionRx = regexp.MustCompile(`^v?([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?` +
+	`(?:-((?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+func parseSemverVersion(input string) (semverVersion, error) {
+	match := semverVersionRx.FindStringSubmatch(input)
+	if match == nil {
+		return semverVersion{}, fmt.Errorf("invalid semantic version: %q", input)
+	}
+	var (
+		result semverVersion
+		err    error
+	)
+	for idx, target := range []*uint64{&result.Major, &result.Minor, &result.Patch} {
+		if match[idx+1] == "" {
+			continue
+		}
+		*target, err = strconv.ParseUint(match[idx+1], 10, 64)
+		if err != nil {
+			return semverVersion{}, fmt.Errorf("invalid semantic version: %q: %w", input, err)
+		}
+	}
+	result.Prerelease = match[4]
+	return result, nil
+}
+
+// String returns the canonical representation of this version.
+func (v semverVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare compares two versions by precedence, as defined in the SemVer specification.
+// Build metadata is ignored.
+func (v semverVersion) Compare(other semverVersion) int {
+	if c := cmp.Compare(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+
+	// a version with a pre-release has lower precedence than the same version without one
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return +1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	// otherwise, pre-releases are compared field by field
+	lhsFields := strings.Split(v.Prerelease, ".")
+	rhsFields := strings.Split(other.Prerelease, ".")
+	for idx := 0; idx < len(lhsFields) && idx < len(rhsFields); idx++ {
+		if c := comparePrereleaseFields(lhsFields[idx], rhsFields[idx]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(lhsFields), len(rhsFields))
+}
+
+func comparePrereleaseFields(lhs, rhs string) int {
+	lhsNum, lhsErr := strconv.ParseUint(lhs, 10, 64)
+	rhsNum, rhsErr := strconv.ParseUint(rhs, 10, 64)
+	switch {
+	case lhsErr == nil && rhsErr == nil:
+		return cmp.Compare(lhsNum, rhsNum)
+	case lhsErr == nil:
+		return -1 // numeric fields have lower precedence than alphanumeric ones
+	case rhsErr == nil:
+		return +1
+	default:
+		return strings.Compare(lhs, rhs)
+	}
+}
+
+// semverConstraint is a parsed version constraint like "^1.2" or ">= 1.0, < 2.0 || 3.x".
+// The outer slice contains alternatives (separated by "||"), each of which is a conjunction of simple constraints.
+type semverConstraint [][]semverSimpleConstraint
+
+// semverSimpleConstraint is a single operator with a version, like ">= 1.2".
+type semverSimpleConstraint struct {
+	Operator string // one of "", "!=", ">", "<", ">=", "<=", "~", "^"
+	Version  semverVersion
+	// These are set when version components were omitted or given as wildcards.
+	// For example, "1.x" has all three set, "1.2" has Dirty and PatchDirty set, and "*" only has Dirty set.
+	Dirty, MinorDirty, PatchDirty bool
+}
+
+// The regexes in this section are the same as in Masterminds/semver, to accept exactly the same inputs.
+const (
+	semverOperatorPattern          = `=||!=|>|<|>=|=>|<=|=<|~|~>|\^`
+	semverConstraintVersionPattern = `v?([0-9|x|X|\*]+)(\.[0-9|x|X|\*]+)?(\.[0-9|x|X|\*]+)?` +
+		`(-([0-9A-Za-z\-]+(\.[0-9A-Za-z\-]+)*))?` +
+		`(\+([0-9A-Za-z\-]+(\.[0-9A-Za-z\-]+)*))?`
+)
+
+var (
+	// Matches a simple constraint like ">= 1.2.x".
+	semverSimpleConstraintRx = regexp.MustCompile(fmt.Sprintf(`^\s*(%s)\s*(%s)\s*$`,
+		semverOperatorPattern, semverConstraintVersionPattern))
+	// Matches a hyphen range like "1.2 - 1.4.5".
+	semverHyphenRangeRx = regexp.MustCompile(fmt.Sprintf(`\s*(%s)\s+-\s+(%s)\s*`,
+		semverConstraintVersionPattern, semverConstraintVersionPattern))
+	// Matches a conjunction of simple constraints, separated by commas and/or whitespace.
+	semverConjunctionRx = regexp.MustCompile(fmt.Sprintf(`^(\s*(%s)\s*(%s)\s*)((?:\s+|,\s*)(%s)\s*(%s)\s*)*$`,
+		semverOperatorPattern, semverConstraintVersionPattern, semverOperatorPattern, semverConstraintVersionPattern))
+	// Finds the simple constraints within a conjunction.
+	semverConjunctionItemRx = regexp.MustCompile(fmt.Sprintf(`(%s)\s*(%s)`,
+		semverOperatorPattern, semverConstraintVersionPattern))
+)
+
+func parseSemverConstraint(input string) (semverConstraint, error) {
+	var result semverConstraint
+	rewritten := semverHyphenRangeRx.ReplaceAllString(input, ">= $1, <= $11 ")
+	for alternative := range strings.SplitSeq(rewritten, "||") {
+		if !semverConjunctionRx.MatchString(alternative) {
+			return nil, fmt.Errorf("invalid version constraint %q: cannot parse %q", input, alternative)
+		}
+		var conjunction []semverSimpleConstraint
+		for _, field := range semverConjunctionItemRx.FindAllString(alternative, -1) {
+			sc, err := parseSemverSimpleConstraint(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %w", input, err)
+			}
+			conjunction = append(conjunction, sc)
+		}
+		result = append(result, conjunction)
+	}
+	return result, nil
+}
+
+func parseSemverSimpleConstraint(input string) (semverSimpleConstraint, error) {
+	match := semverSimpleConstraintRx.FindStringSubmatch(input)
+	if match == nil {
+		return semverSimpleConstraint{}, fmt.Errorf("cannot parse %q", input)
+	}
+	result := semverSimpleConstraint{Operator: match[1]}
+	switch result.Operator {
+	case "=>":
+		result.Operator = ">="
+	case "=<":
+		result.Operator = "<="
+	case "~>":
+		result.Operator = "~"
+	case "=":
+		result.Operator = ""
+	}
+
+	// omitted or wildcard components are replaced with zeroes (build metadata is dropped in this case)
+	isWildcard := func(component string) bool {
+		component = strings.TrimPrefix(component, ".")
+		return component == "" || component == "x" || component == "X" || component == "*"
+	}
+	versionStr := match[2]
+	switch {
+	case isWildcard(match[3]):
+		versionStr = "0.0.0" + match[6]
+		result.Dirty = true
+	case isWildcard(match[4]):
+		versionStr = match[3] + ".0.0" + match[6]
+		result.Dirty, result.MinorDirty = true, true
+	case isWildcard(match[5]):
+		versionStr = match[3] + match[4] + ".0" + match[6]
+		result.Dirty, result.PatchDirty = true, true
+	}
+
+	var err error
+	result.Version, err = parseSemverVersion(versionStr)
+	if err != nil {
+		return semverSimpleConstraint{}, fmt.Errorf("cannot parse %q: %w", input, err)
+	}
+	return result, nil
+}
+
+// Matches returns whether the given version satisfies this constraint.
+func (c semverConstraint) Matches(v semverVersion) bool {
+	for _, conjunction := range c {
+		// Like in Helm, versions with a pre-release are only considered
+		// if at least one constraint in the same conjunction mentions a pre-release.
+		if v.Prerelease != "" && !slices.ContainsFunc(conjunction, semverSimpleConstraint.hasPrerelease) {
+			continue
+		}
+		matchesAll := true
+		for _, sc := range conjunction {
+			if !sc.Matches(v) {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			return true
+		}
+	}
+	return false
+}
+
+func (c semverSimpleConstraint) hasPrerelease() bool {
+	return c.Version.Prerelease != ""
+}
+
+// Matches returns whether the given version satisfies this simple constraint.
+// Pre-release gating is done by semverConstraint.Matches().
+func (c semverSimpleConstraint) Matches(v semverVersion) bool {
+	cv := c.Version
+	switch c.Operator {
+	case "":
+		// with omitted or wildcard components, this works like "~" (e.g. "1.2" means "~1.2")
+		if c.Dirty {
+			return c.matchesTilde(v)
+		}
+		return v.Compare(cv) == 0
+	case "!=":
+		if !c.Dirty {
+			return v.Compare(cv) != 0
+		}
+		switch {
+		case v.Major != cv.Major:
+			return true
+		case c.MinorDirty:
+			return false
+		case v.Minor != cv.Minor:
+			return true
+		case c.PatchDirty:
+			return v.Prerelease != cv.Prerelease
+		default:
+			return v.Patch != cv.Patch
+		}
+	case ">":
+		// e.g. ">1.2" does not match "1.2.5", only "1.3.0" and above
+		switch {
+		case !c.Dirty:
+			return v.Compare(cv) > 0
+		case v.Major != cv.Major:
+			return v.Major > cv.Major
+		case c.MinorDirty:
+			return false
+		case c.PatchDirty:
+			return v.Minor > cv.Minor
+		default:
+			return v.Compare(cv) > 0
+		}
+	case "<":
+		return v.Compare(cv) < 0
+	case ">=":
+		return v.Compare(cv) >= 0
+	case "<=":
+		// e.g. "<=1.2" matches "1.2.5", but not "1.3.0"
+		switch {
+		case !c.Dirty:
+			return v.Compare(cv) <= 0
+		case v.Major != cv.Major:
+			return v.Major < cv.Major
+		default:
+			return c.MinorDirty || v.Minor <= cv.Minor
+		}
+	case "~":
+		return c.matchesTilde(v)
+	case "^":
+		// e.g. "^1.2.3" means ">= 1.2.3, < 2.0.0", but "^0.2.3" means ">= 0.2.3, < 0.3.0"
+		switch {
+		case v.Compare(cv) < 0:
+			return false
+		case cv.Major > 0 || c.MinorDirty:
+			return v.Major == cv.Major
+		case v.Major > 0:
+			return false
+		case cv.Minor > 0 || c.PatchDirty:
+			return v.Minor == cv.Minor
+		case v.Minor > 0:
+			return false
+		default:
+			return v.Patch == cv.Patch
+		}
+	default:
+		return false // unreachable, see parseSemverSimpleConstraint()
+	}
+}
+
+// e.g. "~1.2.3" means ">= 1.2.3, < 1.3.0", and "~1" means ">= 1.0.0, < 2.0.0"
+func (c semverSimpleConstraint) matchesTilde(v semverVersion) bool {
+	cv := c.Version
+	switch {
+	case v.Compare(cv) < 0:
+		return false
+	case cv.Major == 0 && cv.Minor == 0 && cv.Patch == 0 && !c.MinorDirty && !c.PatchDirty:
+		// "~0.0.0" and "~*" match everything
+		return true
+	case v.Major != cv.Major:
+		return false
+	default:
+		return c.MinorDirty || v.Minor == cv.Minor
+	}
+}
@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSemverConstraintMatches(t *testing.T) {
+	// The expected results were obtained from github.com/Masterminds/semver/v3 v3.4.0 (the library used by Helm),
+	// by calling NewConstraint(constraint).Check(NewVersion(version)).
+	testCases := []struct {
+		Constraint string
+		Version    string
+		Expected   bool
+	}{
+		// caret ranges, including the special cases for major version 0
+		{"^1.2.3", "1.2.3", true},
+		{"^1.2.3", "1.9.9", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "1.2.4-beta", false},
+		{"^1.2", "1.2.0", true},
+		{"^1.2", "1.9.9", true},
+		{"^1.2", "1.1.9", false},
+		{"^1.2", "2.0.0", false},
+		{"^1", "1.0.0", true},
+		{"^1", "1.9.9", true},
+		{"^1", "2.0.0", false},
+		{"^0.2.3", "0.2.3", true},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.2.3", "1.0.0", false},
+		{"^0.2", "0.2.0", true},
+		{"^0.2", "0.2.9", true},
+		{"^0.2", "0.3.0", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0.3", "0.1.0", false},
+		{"^0.0", "0.0.4", true},
+		{"^0.0", "0.1.0", false},
+		{"^0", "0.0.1", true},
+		{"^0", "0.3.0", true},
+		{"^0", "1.0.0", false},
+		{"^*", "0.2.0", false},
+		{"^*", "5.1.0", false},
+
+		// tilde ranges, including the special case of "~0.0.0" matching everything
+		{"~1.2.3", "1.2.3", true},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1.2.3", "1.2.2", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.2.9", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.0.0", true},
+		{"~1", "1.9.9", true},
+		{"~1", "2.0.0", false},
+		{"~> 1.2", "1.2.4", true},
+		{"~> 1.2", "1.3.0", false},
+		{"~0.0.0", "0.0.1", true},
+		{"~0.0.0", "5.1.0", true},
+		{"~0", "0.3.0", true},
+		{"~0", "1.0.0", false},
+
+		// wildcards, and omitted components (which behave like wildcards)
+		{"*", "0.0.0", true},
+		{"*", "5.1.0", true},
+		{"*", "1.2.3-beta", false},
+		{"1.x", "1.0.0", true},
+		{"1.x", "1.9.9", true},
+		{"1.x", "2.0.0", false},
+		{"1.2.x", "1.2.9", true},
+		{"1.2.x", "1.3.0", false},
+		{"1.2.*", "1.2.0", true},
+		{"1.2.*", "1.1.9", false},
+		{"1.x.3", "1.0.0", true},
+		{"1.x.3", "2.0.0", false},
+
+		// comparison operators, where ">" and "<=" treat omitted components specially
+		{">1.2.3", "1.2.4", true},
+		{">1.2.3", "1.2.3", false},
+		{">1.2", "1.2.9", false},
+		{">1.2", "1.3.0", true},
+		{">1", "1.9.9", false},
+		{">1", "2.0.0", true},
+		{">=1.2", "1.2.0", true},
+		{">=1.2", "1.1.9", false},
+		{"<1.2.3", "1.2.2", true},
+		{"<1.2.3", "1.2.3", false},
+		{"<1.2", "1.1.9", true},
+		{"<1.2", "1.2.0", false},
+		{"<=1.2.3", "1.2.3", true},
+		{"<=1.2.3", "1.2.4", false},
+		{"<=1.2", "1.2.9", true},
+		{"<=1.2", "1.3.0", false},
+		{"<=1", "1.9.9", true},
+		{"<=1", "2.0.0", false},
+		{"=1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{"1.2", "1.2.9", true},
+		{"1.2", "1.3.0", false},
+		{"!=1.2.3", "1.2.4", true},
+		{"!=1.2.3", "1.2.3", false},
+		{"!=1.2.3", "1.2.4-beta", false},
+		{"!=1.2", "1.2.9", false},
+		{"!=1.2", "1.3.0", true},
+		{"=>1.2", "1.2.0", true},
+		{"=>1.2", "1.1.9", false},
+		{"=<1.2", "1.2.9", true},
+		{"=<1.2", "1.3.0", false},
+
+		// hyphen ranges, conjunctions and alternatives
+		{"1.2 - 1.4.5", "1.2.0", true},
+		{"1.2 - 1.4.5", "1.4.5", true},
+		{"1.2 - 1.4.5", "1.4.6", false},
+		{"1.2 - 1.4.5", "1.1.9", false},
+		{"1.2.3 - 1.4", "1.4.9", true},
+		{"1.2.3 - 1.4", "1.5.0", false},
+		{"1.2.3 - 1.4", "1.2.2", false},
+		{">= 1.2, < 2.0", "1.9.9", true},
+		{">= 1.2, < 2.0", "2.0.0", false},
+		{">=1.2 <2", "1.5.0", true},
+		{">=1.2 <2", "2.0.0", false},
+		{"^1.2 || ^3.0", "3.1.0", true},
+		{"^1.2 || ^3.0", "2.0.0", false},
+
+		// pre-releases only match if a constraint in the same conjunction mentions a pre-release
+		{">=1.2.3-beta.1", "1.2.3-beta.2", true},
+		{">=1.2.3-beta.1", "1.2.3-alpha", false},
+		{">=1.2.3-beta.1", "1.2.3", true},
+		{">=1.2.3-beta.1", "1.5.0-rc.1", true},
+		{">=1.2.3-beta.1, <2.0.0", "1.5.0-rc.1", true},
+		{">=1.2.3-beta.1, <2.0.0", "1.9.9", true},
+		{"^1.2.3 || >= 2.5.0-0", "1.5.0-rc.1", false},
+		{"^1.2.3 || >= 2.5.0-0", "2.0.0-alpha", false},
+		{"^1.2.3 || >= 2.5.0-0", "2.5.0", true},
+
+		// precedence of pre-releases
+		{"1.2.3-beta.2", "1.2.3-beta.2", true},
+		{"1.2.3-beta.2", "1.2.3-beta.10", false},
+		{">1.2.3-beta.2", "1.2.3-beta.10", true},
+		{">1.2.3-beta.2", "1.2.3-beta.a", true},
+		{"^1.2.3-beta.1", "1.2.3-beta.2", true},
+		{"^1.2.3-beta.1", "1.3.0", true},
+		{"^1.2.3-beta.1", "2.0.0-alpha", false},
+		{"~1.2.3-beta", "1.2.3", true},
+		{"~1.2.3-beta", "1.3.0", false},
+
+		// lenient parsing
+		{"^v1.2", "v1.5.0", true},
+		{"^v1.2", "1.5.0", true},
+		{"1.2.3", "1.2.3+build.5", true},
+		{">=01.2.3", "1.2.3", true},
+	}
+
+	for _, tc := range testCases {
+		constraint, err := parseSemverConstraint(tc.Constraint)
+		if err != nil {
+			t.Errorf("could not parse constraint %q: %s", tc.Constraint, err.Error())
+			continue
+		}
+		version, err := parseSemverVersion(tc.Version)
+		if err != nil {
+			t.Errorf("could not parse version %q: %s", tc.Version, err.Error())
+			continue
+		}
+		if actual := constraint.Matches(version); actual != tc.Expected {
+			t.Errorf("expected %q matching %q to be %t, but got %t", tc.Constraint, tc.Version, tc.Expected, actual)
+		}
+	}
+}
+
+func TestSemverParseErrors(t *testing.T) {
+	// these are also rejected by github.com/Masterminds/semver/v3
+	for _, input := range []string{"", "1.2.3 ||", "a", "> = 1.2", ",1.2", "1.2,", "1.2.3 - ", "1.2.3-01", "1.2.3-"} {
+		_, err := parseSemverConstraint(input)
+		if err == nil || !strings.Contains(err.Error(), "invalid version constraint") {
+			t.Errorf("expected constraint %q to be rejected, but got %v", input, err)
+		}
+	}
+	for _, input := range []string{"", "1.2.3-01", "1.2.3-", "1.2.3.4", "x"} {
+		_, err := parseSemverVersion(input)
+		if err == nil {
+			t.Errorf("expected version %q to be rejected", input)
+		}
+	}
+}
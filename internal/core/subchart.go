@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ResolveSubchartRelations translates image relations that were declared for a subchart of this chart
+// (e.g. with the "charts/postgresql:" prefix) into relations for the corresponding values of this chart.
+//
+// For example, `.Values.image.tag` in the subchart "postgresql" becomes `.Values.postgresql.image.tag` in this chart.
+// If the subchart is included with an alias, the alias is used instead of the subchart's name.
+// The subchart may be given by its alias or by its name; the latter only works if the name is unambiguous.
+// The subchart's original target path is retained in the SubchartTargetPath field.
+func (c HelmChart) ResolveSubchartRelations(rels ImageRelations) error {
+	var files map[string][]byte // only read if needed
+	for _, rel := range rels {
+		if rel.Subchart == "" || rel.SubchartTargetPath != "" {
+			continue
+		}
+		if files == nil {
+			var err error
+			files, err = readDirectoryRecursively(c.ChartPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		prefix, err := findSubchartValuePath(files, rel.Subchart)
+		if err != nil {
+			return fmt.Errorf("cannot resolve image relation for .Values.%s in %s of chart %q: %w", rel.TargetPath, rel.Subchart, c.Name, err)
+		}
+		path, err := ParseValuePath(rel.TargetPath)
+		if err != nil {
+			return err
+		}
+		rel.SubchartTargetPath = rel.TargetPath
+		rel.TargetPath = append(prefix, path...).String()
+	}
+	return nil
+}
+
+// Returns the path in the values of a chart below which the values of the given subchart are located.
+// The input contains the chart's files, with paths relative to the chart's root directory.
+// The subchart is given as a path like "charts/foo" or "charts/foo/charts/bar".
+func findSubchartValuePath(files map[string][]byte, subchart string) (ValuePath, error) {
+	var result ValuePath
+	elems := strings.Split(subchart, "/")
+	for idx := 1; idx < len(elems); idx += 2 {
+		name := elems[idx]
+		chartMeta, err := readChartMetadataFromFiles(files)
+		if err != nil {
+			return nil, err
+		}
+
+		// find the dependency in question (either by the name that it is included as, or by its chart name)
+		var candidates []DeclaredChartDependency
+		for _, dep := range chartMeta.Dependencies {
+			if dep.EffectiveName() == name {
+				candidates = []DeclaredChartDependency{dep}
+				break
+			}
+			if dep.Name == name {
+				candidates = append(candidates, dep)
+			}
+		}
+		if len(candidates) > 1 {
+			aliases := make([]string, len(candidates))
+			for idx, dep := range candidates {
+				aliases[idx] = dep.EffectiveName()
+			}
+			return nil, fmt.Errorf("chart %q includes %q multiple times (please refer to it by one of its aliases: %s)",
+				chartMeta.Name, name, strings.Join(aliases, ", "))
+		}
+		chartName, valuesKey := name, name
+		if len(candidates) == 1 {
+			// if there is no matching dependency, the subchart might still be present in `charts/` without being declared;
+			// Helm then uses its name as key in the values
+			chartName, valuesKey = candidates[0].Name, candidates[0].EffectiveName()
+		}
+
+		// find the subchart's own files, to continue with nested subcharts
+		subchartFiles, err := collectSubchartFiles(files)
+		if err != nil {
+			return nil, err
+		}
+		var found bool
+		for _, key := range slices.Sorted(maps.Keys(subchartFiles)) {
+			subchartMeta, err := readChartMetadataFromFiles(subchartFiles[key])
+			if err == nil && subchartMeta.Name == chartName {
+				files = subchartFiles[key]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("chart %q does not contain the subchart %q below charts/ (maybe dependencies need to be built first?)",
+				chartMeta.Name, chartName)
+		}
+		result = append(result, ValuePathElement{Key: valuesKey})
+	}
+	return result, nil
+}
+
+// Like ParseHelmChartYAML, but reads from a set of files as produced by readDirectoryRecursively().
+func readChartMetadataFromFiles(files map[string][]byte) (HelmChart, error) {
+	chart, err := parseHelmChartMetadata("", func(fileName string) ([]byte, error) {
+		buf, exists := files[fileName]
+		if !exists {
+			return nil, fs.ErrNotExist
+		}
+		return buf, nil
+	})
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && chart.Name == "") {
+		return HelmChart{}, errors.New("Chart.yaml is missing or does not contain a chart name") //nolint:staticcheck // Chart.yaml is capitalized for a reason
+	}
+	return chart, err
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+// This chart has subcharts with and without aliases, nested subcharts, and a subchart that is included twice.
+var testSubchartFiles = map[string]string{
+	"Chart.yaml": `apiVersion: v2
+name: app
+version: 1.0.0
+dependencies:
+  - name: postgresql
+    version: 1.0.0
+  - name: memcached
+    version: 7.8.6
+    alias: sessions
+  - name: memcached
+    version: 7.8.6
+    alias: cache
+  - name: keycloak
+    version: 1.0.0
+    alias: auth
+`,
+	"charts/postgresql/Chart.yaml": `apiVersion: v2
+name: postgresql
+version: 1.0.0
+dependencies:
+  - name: common
+    version: 2.0.0
+`,
+	"charts/postgresql/charts/common/Chart.yaml": "apiVersion: v2\nname: common\nversion: 2.0.0\n",
+	"charts/keycloak/Chart.yaml": `apiVersion: v2
+name: keycloak
+version: 1.0.0
+dependencies:
+  - name: common
+    version: 2.0.0
+    alias: kc-common
+`,
+	// not declared as a dependency, but Helm still includes it
+	"charts/redis/Chart.yaml": "apiVersion: v2\nname: redis\nversion: 1.0.0\n",
+}
+
+func newTestSubchartFiles(t *testing.T) map[string]string {
+	t.Helper()
+	files := maps.Clone(testSubchartFiles)
+	// subcharts may also be present as tarballs
+	files["charts/memcached-7.8.6.tgz"] = string(makeTestChartTarball(t, "memcached", "7.8.6"))
+	files["charts/keycloak/charts/common-2.0.0.tgz"] = string(makeTestChartTarball(t, "common", "2.0.0"))
+	return files
+}
+
+func TestFindSubchartValuePath(t *testing.T) {
+	files := make(map[string][]byte)
+	for path, contents := range newTestSubchartFiles(t) {
+		files[path] = []byte(contents)
+	}
+
+	testCases := []struct {
+		Subchart      string
+		Expected      string
+		ExpectedError string
+	}{
+		{Subchart: "charts/postgresql", Expected: "postgresql"},
+		// subcharts can be referred to by their alias, or by their name if that is unambiguous
+		{Subchart: "charts/sessions", Expected: "sessions"},
+		{Subchart: "charts/cache", Expected: "cache"},
+		{Subchart: "charts/auth", Expected: "auth"},
+		{Subchart: "charts/keycloak", Expected: "auth"},
+		{
+			Subchart:      "charts/memcached",
+			ExpectedError: `chart "app" includes "memcached" multiple times (please refer to it by one of its aliases: sessions, cache)`,
+		},
+		{Subchart: "charts/redis", Expected: "redis"},
+		// nested subcharts, also with aliases on both levels
+		{Subchart: "charts/postgresql/charts/common", Expected: "postgresql.common"},
+		{Subchart: "charts/auth/charts/kc-common", Expected: "auth.kc-common"},
+		{Subchart: "charts/keycloak/charts/common", Expected: "auth.kc-common"},
+		// unknown subcharts
+		{
+			Subchart:      "charts/mysql",
+			ExpectedError: `chart "app" does not contain the subchart "mysql" below charts/`,
+		},
+		{
+			Subchart:      "charts/postgresql/charts/mysql",
+			ExpectedError: `chart "postgresql" does not contain the subchart "mysql" below charts/`,
+		},
+	}
+
+	for _, tc := range testCases {
+		path, err := findSubchartValuePath(files, tc.Subchart)
+		switch {
+		case tc.ExpectedError == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tc.Subchart, err.Error())
+		case tc.ExpectedError == "" && path.String() != tc.Expected:
+			t.Errorf("%s: expected value path %q, but got %q", tc.Subchart, tc.Expected, path.String())
+		case tc.ExpectedError != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.ExpectedError)):
+			t.Errorf("%s: expected error %q, but got %v", tc.Subchart, tc.ExpectedError, err)
+		}
+	}
+}
+
+func TestResolveSubchartRelations(t *testing.T) {
+	chart := newTestChart(t, newTestSubchartFiles(t))
+	rels := mustParseImageRelations(t,
+		".Values.image.tag is tag of quay.io/foo/app:1.0",
+		"charts/sessions: .Values.image.tag is tag of docker.io/library/memcached:1.6",
+		"charts/keycloak/charts/common: .Values.images.shell is reference of docker.io/library/busybox:1.36",
+	)
+	err := chart.ResolveSubchartRelations(rels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// relations that are already resolved are left alone
+	err = chart.ResolveSubchartRelations(rels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][3]string{
+		// Subchart, TargetPath, SubchartTargetPath
+		{"", "image.tag", ""},
+		{"charts/sessions", "sessions.image.tag", "image.tag"},
+		{"charts/keycloak/charts/common", "auth.kc-common.images.shell", "images.shell"},
+	}
+	for idx, rel := range rels {
+		actual := [3]string{rel.Subchart, rel.TargetPath, rel.SubchartTargetPath}
+		if actual != expected[idx] {
+			t.Errorf("expected image relation %d to have subchart, target path and subchart target path %q, but got %q", idx, expected[idx], actual)
+		}
+	}
+
+	rels = mustParseImageRelations(t, "charts/mysql: .Values.image.tag is tag of docker.io/library/mysql:8.4")
+	err = chart.ResolveSubchartRelations(rels)
+	expectedError := `cannot resolve image relation for .Values.image.tag in charts/mysql of chart "app": chart "app" does not contain the subchart "mysql"`
+	if err == nil || !strings.HasPrefix(err.Error(), expectedError) {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+}
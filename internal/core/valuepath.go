@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValuePath is a parsed path into the Helm values of a chart, like the "<path>" in ".Values.<path>".
+//
+// The grammar is like for field access in Helm templates, with some extensions:
+//   - Path elements are separated by dots, e.g. `image.repository`.
+//   - List elements are selected with an index in square brackets, e.g. `sidecars[0].image`.
+//   - Keys containing special characters can be written in double quotes, e.g. `podLabels."app.kubernetes.io/name"`.
+//     Inside double quotes, backslashes and double quotes must be escaped with a backslash.
+type ValuePath []ValuePathElement
+
+// ValuePathElement is an element of a ValuePath.
+// It either selects a key in a map, or an index in a list.
+type ValuePathElement struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// Matches keys that can be written in a ValuePath without quotes.
+var bareValuePathKeyRx = regexp.MustCompile(`^[^\s."\[\]\\]+$`)
+
+// ParseValuePath parses a ValuePath from its string representation.
+func ParseValuePath(input string) (ValuePath, error) {
+	var (
+		result ValuePath
+		rest   = input
+	)
+	for {
+		// parse key
+		var key string
+		if strings.HasPrefix(rest, `"`) {
+			var err error
+			key, rest, err = parseQuotedValuePathKey(rest)
+			if err != nil {
+				return nil, fmt.Errorf("malformed value path %q: %w", input, err)
+			}
+		} else {
+			end := strings.IndexAny(rest, `.[`)
+			if end == -1 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+			if !bareValuePathKeyRx.MatchString(key) {
+				return nil, fmt.Errorf("malformed value path %q: expected key, but found %q (keys with special characters must be quoted)", input, key)
+			}
+		}
+		result = append(result, ValuePathElement{Key: key})
+
+		// parse any number of indexes following the key
+		for strings.HasPrefix(rest, "[") {
+			indexStr, remainder, found := strings.Cut(rest[1:], "]")
+			if !found {
+				return nil, fmt.Errorf("malformed value path %q: missing closing bracket", input)
+			}
+			index, err := strconv.ParseUint(indexStr, 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("malformed value path %q: expected list index, but found %q", input, indexStr)
+			}
+			result = append(result, ValuePathElement{Index: int(index), IsIndex: true})
+			rest = remainder
+		}
+
+		// continue with the next key, if any
+		if rest == "" {
+			return result, nil
+		}
+		var found bool
+		rest, found = strings.CutPrefix(rest, ".")
+		if !found {
+			return nil, fmt.Errorf("malformed value path %q: expected \".\" or \"[\", but found %q", input, rest)
+		}
+	}
+}
+
+// Parses a quoted key at the start of the input. Returns the unquoted key and the remaining input.
+func parseQuotedValuePathKey(input string) (key, rest string, err error) {
+	end := findEndOfQuotedString(input)
+	if end == -1 {
+		return "", "", errors.New("missing closing quote")
+	}
+	if end == 1 {
+		return "", "", errors.New("quoted key may not be empty")
+	}
+
+	var sb strings.Builder
+	escaped := false
+	for _, r := range input[1:end] {
+		switch {
+		case escaped:
+			if r != '"' && r != '\\' {
+				return "", "", fmt.Errorf(`invalid escape sequence "\%c" in quoted key`, r)
+			}
+			sb.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String(), input[end+1:], nil
+}
+
+// Given an input that starts with a double quote, returns the index of the matching closing quote, or -1 if there is none.
+// Within the quotes, a backslash escapes the following character.
+// This is the quoting grammar shared by quoted keys in value paths and by quoted templates in image relations.
+func findEndOfQuotedString(input string) int {
+	escaped := false
+	for idx := 1; idx < len(input); idx++ {
+		switch {
+		case escaped:
+			escaped = false
+		case input[idx] == '\\':
+			escaped = true
+		case input[idx] == '"':
+			return idx
+		}
+	}
+	return -1
+}
+
+// String returns the canonical string representation of this path, which ParseValuePath() understands.
+func (p ValuePath) String() string {
+	var sb strings.Builder
+	for idx, elem := range p {
+		switch {
+		case elem.IsIndex:
+			fmt.Fprintf(&sb, "[%d]", elem.Index)
+		default:
+			if idx > 0 {
+				sb.WriteByte('.')
+			}
+			if bareValuePathKeyRx.MatchString(elem.Key) {
+				sb.WriteString(elem.Key)
+			} else {
+				sb.WriteByte('"')
+				sb.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(elem.Key))
+				sb.WriteByte('"')
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Append returns a new path with the given element appended.
+// This does not modify the original path.
+func (p ValuePath) Append(elem ValuePathElement) ValuePath {
+	result := make(ValuePath, len(p), len(p)+1)
+	copy(result, p)
+	return append(result, elem)
+}
+
+// ListPrefixes returns the prefixes of this path that refer to lists,
+// e.g. `sidecars` and `sidecars[0].ports` for `sidecars[0].ports[1].name`.
+func (p ValuePath) ListPrefixes() []ValuePath {
+	var result []ValuePath
+	for idx, elem := range p {
+		if elem.IsIndex && idx > 0 && !p[idx-1].IsIndex {
+			result = append(result, p[:idx])
+		}
+	}
+	return result
+}
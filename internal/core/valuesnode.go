@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuildLocalizedValuesOnto is like BuildLocalizedValues, but applies the image relations on top of a base values file.
+// This is used by `unbundle --base-values`.
+//
+// The result is a single values file containing both the base values and the localized values.
+// Since the base values file is handled as a tree of YAML nodes, comments and key order in it are retained.
+//
+// Helm replaces lists wholesale when merging values files, so when an image relation targets an element of a list
+// that does not exist in the base values file yet, the list is seeded from the chart's own values.yaml (given as `defaults`).
+// This ensures that the other fields of the list elements survive the merge.
+func (rels ImageRelations) BuildLocalizedValuesOnto(base, defaults []byte) ([]byte, error) {
+	var baseDoc, defaultsDoc yaml.Node
+	err := yaml.Unmarshal(base, &baseDoc)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing base values: %w", err)
+	}
+	err = yaml.Unmarshal(defaults, &defaultsDoc)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing values.yaml of chart: %w", err)
+	}
+
+	if len(baseDoc.Content) == 0 {
+		// base values file is empty
+		baseDoc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	v := valuesTree{Root: baseDoc.Content[0]}
+	if len(defaultsDoc.Content) > 0 {
+		v.Defaults = defaultsDoc.Content[0]
+	}
+	if v.Root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("while parsing base values: line %d: expected a mapping at the top level", v.Root.Line)
+	}
+
+	err = v.InsertImageRelations(rels, ImageRelation.GetValue)
+	if err != nil {
+		return nil, err
+	}
+	holes := v.UnfilledHoles()
+	if len(holes) > 0 {
+		return nil, fmt.Errorf("no image relation targets the list element .Values.%s, but a later element in the same list is targeted "+
+			"(and neither the base values nor the chart's values.yaml contain this list)", holes[0].String())
+	}
+
+	return yaml.Marshal(&baseDoc)
+}
+
+// valuesTree is a values file represented as a tree of YAML nodes.
+// It is used by BuildLocalizedValues() with an empty root, and by BuildLocalizedValuesOnto() with the base values file as root.
+type valuesTree struct {
+	Root     *yaml.Node // always a mapping node
+	Defaults *yaml.Node // may be nil
+	// list elements that were created as placeholders by Insert()
+	holes []valuesTreeHole
+}
+
+type valuesTreeHole struct {
+	Node *yaml.Node
+	Path ValuePath
+}
+
+// InsertImageRelations inserts the values of all given image relations at their respective target paths.
+func (v *valuesTree) InsertImageRelations(rels ImageRelations, getValue func(ImageRelation) (string, error)) error {
+	for _, rel := range rels {
+		value, err := getValue(*rel)
+		if err != nil {
+			return err
+		}
+		path, err := ParseValuePath(rel.TargetPath)
+		if err != nil {
+			return err
+		}
+		err = v.Insert(path, value)
+		if err != nil {
+			return fmt.Errorf("cannot insert value for .Values.%s: %w", rel.TargetPath, err)
+		}
+	}
+	return nil
+}
+
+// UnfilledHoles returns the paths of all list elements that were created as placeholders by Insert(),
+// but were not filled by a later Insert() or from the defaults.
+func (v *valuesTree) UnfilledHoles() []ValuePath {
+	var result []ValuePath
+	for _, hole := range v.holes {
+		if hole.Node.Kind == yaml.ScalarNode && hole.Node.Tag == "!!null" {
+			result = append(result, hole.Path)
+		}
+	}
+	return result
+}
+
+// Insert sets the value at the given path to the given string, creating maps and lists along the way as needed.
+// Newly created lists are seeded from the same location in v.Defaults, if possible.
+func (v *valuesTree) Insert(path ValuePath, value string) error {
+	node := v.Root
+	for idx, elem := range path {
+		// find or create child node
+		var child *yaml.Node
+		if elem.IsIndex {
+			if node.Kind != yaml.SequenceNode {
+				return fmt.Errorf("cannot insert list element into .Values.%s%s, which is not a list", path[:idx].String(), describeLine(node))
+			}
+			for len(node.Content) <= elem.Index {
+				hole := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+				node.Content = append(node.Content, hole)
+				v.holes = append(v.holes, valuesTreeHole{
+					Node: hole,
+					Path: path[:idx].Append(ValuePathElement{Index: len(node.Content) - 1, IsIndex: true}),
+				})
+			}
+			child = node.Content[elem.Index]
+		} else {
+			if node.Kind != yaml.MappingNode {
+				return fmt.Errorf("cannot insert key %q into .Values.%s%s, which is not a map", elem.Key, path[:idx].String(), describeLine(node))
+			}
+			child = findValueInMappingNode(node, elem.Key)
+			if child == nil {
+				child = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: elem.Key}, child)
+			}
+		}
+
+		// when at the end of the path, write the value
+		if idx == len(path)-1 {
+			if child.Kind != yaml.ScalarNode {
+				return fmt.Errorf("cannot overwrite .Values.%s%s with a string, since it is not a scalar value", path.String(), describeLine(child))
+			}
+			child.Tag = "!!str"
+			child.Value = value
+			if child.Style != yaml.SingleQuotedStyle && child.Style != yaml.DoubleQuotedStyle {
+				child.Style = 0
+			}
+			return nil
+		}
+
+		// otherwise, fill placeholders with an empty map or list (or with the list from the defaults, if any)
+		if child.Kind == yaml.ScalarNode && child.Tag == "!!null" {
+			if path[idx+1].IsIndex {
+				*child = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				defaultNode := lookupYAMLNode(v.Defaults, path[:idx+1])
+				if defaultNode != nil && defaultNode.Kind == yaml.SequenceNode {
+					*child = *cloneYAMLNode(defaultNode)
+				}
+			} else {
+				*child = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+		}
+		node = child
+	}
+	return nil
+}
+
+// Returns a suffix like " (line 42)" for nodes that were parsed from a file, or an empty string for nodes that were created by Insert().
+func describeLine(node *yaml.Node) string {
+	if node.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (line %d)", node.Line)
+}
+
+// Returns the value node for the given key in a mapping node, or nil if the key does not exist.
+func findValueInMappingNode(node *yaml.Node, key string) *yaml.Node {
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if node.Content[idx].Value == key {
+			return node.Content[idx+1]
+		}
+	}
+	return nil
+}
+
+// Returns the node at the given path below the given node, or nil if there is no such node.
+func lookupYAMLNode(node *yaml.Node, path ValuePath) *yaml.Node {
+	for _, elem := range path {
+		switch {
+		case node == nil:
+			return nil
+		case elem.IsIndex:
+			if node.Kind != yaml.SequenceNode || len(node.Content) <= elem.Index {
+				return nil
+			}
+			node = node.Content[elem.Index]
+		default:
+			if node.Kind != yaml.MappingNode {
+				return nil
+			}
+			node = findValueInMappingNode(node, elem.Key)
+		}
+	}
+	return node
+}
+
+// Returns a deep copy of the given node.
+func cloneYAMLNode(node *yaml.Node) *yaml.Node {
+	result := *node
+	result.Content = make([]*yaml.Node, len(node.Content))
+	for idx, child := range node.Content {
+		result.Content[idx] = cloneYAMLNode(child)
+	}
+	return &result
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBuildLocalizedValues(t *testing.T) {
+	rels := mustParseImageRelations(t,
+		".Values.image.repository is repository of quay.io/foo/app:1.0",
+		".Values.image.tag is tag of quay.io/foo/app:1.0",
+		".Values.sidecars[1].image is reference of quay.io/foo/sidecar:2.0",
+		".Values.sidecars[0].image is reference of quay.io/foo/sidecar:1.0",
+		`.Values.podLabels."app.kubernetes.io/version" is tag of quay.io/foo/app:1.0`,
+	)
+	values, err := rels.BuildLocalizedValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := yaml.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"image:",
+		"    repository: quay.io/foo/app",
+		`    tag: "1.0"`,
+		"podLabels:",
+		`    app.kubernetes.io/version: "1.0"`,
+		"sidecars:",
+		"    - image: quay.io/foo/sidecar:1.0",
+		"    - image: quay.io/foo/sidecar:2.0",
+		"",
+	}, "\n")
+	if string(buf) != expected {
+		t.Errorf("expected localized values:\n%s\nbut got:\n%s", expected, string(buf))
+	}
+}
+
+func TestBuildLocalizedValuesErrors(t *testing.T) {
+	testCases := []struct {
+		Inputs        []string
+		ExpectedError string
+	}{
+		{
+			Inputs: []string{".Values.sidecars[1].image is reference of quay.io/foo/sidecar:2.0"},
+			ExpectedError: "no image relation targets the list element .Values.sidecars[0], but a later element in the same list is targeted " +
+				"(since Helm replaces lists wholesale, all elements up to the last targeted one must be targeted)",
+		},
+		{
+			Inputs: []string{
+				".Values.image is reference of quay.io/foo/app:1.0",
+				".Values.image.tag is tag of quay.io/foo/app:1.0",
+			},
+			ExpectedError: `cannot insert value for .Values.image.tag: cannot insert key "tag" into .Values.image, which is not a map`,
+		},
+		{
+			Inputs: []string{
+				".Values.image.tag is tag of quay.io/foo/app:1.0",
+				".Values.image is reference of quay.io/foo/app:1.0",
+			},
+			ExpectedError: "cannot insert value for .Values.image: cannot overwrite .Values.image with a string, since it is not a scalar value",
+		},
+		{
+			Inputs: []string{
+				".Values.sidecars.image is reference of quay.io/foo/sidecar:1.0",
+				".Values.sidecars[0].image is reference of quay.io/foo/sidecar:1.0",
+			},
+			ExpectedError: "cannot insert value for .Values.sidecars[0].image: cannot insert list element into .Values.sidecars, which is not a list",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := mustParseImageRelations(t, tc.Inputs...).BuildLocalizedValues()
+		if err == nil || err.Error() != tc.ExpectedError {
+			t.Errorf("for %q: expected error %q, but got %v", tc.Inputs, tc.ExpectedError, err)
+		}
+	}
+}
+
+func TestBuildLocalizedValuesOnto(t *testing.T) {
+	base := strings.Join([]string{
+		"# comments in the base values are retained",
+		"replicas: 3",
+		"image:",
+		"  repository: 'example.org/app' # quoting style is retained",
+		"",
+	}, "\n")
+	defaults := strings.Join([]string{
+		"sidecars:",
+		"  - name: proxy",
+		"    image: docker.io/envoyproxy/envoy:v1.0",
+		"  - name: exporter",
+		"    image: docker.io/prom/exporter:v1.0",
+		"",
+	}, "\n")
+
+	rels := mustParseImageRelations(t,
+		".Values.image.repository is repository of quay.io/foo/app:1.0",
+		".Values.sidecars[1].image is reference of quay.io/foo/exporter:2.0",
+	)
+	buf, err := rels.BuildLocalizedValuesOnto([]byte(base), []byte(defaults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sidecars list does not exist in the base values, so it is seeded from the defaults
+	expected := strings.Join([]string{
+		"# comments in the base values are retained",
+		"replicas: 3",
+		"image:",
+		"    repository: 'quay.io/foo/app' # quoting style is retained",
+		"sidecars:",
+		"    - name: proxy",
+		"      image: docker.io/envoyproxy/envoy:v1.0",
+		"    - name: exporter",
+		"      image: quay.io/foo/exporter:2.0",
+		"",
+	}, "\n")
+	if string(buf) != expected {
+		t.Errorf("expected values:\n%s\nbut got:\n%s", expected, string(buf))
+	}
+
+	// without defaults, the hole in the list is reported
+	_, err = rels.BuildLocalizedValuesOnto([]byte(base), nil)
+	expectedError := "no image relation targets the list element .Values.sidecars[0], but a later element in the same list is targeted " +
+		"(and neither the base values nor the chart's values.yaml contain this list)"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+
+	// errors for nodes from the base values file include line numbers
+	_, err = rels.BuildLocalizedValuesOnto([]byte("image: example.org/app:1.0\n"), nil)
+	expectedError = `cannot insert value for .Values.image.repository: cannot insert key "repository" into .Values.image (line 1), which is not a map`
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, but got %v", expectedError, err)
+	}
+}
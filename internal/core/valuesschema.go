@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ValidateValuesSchema implements `bundle --validate-schema`.
+// If this chart (or any of its subcharts below `charts/`) has a values.schema.json, the chart's values are validated against it,
+// first with only the chart's own values.yaml, and then with the localized-values.yaml from the given image relations applied on top.
+// Like in Helm, the schema of each subchart applies to the values below the subchart's name (or alias).
+//
+// Returns warnings about parts of the schemas that could not be checked.
+func (c HelmChart) ValidateValuesSchema(rels ImageRelations) (warnings []string, err error) {
+	files, err := readDirectoryRecursively(c.ChartPath)
+	if err != nil {
+		return nil, err
+	}
+	_, values, err := computeEffectiveValues(files, false)
+	if err != nil {
+		return nil, fmt.Errorf("while reading values of %s: %w", c.ChartPath, err)
+	}
+
+	violations, warnings, err := validateValuesAgainstSchemas(files, values, nil)
+	if err != nil {
+		return nil, fmt.Errorf("while validating values of %s: %w", c.ChartPath, err)
+	}
+	if len(violations) > 0 {
+		return warnings, fmt.Errorf("values.yaml of chart %q does not match values.schema.json: %s",
+			c.Name, strings.Join(violations, "; "))
+	}
+
+	localizedValues, err := rels.BuildLocalizedValues()
+	if err != nil {
+		return warnings, fmt.Errorf("while building localized-values.yaml for chart %q: %w", c.Name, err)
+	}
+	violations, moreWarnings, err := validateValuesAgainstSchemas(files, coalesceValues(localizedValues, values), nil)
+	if err != nil {
+		return warnings, fmt.Errorf("while validating values of %s: %w", c.ChartPath, err)
+	}
+	for _, warning := range moreWarnings {
+		if !slices.Contains(warnings, warning) {
+			warnings = append(warnings, warning)
+		}
+	}
+	if len(violations) > 0 {
+		return warnings, fmt.Errorf("values of chart %q do not match values.schema.json when localized-values.yaml is applied (please check the target paths of the image relations): %s",
+			c.Name, strings.Join(violations, "; "))
+	}
+	return warnings, nil
+}
+
+// Validates the given values against the values.schema.json of a chart and all of its subcharts.
+// The input contains the chart's files, with paths relative to the chart's root directory.
+// The path is where the values are located within the values of the top-level chart.
+// Returns violations of the schemas, as well as warnings about parts of the schemas that could not be checked.
+func validateValuesAgainstSchemas(files map[string][]byte, values map[string]any, path ValuePath) (violations, warnings []string, err error) {
+	chart, err := readChartMetadataFromFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if buf, exists := files["values.schema.json"]; exists {
+		schema, err := parseJSONSchema(buf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while parsing values.schema.json of chart %q: %w", chart.Name, err)
+		}
+		violations = schema.Validate(values, path)
+		for _, warning := range schema.Warnings() {
+			warnings = append(warnings, fmt.Sprintf("in values.schema.json of chart %q: %s", chart.Name, warning))
+		}
+	}
+
+	subchartFiles, err := collectSubchartFiles(files)
+	if err != nil {
+		return nil, nil, fmt.Errorf("in chart %q: %w", chart.Name, err)
+	}
+	for _, key := range slices.Sorted(maps.Keys(subchartFiles)) {
+		subchart, err := readChartMetadataFromFiles(subchartFiles[key])
+		if err != nil {
+			return nil, nil, fmt.Errorf("while reading charts/%s in chart %q: %w", key, chart.Name, err)
+		}
+		for _, valuesKey := range chart.subchartValuesKeys(subchart.Name) {
+			subchartValues, _ := values[valuesKey].(map[string]any)
+			subchartValues = withParentGlobals(subchartValues, values)
+			subchartPath := path.Append(ValuePathElement{Key: valuesKey})
+			moreViolations, moreWarnings, err := validateValuesAgainstSchemas(subchartFiles[key], subchartValues, subchartPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("while validating charts/%s in chart %q: %w", key, chart.Name, err)
+			}
+			violations = append(violations, moreViolations...)
+			warnings = append(warnings, moreWarnings...)
+		}
+	}
+	return violations, warnings, nil
+}
+
+// Returns the values of a subchart as Helm presents them to the subchart's schema:
+// The "global" key is always present, and contains the globals of the parent chart on top of the subchart's own globals.
+// This matches coalesceGlobals() in Helm.
+func withParentGlobals(subchartValues, parentValues map[string]any) map[string]any {
+	ownGlobals, isOwnMap := subchartValues["global"].(map[string]any)
+	parentGlobals, isParentMap := parentValues["global"].(map[string]any)
+	if (subchartValues["global"] != nil && !isOwnMap) || (parentValues["global"] != nil && !isParentMap) {
+		return subchartValues // Helm skips globals that are not maps
+	}
+	result := maps.Clone(subchartValues)
+	if result == nil {
+		result = make(map[string]any)
+	}
+	result["global"] = coalesceValues(parentGlobals, ownGlobals)
+	return result
+}
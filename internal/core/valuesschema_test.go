@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateValuesSchemaWithGlobals(t *testing.T) {
+	files := map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: parent\nversion: 1.0.0\n",
+		"values.yaml": "global:\n  region: eu-de-1\nsub:\n  replicas: 2\n",
+
+		"charts/sub/Chart.yaml":  "apiVersion: v2\nname: sub\nversion: 1.0.0\n",
+		"charts/sub/values.yaml": "replicas: 1\nglobal:\n  region: default\n  tier: prod\n",
+		"charts/sub/values.schema.json": `{
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"type": "object",
+			"required": ["global"],
+			"properties": {
+				"replicas": {"type": "integer", "minimum": 1},
+				"global": {
+					"type": "object",
+					"required": ["region", "tier"],
+					"properties": {
+						"region": {"type": "string", "enum": ["eu-de-1", "eu-nl-1"]},
+						"tier": {"type": "string", "pattern": "^(?!dev)"}
+					}
+				}
+			},
+			"additionalProperties": false
+		}`,
+	}
+
+	// the subchart sees the parent's globals on top of its own
+	chart := newTestChart(t, files)
+	warnings, err := chart.ValidateValuesSchema(nil)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	// the pattern is only reported once, even though the values are validated twice
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], `in values.schema.json of chart "sub": cannot check values against the pattern "^(?!dev)": `) {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	files["values.yaml"] = "global:\n  region: us-west-1\n"
+	chart = newTestChart(t, files)
+	_, err = chart.ValidateValuesSchema(nil)
+	if err == nil || !strings.Contains(err.Error(), `at .Values.sub.global.region: expected one of ["eu-de-1","eu-nl-1"], but got "us-west-1"`) {
+		t.Errorf("expected violation for parent global, but got %v", err)
+	}
+}
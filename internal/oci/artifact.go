@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// Artifact is an OCI artifact (usually an image or an image index),
+// together with all the manifests and blobs that it refers to.
+//
+// Manifests are held in memory, but since blobs can be arbitrarily large,
+// their contents are only read from the originating repository when needed.
+type Artifact struct {
+	// describes the top-level manifest
+	Root Descriptor
+	// all manifests in this artifact (including the root manifest), ordered such that referenced manifests come before referencing ones
+	Manifests []Descriptor
+	// contents of all manifests in this artifact
+	ManifestContents map[string][]byte // key = digest
+	// all blobs (configs and layers) in this artifact, sorted by digest and without duplicates
+	Blobs []Descriptor
+	// the repository that this artifact was fetched from
+	repo Repository
+}
+
+// Sink is a storage location that an Artifact can be pushed into.
+type Sink interface {
+	// PutBlob uploads a blob (a config or layer) into the sink, unless it already exists there.
+	// The contents are obtained by calling the given function, which may be called more than once (e.g. to retry an upload).
+	PutBlob(ctx context.Context, desc Descriptor, open func() (io.ReadCloser, error)) error
+	// PutManifest uploads a manifest into the sink.
+	// The reference can either be a tag, or the digest of the manifest.
+	PutManifest(ctx context.Context, reference string, desc Descriptor, buf []byte) error
+}
+
+// FetchArtifact downloads all manifests of a complete artifact from the given repository.
+// The reference can either be a tag or a digest.
+//
+// Blobs are not downloaded yet. Their contents are read from the repository when calling OpenBlob() or PushTo().
+func FetchArtifact(ctx context.Context, repo Repository, reference string) (Artifact, error) {
+	desc, buf, err := repo.GetManifest(ctx, reference)
+	if err != nil {
+		return Artifact{}, err
+	}
+	a := Artifact{Root: desc, ManifestContents: make(map[string][]byte), repo: repo}
+	blobs := make(map[string]Descriptor)
+	err = a.collect(desc, buf, blobs, func(child Descriptor) ([]byte, error) {
+		_, buf, err := repo.GetManifest(ctx, child.Digest)
+		return buf, err
+	})
+	if err != nil {
+		return Artifact{}, err
+	}
+	a.Root = a.Manifests[len(a.Manifests)-1] // this has the MediaType filled in by collect()
+	for _, digest := range slices.Sorted(maps.Keys(blobs)) {
+		a.Blobs = append(a.Blobs, blobs[digest])
+	}
+	return a, nil
+}
+
+// Adds the given manifest and all manifests referenced by it to a.ManifestContents and a.Manifests,
+// and collects the descriptors of all referenced blobs into the given map.
+func (a *Artifact) collect(desc Descriptor, buf []byte, blobs map[string]Descriptor, fetchManifest func(Descriptor) ([]byte, error)) error {
+	var manifest Manifest
+	err := json.Unmarshal(buf, &manifest)
+	if err != nil {
+		return fmt.Errorf("could not parse manifest %s: %w", desc.Digest, err)
+	}
+	if desc.MediaType == "" {
+		desc.MediaType = manifest.MediaType
+	}
+
+	for _, child := range manifest.Manifests {
+		if _, exists := a.ManifestContents[child.Digest]; exists {
+			continue
+		}
+		childBuf, err := fetchManifest(child)
+		if err != nil {
+			return err
+		}
+		err = a.collect(child, childBuf, blobs, fetchManifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	refs := slices.Clone(manifest.Layers)
+	if manifest.Config != nil {
+		refs = append(refs, *manifest.Config)
+	}
+	for _, blob := range refs {
+		if _, exists := blobs[blob.Digest]; !exists {
+			blobs[blob.Digest] = blob
+		}
+	}
+
+	a.ManifestContents[desc.Digest] = buf
+	a.Manifests = append(a.Manifests, desc)
+	return nil
+}
+
+// OpenBlob opens one of the blobs in this artifact for reading.
+// The returned reader verifies the digest of the contents once it reaches EOF.
+func (a Artifact) OpenBlob(ctx context.Context, desc Descriptor) (io.ReadCloser, error) {
+	return a.repo.OpenBlob(ctx, desc.Digest)
+}
+
+// PushTo uploads this artifact into the given sink, and tags the root manifest with the given tag.
+// If the tag is empty, the root manifest is only stored by its digest.
+func (a Artifact) PushTo(ctx context.Context, sink Sink, tag string) error {
+	// blobs need to be pushed first, since registries may refuse manifests referring to nonexistent blobs
+	for _, desc := range a.Blobs {
+		err := sink.PutBlob(ctx, desc, func() (io.ReadCloser, error) {
+			return a.OpenBlob(ctx, desc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, desc := range a.Manifests {
+		reference := desc.Digest
+		if desc.Digest == a.Root.Digest && tag != "" {
+			reference = tag
+		}
+		err := sink.PutManifest(ctx, reference, desc, a.ManifestContents[desc.Digest])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
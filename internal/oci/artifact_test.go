@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testImage describes the contents of the image index created by newTestImageLayout().
+type testImage struct {
+	Index      Descriptor
+	Manifests  []Descriptor // the platform-specific manifests
+	Blobs      []Descriptor // configs and layers, sorted by digest
+	BlobByName map[string]Descriptor
+}
+
+// Creates an OCI image layout in a temporary directory, containing an image index tagged as "1.0".
+// The index contains two platform-specific images that share their base layer.
+func newTestImageLayout(t *testing.T) (*ImageLayout, testImage) {
+	t.Helper()
+	layout, err := OpenImageLayout(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+
+	result := testImage{BlobByName: make(map[string]Descriptor)}
+	putBlob := func(name, mediaType string, buf []byte) Descriptor {
+		desc := Descriptor{MediaType: mediaType, Digest: DigestOf(buf), Size: int64(len(buf))}
+		err := layout.PutBlob(ctx, desc, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.BlobByName[name] = desc
+		return desc
+	}
+	putManifest := func(reference string, manifest Manifest) Descriptor {
+		buf, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc := Descriptor{MediaType: manifest.MediaType, Digest: DigestOf(buf), Size: int64(len(buf))}
+		if reference == "" {
+			reference = desc.Digest
+		}
+		err = layout.PutManifest(ctx, reference, desc, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+
+	baseLayer := putBlob("base", "application/vnd.oci.image.layer.v1.tar", bytes.Repeat([]byte("base layer\n"), 1000))
+	for _, arch := range []string{"amd64", "arm64"} {
+		config := putBlob("config-"+arch, "application/vnd.oci.image.config.v1+json", []byte(`{"architecture":"`+arch+`","os":"linux"}`))
+		layer := putBlob("layer-"+arch, "application/vnd.oci.image.layer.v1.tar", []byte("binary for "+arch))
+		result.Manifests = append(result.Manifests, putManifest("", Manifest{
+			SchemaVersion: 2,
+			MediaType:     ImageManifestMediaType,
+			Config:        &config,
+			Layers:        []Descriptor{baseLayer, layer},
+		}))
+	}
+	result.Index = putManifest("1.0", Manifest{
+		SchemaVersion: 2,
+		MediaType:     ImageIndexMediaType,
+		Manifests:     result.Manifests,
+	})
+
+	for _, desc := range result.BlobByName {
+		result.Blobs = append(result.Blobs, desc)
+	}
+	slices.SortFunc(result.Blobs, func(lhs, rhs Descriptor) int {
+		return strings.Compare(lhs.Digest, rhs.Digest)
+	})
+	return layout, result
+}
+
+func digestsOf(descs []Descriptor) []string {
+	result := make([]string, len(descs))
+	for idx, desc := range descs {
+		result[idx] = desc.Digest
+	}
+	return result
+}
+
+// Checks that the artifact has the structure of the artifact created by newTestImageLayout().
+func checkTestArtifact(t *testing.T, a Artifact, expected testImage) {
+	t.Helper()
+	if a.Root.Digest != expected.Index.Digest || a.Root.MediaType != ImageIndexMediaType || a.Root.Size != expected.Index.Size {
+		t.Errorf("expected root %#v, but got %#v", expected.Index, a.Root)
+	}
+
+	// referenced manifests must come before the referencing index
+	expectedManifests := append(digestsOf(expected.Manifests), expected.Index.Digest)
+	if actual := digestsOf(a.Manifests); !slices.Equal(actual, expectedManifests) {
+		t.Errorf("expected manifests %v, but got %v", expectedManifests, actual)
+	}
+	for _, desc := range a.Manifests {
+		if DigestOf(a.ManifestContents[desc.Digest]) != desc.Digest {
+			t.Errorf("contents of manifest %s do not match its digest", desc.Digest)
+		}
+	}
+
+	// the base layer is shared between both images, but must only be listed once
+	if actual, expectedBlobs := digestsOf(a.Blobs), digestsOf(expected.Blobs); !slices.Equal(actual, expectedBlobs) {
+		t.Errorf("expected blobs %v, but got %v", expectedBlobs, actual)
+	}
+	for _, desc := range a.Blobs {
+		reader, err := a.OpenBlob(t.Context(), desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Errorf("could not read blob %s: %s", desc.Digest, err.Error())
+		}
+		if int64(len(buf)) != desc.Size {
+			t.Errorf("expected blob %s to have %d bytes, but got %d bytes", desc.Digest, desc.Size, len(buf))
+		}
+	}
+}
+
+func TestFetchArtifact(t *testing.T) {
+	layout, expected := newTestImageLayout(t)
+
+	a, err := FetchArtifact(t.Context(), layout, "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestArtifact(t, a, expected)
+
+	// fetching by digest yields the same result
+	a, err = FetchArtifact(t.Context(), layout, expected.Index.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestArtifact(t, a, expected)
+
+	_, err = FetchArtifact(t.Context(), layout, "2.0")
+	var nfe *NotFoundError
+	if !errors.As(err, &nfe) {
+		t.Errorf("expected NotFoundError for unknown tag, but got %v", err)
+	}
+}
+
+func TestPushArtifactIntoImageLayout(t *testing.T) {
+	source, expected := newTestImageLayout(t)
+	a, err := FetchArtifact(t.Context(), source, "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := OpenImageLayout(filepath.Join(t.TempDir(), "target"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.PushTo(t.Context(), target, "foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pushing again must be idempotent
+	err = a.PushTo(t.Context(), target, "foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := target.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != expected.Index.Digest || index.Manifests[0].Annotations[imageRefNameAnnotation] != "foo/bar:1.0" {
+		t.Errorf("unexpected index.json in target: %#v", index)
+	}
+
+	pushed, err := FetchArtifact(t.Context(), target, "foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestArtifact(t, pushed, expected)
+}
+
+func TestPushArtifactWithCorruptedBlob(t *testing.T) {
+	source, expected := newTestImageLayout(t)
+	a, err := FetchArtifact(t.Context(), source, "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt a blob in the source after the artifact has been fetched
+	corrupted := expected.BlobByName["layer-arm64"]
+	err = os.WriteFile(source.blobPath(corrupted.Digest), []byte("binary for ARM64"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := OpenImageLayout(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.PushTo(t.Context(), target, "1.0")
+	var dme *DigestMismatchError
+	if !errors.As(err, &dme) || dme.Expected != corrupted.Digest {
+		t.Fatalf("expected DigestMismatchError for %s, but got %v", corrupted.Digest, err)
+	}
+
+	// the corrupted blob must not appear in the target, not even partially
+	_, err = os.Stat(target.blobPath(corrupted.Digest))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected corrupted blob to be absent from target, but stat returned %v", err)
+	}
+	_, err = os.Stat(target.blobPath(corrupted.Digest) + ".partial")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no partial file in target, but stat returned %v", err)
+	}
+}
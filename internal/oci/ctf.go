@@ -0,0 +1,570 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+// ArtifactIndex is the contents of the artifact-index.json file in a CTF archive.
+type ArtifactIndex struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Artifacts     []ArtifactIndexEntry `json:"artifacts"`
+}
+
+// ArtifactIndexEntry appears in type ArtifactIndex.
+type ArtifactIndexEntry struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest"`
+	MediaType  string `json:"mediaType,omitempty"`
+}
+
+const ctfArtifactIndexFileName = "artifact-index.json"
+
+// Returns the path of a blob within a CTF archive.
+func ctfBlobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", ".", 1))
+}
+
+// CTF is a read-only view of an archive in the Common Transport Format (CTF), the file-based storage format of OCM.
+// A CTF archive can either be a directory, or a tar file (optionally gzip-compressed) containing that same directory structure.
+//
+// A CTF archive contains multiple OCI repositories that share the same blob storage.
+type CTF struct {
+	Path  string
+	Index ArtifactIndex
+	// opens a file from within the archive for reading (path is relative to the archive root)
+	openFile func(relPath string) (io.ReadCloser, error)
+}
+
+// OpenCTF opens the CTF archive at the given path.
+func OpenCTF(archivePath string) (*CTF, error) {
+	fi, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctf := &CTF{Path: archivePath}
+	if fi.IsDir() {
+		ctf.openFile = func(relPath string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(archivePath, filepath.FromSlash(relPath)))
+		}
+	} else {
+		ctf.openFile, err = indexCTFTarball(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("while reading CTF archive %s: %w", archivePath, err)
+		}
+	}
+
+	buf, err := ctf.readFile(ctfArtifactIndexFileName)
+	if err != nil {
+		return nil, fmt.Errorf("while reading CTF archive %s: %w", archivePath, err)
+	}
+	err = json.Unmarshal(buf, &ctf.Index)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s in CTF archive %s: %w", ctfArtifactIndexFileName, archivePath, err)
+	}
+	return ctf, nil
+}
+
+// Reads a file from within the archive into memory.
+func (c *CTF) readFile(relPath string) ([]byte, error) {
+	reader, err := c.openFile(relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Scans a CTF tarball and returns a function that can open files within it.
+//
+// For uncompressed tarballs, the file contents are read from disk on demand.
+// For compressed tarballs, random access is not possible, so all contents are held in memory instead.
+func indexCTFTarball(archivePath string) (func(string) (io.ReadCloser, error), error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var magic [2]byte
+	_, err = io.ReadFull(file, magic[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	isCompressed := magic == [2]byte{0x1f, 0x8b}
+
+	var (
+		reader   = &countingReader{Reader: file}
+		contents = make(map[string][]byte) // only for compressed tarballs
+		sections = make(map[string][2]int64)
+	)
+	var tr *tar.Reader
+	if isCompressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		tr = tar.NewReader(gz)
+	} else {
+		tr = tar.NewReader(reader)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if isCompressed {
+			contents[name], err = io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			sections[name] = [2]int64{reader.Count, hdr.Size}
+		}
+	}
+
+	notFound := func(relPath string) error {
+		return fmt.Errorf("file %s not found in %s: %w", relPath, archivePath, os.ErrNotExist)
+	}
+	if isCompressed {
+		return func(relPath string) (io.ReadCloser, error) {
+			buf, exists := contents[relPath]
+			if !exists {
+				return nil, notFound(relPath)
+			}
+			return io.NopCloser(bytes.NewReader(buf)), nil
+		}, nil
+	}
+	return func(relPath string) (io.ReadCloser, error) {
+		section, exists := sections[relPath]
+		if !exists {
+			return nil, notFound(relPath)
+		}
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		return sectionReadCloser{io.NewSectionReader(file, section[0], section[1]), file}, nil
+	}, nil
+}
+
+// Reads a section of a file, and closes the file when done.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+type countingReader struct {
+	io.Reader
+	Count int64
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.Reader.Read(buf)
+	r.Count += int64(n)
+	return n, err
+}
+
+// Repository returns a view of the repository with the given name within this archive.
+func (c *CTF) Repository(name string) Repository {
+	return ctfRepository{ctf: c, name: name}
+}
+
+// ctfRepository implements the Repository interface for a CTF archive.
+type ctfRepository struct {
+	ctf  *CTF
+	name string
+}
+
+// GetManifest implements the Repository interface.
+func (r ctfRepository) GetManifest(ctx context.Context, reference string) (Descriptor, []byte, error) {
+	var entry *ArtifactIndexEntry
+	for idx, e := range r.ctf.Index.Artifacts {
+		if e.Repository == r.name && (e.Tag == reference || e.Digest == reference) {
+			entry = &r.ctf.Index.Artifacts[idx]
+			break
+		}
+	}
+	if entry == nil {
+		return Descriptor{}, nil, &NotFoundError{Description: fmt.Sprintf("manifest %s:%s in %s", r.name, reference, r.ctf.Path)}
+	}
+
+	buf, err := ReadBlob(ctx, r, entry.Digest)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	mediaType := entry.MediaType
+	if mediaType == "" {
+		var manifest Manifest
+		err := json.Unmarshal(buf, &manifest)
+		if err != nil {
+			return Descriptor{}, nil, fmt.Errorf("while parsing manifest %s:%s in %s: %w", r.name, reference, r.ctf.Path, err)
+		}
+		mediaType = manifest.MediaType
+	}
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    entry.Digest,
+		Size:      int64(len(buf)),
+	}, buf, nil
+}
+
+// OpenBlob implements the Repository interface.
+func (r ctfRepository) OpenBlob(_ context.Context, digest string) (io.ReadCloser, error) {
+	reader, err := r.ctf.openFile(ctfBlobPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &NotFoundError{Description: fmt.Sprintf("blob %s in %s", digest, r.ctf.Path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newVerifyingReader(reader, digest)
+}
+
+// CTFBuilder assembles a new CTF archive.
+//
+// Blobs are written to disk as soon as they are added, so that they do not need to be held in memory:
+// For directory archives, blobs go directly into the archive directory.
+// For tarball archives, blobs go into a staging directory next to the tarball, which is removed once the tarball is complete.
+type CTFBuilder struct {
+	archivePath string
+	workDir     string // contains the archive's directory structure while it is being assembled
+	index       ArtifactIndex
+	blobs       map[string]int64 // key = digest, value = size
+	finished    bool
+}
+
+// NewCTFBuilder prepares the creation of a CTF archive at the given path, which must not exist yet.
+//
+// If the path ends in ".tar", ".tgz" or ".tar.gz", the archive is written as a tarball (compressed if appropriate).
+// Otherwise, the archive is written as a directory.
+//
+// Once all contents have been added, Finish() must be called to complete the archive.
+// Discard() should be deferred to clean up in case of errors.
+func NewCTFBuilder(archivePath string) (*CTFBuilder, error) {
+	_, err := os.Lstat(archivePath)
+	if err == nil {
+		return nil, fmt.Errorf("cannot write CTF archive to %s: file exists", archivePath)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(archivePath), 0777) // NOTE: final mode is subject to umask
+	if err != nil {
+		return nil, err
+	}
+
+	workDir := archivePath
+	if isTarball, _ := ctfTarballFormat(archivePath); isTarball {
+		workDir, err = os.MkdirTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".tmp-*")
+	} else {
+		err = os.Mkdir(archivePath, 0777) // NOTE: final mode is subject to umask
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = os.Mkdir(filepath.Join(workDir, "blobs"), 0777) // NOTE: final mode is subject to umask
+	if err != nil {
+		return nil, errors.Join(err, os.RemoveAll(workDir))
+	}
+
+	return &CTFBuilder{
+		archivePath: archivePath,
+		workDir:     workDir,
+		index:       ArtifactIndex{SchemaVersion: 1},
+		blobs:       make(map[string]int64),
+	}, nil
+}
+
+// Returns whether the archive at the given path shall be written as a tarball, and if so, whether to compress it.
+func ctfTarballFormat(archivePath string) (isTarball, compress bool) {
+	switch {
+	case strings.HasSuffix(archivePath, ".tar"):
+		return true, false
+	case strings.HasSuffix(archivePath, ".tgz"), strings.HasSuffix(archivePath, ".tar.gz"):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// AddBlob adds a blob to the archive, and returns a descriptor for it.
+func (b *CTFBuilder) AddBlob(mediaType string, buf []byte) (Descriptor, error) {
+	return b.AddBlobFrom(mediaType, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// AddBlobFrom is like AddBlob, but the blob contents are streamed into the archive by the given callback.
+func (b *CTFBuilder) AddBlobFrom(mediaType string, write func(io.Writer) error) (Descriptor, error) {
+	file, err := os.CreateTemp(filepath.Join(b.workDir, "blobs"), ".tmp-*")
+	if err != nil {
+		return Descriptor{}, err
+	}
+	hash := sha256.New()
+	counter := &countingWriter{Writer: io.MultiWriter(file, hash)}
+	err = write(counter)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Descriptor{}, errors.Join(err, os.Remove(file.Name()))
+	}
+
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if _, exists := b.blobs[digest]; exists {
+		err = os.Remove(file.Name())
+	} else {
+		err = os.Rename(file.Name(), filepath.Join(b.workDir, filepath.FromSlash(ctfBlobPath(digest))))
+	}
+	if err != nil {
+		return Descriptor{}, err
+	}
+	b.blobs[digest] = counter.Count
+	return Descriptor{
+		MediaType: mediaType,
+		Digest:    digest,
+		Size:      counter.Count,
+	}, nil
+}
+
+type countingWriter struct {
+	io.Writer
+	Count int64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.Writer.Write(buf)
+	w.Count += int64(n)
+	return n, err
+}
+
+// AddManifest adds a manifest to the archive, and tags it in the given repository.
+// All blobs referenced by the manifest must have been added with AddBlob() before.
+func (b *CTFBuilder) AddManifest(repository, tag string, manifest Manifest) (Descriptor, error) {
+	refs := slices.Clone(manifest.Layers)
+	if manifest.Config != nil {
+		refs = append(refs, *manifest.Config)
+	}
+	for _, ref := range append(refs, manifest.Manifests...) {
+		if _, exists := b.blobs[ref.Digest]; !exists {
+			return Descriptor{}, fmt.Errorf("cannot add manifest %s:%s: referenced blob %s has not been added yet", repository, tag, ref.Digest)
+		}
+	}
+
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("cannot add manifest %s:%s: %w", repository, tag, err)
+	}
+	desc, err := b.AddBlob(manifest.MediaType, buf)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("cannot add manifest %s:%s: %w", repository, tag, err)
+	}
+	b.index.Artifacts = append(b.index.Artifacts, ArtifactIndexEntry{
+		Repository: repository,
+		Tag:        tag,
+		Digest:     desc.Digest,
+		MediaType:  desc.MediaType,
+	})
+	return desc, nil
+}
+
+// Finish completes the archive by writing its index (and, for tarball archives, by packing the tarball).
+//
+// The output is reproducible: When the same contents are added, the same bytes will be written.
+func (b *CTFBuilder) Finish() error {
+	indexBuf, err := json.Marshal(b.index)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(b.workDir, ctfArtifactIndexFileName), indexBuf, 0666) // NOTE: final mode is subject to umask
+	if err != nil {
+		return err
+	}
+
+	if isTarball, compress := ctfTarballFormat(b.archivePath); isTarball {
+		err = b.writeTarball(compress)
+		if err != nil {
+			return err
+		}
+		err = os.RemoveAll(b.workDir)
+		if err != nil {
+			return err
+		}
+	}
+	b.finished = true
+	return nil
+}
+
+// Discard removes everything that was written by this builder, unless Finish() has completed successfully.
+// This is intended to be deferred right after NewCTFBuilder().
+func (b *CTFBuilder) Discard() {
+	if b.finished {
+		return
+	}
+	err := errors.Join(os.RemoveAll(b.workDir), os.RemoveAll(b.archivePath))
+	if err != nil {
+		logg.Error("while cleaning up incomplete CTF archive %s: %s", b.archivePath, err.Error())
+	}
+}
+
+func (b *CTFBuilder) writeTarball(compress bool) (returnedErr error) {
+	file, err := os.Create(b.archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := file.Close()
+		if returnedErr == nil {
+			returnedErr = err
+		}
+	}()
+
+	var writer io.Writer = file
+	if compress {
+		gz := gzip.NewWriter(file)
+		defer func() {
+			err := gz.Close()
+			if returnedErr == nil {
+				returnedErr = err
+			}
+		}()
+		writer = gz
+	}
+
+	// NOTE: The tarball writer requires files in sorted order.
+	// "artifact-index.json" sorts before all blob paths.
+	relPaths := []string{ctfArtifactIndexFileName}
+	for _, digest := range slices.Sorted(maps.Keys(b.blobs)) {
+		relPaths = append(relPaths, ctfBlobPath(digest))
+	}
+	tw := newTarballWriter(writer)
+	for _, relPath := range relPaths {
+		err := b.copyFileInto(tw, relPath)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (b *CTFBuilder) copyFileInto(tw *tarballWriter, relPath string) error {
+	file, err := os.Open(filepath.Join(b.workDir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return tw.WriteFile(relPath, fi.Size(), file)
+}
+
+// WriteTarball writes a tarball containing the given files into the given writer.
+// Directory entries are generated as needed.
+// All file attributes besides name and contents are set to fixed values, to make the output reproducible.
+func WriteTarball(writer io.Writer, files map[string][]byte) error {
+	tw := newTarballWriter(writer)
+	for _, relPath := range slices.Sorted(maps.Keys(files)) {
+		buf := files[relPath]
+		err := tw.WriteFile(relPath, int64(len(buf)), bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// tarballWriter writes tarballs in the same way as WriteTarball(), but streams file contents from readers.
+// Files must be written in sorted order to obtain the same output as WriteTarball().
+type tarballWriter struct {
+	tw     *tar.Writer
+	hasDir map[string]bool
+}
+
+func newTarballWriter(writer io.Writer) *tarballWriter {
+	return &tarballWriter{tw: tar.NewWriter(writer), hasDir: make(map[string]bool)}
+}
+
+// WriteFile writes a file with the given size, whose contents are read from the given reader.
+func (t *tarballWriter) WriteFile(relPath string, size int64, contents io.Reader) error {
+	// generate entries for parent directories, if not done yet
+	var parents []string
+	for dir := path.Dir(relPath); dir != "." && !t.hasDir[dir]; dir = path.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	slices.Reverse(parents)
+	for _, dir := range parents {
+		err := t.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0755,
+			ModTime:  time.Unix(0, 0),
+		})
+		if err != nil {
+			return err
+		}
+		t.hasDir[dir] = true
+	}
+
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     relPath,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(t.tw, contents)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("expected %s to contain %d bytes, but got %d bytes", relPath, size, n)
+	}
+	return nil
+}
+
+// Close finishes the tarball. It does not close the underlying writer.
+func (t *tarballWriter) Close() error {
+	return t.tw.Close()
+}
@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Fills a CTFBuilder with a manifest that references one blob of each kind (in-memory and streamed).
+func fillTestCTF(t *testing.T, b *CTFBuilder) (manifest, config, layer Descriptor) {
+	t.Helper()
+	config, err := b.AddBlob("application/vnd.example.config+json", []byte(`{"hello":"world"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err = b.AddBlobFrom("application/octet-stream", func(w io.Writer) error {
+		for range 100 {
+			_, err := w.Write([]byte("streamed layer contents\n"))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// adding the same contents again must not produce a second blob
+	duplicate, err := b.AddBlob("application/octet-stream", bytes.Repeat([]byte("streamed layer contents\n"), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicate.Digest != layer.Digest || duplicate.Size != layer.Size {
+		t.Errorf("expected duplicate blob to have descriptor %#v, but got %#v", layer, duplicate)
+	}
+
+	manifest, err = b.AddManifest("component-descriptors/example.org/foo", "1.0.0", Manifest{
+		SchemaVersion: 2,
+		MediaType:     ImageManifestMediaType,
+		Config:        &config,
+		Layers:        []Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest, config, layer
+}
+
+func TestCTFBuilderRoundTrip(t *testing.T) {
+	for _, fileName := range []string{"archive", "archive.tar", "archive.tgz", "archive.tar.gz"} {
+		t.Run(fileName, func(t *testing.T) {
+			parentDir := t.TempDir()
+			archivePath := filepath.Join(parentDir, fileName)
+			b, err := NewCTFBuilder(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Discard()
+			manifest, config, layer := fillTestCTF(t, b)
+			err = b.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// no staging files may be left behind next to the archive
+			entries, err := os.ReadDir(parentDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != fileName {
+				t.Errorf("expected only %s in parent directory, but found %d entries", fileName, len(entries))
+			}
+
+			ctf, err := OpenCTF(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ctf.Index.Artifacts) != 1 {
+				t.Fatalf("expected 1 artifact in index, but got %#v", ctf.Index)
+			}
+			repo := ctf.Repository("component-descriptors/example.org/foo")
+			desc, buf, err := repo.GetManifest(t.Context(), "1.0.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != manifest.Digest || desc.MediaType != ImageManifestMediaType || DigestOf(buf) != manifest.Digest {
+				t.Errorf("expected manifest %#v, but got %#v", manifest, desc)
+			}
+			for _, blob := range []Descriptor{config, layer} {
+				buf, err := ReadBlob(t.Context(), repo, blob.Digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if int64(len(buf)) != blob.Size {
+					t.Errorf("expected blob %s to have %d bytes, but got %d", blob.Digest, blob.Size, len(buf))
+				}
+			}
+
+			_, _, err = repo.GetManifest(t.Context(), "2.0.0")
+			var nfe *NotFoundError
+			if !errors.As(err, &nfe) {
+				t.Errorf("expected NotFoundError for unknown tag, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestCTFBuilderIsReproducible(t *testing.T) {
+	var archives [][]byte
+	for range 2 {
+		archivePath := filepath.Join(t.TempDir(), "archive.tgz")
+		b, err := NewCTFBuilder(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Discard()
+		fillTestCTF(t, b)
+		err = b.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := os.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, buf)
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Error("building the same CTF archive twice did not yield the same bytes")
+	}
+}
+
+func TestCTFBuilderErrors(t *testing.T) {
+	// target path must not exist yet
+	_, err := NewCTFBuilder(t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "file exists") {
+		t.Errorf("expected error for existing target path, but got %v", err)
+	}
+
+	// manifests may only reference blobs that were added before
+	archivePath := filepath.Join(t.TempDir(), "archive")
+	b, err := NewCTFBuilder(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Discard()
+	dangling := Descriptor{MediaType: "application/octet-stream", Digest: DigestOf([]byte("missing")), Size: 7}
+	_, err = b.AddManifest("foo", "1.0", Manifest{SchemaVersion: 2, MediaType: ImageManifestMediaType, Config: &dangling})
+	if err == nil || !strings.Contains(err.Error(), "has not been added yet") {
+		t.Errorf("expected error for dangling reference, but got %v", err)
+	}
+
+	// a failing write callback must not leave a blob behind
+	_, err = b.AddBlobFrom("application/octet-stream", func(w io.Writer) error {
+		_, err := w.Write([]byte("partial"))
+		if err != nil {
+			return err
+		}
+		return errors.New("datacenter on fire")
+	})
+	if err == nil || !strings.Contains(err.Error(), "datacenter on fire") {
+		t.Errorf("expected error from write callback, but got %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(archivePath, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no blobs after failed write, but found %d entries", len(entries))
+	}
+
+	// an unfinished archive is removed by Discard()
+	b.Discard()
+	_, err = os.Stat(archivePath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected unfinished archive to be removed, but stat returned %v", err)
+	}
+}
+
+func TestCTFRejectsCorruptedBlob(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive")
+	b, err := NewCTFBuilder(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Discard()
+	_, _, layer := fillTestCTF(t, b)
+	err = b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(archivePath, filepath.FromSlash(ctfBlobPath(layer.Digest))), []byte("tampered"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctf, err := OpenCTF(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadBlob(t.Context(), ctf.Repository("component-descriptors/example.org/foo"), layer.Digest)
+	var dme *DigestMismatchError
+	if !errors.As(err, &dme) || dme.Expected != layer.Digest {
+		t.Errorf("expected DigestMismatchError, but got %v", err)
+	}
+}
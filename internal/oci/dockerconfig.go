@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type basicCredentials struct {
+	// base64-encoded "username:password", as in the Authorization header
+	Encoded string
+}
+
+// Finds credentials for the given registry host in the config file of the Docker CLI.
+// Only credentials stored directly in the config file are supported (i.e. no credential helpers).
+func findDockerCredentials(host string) (*basicCredentials, error) {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil //nolint:nilerr // no home directory means no Docker config, which is not an error
+		}
+		configDir = filepath.Join(homeDir, ".docker")
+	}
+	configPath := filepath.Join(configDir, "config.json")
+	buf, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var data struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %w", configPath, err)
+	}
+
+	for key, entry := range data.Auths {
+		// keys may be given as URLs, e.g. "https://index.docker.io/v1/" for Docker Hub
+		keyHost := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		keyHost, _, _ = strings.Cut(keyHost, "/")
+		if keyHost == "index.docker.io" {
+			keyHost = "docker.io"
+		}
+		if keyHost != host {
+			continue
+		}
+
+		switch {
+		case entry.Auth != "":
+			return &basicCredentials{Encoded: entry.Auth}, nil
+		case entry.Username != "":
+			encoded := base64.StdEncoding.EncodeToString([]byte(entry.Username + ":" + entry.Password))
+			return &basicCredentials{Encoded: encoded}, nil
+		}
+	}
+	return nil, nil
+}
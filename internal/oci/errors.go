@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NotFoundError is returned when a manifest or blob does not exist in a Repository.
+type NotFoundError struct {
+	// e.g. "manifest example/foo:1.0" or "blob sha256:..."
+	Description string
+}
+
+// Error implements the builtin/error interface.
+func (e *NotFoundError) Error() string {
+	return e.Description + " not found"
+}
+
+// UnexpectedStatusError is returned when an OCI registry responds with an unexpected HTTP status.
+type UnexpectedStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+// Error implements the builtin/error interface.
+func (e *UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("%s %s returned unexpected status %d: %s",
+		e.Method, e.URL, e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// DigestMismatchError is returned when a payload does not have the digest that it was expected to have.
+type DigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+// Error implements the builtin/error interface.
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("expected digest %s, but payload has digest %s", e.Expected, e.Actual)
+}
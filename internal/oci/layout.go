@@ -0,0 +1,449 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Constants for the OCI image layout format.
+// Ref: <https://github.com/opencontainers/image-spec/blob/main/image-layout.md>
+const (
+	imageLayoutFileName        = "oci-layout"
+	imageLayoutFileContents    = `{"imageLayoutVersion":"1.0.0"}`
+	imageLayoutIndexFileName   = "index.json"
+	imageRefNameAnnotation     = "org.opencontainers.image.ref.name"
+	artifactSetTarballMIMEType = "+tar+gzip"
+)
+
+// Returns the path of a blob within an OCI image layout.
+func imageLayoutBlobPath(digest string) string {
+	algo, hash, _ := strings.Cut(digest, ":")
+	return path.Join("blobs", algo, hash)
+}
+
+// Matches the paths of blobs within an OCI image layout that we know how to verify.
+var imageLayoutBlobPathRx = regexp.MustCompile(`^blobs/sha256/[0-9a-f]{64}$`)
+
+// Inverse of imageLayoutBlobPath(), for paths that match imageLayoutBlobPathRx.
+func digestFromImageLayoutBlobPath(relPath string) string {
+	return "sha256:" + path.Base(relPath)
+}
+
+// ImageLayoutTarballMediaType returns the media type for the result of WriteImageLayoutTarball().
+// This is the media type that OCM uses for OCI artifacts that are stored as local blobs.
+func (a Artifact) ImageLayoutTarballMediaType() string {
+	return a.Root.MediaType + artifactSetTarballMIMEType
+}
+
+// WriteImageLayoutTarball serializes this artifact into a gzipped tarball containing an OCI image layout.
+// The root manifest is annotated with the given reference name, if any.
+//
+// Blob contents are streamed from the repository that the artifact was fetched from.
+// The output is reproducible: When the same artifact is written, the same bytes will be written.
+func (a Artifact) WriteImageLayoutTarball(ctx context.Context, writer io.Writer, refName string) error {
+	root := a.Root
+	if refName != "" {
+		root.Annotations = map[string]string{imageRefNameAnnotation: refName}
+	}
+	indexBuf, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     ImageIndexMediaType,
+		Manifests:     []Descriptor{root},
+	})
+	if err != nil {
+		return err
+	}
+
+	// NOTE: The tarball writer requires files in sorted order.
+	// All blob paths sort before "index.json" and "oci-layout".
+	contents := make(map[string]Descriptor, len(a.Manifests)+len(a.Blobs))
+	for _, desc := range a.Manifests {
+		contents[desc.Digest] = Descriptor{Digest: desc.Digest, Size: int64(len(a.ManifestContents[desc.Digest]))}
+	}
+	for _, desc := range a.Blobs {
+		if _, exists := contents[desc.Digest]; !exists {
+			contents[desc.Digest] = desc
+		}
+	}
+	digests := make([]string, 0, len(contents))
+	for digest := range contents {
+		digests = append(digests, digest)
+	}
+	slices.Sort(digests)
+
+	gz := gzip.NewWriter(writer)
+	tw := newTarballWriter(gz)
+	for _, digest := range digests {
+		desc := contents[digest]
+		err := a.writeContentsInto(ctx, tw, imageLayoutBlobPath(digest), desc)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.WriteFile(imageLayoutIndexFileName, int64(len(indexBuf)), bytes.NewReader(indexBuf))
+	if err != nil {
+		return err
+	}
+	err = tw.WriteFile(imageLayoutFileName, int64(len(imageLayoutFileContents)), strings.NewReader(imageLayoutFileContents))
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Writes the contents of a manifest or blob of this artifact into a tarball.
+func (a Artifact) writeContentsInto(ctx context.Context, tw *tarballWriter, relPath string, desc Descriptor) error {
+	if buf, isManifest := a.ManifestContents[desc.Digest]; isManifest {
+		return tw.WriteFile(relPath, int64(len(buf)), bytes.NewReader(buf))
+	}
+	reader, err := a.OpenBlob(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	err = tw.WriteFile(relPath, desc.Size, reader)
+	if err != nil {
+		return fmt.Errorf("while copying blob %s: %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// VerifyImageLayoutTarball reads a tarball produced by WriteImageLayoutTarball(),
+// and checks that all blobs therein match their respective digests.
+// The image layout must contain exactly one artifact, whose root descriptor is returned.
+//
+// The tarball is processed as a stream, and nothing is written to disk.
+func VerifyImageLayoutTarball(reader io.Reader) (Descriptor, error) {
+	var (
+		indexBuf      []byte
+		verifiedBlobs = make(map[string]bool)
+	)
+	err := walkImageLayoutTarball(reader, func(relPath string, contents io.Reader) error {
+		if relPath == imageLayoutIndexFileName {
+			var err error
+			indexBuf, err = io.ReadAll(contents)
+			return err
+		}
+		if !imageLayoutBlobPathRx.MatchString(relPath) {
+			return nil
+		}
+		digest := digestFromImageLayoutBlobPath(relPath)
+		vr, err := newVerifyingReader(io.NopCloser(contents), digest)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, vr)
+		if err != nil {
+			return fmt.Errorf("while reading blob %s in OCI image layout: %w", digest, err)
+		}
+		verifiedBlobs[digest] = true
+		return nil
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	root, err := parseImageLayoutIndex(indexBuf)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if !verifiedBlobs[root.Digest] {
+		return Descriptor{}, &NotFoundError{Description: "manifest " + root.Digest + " in OCI image layout"}
+	}
+	root.Annotations = nil
+	return root, nil
+}
+
+// ExtractImageLayoutTarball reads a tarball produced by WriteImageLayoutTarball(),
+// and extracts it into the given directory, which must be empty or not exist yet.
+// All blobs are checked against their respective digests while extracting.
+// The image layout must contain exactly one artifact.
+//
+// Returns the artifact, and the reference name that the root manifest is annotated with (if any).
+// The artifact's blobs are read from the extracted image layout, so the directory must be kept around as long as the artifact is in use.
+func ExtractImageLayoutTarball(ctx context.Context, reader io.Reader, dirPath string) (a Artifact, refName string, err error) {
+	err = walkImageLayoutTarball(reader, func(relPath string, contents io.Reader) error {
+		var err error
+		switch {
+		case relPath == imageLayoutFileName || relPath == imageLayoutIndexFileName:
+			// continue below
+		case imageLayoutBlobPathRx.MatchString(relPath):
+			contents, err = newVerifyingReader(io.NopCloser(contents), digestFromImageLayoutBlobPath(relPath))
+			if err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		err = writeFileAtomically(filepath.Join(dirPath, filepath.FromSlash(relPath)), contents)
+		if err != nil {
+			return fmt.Errorf("while extracting %s from OCI image layout: %w", relPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Artifact{}, "", err
+	}
+
+	layout, err := OpenImageLayout(dirPath)
+	if err != nil {
+		return Artifact{}, "", err
+	}
+	indexBuf, err := os.ReadFile(filepath.Join(dirPath, imageLayoutIndexFileName))
+	if err != nil {
+		return Artifact{}, "", err
+	}
+	root, err := parseImageLayoutIndex(indexBuf)
+	if err != nil {
+		return Artifact{}, "", err
+	}
+	a, err = FetchArtifact(ctx, layout, root.Digest)
+	if err != nil {
+		return Artifact{}, "", err
+	}
+	return a, root.Annotations[imageRefNameAnnotation], nil
+}
+
+// Calls the given callback for each regular file in a gzipped tarball.
+func walkImageLayoutTarball(reader io.Reader, callback func(relPath string, contents io.Reader) error) error {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			err = callback(path.Clean(hdr.Name), tr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Parses the index.json of an image layout that is expected to contain exactly one artifact, and returns the descriptor of that artifact.
+func parseImageLayoutIndex(indexBuf []byte) (Descriptor, error) {
+	if indexBuf == nil {
+		return Descriptor{}, &NotFoundError{Description: imageLayoutIndexFileName + " in OCI image layout"}
+	}
+	var index Manifest
+	err := json.Unmarshal(indexBuf, &index)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("could not parse %s in OCI image layout: %w", imageLayoutIndexFileName, err)
+	}
+	if len(index.Manifests) != 1 {
+		return Descriptor{}, fmt.Errorf("expected OCI image layout to contain exactly one artifact, but found %d", len(index.Manifests))
+	}
+	return index.Manifests[0], nil
+}
+
+// ImageLayout is an OCI image layout directory on disk.
+// It implements the Repository and Sink interfaces.
+type ImageLayout struct {
+	Path string
+}
+
+// OpenImageLayout prepares an OCI image layout at the given path, creating it if necessary.
+func OpenImageLayout(dirPath string) (*ImageLayout, error) {
+	layoutFilePath := filepath.Join(dirPath, imageLayoutFileName)
+	_, err := os.Stat(layoutFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.MkdirAll(dirPath, 0777) // NOTE: final mode is subject to umask
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(layoutFilePath, []byte(imageLayoutFileContents), 0666) // NOTE: final mode is subject to umask
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ImageLayout{Path: dirPath}, nil
+}
+
+// Returns the path of a blob within this image layout.
+func (l *ImageLayout) blobPath(digest string) string {
+	return filepath.Join(l.Path, filepath.FromSlash(imageLayoutBlobPath(digest)))
+}
+
+// Reads and parses the index.json file, or returns an empty index if it does not exist yet.
+func (l *ImageLayout) readIndex() (Manifest, error) {
+	indexPath := filepath.Join(l.Path, imageLayoutIndexFileName)
+	index := Manifest{SchemaVersion: 2, MediaType: ImageIndexMediaType}
+	indexBuf, err := os.ReadFile(indexPath)
+	switch {
+	case err == nil:
+		err = json.Unmarshal(indexBuf, &index)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("could not parse %s: %w", indexPath, err)
+		}
+		return index, nil
+	case errors.Is(err, os.ErrNotExist):
+		return index, nil
+	default:
+		return Manifest{}, err
+	}
+}
+
+// GetManifest implements the Repository interface.
+//
+// The reference can either be a digest, or a reference name that a manifest is annotated with in index.json.
+func (l *ImageLayout) GetManifest(ctx context.Context, reference string) (Descriptor, []byte, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	desc := Descriptor{Digest: reference}
+	found := false
+	for _, m := range index.Manifests {
+		if m.Digest == reference || m.Annotations[imageRefNameAnnotation] == reference {
+			desc = Descriptor{MediaType: m.MediaType, Digest: m.Digest}
+			found = true
+			break
+		}
+	}
+	if !found && !strings.HasPrefix(reference, "sha256:") {
+		return Descriptor{}, nil, &NotFoundError{Description: fmt.Sprintf("manifest %s in %s", reference, l.Path)}
+	}
+
+	buf, err := ReadBlob(ctx, l, desc.Digest)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	if desc.MediaType == "" {
+		var manifest Manifest
+		err := json.Unmarshal(buf, &manifest)
+		if err != nil {
+			return Descriptor{}, nil, fmt.Errorf("while parsing manifest %s in %s: %w", reference, l.Path, err)
+		}
+		desc.MediaType = manifest.MediaType
+	}
+	desc.Size = int64(len(buf))
+	return desc, buf, nil
+}
+
+// OpenBlob implements the Repository interface.
+func (l *ImageLayout) OpenBlob(_ context.Context, digest string) (io.ReadCloser, error) {
+	err := checkDigestAlgorithm(digest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(l.blobPath(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &NotFoundError{Description: fmt.Sprintf("blob %s in %s", digest, l.Path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newVerifyingReader(file, digest)
+}
+
+// PutBlob implements the Sink interface.
+func (l *ImageLayout) PutBlob(_ context.Context, desc Descriptor, open func() (io.ReadCloser, error)) error {
+	blobPath := l.blobPath(desc.Digest)
+	_, err := os.Stat(blobPath)
+	if err == nil {
+		return nil // blob exists already
+	}
+
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	// NOTE: We do not trust the source here, even though readers from Repository.OpenBlob() are already verifying.
+	verifiedReader, err := newVerifyingReader(io.NopCloser(reader), desc.Digest)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomically(blobPath, verifiedReader)
+	if err != nil {
+		return fmt.Errorf("while writing blob %s into %s: %w", desc.Digest, l.Path, err)
+	}
+	return nil
+}
+
+// PutManifest implements the Sink interface.
+//
+// If the reference is not a digest, the manifest is listed in index.json with the reference as its reference name.
+func (l *ImageLayout) PutManifest(ctx context.Context, reference string, desc Descriptor, buf []byte) error {
+	err := l.PutBlob(ctx, desc, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	})
+	if err != nil {
+		return err
+	}
+	if reference == desc.Digest {
+		return nil
+	}
+
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	// replace any previous manifest with the same reference name
+	entry := desc
+	entry.Annotations = map[string]string{imageRefNameAnnotation: reference}
+	manifests := []Descriptor{}
+	for _, m := range index.Manifests {
+		if m.Annotations[imageRefNameAnnotation] != reference {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, entry)
+
+	indexBuf, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(l.Path, imageLayoutIndexFileName), indexBuf, 0666) // NOTE: final mode is subject to umask
+}
+
+// Writes a file by streaming the contents into a temporary file next to it, and renaming it into place once complete.
+// This ensures that incomplete files (e.g. after failed digest verification) never appear under the final path.
+func writeFileAtomically(filePath string, contents io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0777) // NOTE: final mode is subject to umask
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + ".partial"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666) // NOTE: final mode is subject to umask
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, contents)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tmpPath))
+	}
+	return os.Rename(tmpPath, filePath)
+}
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestImageLayoutTarballRoundTrip(t *testing.T) {
+	source, expected := newTestImageLayout(t)
+	a, err := FetchArtifact(t.Context(), source, "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType := a.ImageLayoutTarballMediaType(); mediaType != ImageIndexMediaType+"+tar+gzip" {
+		t.Errorf("unexpected media type for tarball: %q", mediaType)
+	}
+
+	// write the tarball to disk
+	tarballPath := filepath.Join(t.TempDir(), "image.tar.gz")
+	file, err := os.Create(tarballPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.WriteImageLayoutTarball(t.Context(), file, "foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the output must be reproducible
+	var buf bytes.Buffer
+	err = a.WriteImageLayoutTarball(t.Context(), &buf, "foo/bar:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball, err := os.ReadFile(tarballPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tarball, buf.Bytes()) {
+		t.Error("writing the same artifact twice did not yield the same tarball")
+	}
+
+	// verify without extracting
+	root, err := VerifyImageLayoutTarball(bytes.NewReader(tarball))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Digest != expected.Index.Digest || root.MediaType != ImageIndexMediaType || len(root.Annotations) != 0 {
+		t.Errorf("expected root %#v, but got %#v", expected.Index, root)
+	}
+
+	// extract and parse
+	extractedPath := filepath.Join(t.TempDir(), "extracted")
+	extracted, refName, err := ExtractImageLayoutTarball(t.Context(), bytes.NewReader(tarball), extractedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refName != "foo/bar:1.0" {
+		t.Errorf("expected reference name %q, but got %q", "foo/bar:1.0", refName)
+	}
+	checkTestArtifact(t, extracted, expected)
+
+	// all files in the extracted layout must match their digests
+	for _, digest := range append(digestsOf(extracted.Manifests), digestsOf(extracted.Blobs)...) {
+		buf, err := os.ReadFile(filepath.Join(extractedPath, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = VerifyDigest(buf, digest)
+		if err != nil {
+			t.Errorf("extracted blob %s: %s", digest, err.Error())
+		}
+	}
+	for _, fileName := range []string{"oci-layout", "index.json"} {
+		_, err := os.Stat(filepath.Join(extractedPath, fileName))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Builds a tarball with the same structure as WriteImageLayoutTarball(), but with arbitrary contents.
+func buildImageLayoutTarball(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	err := WriteTarball(gz, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageLayoutTarballWithTamperedBlob(t *testing.T) {
+	manifestBuf := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	manifestDigest := DigestOf(manifestBuf)
+	indexBuf := []byte(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` +
+		manifestDigest + `","size":` + strconv.Itoa(len(manifestBuf)) + `}]}`)
+	tarball := buildImageLayoutTarball(t, map[string][]byte{
+		"oci-layout": []byte(imageLayoutFileContents),
+		"index.json": indexBuf,
+		// the manifest is stored under its correct path, but with modified contents
+		imageLayoutBlobPath(manifestDigest): bytes.ReplaceAll(manifestBuf, []byte(`"layers":[]`), []byte(`"layers":null`)),
+	})
+
+	_, err := VerifyImageLayoutTarball(bytes.NewReader(tarball))
+	var dme *DigestMismatchError
+	if !errors.As(err, &dme) || dme.Expected != manifestDigest {
+		t.Errorf("expected VerifyImageLayoutTarball to fail with DigestMismatchError, but got %v", err)
+	}
+
+	extractedPath := t.TempDir()
+	_, _, err = ExtractImageLayoutTarball(t.Context(), bytes.NewReader(tarball), extractedPath)
+	if !errors.As(err, &dme) || dme.Expected != manifestDigest {
+		t.Errorf("expected ExtractImageLayoutTarball to fail with DigestMismatchError, but got %v", err)
+	}
+	_, err = os.Stat(filepath.Join(extractedPath, "blobs", "sha256", strings.TrimPrefix(manifestDigest, "sha256:")))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected tampered blob to not be extracted, but stat returned %v", err)
+	}
+}
+
+func TestImageLayoutTarballWithInvalidIndex(t *testing.T) {
+	tarball := buildImageLayoutTarball(t, map[string][]byte{
+		"oci-layout": []byte(imageLayoutFileContents),
+		"index.json": []byte(`{"schemaVersion":2,"manifests":[]}`),
+	})
+	_, err := VerifyImageLayoutTarball(bytes.NewReader(tarball))
+	if err == nil || !strings.Contains(err.Error(), "exactly one artifact") {
+		t.Errorf("expected error about number of artifacts, but got %v", err)
+	}
+
+	tarball = buildImageLayoutTarball(t, map[string][]byte{
+		"oci-layout": []byte(imageLayoutFileContents),
+	})
+	_, err = VerifyImageLayoutTarball(bytes.NewReader(tarball))
+	var nfe *NotFoundError
+	if !errors.As(err, &nfe) {
+		t.Errorf("expected NotFoundError for missing index.json, but got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+var sha256DigestRx = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Registry is a client for the API of an OCI registry (also known as a Docker registry).
+type Registry struct {
+	// e.g. "ghcr.io" or "localhost:5000"
+	Host string
+
+	baseURL     string
+	credentials *basicCredentials
+	tokens      map[string]string // key = scope for which token was issued
+	tokensMutex sync.Mutex
+}
+
+// NewRegistry builds a Registry client for the given host.
+//
+// Requests go to HTTPS, except for localhost, which is contacted via plain HTTP
+// (this matches the behavior of the Docker CLI, and is useful for testing against a local registry).
+// Credentials are taken from the Docker CLI configuration file, if it contains any for this host.
+func NewRegistry(host string) (*Registry, error) {
+	apiHost := host
+	if host == "docker.io" {
+		// Docker Hub is special in that its canonical host name is different from its API endpoint
+		apiHost = "registry-1.docker.io"
+	}
+
+	scheme := "https"
+	hostname := apiHost
+	if h, _, err := net.SplitHostPort(apiHost); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" || net.ParseIP(hostname).IsLoopback() {
+		scheme = "http"
+	}
+
+	creds, err := findDockerCredentials(host)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		Host:        host,
+		baseURL:     fmt.Sprintf("%s://%s", scheme, apiHost),
+		credentials: creds,
+		tokens:      make(map[string]string),
+	}, nil
+}
+
+// Repository returns a client for the repository with the given name in this registry.
+func (r *Registry) Repository(name string) *RegistryRepository {
+	return &RegistryRepository{registry: r, name: name}
+}
+
+// RegistryRepository is a client for a single repository within an OCI registry.
+// It implements the Repository and Sink interfaces.
+type RegistryRepository struct {
+	registry *Registry
+	name     string
+}
+
+// GetManifest implements the Repository interface.
+func (r *RegistryRepository) GetManifest(ctx context.Context, reference string) (Descriptor, []byte, error) {
+	header := http.Header{"Accept": {acceptHeaderForManifestLookup}}
+	resp, buf, err := r.registry.do(ctx, http.MethodGet, r.url("manifests/"+reference), r.name, header, nil, "pull")
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// continue below
+	case http.StatusNotFound:
+		return Descriptor{}, nil, &NotFoundError{Description: fmt.Sprintf("manifest %s:%s in %s", r.name, reference, r.registry.Host)}
+	default:
+		return Descriptor{}, nil, unexpectedStatusError(resp, buf)
+	}
+
+	desc := Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    DigestOf(buf),
+		Size:      int64(len(buf)),
+	}
+	if strings.HasPrefix(reference, "sha256:") && reference != desc.Digest {
+		return Descriptor{}, nil, fmt.Errorf("while downloading manifest %s@%s: %w",
+			r.name, reference, &DigestMismatchError{Expected: reference, Actual: desc.Digest})
+	}
+	return desc, buf, nil
+}
+
+// ResolveManifest looks up the descriptor of a manifest by its tag or digest.
+//
+// This uses a HEAD request, so the manifest is not downloaded and the request does not count against pull rate limits.
+// If the registry does not report the digest in the Docker-Content-Digest header, GetManifest() is used instead.
+func (r *RegistryRepository) ResolveManifest(ctx context.Context, reference string) (Descriptor, error) {
+	header := http.Header{"Accept": {acceptHeaderForManifestLookup}}
+	resp, _, err := r.registry.do(ctx, http.MethodHead, r.url("manifests/"+reference), r.name, header, nil, "pull")
+	if err != nil {
+		return Descriptor{}, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// continue below
+	case http.StatusNotFound:
+		return Descriptor{}, &NotFoundError{Description: fmt.Sprintf("manifest %s:%s in %s", r.name, reference, r.registry.Host)}
+	default:
+		return Descriptor{}, unexpectedStatusError(resp, nil)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if !sha256DigestRx.MatchString(digest) || resp.ContentLength < 0 {
+		logg.Debug("registry did not report digest of manifest %s:%s in HEAD response, falling back to GET", r.name, reference)
+		desc, _, err := r.GetManifest(ctx, reference)
+		return desc, err
+	}
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return Descriptor{}, fmt.Errorf("while looking up manifest %s@%s: %w",
+			r.name, reference, &DigestMismatchError{Expected: reference, Actual: digest})
+	}
+	return Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    digest,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// OpenBlob implements the Repository interface.
+func (r *RegistryRepository) OpenBlob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	resp, err := r.registry.doStreaming(ctx, http.MethodGet, r.url("blobs/"+digest), r.name, nil, nil, "pull")
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return newVerifyingReader(resp.Body, digest)
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, &NotFoundError{Description: fmt.Sprintf("blob %s in %s/%s", digest, r.registry.Host, r.name)}
+	default:
+		buf, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nil, unexpectedStatusError(resp, buf)
+	}
+}
+
+// PutBlob implements the Sink interface.
+func (r *RegistryRepository) PutBlob(ctx context.Context, desc Descriptor, open func() (io.ReadCloser, error)) error {
+	// skip upload if the blob exists already
+	resp, _, err := r.registry.do(ctx, http.MethodHead, r.url("blobs/"+desc.Digest), r.name, nil, nil, "pull,push")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	// start upload session
+	resp, body, err := r.registry.do(ctx, http.MethodPost, r.url("blobs/uploads/"), r.name, nil, nil, "pull,push")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return unexpectedStatusError(resp, body)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("while uploading blob %s to %s: malformed upload location: %w", desc.Digest, r.name, err)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	// upload blob contents in one go
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	reqBody := &requestBody{Open: open, Size: desc.Size}
+	resp, err = r.registry.doStreaming(ctx, http.MethodPut, location.String(), r.name, header, reqBody, "pull,push")
+	if err != nil {
+		return fmt.Errorf("while uploading blob %s to %s: %w", desc.Digest, r.name, err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return unexpectedStatusError(resp, body)
+	}
+	return nil
+}
+
+// PutManifest implements the Sink interface.
+func (r *RegistryRepository) PutManifest(ctx context.Context, reference string, desc Descriptor, buf []byte) error {
+	header := http.Header{"Content-Type": {desc.MediaType}}
+	resp, body, err := r.registry.do(ctx, http.MethodPut, r.url("manifests/"+reference), r.name, header, buf, "pull,push")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return unexpectedStatusError(resp, body)
+	}
+	return nil
+}
+
+// Returns the URL for an API endpoint below this repository.
+func (r *RegistryRepository) url(subpath string) string {
+	return fmt.Sprintf("%s/v2/%s/%s", r.registry.baseURL, r.name, subpath)
+}
+
+// requestBody is a request body that can be read multiple times, in case a request needs to be retried.
+type requestBody struct {
+	Open func() (io.ReadCloser, error)
+	Size int64
+}
+
+// Executes a request against the registry API, handling authentication as required.
+// The response body is read completely and returned alongside the response.
+func (r *Registry) do(ctx context.Context, method, reqURL, repoName string, header http.Header, body []byte, actions string) (*http.Response, []byte, error) {
+	var reqBody *requestBody
+	if body != nil {
+		reqBody = &requestBody{
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil },
+			Size: int64(len(body)),
+		}
+	}
+	resp, err := r.doStreaming(ctx, method, reqURL, repoName, header, reqBody, actions)
+	if err != nil {
+		return nil, nil, err
+	}
+	buf, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("while reading response body for %s %s: %w", method, reqURL, err)
+	}
+	return resp, buf, nil
+}
+
+// Like do(), but the response body is not read. The caller must close it.
+func (r *Registry) doStreaming(ctx context.Context, method, reqURL, repoName string, header http.Header, body *requestBody, actions string) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:%s", repoName, actions)
+
+	// NOTE: This loop runs at most twice: Once without auth (or with a cached token),
+	// and once more after obtaining a token if the first attempt was rejected with 401.
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, reqURL, http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Body, err = body.Open()
+			if err != nil {
+				return nil, err
+			}
+			req.ContentLength = body.Size
+			req.GetBody = body.Open
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		r.tokensMutex.Lock()
+		token, hasToken := r.tokens[scope]
+		r.tokensMutex.Unlock()
+		if hasToken {
+			req.Header.Set("Authorization", token)
+		}
+
+		logg.Debug("%s %s", method, reqURL)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("while reading response body for %s %s: %w", method, reqURL, err)
+		}
+		err = r.authenticate(ctx, resp.Header.Get("Www-Authenticate"), scope)
+		if err != nil {
+			return nil, fmt.Errorf("while authenticating for %s %s: %w", method, reqURL, err)
+		}
+	}
+}
+
+// Obtains an Authorization header value for the given scope, following the challenge from a 401 response.
+// Ref: <https://distribution.github.io/distribution/spec/auth/token/>
+func (r *Registry) authenticate(ctx context.Context, challenge, scope string) error {
+	authType, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(authType) {
+	case "basic":
+		if r.credentials == nil {
+			return fmt.Errorf("registry %s requires credentials, but none were found in the Docker config", r.Host)
+		}
+		r.tokensMutex.Lock()
+		r.tokens[scope] = "Basic " + r.credentials.Encoded
+		r.tokensMutex.Unlock()
+		return nil
+	case "bearer":
+		// continue below
+	default:
+		return fmt.Errorf("unsupported authentication challenge: %q", challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	tokenURL := params["realm"] + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	if r.credentials != nil {
+		req.Header.Set("Authorization", "Basic "+r.credentials.Encoded)
+	}
+
+	logg.Debug("GET %s", tokenURL)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatusError(resp, buf)
+	}
+
+	var data struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.Unmarshal(buf, &data)
+	if err != nil {
+		return fmt.Errorf("could not parse token response from %s: %w", params["realm"], err)
+	}
+	token := data.Token
+	if token == "" {
+		token = data.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("token response from %s did not contain a token", params["realm"])
+	}
+
+	r.tokensMutex.Lock()
+	r.tokens[scope] = "Bearer " + token
+	r.tokensMutex.Unlock()
+	return nil
+}
+
+// Parses a WWW-Authenticate header like `Bearer realm="https://example.com/token",service="example.com"`.
+func parseAuthChallenge(challenge string) (authType string, params map[string]string) {
+	authType, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params = make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return authType, params
+}
+
+func unexpectedStatusError(resp *http.Response, body []byte) error {
+	return &UnexpectedStatusError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Media types for OCI manifests and indexes, as well as their Docker-specific predecessors.
+const (
+	ImageManifestMediaType        = "application/vnd.oci.image.manifest.v1+json"
+	ImageIndexMediaType           = "application/vnd.oci.image.index.v1+json"
+	DockerManifestMediaType       = "application/vnd.docker.distribution.manifest.v2+json"
+	DockerManifestListMediaType   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	acceptHeaderForManifestLookup = ImageManifestMediaType + ", " + ImageIndexMediaType + ", " + DockerManifestMediaType + ", " + DockerManifestListMediaType
+)
+
+// Descriptor is an OCI content descriptor.
+//
+// This is a heavily abridged type declaration that only contains the fields we need.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest, or an OCI image index.
+// Since both types of manifests are structurally very similar, they are unified into one type here.
+//
+// This is a heavily abridged type declaration that only contains the fields we need.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        *Descriptor  `json:"config,omitempty"`    // only in image manifests
+	Layers        []Descriptor `json:"layers,omitempty"`    // only in image manifests
+	Manifests     []Descriptor `json:"manifests,omitempty"` // only in image indexes
+}
+
+// IsIndex returns whether this manifest is an image index (i.e. it refers to other manifests instead of to layers).
+func (m Manifest) IsIndex() bool {
+	return m.MediaType == ImageIndexMediaType || m.MediaType == DockerManifestListMediaType
+}
+
+// Repository is a read-only view of a single repository in an OCI registry, or of an equivalent storage.
+type Repository interface {
+	// GetManifest retrieves a manifest by its tag or digest.
+	// The returned Descriptor describes the manifest itself.
+	GetManifest(ctx context.Context, reference string) (Descriptor, []byte, error)
+	// OpenBlob opens a blob by its digest for reading.
+	// The returned reader verifies the digest of the contents once it reaches EOF.
+	OpenBlob(ctx context.Context, digest string) (io.ReadCloser, error)
+}
+
+// ReadBlob reads a blob from the given repository into memory.
+// This should only be used for blobs that are known to be small, like configs or descriptors.
+func ReadBlob(ctx context.Context, repo Repository, digest string) ([]byte, error) {
+	reader, err := repo.OpenBlob(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// DigestOf computes the SHA-256 digest of the given payload, in the format used by OCI.
+func DigestOf(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// VerifyDigest checks that the given payload has the given digest.
+func VerifyDigest(buf []byte, digest string) error {
+	err := checkDigestAlgorithm(digest)
+	if err != nil {
+		return err
+	}
+	actualDigest := DigestOf(buf)
+	if actualDigest != digest {
+		return &DigestMismatchError{Expected: digest, Actual: actualDigest}
+	}
+	return nil
+}
+
+func checkDigestAlgorithm(digest string) error {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("cannot verify digest %q: only sha256 digests are supported", digest)
+	}
+	return nil
+}
+
+// verifyingReader wraps a reader for a blob, and verifies the blob's digest once EOF is reached.
+// If the digest does not match, the final Read() returns a DigestMismatchError instead of io.EOF.
+type verifyingReader struct {
+	io.ReadCloser
+	digest string
+	hash   hash.Hash
+}
+
+// Wraps a reader for a blob such that its digest is verified while reading.
+func newVerifyingReader(reader io.ReadCloser, digest string) (io.ReadCloser, error) {
+	err := checkDigestAlgorithm(digest)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &verifyingReader{ReadCloser: reader, digest: digest, hash: sha256.New()}, nil
+}
+
+// Read implements the io.Reader interface.
+func (r *verifyingReader) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	r.hash.Write(buf[:n])
+	if errors.Is(err, io.EOF) {
+		actualDigest := "sha256:" + hex.EncodeToString(r.hash.Sum(nil))
+		if actualDigest != r.digest {
+			return n, &DigestMismatchError{Expected: r.digest, Actual: actualDigest}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+// ExecHelm executes the `helm` command with the given arguments and returns its stdout.
+func ExecHelm(ctx context.Context, args ...string) ([]byte, error) {
+	logg.Debug("running helm binary with arguments %#v", args)
+	cmd := exec.CommandContext(ctx, "helm", args...)
+	cmd.Stdin = nil
+	cmd.Stderr = os.Stderr
+
+	buf, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("while running helm binary with arguments %#v: %w", args, err)
+	}
+	return buf, err
+}
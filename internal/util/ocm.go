@@ -4,7 +4,10 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 
@@ -12,9 +15,9 @@ import (
 )
 
 // ExecOCM executes the `ocm` command with the given arguments and returns its stdout.
-func ExecOCM(args ...string) ([]byte, error) {
+func ExecOCM(ctx context.Context, args ...string) ([]byte, error) {
 	logg.Debug("running ocm binary with arguments %#v", args)
-	cmd := exec.Command("ocm", args...)
+	cmd := exec.CommandContext(ctx, "ocm", args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 
@@ -24,3 +27,55 @@ func ExecOCM(args ...string) ([]byte, error) {
 	}
 	return buf, err
 }
+
+// StreamOCM executes the `ocm` command with the given arguments and returns a reader for its stdout.
+// If the command fails, the error is reported by the final Read() instead of io.EOF.
+// Closing the reader before EOF terminates the command.
+func StreamOCM(ctx context.Context, args ...string) (io.ReadCloser, error) {
+	logg.Debug("running ocm binary with arguments %#v", args)
+	cmd := exec.CommandContext(ctx, "ocm", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("while running ocm binary with arguments %#v: %w", args, err)
+	}
+	return &ocmOutputReader{stdout, cmd, args, false}, nil
+}
+
+// ocmOutputReader is the reader returned by StreamOCM().
+type ocmOutputReader struct {
+	stdout io.Reader
+	cmd    *exec.Cmd
+	args   []string
+	exited bool
+}
+
+// Read implements the io.Reader interface.
+func (r *ocmOutputReader) Read(buf []byte) (int, error) {
+	n, err := r.stdout.Read(buf)
+	if errors.Is(err, io.EOF) && !r.exited {
+		r.exited = true
+		waitErr := r.cmd.Wait()
+		if waitErr != nil {
+			return n, fmt.Errorf("while running ocm binary with arguments %#v: %w", r.args, waitErr)
+		}
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (r *ocmOutputReader) Close() error {
+	if r.exited {
+		return nil
+	}
+	r.exited = true
+	r.cmd.Process.Kill() //nolint:errcheck // fails if the process already exited, which is fine
+	r.cmd.Wait()         //nolint:errcheck // the output is not needed anymore, so the exit status is not interesting either
+	return nil
+}
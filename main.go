@@ -8,8 +8,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,6 +21,7 @@ import (
 	"github.com/sapcc/go-bits/must"
 	"github.com/spf13/cobra"
 	"go.podman.io/image/v5/docker/reference"
+	. "go.xyrillian.de/gg/option"
 	"gopkg.in/yaml.v3"
 
 	"github.com/sapcc/ocm-helm-toolbox/internal/core"
@@ -77,18 +80,38 @@ func addTimestampToVersionCmd() *cobra.Command {
 // subcommand: bundle
 
 type bundleOpts struct {
-	ComponentNamePrefix string
-	ProviderName        string
-	RawImageRelations   []string
+	ComponentNamePrefix  string
+	ProviderName         string
+	RawImageRelations    []string
+	ImageRelationsFiles  []string
+	RawArtifactRelations []string
+	FileResourcePaths    []string
+	OutputCTFPath        string
+	CopyImages           bool
+	ResolveDigests       bool
+	DiscoverImages       string
+	Strict               bool
+	BuildDependencies    bool
+	ValidateSchema       bool
+	SigningKeyPath       string
+	SignatureName        string
+	GenerateSBOM         bool
+	GitRemoteName        string
+	RequireCleanWorktree bool
 }
 
 func bundleCmd() *cobra.Command {
 	var opts bundleOpts
 	cmd := &cobra.Command{
-		Use:   "bundle <helm-chart-directory>",
+		Use:   "bundle <helm-chart-directory>...",
 		Short: "Prepares a component constructor for a Helm chart.",
 		Long: docstring(
 			`Prepares a component constructor for the given Helm chart, for consumption by "ocm add componentversions".`,
+			`Alternatively, if --output-ctf is given, the component version is written into a CTF archive directly.`,
+			``,
+			`If multiple Helm charts are given, all of them are bundled into the same component version.`,
+			`The order of arguments is recorded as the order in which the charts need to be installed.`,
+			`For example, a chart providing CRDs would be given before the chart that uses these CRDs.`,
 			``,
 			`To make the bundle hermetic, all images referenced by the Helm chart should be declared with --image-relation. For example:`,
 			`    --image-relation ".Values.db_metrics.image.repository is repository of quay.io/prometheuscommunity/postgres_exporter:0.16.0"`,
@@ -97,8 +120,33 @@ func bundleCmd() *cobra.Command {
 			``,
 			`Images so declared as related to the Helm chart will be bundled into the OCM component version, and transported inside it.`,
 			`On unbundle, a localized-values.yaml file will be rendered which overwrites the declared value paths to refer to the bundled images.`,
+			``,
+			`When bundling multiple Helm charts, each image relation must be prefixed with the name of the chart that it applies to:`,
+			`    --image-relation "gatekeeper: .Values.image.tag is tag of openpolicyagent/gatekeeper:v3.19.1"`,
+			``,
+			`Image relations can also be declared for subcharts below charts/, in terms of the subchart's own values:`,
+			`    --image-relation "charts/postgresql: .Values.image.tag is tag of docker.io/bitnami/postgresql:17.5.0"`,
+			`    --image-relation "keppel/charts/postgresql: .Values.image.tag is tag of docker.io/bitnami/postgresql:17.5.0"`,
+			`The value path is then translated into the parent chart's values (in this case, ".Values.postgresql.image.tag").`,
+			`If the subchart is included with an alias, the value path is translated using the alias, and the alias may be given instead of the name.`,
+			`Nested subcharts can be given as "charts/<name>/charts/<name>".`,
+			``,
+			`OCI artifacts other than images (e.g. OPA bundles) can be declared with --artifact-relation in the same way.`,
+			`Plain files and directories that are not part of any chart (e.g. Grafana dashboards) can be bundled with --file-resource.`,
+			``,
+			`Before bundling, each chart's subcharts are checked to be exactly what "helm dependency build" would have placed in charts/:`,
+			`  - Chart.lock must list the same dependencies as Chart.yaml, with the same repositories, and with versions that satisfy`,
+			`    the version constraints from Chart.yaml. Its digest must match as computed by Helm, i.e. it must not be out of date.`,
+			`    (The digest is not checked if a dependency refers to a repository alias like "@stable", since Helm resolves those`,
+			`    using its local repository config.)`,
+			`  - A chart may be included multiple times with different values for "alias". The respective entries in Chart.lock`,
+			`    are matched up in order. The "condition", "tags" and "import-values" fields are accepted, but do not change`,
+			`    which subcharts must be present, since they only take effect during installation.`,
+			`  - For dependencies from "file://" repositories, the version must agree with the Chart.yaml of the referenced chart.`,
+			`  - Each subchart can be present in charts/ either as a packaged "<name>-<version>.tgz" or as an unpacked directory.`,
+			`Charts with "apiVersion: v1" are supported as well. Their dependencies are read from requirements.yaml and requirements.lock.`,
 		),
-		Args: cobra.ExactArgs(1), // TODO: support bundling multiple helm-charts that need to be installed in order (e.g. gatekeeper -> gatekeeper-config)
+		Args: cobra.MinimumNArgs(1),
 		RunE: opts.Run,
 	}
 
@@ -111,16 +159,130 @@ func bundleCmd() *cobra.Command {
 		`(required) The provider name value for the component metadata.`,
 	)
 	cmd.Flags().StringArrayVar(&opts.RawImageRelations, "image-relation", nil, docstring(
-		`A declaration of the form ".Values.<path> is <repository|digest|tag|reference> of <docker-image-ref>".`,
+		`A declaration of the form "[<chart-name>: ].Values.<path> is <attribute> of <docker-image-ref>".`,
+		`Instead of "<chart-name>", the prefix may also refer to a subchart as "[<chart-name>/]charts/<subchart-name>".`,
 		`See command documentation above for what this declaration causes.`,
 		`The option may be given multiple times to include multiple declarations.`,
-		`A single option may also contain multiple declarations, separated by commas.`,
+		`A single option may also contain multiple declarations, separated by commas or newlines`,
+		`(commas within quoted keys, quoted templates and command substitutions do not separate declarations).`,
+		``,
+		`The <attribute> is one of: reference, repository, registry, path, tag, digest, tag-and-digest.`,
+		`For example, for "quay.io/foo/bar:1.0", the registry is "quay.io" and the path is "foo/bar".`,
+		`Alternatively, the <attribute> may be a quoted Go template, e.g. '.Values.image is "{{.Registry}}/{{.Path}}" of ...'.`,
+		`Templates can use the fields {{.Reference}}, {{.Repository}}, {{.Registry}}, {{.Path}}, {{.Tag}}, {{.Digest}} and {{.TagAndDigest}}.`,
+		``,
+		`The <path> may select list elements by index, e.g. ".Values.sidecars[0].image".`,
+		`Keys with special characters can be quoted, e.g. '.Values.podLabels."app.kubernetes.io/version"'.`,
+		`Since Helm replaces lists wholesale instead of merging them, a warning is shown when targeting list elements.`,
 		``,
 		`References to ${ENVIRONMENT_VARIABLES} in exactly this one form are replaced with the respective variable's value.`,
 		`After that, $(command substitutions) in exactly this one form are replaced by the output of the command.`,
 		`Command substitution does not understand any quoting or nested shell syntax.`,
 		`Only a list of bare words is supported, like "$(cat version.txt)".`,
 	))
+	cmd.Flags().StringArrayVar(&opts.ImageRelationsFiles, "image-relations-file", nil, docstring(
+		`Path to a YAML file containing additional image relation declarations, as a list of objects like:`,
+		`    - chart: gatekeeper # optional, like the "<chart-name>:" prefix in --image-relation`,
+		`      target: .Values.image.tag`,
+		`      attribute: tag # same as <attribute> in --image-relation; alternatively, template: "{{.Tag}}"`,
+		`      image: openpolicyagent/gatekeeper:v3.19.1`,
+		`      type: ociImage # optional; "ociArtifact" declares an artifact like --artifact-relation`,
+		`Variable references and command substitutions are resolved in each field in the same way as for --image-relation.`,
+		`The option may be given multiple times, and may be combined with --image-relation.`,
+	))
+	cmd.Flags().StringArrayVar(&opts.RawArtifactRelations, "artifact-relation", nil, docstring(
+		`Like --image-relation, but for an OCI artifact that is not a container image, e.g. an OPA bundle. For example:`,
+		`    --artifact-relation ".Values.opa.bundle is reference of oci://ghcr.io/example/policies:1.0"`,
+		`The "oci://" prefix on the artifact reference is optional.`,
+		`The artifact is bundled as a resource of type "ociArtifact", and is otherwise treated in the same way as related images,`,
+		`including --copy-images, --resolve-digests, and "unbundle --push-images-to" and "unbundle --relocate".`,
+	))
+	cmd.Flags().StringArrayVar(&opts.FileResourcePaths, "file-resource", nil, docstring(
+		`Path to a file or directory that shall be bundled into the component version as an additional resource,`,
+		`e.g. a Grafana dashboard or a tarball of CRDs. On unbundle, it is written into the "files" subdirectory of the target directory.`,
+		`Files are bundled as resources of type "blob", directories as resources of type "directoryTree".`,
+		`The option may be given multiple times, but the basenames of all paths must be unique.`,
+		`Since each chart is unpacked into a subdirectory named after it, this option cannot be used when bundling a chart named "files".`,
+	))
+	cmd.Flags().StringVar(&opts.OutputCTFPath, "output-ctf", "", docstring(
+		`If given, a CTF archive containing the component version is written into this path,`,
+		`instead of printing a component constructor on stdout.`,
+		`If the path ends in ".tar", ".tgz" or ".tar.gz", the CTF archive is written as a tarball.`,
+		`Otherwise, it is written as a directory. The path must not exist yet.`,
+	))
+	cmd.Flags().BoolVar(&opts.GenerateSBOM, "sbom", false, docstring(
+		`If given, a software bill of materials in CycloneDX format is generated for each Helm chart,`,
+		`and bundled as an additional resource of type "sbom". It lists the chart, the exact versions of its subcharts from Chart.lock,`,
+		`and all related images and artifacts. If the chart is in a Git checkout, the Git location is recorded as its source.`,
+		`On unbundle, it is written into the chart's directory under the file name "sbom.cdx.json".`,
+	))
+	cmd.Flags().StringVar(&opts.SigningKeyPath, "sign-key", "", docstring(
+		`Path to a PEM-encoded RSA or Ed25519 private key. If given, the component descriptor is signed with this key,`,
+		`using a SHA-256 digest of its normalized contents. This requires --output-ctf.`,
+		`The signature covers the digests of all resources, so images that are not copied with --copy-images`,
+		`must be pinned to a digest (e.g. with --resolve-digests). The signature can be checked with "unbundle --verify-key".`,
+		`Compatibility with signature verification in OCM's own tooling is not guaranteed, and OCM cannot check Ed25519 signatures at all.`,
+	))
+	cmd.Flags().StringVar(&opts.SignatureName, "signature-name", "ocm-helm-toolbox", docstring(
+		`The name under which the signature from --sign-key is recorded in the component descriptor.`,
+	))
+	cmd.Flags().BoolVar(&opts.CopyImages, "copy-images", false, docstring(
+		`If given, related images are copied into the component version by value (as OCI image layouts stored in local blobs),`,
+		`instead of only being referenced. This is useful for delivery into air-gapped environments.`,
+		`The images can be pushed into a different registry during unbundle with --push-images-to.`,
+	))
+	cmd.Flags().BoolVar(&opts.ResolveDigests, "resolve-digests", false, docstring(
+		`If given, each related image that is referenced only by tag is pinned to the manifest digest that the tag currently points to,`,
+		`by asking the image's registry. The image is then referenced as "<repository>:<tag>@<digest>" in the component version.`,
+		`This ensures that deployments are immutable even if the tag is pushed again later.`,
+		`Image references without tag or digest are rejected when this option is given.`,
+	))
+	cmd.Flags().StringVar(&opts.DiscoverImages, "discover-images", "", docstring(
+		`If given, image relations are discovered from the values.yaml of each Helm chart, including subcharts in its charts/ directory.`,
+		`Subcharts that are disabled by the "condition" of their dependency declaration are skipped.`,
+		`Values of the form {registry, repository, tag, digest} as well as image references in values named "image" or "*Image" are recognized.`,
+		`Discovered relations do not override explicitly declared ones for the same value path.`,
+		`With "--discover-images" or "--discover-images=use", discovered relations are added to the component version.`,
+		`With "--discover-images=print", discovered relations are printed as --image-relation options for review, and nothing is bundled.`,
+		`In both cases, images in the output of "helm template" that are not covered by any relation are reported.`,
+	))
+	cmd.Flags().Lookup("discover-images").NoOptDefVal = "use"
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, docstring(
+		`If given, each Helm chart is rendered with "helm template" with localized-values.yaml applied, like after unbundling.`,
+		`Bundling fails if any Pod, Deployment, StatefulSet, DaemonSet, ReplicaSet, Job or CronJob in the output`,
+		`refers to an image that is not entirely taken from localized-values.yaml, i.e. that would not be taken from the bundle.`,
+		`For this check, related images are replaced by sentinel references, so that images that are hardcoded in the chart are detected`,
+		`even if they are identical to a related image, and so are images where only some parts (e.g. only the tag) are localized.`,
+		`This requires the "helm" command to be installed.`,
+	))
+	cmd.Flags().BoolVar(&opts.BuildDependencies, "build-dependencies", false, docstring(
+		`If given, the dependencies listed in Chart.lock are placed into the charts/ directory of each Helm chart before bundling,`,
+		`like "helm dependency build" would do, but without requiring the "helm" command.`,
+		`Dependencies are downloaded from HTTP chart repositories (verifying the digest listed in the repository's index.yaml)`,
+		`or from OCI registries (verifying the digests listed in the manifest), or packaged from local directories for "file://" repositories.`,
+		`Repository aliases like "@stable" are not supported. Chart.lock must agree with Chart.yaml, or else bundling fails.`,
+		`Credentials for HTTP chart repositories are taken from Helm's repositories.yaml (as written by "helm repo add --username"),`,
+		`and credentials for OCI registries are taken from the Docker config.`,
+	))
+	cmd.Flags().BoolVar(&opts.ValidateSchema, "validate-schema", false, docstring(
+		`If given, the values of each Helm chart that has a values.schema.json (or has subcharts with a values.schema.json) are validated against it,`,
+		`first with only the chart's own values.yaml, and then with localized-values.yaml applied, like after unbundling.`,
+		`This catches image relations whose target paths are not allowed by the chart's schema before the bundle is published.`,
+		`Only references within the same values.schema.json are supported in "$ref".`,
+		`Regexes in "pattern" and "patternProperties" that Go's regexp package cannot compile (e.g. because of lookahead) are skipped with a warning.`,
+		`Note that values which are only supplied at install time cannot be known here, so charts whose schema requires them cannot be validated this way.`,
+	))
+	cmd.Flags().StringVar(&opts.GitRemoteName, "git-remote", "origin", docstring(
+		`If a Helm chart is in a Git checkout, its Git location is recorded in the "cloud.sap/git-location" label.`,
+		`This option selects the remote whose URL is recorded there. Credentials contained in the URL are removed.`,
+	))
+	cmd.Flags().BoolVar(&opts.RequireCleanWorktree, "require-clean-worktree", false, docstring(
+		`If given, bundling fails if the directory of a Helm chart is in a Git checkout and contains uncommitted changes (including untracked files).`,
+		`Otherwise, such changes are only recorded by setting "dirty" to true in the "cloud.sap/git-location" label.`,
+		`Some Git features are not evaluated when looking for changes: [include] and [includeIf] in the Git config,`,
+		`and content conversions from gitattributes or core.autocrlf (e.g. line endings or Git LFS).`,
+		`If these could hide or fake a change, the "dirty" field is left out of the label, and this option makes bundling fail.`,
+	))
 	return cmd
 }
 
@@ -131,42 +293,210 @@ func (opts *bundleOpts) Run(cmd *cobra.Command, args []string) error {
 	if opts.ProviderName == "" {
 		return errors.New("no value provided for --provider-name")
 	}
+	if !slices.Contains([]string{"", "use", "print"}, opts.DiscoverImages) {
+		return fmt.Errorf(`invalid value for --discover-images: %q (expected "use" or "print")`, opts.DiscoverImages)
+	}
 
-	// prepare OCM resource for the Helm chart
-	chart, err := core.ParseHelmChartYAML(args[0])
-	if err != nil {
-		return err
+	var signer *core.OCMSigner
+	if opts.SigningKeyPath != "" {
+		if opts.OutputCTFPath == "" {
+			return errors.New("--sign-key requires --output-ctf")
+		}
+		key, err := core.ReadOCMPrivateKey(opts.SigningKeyPath)
+		if err != nil {
+			return err
+		}
+		signer = &core.OCMSigner{Name: opts.SignatureName, Key: key}
 	}
-	err = chart.ValidateDependencies()
+
+	fileResources, err := core.ParseFileResources(opts.FileResourcePaths)
 	if err != nil {
 		return err
 	}
-	chartResource, err := chart.AsOCMResource()
-	if err != nil {
-		return err
+
+	gitOpts := core.GitLocationOptions{
+		RemoteName:           opts.GitRemoteName,
+		RequireCleanWorktree: opts.RequireCleanWorktree,
 	}
 
+	// prepare OCM resources for the Helm charts
+	charts := make([]core.HelmChart, len(args))
+	chartNames := make([]string, len(args))
+	gitLocations := make([]Option[core.GitLocation], len(args))
+	for idx, chartPath := range args {
+		chart, err := core.ParseHelmChartYAML(chartPath)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(chartNames, chart.Name) {
+			return fmt.Errorf("cannot bundle multiple Helm charts with the same name %q", chart.Name)
+		}
+		if opts.BuildDependencies {
+			err = chart.BuildDependencies(cmd.Context())
+			if err != nil {
+				return err
+			}
+		}
+		err = chart.ValidateDependencies()
+		if err != nil {
+			return err
+		}
+		gitLocations[idx], err = core.TryGetGitLocation(chart.ChartPath, gitOpts)
+		if err != nil {
+			return err
+		}
+		if gitLocations[idx].IsSomeAnd(func(loc core.GitLocation) bool { return loc.IsDirty.IsNone() }) {
+			logg.Info("WARNING: cannot tell whether %s has uncommitted changes, so its Git location is recorded without the \"dirty\" field (use --debug for details)", chart.ChartPath)
+		}
+		charts[idx] = chart
+		chartNames[idx] = chart.Name
+	}
+	if len(fileResources) > 0 {
+		err = core.CheckFileResourcesDirName(chartNames)
+		if err != nil {
+			return err
+		}
+	}
+	componentVersion := charts[0].Version
+
 	// prepare OCM resources for related images
 	rels, err := core.ParseImageRelations(cmd.Context(), opts.RawImageRelations)
 	if err != nil {
 		return err
 	}
-	imageResources, imageRelationsJSON, err := rels.AsOCMResources(chart.Version)
+	for _, filePath := range opts.ImageRelationsFiles {
+		moreRels, err := core.ReadImageRelationsFile(cmd.Context(), filePath)
+		if err != nil {
+			return err
+		}
+		rels = append(rels, moreRels...)
+	}
+	artifactRels, err := core.ParseArtifactRelations(cmd.Context(), opts.RawArtifactRelations)
+	if err != nil {
+		return err
+	}
+	rels = append(rels, artifactRels...)
+	err = rels.AssignToCharts(chartNames)
 	if err != nil {
 		return err
 	}
-	chartResource.Labels = append(chartResource.Labels, core.OCMLabel{
-		Name:  core.ImageRelationsLabelName,
-		Value: imageRelationsJSON,
-	})
+	for _, chart := range charts {
+		err = chart.ResolveSubchartRelations(rels.SelectChart(chart.Name))
+		if err != nil {
+			return err
+		}
+	}
+	if opts.DiscoverImages != "" {
+		discoveredRels, err := discoverImageRelations(cmd.Context(), charts, rels)
+		if err != nil {
+			return err
+		}
+		if opts.DiscoverImages == "print" {
+			for _, rel := range discoveredRels {
+				fmt.Printf("--image-relation %q\n", rel.AsFlagValue(len(charts) > 1))
+			}
+			return nil
+		}
+		rels = append(rels, discoveredRels...)
+	}
+	if opts.ResolveDigests {
+		err = rels.ResolveDigests(cmd.Context(), core.NewRegistryDigestResolver())
+		if err != nil {
+			return err
+		}
+	}
+	for _, chartName := range chartNames {
+		warnings, err := rels.SelectChart(chartName).CheckTargetPaths()
+		if err != nil {
+			return fmt.Errorf("while checking image relations for chart %q: %w", chartName, err)
+		}
+		for _, warning := range warnings {
+			logg.Info("WARNING: %s", warning)
+		}
+	}
+	rels.AssignResourceNames() // across all charts at once, to ensure that resource names are unique within the component version
+	if opts.ValidateSchema {
+		for _, chart := range charts {
+			warnings, err := chart.ValidateValuesSchema(rels.SelectChart(chart.Name))
+			for _, warning := range warnings {
+				logg.Info("WARNING: %s", warning)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if opts.Strict {
+		for _, chart := range charts {
+			err := chart.VerifyHermeticity(cmd.Context(), rels.SelectChart(chart.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var (
+		chartResources []core.OCMResourceDeclaration
+		imageResources []core.OCMResourceDeclaration
+		isImageResName = make(map[string]bool)
+	)
+	for idx, chart := range charts {
+		chartResource, err := chart.AsOCMResource(gitLocations[idx])
+		if err != nil {
+			return err
+		}
+		chartImageResources, imageRelationsJSON, err := rels.SelectChart(chart.Name).AsOCMResources(componentVersion, opts.CopyImages)
+		if err != nil {
+			return err
+		}
+		chartResource.Labels = append(chartResource.Labels,
+			core.OCMLabel{
+				Name:    core.ImageRelationsLabelName,
+				Value:   imageRelationsJSON,
+				Signing: true,
+			},
+			core.OCMLabel{
+				Name:    core.InstallOrderLabelName,
+				Value:   idx,
+				Signing: true,
+			},
+		)
+		chartResources = append(chartResources, chartResource)
+
+		// images that are related to multiple charts shall only be declared once
+		for _, res := range chartImageResources {
+			if !isImageResName[res.Name] {
+				imageResources = append(imageResources, res)
+				isImageResName[res.Name] = true
+			}
+		}
+	}
 
-	// render component-constructor.yaml
 	component := core.OCMComponentDeclaration{
-		Name:      opts.ComponentNamePrefix + chart.Name,
-		Version:   chart.Version,
+		Name:      opts.ComponentNamePrefix + charts[0].Name,
+		Version:   componentVersion,
 		Provider:  map[string]any{"name": opts.ProviderName},
-		Resources: append([]core.OCMResourceDeclaration{chartResource}, imageResources...),
+		Resources: append(chartResources, imageResources...),
+	}
+	for _, f := range fileResources {
+		component.Resources = append(component.Resources, f.AsOCMResource(componentVersion))
 	}
+	if opts.GenerateSBOM {
+		for idx, chart := range charts {
+			buf, err := chart.BuildSBOM(component.Name, componentVersion, rels.SelectChart(chart.Name), gitLocations[idx])
+			if err != nil {
+				return err
+			}
+			component.Resources = append(component.Resources, core.SBOMAsOCMResource(chart, buf))
+		}
+	}
+
+	// render CTF archive, if requested
+	if opts.OutputCTFPath != "" {
+		return component.WriteCTF(cmd.Context(), opts.OutputCTFPath, signer)
+	}
+
+	// otherwise render component-constructor.yaml
 	buf, err := yaml.Marshal(map[string]any{"components": []core.OCMComponentDeclaration{component}})
 	if err != nil {
 		return fmt.Errorf("while marshaling component-constructor.yaml: %w", err)
@@ -175,11 +505,55 @@ func (opts *bundleOpts) Run(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-///////////////////////////////////////////////////////////////////////////////////////////
+// Implements `bundle --discover-images`. Returns all discovered image relations that do not conflict with explicitly declared ones.
+// Images in the rendered charts that are not covered by any relation are reported on stderr.
+func discoverImageRelations(ctx context.Context, charts []core.HelmChart, declaredRels core.ImageRelations) (core.ImageRelations, error) {
+	var result core.ImageRelations
+	for _, chart := range charts {
+		rels, err := chart.DiscoverImageRelations()
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels {
+			isDeclared := slices.ContainsFunc(declaredRels, func(other *core.ImageRelation) bool {
+				return other.ChartName == rel.ChartName && other.TargetPath == rel.TargetPath
+			})
+			if !isDeclared {
+				result = append(result, rel)
+			}
+		}
+	}
+
+	// NOTE: This check is advisory only, so it does not fail the bundle if the chart cannot be rendered.
+	allRels := slices.Concat(declaredRels, result)
+	for _, chart := range charts {
+		uncoveredImages, err := core.FindUncoveredImagesInChart(ctx, chart, allRels.SelectChart(chart.Name), nil)
+		if err != nil {
+			logg.Error("cannot check chart %q for images that are not covered by image relations: %s", chart.Name, err.Error())
+			continue
+		}
+		for _, img := range uncoveredImages {
+			logg.Error("chart %q renders %s, which is not covered by any image relation", chart.Name, img.String())
+		}
+	}
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
 // subcommand: unbundle
 
+type unbundleOpts struct {
+	OCMBackend         string
+	PushImagesTo       string
+	RawRelocations     []string
+	RelocationFilePath string
+	RawBaseValues      []string
+	VerifyKeyPath      string
+}
+
 func unbundleCmd() *cobra.Command {
-	return &cobra.Command{
+	var opts unbundleOpts
+	cmd := &cobra.Command{
 		Use:   "unbundle <component-version> <target-directory>",
 		Short: "Unpacks a Helm chart from an OCM component version.",
 		Long: docstring(
@@ -188,27 +562,125 @@ func unbundleCmd() *cobra.Command {
 			`The component version can be given either as the path to a CTF archive on the filesystem,`,
 			`or as a fully qualified reference into an OCI registry, in the form "$OCI_REGISTRY//$COMPONENT_NAME:$COMPONENT_VERSION".`,
 			``,
+			`Each Helm chart is unpacked into a subdirectory of the target directory that is named after the chart.`,
+			`The names of these subdirectories are written into the file "install-order.txt" in the target directory,`,
+			`one per line, in the order in which the charts were given to the "bundle" subcommand.`,
+			``,
 			`If the component version contains image relations, a file "localized-values.yaml" is rendered`,
-			`into the output directory. This file must be given to Helm with the --values switch.`,
+			`into each chart's directory. This file must be given to Helm with the --values switch.`,
 			``,
-			fmt.Sprintf(`If the Helm chart carries a %q label, its contents are written`, core.GitLocationLabelName),
-			`into the output directory under the file name "git-location.json".`,
+			fmt.Sprintf(`If a Helm chart carries a %q label, its contents are written`, core.GitLocationLabelName),
+			`into the chart's directory under the file name "git-location.json".`,
+			`Likewise, if the chart was bundled with "bundle --sbom", the SBOM is written into the chart's directory as "sbom.cdx.json".`,
+			``,
+			`Files and directories that were bundled with "bundle --file-resource" are written into the subdirectory "files"`,
+			`of the target directory, under their original basename.`,
+			``,
+			`The payload of each resource that is unpacked is checked against the digest recorded for it in the component descriptor.`,
 		),
-		Args: cobra.ExactArgs(2), // TODO: support component versions containing multiple Helm charts (by taking multiple target dirs)
-		RunE: unbundle,
+		Args: cobra.ExactArgs(2),
+		RunE: opts.Run,
 	}
+
+	cmd.Flags().StringVar(&opts.OCMBackend, "ocm-backend", "native", docstring(
+		fmt.Sprintf(`How to access the component version (one of: %s).`, strings.Join(core.OCMClientBackends, ", ")),
+		`The "native" backend reads CTF archives and OCI registries directly.`,
+		`The "exec" backend delegates to the "ocm" CLI, which must be installed.`,
+	))
+	cmd.Flags().StringVar(&opts.PushImagesTo, "push-images-to", "", docstring(
+		`If given, images that were copied into the component version with "bundle --copy-images" are pushed into this location,`,
+		`and localized-values.yaml refers to the pushed images instead of the original ones.`,
+		`The location can either be a registry with an optional path prefix, e.g. "registry.example.org/mirror",`,
+		`or the path to an OCI image layout directory with a prefix of "oci:", e.g. "oci:./images".`,
+		`Images retain their repository path and tag, e.g. "quay.io/foo/bar:1.0" becomes "registry.example.org/mirror/foo/bar:1.0".`,
+		`Images in an OCI image layout cannot be pulled by reference, so localized-values.yaml is not changed in this case.`,
+	))
+	cmd.Flags().StringArrayVar(&opts.RawRelocations, "relocate", nil, docstring(
+		`A rule of the form "<from>=><to>", e.g. "quay.io=>mirror.internal/quay", for rewriting image references in localized-values.yaml.`,
+		`Image references whose fully-qualified repository name starts with the <from> prefix get it replaced by the <to> prefix.`,
+		`Prefixes only match on whole path elements, and Docker Hub images need to be matched as e.g. "docker.io/library".`,
+		`The <to> prefix must start with a registry hostname. Tags and digests are retained.`,
+		`If multiple rules match, the one with the longest <from> prefix wins.`,
+		`The option may be given multiple times to include multiple rules.`,
+		`Rules are applied after images have been pushed with --push-images-to.`,
+	))
+	cmd.Flags().StringVar(&opts.RelocationFilePath, "relocation-file", "", docstring(
+		`Path to a YAML file containing additional rules like for --relocate, as a mapping from <from> to <to> prefixes, e.g.:`,
+		`    quay.io: mirror.internal/quay`,
+		`    docker.io/library: mirror.internal/dockerhub`,
+	))
+	cmd.Flags().StringArrayVar(&opts.RawBaseValues, "base-values", nil, docstring(
+		`Path to a values file (e.g. "values-prod.yaml") that the localized values are merged into.`,
+		`If given, localized-values.yaml contains the contents of this file with the localized values applied on top,`,
+		`and it is the only values file that needs to be given to Helm. Comments and key order from this file are retained.`,
+		`If an image relation targets an element of a list that does not appear in this file, the list is copied from`,
+		`the chart's values.yaml first, to avoid losing the other fields of its elements when Helm replaces the list.`,
+		`When unbundling multiple charts, the option must be given once per chart in the form "<chart-name>=<path>".`,
+	))
+	cmd.Flags().StringVar(&opts.VerifyKeyPath, "verify-key", "", docstring(
+		`Path to a PEM-encoded RSA or Ed25519 public key. If given, the component version must carry a signature`,
+		`that was made with the corresponding private key (e.g. by "bundle --sign-key") over its current contents,`,
+		`and the payload of each resource that is unpacked must match the digest recorded in the component descriptor.`,
+		`Images that are only referenced must be pinned to the digest recorded in the component descriptor.`,
+		`Otherwise, nothing is unpacked.`,
+	))
+	return cmd
 }
 
-func unbundle(cmd *cobra.Command, args []string) error {
+// unbundler holds state that is shared between the steps of the "unbundle" subcommand.
+type unbundler struct {
+	Opts                *unbundleOpts
+	Client              core.OCMClient
+	ComponentVersionRef string
+	Resources           core.OCMResourceInfoSet
+	Relocations         core.ImageRelocations
+	BaseValuesPaths     map[string]string // key = chart directory name
+	OutputDirPath       string
+	VerifyPayloads      bool
+	// images that have been resolved already (key = resource name)
+	imageRefs map[string]reference.Named
+}
+
+func (opts *unbundleOpts) Run(cmd *cobra.Command, args []string) error {
+	client, err := core.NewOCMClient(opts.OCMBackend)
+	if err != nil {
+		return err
+	}
+	relocs, err := core.ParseImageRelocations(opts.RawRelocations)
+	if err != nil {
+		return err
+	}
+	if opts.RelocationFilePath != "" {
+		moreRelocs, err := core.ReadImageRelocationsFile(opts.RelocationFilePath)
+		if err != nil {
+			return err
+		}
+		relocs = append(relocs, moreRelocs...)
+	}
+
 	// enumerate resources in this component version
 	componentVersionRef := args[0]
 	if componentVersionRef == "" {
 		return errors.New("missing component version")
 	}
-	resources, err := core.GetOCMResources(componentVersionRef)
+	resources, err := core.GetOCMResources(cmd.Context(), client, componentVersionRef)
 	if err != nil {
 		return err
 	}
+	if opts.VerifyKeyPath != "" {
+		publicKey, err := core.ReadOCMPublicKey(opts.VerifyKeyPath)
+		if err != nil {
+			return err
+		}
+		buf, err := client.GetComponentDescriptor(cmd.Context(), componentVersionRef)
+		if err != nil {
+			return err
+		}
+		err = core.VerifyOCMComponentDescriptor(buf, publicKey)
+		if err != nil {
+			return fmt.Errorf("while verifying %s: %w", componentVersionRef, err)
+		}
+	}
 
 	// prepare output directory
 	outputDirPath := args[1]
@@ -220,89 +692,260 @@ func unbundle(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// unpack the Helm chart
-	res, err := resources.FindExactlyOneWith(`type: "helmChart"`, func(res core.OCMResourceInfo) bool {
-		return res.Type == "helmChart"
-	})
+	// find all Helm charts, in the order in which they need to be installed
+	chartResources, err := resources.FindHelmChartsInInstallOrder()
 	if err != nil {
 		return err
 	}
-	buf, err := res.GetPayloadFrom(componentVersionRef)
+
+	// assign --base-values to charts
+	chartDirNames := make([]string, len(chartResources))
+	for idx, res := range chartResources {
+		chartDirNames[idx] = strings.TrimPrefix(res.Name, "helm-chart-")
+	}
+	baseValuesPaths := make(map[string]string, len(opts.RawBaseValues))
+	for _, input := range opts.RawBaseValues {
+		chartDirName, path, found := strings.Cut(input, "=")
+		if !found || !slices.Contains(chartDirNames, chartDirName) {
+			if len(chartDirNames) > 1 {
+				return fmt.Errorf(`while parsing --base-values %q: expected the form "<chart-name>=<path>" with one of the following chart names: %s`,
+					input, strings.Join(chartDirNames, ", "))
+			}
+			chartDirName, path = chartDirNames[0], input
+		}
+		if _, exists := baseValuesPaths[chartDirName]; exists {
+			return fmt.Errorf("--base-values was given multiple times for chart %q", chartDirName)
+		}
+		baseValuesPaths[chartDirName] = path
+	}
+
+	// check that the charts do not collide with the directory for --file-resource, before unpacking anything
+	hasFileResources := slices.ContainsFunc(resources, func(res core.OCMResourceInfo) bool {
+		_, ok := res.GetLabel(core.FileNameLabelName)
+		return ok
+	})
+	if hasFileResources {
+		err = core.CheckFileResourcesDirName(chartDirNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	// unpack each Helm chart into its own subdirectory
+	u := unbundler{
+		Opts:                opts,
+		Client:              client,
+		ComponentVersionRef: componentVersionRef,
+		Resources:           resources,
+		Relocations:         relocs,
+		BaseValuesPaths:     baseValuesPaths,
+		OutputDirPath:       outputDirPath,
+		VerifyPayloads:      opts.VerifyKeyPath != "",
+		imageRefs:           make(map[string]reference.Named),
+	}
+	var installOrder strings.Builder
+	for _, res := range chartResources {
+		chartDirName, err := u.unpackHelmChart(cmd.Context(), res)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(&installOrder, chartDirName)
+	}
+
+	// unpack files and directories that were bundled with --file-resource
+	for _, res := range resources {
+		if _, ok := res.GetLabel(core.FileNameLabelName); !ok {
+			continue
+		}
+		buf, err := u.readPayload(cmd.Context(), res)
+		if err != nil {
+			return err
+		}
+		err = core.UnpackFileResource(res, buf, filepath.Join(outputDirPath, core.FileResourcesDirName))
+		if err != nil {
+			return err
+		}
+	}
+
+	// render install-order.txt (for consumption by CD pipelines that install the charts one after another)
+	installOrderPath := filepath.Join(outputDirPath, "install-order.txt")
+	return os.WriteFile(installOrderPath, []byte(installOrder.String()), 0666) // NOTE: final mode is subject to umask
+}
+
+// Opens the payload of the given resource for reading.
+// If signatures are verified, the resource must have a digest, to ensure that the signature applies to the payload.
+func (u *unbundler) getPayload(ctx context.Context, res core.OCMResourceInfo) (io.ReadCloser, error) {
+	if u.VerifyPayloads && res.Digest == nil {
+		return nil, fmt.Errorf("resource %q does not have a digest, so its payload cannot be verified", res.Name)
+	}
+	return res.GetPayloadFrom(ctx, u.Client, u.ComponentVersionRef)
+}
+
+// Like getPayload, but reads the entire payload into memory.
+// This should only be used for payloads that are known to be small, like Helm charts.
+func (u *unbundler) readPayload(ctx context.Context, res core.OCMResourceInfo) ([]byte, error) {
+	reader, err := u.getPayload(ctx, res)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer reader.Close()
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not download resource %q: %w", res.Name, err)
 	}
-	chartPath := filepath.Join(outputDirPath, strings.TrimPrefix(res.Name, "helm-chart-"))
+	return buf, nil
+}
+
+// Unpacks a single Helm chart below the output directory, and returns the name of the directory it was unpacked into.
+func (u *unbundler) unpackHelmChart(ctx context.Context, res core.OCMResourceInfo) (string, error) {
+	// unpack the Helm chart
+	buf, err := u.readPayload(ctx, res)
+	if err != nil {
+		return "", err
+	}
+	chartDirName := strings.TrimPrefix(res.Name, "helm-chart-")
+	chartPath := filepath.Join(u.OutputDirPath, chartDirName)
 	err = core.UnpackHelmChartTarball(buf, chartPath)
 	if err != nil {
-		return fmt.Errorf("could not unpack resource %q: %w", res.Name, err)
+		return "", fmt.Errorf("could not unpack resource %q: %w", res.Name, err)
 	}
 
 	// parse image-relations.json
-	resLabels := make(map[core.OCMLabelName]any, len(res.Labels))
-	for _, label := range res.Labels {
-		resLabels[label.Name] = label.Value
-	}
-	relationsValue, ok := resLabels[core.ImageRelationsLabelName]
+	relationsValue, ok := res.GetLabel(core.ImageRelationsLabelName)
 	if !ok {
-		return fmt.Errorf("could not unpack resource %q: missing required label %q",
+		return "", fmt.Errorf("could not unpack resource %q: missing required label %q",
 			res.Name, core.ImageRelationsLabelName)
 	}
 	relationsJSON, ok := relationsValue.(string)
 	if !ok {
-		return fmt.Errorf("could not read label %q on resource %q: expected string value, but got %#v",
+		return "", fmt.Errorf("could not read label %q on resource %q: expected string value, but got %#v",
 			core.ImageRelationsLabelName, res.Name, relationsValue)
 	}
 	var rels core.ImageRelations
 	err = json.Unmarshal([]byte(relationsJSON), &rels)
 	if err != nil {
-		return fmt.Errorf("could not read label %q on resource %q: %w", core.ImageRelationsLabelName, res.Name, err)
+		return "", fmt.Errorf("could not read label %q on resource %q: %w", core.ImageRelationsLabelName, res.Name, err)
 	}
 
 	// in image relations, resolve ImageResourceName back into ImageReference
 	for _, rel := range rels {
-		resName := rel.ImageResourceName
-		res, err := resources.FindExactlyOneWith(fmt.Sprintf("name: %q", resName), func(res core.OCMResourceInfo) bool {
-			return res.Name == resName
-		})
+		rel.ImageReference, err = u.resolveImageResource(ctx, rel.ImageResourceName)
 		if err != nil {
-			return fmt.Errorf("while resolving image relations: %w", err)
-		}
-		if res.Type != "ociImage" || res.Access.Type != "ociArtifact" || res.Access.ImageReference == "" {
-			return fmt.Errorf("while resolving image relations: resource %q does not contain an OCI image reference", res.Name)
-		}
-		rel.ImageReference, err = reference.ParseNormalizedNamed(res.Access.ImageReference)
-		if err != nil {
-			return fmt.Errorf("could not parse image reference %q in resource %q: %w", res.Access.ImageReference, res.Name, err)
+			return "", fmt.Errorf("while resolving image relations: %w", err)
 		}
 	}
 
 	// render localized-values.yaml
-	localizedValues, err := rels.BuildLocalizedValues()
+	buf, err = u.buildLocalizedValues(rels, chartDirName, chartPath)
 	if err != nil {
-		return fmt.Errorf("could not build localized-values.yaml: %w", err)
-	}
-	buf, err = yaml.Marshal(localizedValues)
-	if err != nil {
-		return fmt.Errorf("could not marshal localized-values.yaml: %w", err)
+		return "", fmt.Errorf("could not build localized-values.yaml for resource %q: %w", res.Name, err)
 	}
 	localizedValuesPath := filepath.Join(chartPath, "localized-values.yaml")
 	err = os.WriteFile(localizedValuesPath, buf, 0666) // NOTE: final mode is subject to umask
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// render git-metadata.json (for consumption by concourse-release-resource)
-	gitLocationValue, ok := resLabels[core.GitLocationLabelName]
+	gitLocationValue, ok := res.GetLabel(core.GitLocationLabelName)
 	if ok {
 		gitLocationJSON, ok := gitLocationValue.(string)
 		if ok {
 			gitLocationPath := filepath.Join(chartPath, "git-location.json")
 			err = os.WriteFile(gitLocationPath, []byte(gitLocationJSON), 0666) // NOTE: final mode is subject to umask
 			if err != nil {
-				return err
+				return "", err
 			}
 		}
 	}
 
-	return nil
+	// render sbom.cdx.json (if the chart was bundled with --sbom)
+	for _, sbomRes := range u.Resources {
+		chartResName, ok := sbomRes.GetLabel(core.SBOMForLabelName)
+		if !ok || chartResName != res.Name {
+			continue
+		}
+		buf, err := u.readPayload(ctx, sbomRes)
+		if err != nil {
+			return "", err
+		}
+		sbomPath := filepath.Join(chartPath, "sbom.cdx.json")
+		err = os.WriteFile(sbomPath, buf, 0666) // NOTE: final mode is subject to umask
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return chartDirName, nil
+}
+
+// Renders the contents of localized-values.yaml for the given chart, using the base values file if one was given.
+func (u *unbundler) buildLocalizedValues(rels core.ImageRelations, chartDirName, chartPath string) ([]byte, error) {
+	baseValuesPath, ok := u.BaseValuesPaths[chartDirName]
+	if !ok {
+		localizedValues, err := rels.BuildLocalizedValues()
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(localizedValues)
+	}
+
+	base, err := os.ReadFile(baseValuesPath)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := os.ReadFile(filepath.Join(chartPath, "values.yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return rels.BuildLocalizedValuesOnto(base, defaults)
+}
+
+// Finds the image resource with the given name, and returns the reference that localized-values.yaml shall use for it.
+// If requested, bundled images are pushed to a different location, and relocation rules are applied during this step.
+func (u *unbundler) resolveImageResource(ctx context.Context, resName string) (reference.Named, error) {
+	if imageRef, ok := u.imageRefs[resName]; ok {
+		return imageRef, nil
+	}
+
+	res, err := u.Resources.FindExactlyOneWith(fmt.Sprintf("name: %q", resName), func(res core.OCMResourceInfo) bool {
+		return res.Name == resName
+	})
+	if err != nil {
+		return nil, err
+	}
+	imageRefStr, ok := res.Access.GetImageReference()
+	if (res.Type != "ociImage" && res.Type != "ociArtifact") || !ok {
+		return nil, fmt.Errorf("resource %q does not contain an OCI artifact reference", res.Name)
+	}
+	imageRef, err := reference.ParseNormalizedNamed(imageRefStr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image reference %q in resource %q: %w", imageRefStr, res.Name, err)
+	}
+
+	if u.VerifyPayloads && !res.Access.IsLocalBlob() {
+		err = res.VerifyImageReference(imageRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if u.Opts.PushImagesTo != "" && res.Access.IsLocalBlob() {
+		// NOTE: Images can be large, so they are streamed instead of being read into memory.
+		payload, err := u.getPayload(ctx, res)
+		if err != nil {
+			return nil, err
+		}
+		defer payload.Close()
+		imageRef, err = core.PushBundledImage(ctx, payload, imageRef, u.Opts.PushImagesTo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	imageRef, err = u.Relocations.Apply(imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	u.imageRefs[resName] = imageRef
+	return imageRef, nil
 }